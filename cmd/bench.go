@@ -0,0 +1,113 @@
+// Copyright © 2016 Asteris, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	log "github.com/wrouesnel/go.log"
+	vaultfs "github.com/wrouesnel/vaultfs/fs"
+	"golang.org/x/net/context"
+)
+
+// benchCmd represents the bench command
+var benchCmd = &cobra.Command{
+	Use:   "bench {vault-path}",
+	Short: "measure the latency of filesystem operations against a live Vault, without mounting",
+	Long: `Walk the tree beneath a Vault path as ls -R and cat of every file would,
+serving the lookups, directory reads and file reads as a mount does for the
+kernel, and print the latency of each. It takes the settings of the mount
+command, so runs with and without --cache-ttl compare the cache. Virtual
+entries (starting with a dot) and dynamic secrets are skipped, but the
+secrets walked are read, so point it at KV secrets.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("expected exactly one argument, a Vault path")
+		}
+
+		if err := viper.BindPFlags(cmd.Flags()); err != nil {
+			log.WithError(err).Fatal("could not bind flags")
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		vaultConfig, err := vaultClientConfig(viper.GetViper())
+		if err != nil {
+			log.Fatalln("Error reading vault environment keys:", err)
+		}
+
+		options := fsOptions(viper.GetViper(), vaultConfig, "")
+		options.Root = args[0]
+		fs, err := vaultfs.New(options)
+		if err != nil {
+			log.WithError(err).Fatal("error creating fs")
+		}
+		if err := configureFS(fs, viper.GetViper()); err != nil {
+			log.WithError(err).Fatal("invalid filesystem settings")
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		if duration := viper.GetDuration("duration"); duration > 0 {
+			ctx, cancel = context.WithTimeout(context.Background(), duration)
+		}
+		defer cancel()
+		go func() {
+			c := make(chan os.Signal, 1)
+			signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+			<-c
+			cancel()
+		}()
+
+		started := time.Now()
+		stats, err := fs.Bench(ctx, viper.GetInt("depth"), viper.GetInt("iterations"), viper.GetInt("concurrency"))
+		elapsed := time.Since(started)
+		if err != nil && err != context.DeadlineExceeded {
+			log.WithError(err).Warn("benchmark stopped early")
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(w, "OP\tCOUNT\tERRORS\tMIN\tMEDIAN\tP99\tMAX\tOPS/S\t")
+		for _, s := range stats {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%v\t%v\t%v\t%v\t%.1f\t\n", s.Op, s.Count, s.Errors,
+				s.Min, s.Median, s.P99, s.Max, s.Throughput())
+		}
+		w.Flush()
+
+		requests := uint64(0)
+		for _, n := range fs.RequestStats().Requests {
+			requests += n
+		}
+		cache := fs.CacheStats()
+		fmt.Printf("\n%d vault requests in %v, %d cache hits, %d misses\n", requests, elapsed.Round(time.Millisecond), cache.Hits, cache.Misses)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(benchCmd)
+	addFilesystemFlags(benchCmd)
+	benchCmd.Flags().Int("depth", 3, "number of directory levels beneath the path to walk")
+	benchCmd.Flags().Int("iterations", 10, "number of times each worker walks the tree")
+	benchCmd.Flags().Int("concurrency", 1, "number of workers walking the tree at once")
+	benchCmd.Flags().Duration("duration", 0, "stop after this long (default is to finish the iterations)")
+}
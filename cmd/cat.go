@@ -0,0 +1,88 @@
+// Copyright © 2016 Asteris, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	log "github.com/wrouesnel/go.log"
+)
+
+// catCmd represents the cat command
+var catCmd = &cobra.Command{
+	Use:   "cat {path}",
+	Short: "print a secret from Vault, without mounting",
+	Long: `Print the data of a secret as JSON (or a single value with --field),
+read through the same backend and auth flags as a mount, for debugging
+connectivity and policies.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("expected exactly one argument, a Vault path")
+		}
+
+		if err := viper.BindPFlags(cmd.Flags()); err != nil {
+			log.WithError(err).Fatal("could not bind flags")
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		backend, err := newBackend()
+		if err != nil {
+			log.WithError(err).Fatal("could not authenticate to vault")
+		}
+
+		secret, err := backend.Read(args[0])
+		if err != nil {
+			log.WithError(err).WithField("path", args[0]).Fatal("could not read secret")
+		}
+		if secret == nil {
+			log.WithField("path", args[0]).Fatal("secret not found")
+		}
+
+		if field := viper.GetString("field"); field != "" {
+			value, found := secret.Data[field]
+			if !found {
+				log.WithField("path", args[0]).WithField("field", field).Fatal("field not found")
+			}
+			if s, ok := value.(string); ok {
+				fmt.Println(s)
+				return
+			}
+			printJSON(value)
+			return
+		}
+		printJSON(secret.Data)
+	},
+}
+
+// printJSON prints value to stdout as indented JSON.
+func printJSON(value interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(value); err != nil {
+		log.WithError(err).Fatal("could not encode output")
+	}
+}
+
+func init() {
+	RootCmd.AddCommand(catCmd)
+	catCmd.Flags().String("field", "", "print only the value of this data key")
+}
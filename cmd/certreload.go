@@ -0,0 +1,75 @@
+// Copyright © 2016 Asteris, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"os"
+	"time"
+
+	"github.com/spf13/viper"
+	log "github.com/wrouesnel/go.log"
+)
+
+// certFileState is what is compared to notice a certificate file changing.
+type certFileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// statCertFiles returns the state of each of files which exists.
+func statCertFiles(files []string) map[string]certFileState {
+	states := make(map[string]certFileState, len(files))
+	for _, file := range files {
+		// Stat follows symlinks, so the swapped ..data links of a
+		// Kubernetes secret volume are seen as a change.
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		states[file] = certFileState{info.ModTime(), info.Size()}
+	}
+	return states
+}
+
+// watchClientCert polls the client certificate and key files of the settings
+// (or environment) and calls reload once they change, so rotated certificates
+// are picked up without a remount. It never returns, so is run in its own
+// goroutine, and does nothing without a client certificate or interval.
+func watchClientCert(settings *viper.Viper, reload func()) {
+	interval := settings.GetDuration("client-cert-reload-interval")
+	tlsConfig, _ := vaultTLSConfig(settings)
+	if interval <= 0 || tlsConfig.ClientCert == "" {
+		return
+	}
+
+	files := []string{tlsConfig.ClientCert, tlsConfig.ClientKey}
+	last := statCertFiles(files)
+	for range time.Tick(interval) {
+		current := statCertFiles(files)
+		changed := len(current) != len(last)
+		for file, state := range current {
+			if last[file] != state {
+				changed = true
+			}
+		}
+		if !changed {
+			continue
+		}
+		last = current
+
+		log.WithField("client_cert", tlsConfig.ClientCert).Info("client certificate changed, reconnecting to vault")
+		reload()
+	}
+}
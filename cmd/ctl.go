@@ -0,0 +1,115 @@
+// Copyright © 2016 Asteris, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	log "github.com/wrouesnel/go.log"
+	vaultfs "github.com/wrouesnel/vaultfs/fs"
+)
+
+// ctlCommands are the commands of the control API.
+var ctlCommands = []string{
+	vaultfs.ControlReload,
+	vaultfs.ControlReauth,
+	vaultfs.ControlFlush,
+	vaultfs.ControlStats,
+	vaultfs.ControlUnmount,
+}
+
+// ctlCmd represents the ctl command
+var ctlCmd = &cobra.Command{
+	Use:   "ctl {" + strings.Join(ctlCommands, "|") + "}",
+	Short: "manage a running mount through its control socket",
+	Long: `Send a command to a mount started with --control-socket:
+
+  reload   read the config file again and reconnect to Vault, as on SIGHUP,
+           dropping cached responses, capabilities and tenant tokens
+  reauth   log in to Vault again for a fresh token (not possible with a given token)
+  flush    drop cached responses
+  stats    print cache and journal statistics as JSON
+  unmount  unmount gracefully`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("expected exactly one argument, a command")
+		}
+
+		if err := viper.BindPFlags(cmd.Flags()); err != nil {
+			log.WithError(err).Fatal("could not bind flags")
+		}
+
+		if viper.GetString("control-socket") == "" {
+			return errors.New("--control-socket is required")
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		command := args[0]
+		method := "POST"
+		switch command {
+		case vaultfs.ControlStats:
+			method = "GET"
+		case vaultfs.ControlReload, vaultfs.ControlReauth, vaultfs.ControlFlush, vaultfs.ControlUnmount:
+		default:
+			log.WithField("command", command).Fatal("unknown command")
+		}
+
+		socketPath := viper.GetString("control-socket")
+		client := &http.Client{
+			Transport: &http.Transport{
+				Dial: func(network, addr string) (net.Conn, error) {
+					return net.Dial("unix", socketPath)
+				},
+			},
+		}
+
+		req, err := http.NewRequest(method, "http://vaultfs/"+command, nil)
+		if err != nil {
+			log.WithError(err).Fatal("could not create request")
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			log.WithError(err).Fatal("could not reach control socket")
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode >= 300 {
+			body, _ := ioutil.ReadAll(resp.Body)
+			log.WithField("status", resp.Status).Fatal(strings.TrimSpace(string(body)))
+		}
+		if _, err := io.Copy(os.Stdout, resp.Body); err != nil {
+			log.WithError(err).Fatal("could not read response")
+		}
+		if method == "GET" {
+			fmt.Println()
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(ctlCmd)
+	ctlCmd.Flags().String("control-socket", "", "control socket of the mount")
+}
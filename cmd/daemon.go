@@ -0,0 +1,203 @@
+// Copyright © 2016 Asteris, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// daemonEnv marks the background process started by --daemon, so it doesn't
+// start another.
+const daemonEnv = "VAULTFS_DAEMON"
+
+// readyFd is the descriptor of the pipe the background process reports over
+// once it has mounted, and daemonReady what it writes.
+const (
+	readyFd     = 3
+	daemonReady = "ready\n"
+)
+
+// daemonize starts the current command again in the background, detached from
+// the terminal, and returns its pid once it has mounted. An error is returned
+// if it exits without mounting. Its output is discarded, so it should log to
+// syslog or journald.
+func daemonize() (int, error) {
+	devNull, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer devNull.Close()
+
+	ready, notify, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer ready.Close()
+
+	child := exec.Command("/proc/self/exe", os.Args[1:]...)
+	child.Env = append(os.Environ(), daemonEnv+"=1")
+	child.Stdin = devNull
+	child.Stdout = devNull
+	child.Stderr = devNull
+	child.ExtraFiles = []*os.File{notify} // readyFd
+	child.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = child.Start()
+	notify.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	// The pipe is closed without a word if the child exits first.
+	status := make([]byte, len(daemonReady))
+	if _, err := io.ReadFull(ready, status); err != nil || string(status) != daemonReady {
+		return child.Process.Pid, errors.New("the background process exited without mounting (see its log)")
+	}
+	return child.Process.Pid, nil
+}
+
+// isDaemon reports whether this is the background process started by
+// daemonize.
+func isDaemon() bool {
+	return os.Getenv(daemonEnv) != ""
+}
+
+// notifyReady reports to the process which started this one with daemonize
+// that it has mounted.
+func notifyReady() {
+	notify := os.NewFile(readyFd, "ready")
+	notify.Write([]byte(daemonReady))
+	notify.Close()
+}
+
+// defaultPidFile returns the pidfile of the mount at mountpoint: in
+// $XDG_RUNTIME_DIR, /run for root, or otherwise a directory of the user's own
+// in the temporary directory, which is world-writable so can't hold the
+// pidfile itself.
+func defaultPidFile(mountpoint string) (string, error) {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" && os.Getuid() == 0 {
+		dir = "/run"
+	}
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), fmt.Sprintf("vaultfs-%d", os.Getuid()))
+		if err := privateDir(dir); err != nil {
+			return "", err
+		}
+	}
+
+	if abs, err := filepath.Abs(mountpoint); err == nil {
+		mountpoint = abs
+	}
+	name := strings.Replace(strings.Trim(mountpoint, "/"), "/", "-", -1)
+	return filepath.Join(dir, "vaultfs-"+name+".pid"), nil
+}
+
+// privateDir creates dir accessible only to this user, or checks that it is
+// if it exists, as another user may have created it first.
+func privateDir(dir string) error {
+	if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+		return err
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() || info.Mode().Perm() != 0700 || !ownedByUser(info) {
+		return fmt.Errorf("%s is not a directory private to this user", dir)
+	}
+	return nil
+}
+
+// ownedByUser returns true if the file of info belongs to this user.
+func ownedByUser(info os.FileInfo) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && int(stat.Uid) == os.Getuid()
+}
+
+// writePidFile writes the pid of this process to filename. The file is
+// created, without following a symlink in its place, so it can't be used to
+// overwrite another file. A pidfile left behind by a process which has died is
+// replaced.
+func writePidFile(filename string) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL|syscall.O_NOFOLLOW, 0644)
+	if os.IsExist(err) {
+		pid, err := readPidFile(filename)
+		if err != nil {
+			return err
+		}
+		if syscall.Kill(pid, 0) != syscall.ESRCH {
+			return fmt.Errorf("%s exists, is vaultfs already mounted there?", filename)
+		}
+		if err := os.Remove(filename); err != nil {
+			return err
+		}
+		f, err = os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL|syscall.O_NOFOLLOW, 0644)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "%d\n", os.Getpid()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readPidFile reads the pid in filename, which must be a file belonging to
+// this user, so that another user can't direct signals at the pid in it.
+func readPidFile(filename string) (int, error) {
+	f, err := os.OpenFile(filename, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if !info.Mode().IsRegular() || !ownedByUser(info) {
+		return 0, fmt.Errorf("%s is not a pidfile of this user", filename)
+	}
+
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(content)))
+}
+
+// isVaultfsProcess returns true if the process pid runs this executable, so
+// is a mount rather than an unrelated process which has reused the pid of one.
+func isVaultfsProcess(pid int) bool {
+	self, err := os.Readlink("/proc/self/exe")
+	if err != nil {
+		return false
+	}
+	exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	if err != nil {
+		return false
+	}
+	// An executable replaced by an upgrade is shown as deleted.
+	return strings.TrimSuffix(exe, " (deleted)") == strings.TrimSuffix(self, " (deleted)")
+}
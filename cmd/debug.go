@@ -0,0 +1,42 @@
+// Copyright © 2016 Asteris, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"expvar"
+	"net"
+	"net/http"
+	_ "net/http/pprof" // registers the profiling handlers on http.DefaultServeMux
+
+	log "github.com/wrouesnel/go.log"
+)
+
+// serveDebug serves the pprof profiles at /debug/pprof/ and the expvar
+// variables at /debug/vars on address in the background, publishing vars as
+// the vaultfs variable, so a long-running mount can be diagnosed without
+// restarting it. It exits if address can't be listened on.
+func serveDebug(address string, vars func() interface{}) {
+	expvar.Publish("vaultfs", expvar.Func(vars))
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		log.WithError(err).Fatal("could not listen for debugging")
+	}
+	log.WithField("address", listener.Addr()).Warn("serving debug endpoint, which exposes the internals of the process")
+	go func() {
+		err := http.Serve(listener, nil)
+		log.WithError(err).Error("debug endpoint stopped")
+	}()
+}
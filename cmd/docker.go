@@ -15,14 +15,26 @@
 package cmd
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
 
+	"github.com/docker/go-connections/sockets"
+	"github.com/docker/go-plugins-helpers/sdk"
 	"github.com/docker/go-plugins-helpers/volume"
-	"github.com/hashicorp/vault/api"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	log "github.com/wrouesnel/go.log"
 	"github.com/wrouesnel/vaultfs/docker"
+	vaultfs "github.com/wrouesnel/vaultfs/fs"
 )
 
 // dockerCmd represents the docker command
@@ -38,25 +50,41 @@ var dockerCmd = &cobra.Command{
 			log.WithError(err).Fatal("could not bind flags")
 		}
 
+		// The plugin's own address and insecure flags predate the global
+		// vault-address and tls-skip-verify, which they set.
+		if cmd.Flags().Changed("address") {
+			viper.Set("vault-address", []string{viper.GetString("address")})
+		}
+		if viper.GetBool("insecure") {
+			viper.Set("tls-skip-verify", true)
+		}
+
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		vaultConfig := api.DefaultConfig()
-		if err := vaultConfig.ReadEnvironment(); err != nil {
+		if propagated := viper.GetString("propagated-mount"); propagated != "" {
+			if err := checkPropagatedMount(args[0], propagated); err != nil {
+				log.WithError(err).Fatal("invalid volume root")
+			}
+		}
+
+		vaultConfig, err := vaultClientConfig(viper.GetViper())
+		if err != nil {
 			log.Fatalln("Error reading vault environment keys:", err)
 		}
 
 		driver := docker.New(docker.Config{
-			Root:       args[0],
-			Token:      viper.GetString("token"),
-			AuthMethod: viper.GetString("auth-method"),
-			Vault:      vaultConfig,
+			Root:    args[0],
+			Options: fsOptions(viper.GetViper(), vaultConfig, ""),
+			Configure: func(fs *vaultfs.VaultFS) error {
+				return configureFS(fs, viper.GetViper())
+			},
 		})
 
 		log.WithFields(log.Fields{
 			"root":     args[0],
-			"address":  viper.GetString("address"),
-			"insecure": viper.GetBool("insecure"),
+			"address":  vaultConfig.Address,
+			"insecure": viper.GetBool("tls-skip-verify"),
 			"socket":   viper.GetString("socket"),
 		}).Info("starting plugin server")
 
@@ -66,20 +94,136 @@ var dockerCmd = &cobra.Command{
 			}
 		}()
 
-		handler := volume.NewHandler(driver)
-		log.WithField("socket", viper.GetString("socket")).Info("serving unix socket")
-		err := handler.ServeUnix(viper.GetString("socket"), 0)
+		servePlugin(volume.NewHandler(driver).Handler, dockerPluginName)
+	},
+}
+
+// dockerPluginName is the name the plugin is registered with.
+const dockerPluginName = "vault"
+
+// servePlugin serves a docker plugin handler on the socket, or over TCP, of
+// the plugin flags until it fails.
+func servePlugin(handler sdk.Handler, name string) {
+	if address := viper.GetString("tcp-address"); address != "" {
+		tlsConfig, err := pluginTLSConfig()
 		if err != nil {
+			log.WithError(err).Fatal("invalid plugin TLS configuration")
+		}
+		log.WithField("address", address).Info("serving tcp")
+		if err := handler.ServeTCP(name, address, tlsConfig); err != nil {
 			log.WithError(err).Fatal("failed serving")
 		}
-	},
+		return
+	}
+
+	socket := viper.GetString("socket")
+	listener, err := pluginSocket(socket, viper.GetString("socket-group"), viper.GetString("socket-mode"))
+	if err != nil {
+		log.WithError(err).Fatal("could not listen on socket")
+	}
+	defer os.Remove(socket)
+	log.WithField("socket", socket).Info("serving unix socket")
+	if err := handler.Serve(listener); err != nil {
+		log.WithError(err).Fatal("failed serving")
+	}
+}
+
+// addPluginFlags adds the flags of how a docker plugin is served to cmd.
+func addPluginFlags(cmd *cobra.Command, socket string) {
+	cmd.Flags().StringP("socket", "s", socket, "socket address to communicate with docker")
+	cmd.Flags().String("socket-group", "", "group (name or gid) owning the socket (default root)")
+	cmd.Flags().String("socket-mode", "0660", "permissions of the socket, in octal")
+	cmd.Flags().String("tcp-address", "", "serve the plugin on this TCP address (host:port) instead of the socket, registering it in /etc/docker/plugins")
+	cmd.Flags().String("plugin-tls-cert", "", "certificate file of the TCP listener")
+	cmd.Flags().String("plugin-tls-key", "", "private key file of the TCP listener")
+	cmd.Flags().String("plugin-tls-ca", "", "CA certificate file to verify docker's client certificate with (default is not to require one)")
+}
+
+// pluginSocket listens on the unix socket at path, owned by group (a name or
+// gid, default root) with the octal permissions mode.
+func pluginSocket(path string, group string, mode string) (net.Listener, error) {
+	gid := 0
+	if group != "" {
+		if id, err := strconv.Atoi(group); err == nil {
+			gid = id
+		} else {
+			g, err := user.LookupGroup(group)
+			if err != nil {
+				return nil, err
+			}
+			if gid, err = strconv.Atoi(g.Gid); err != nil {
+				return nil, err
+			}
+		}
+	}
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid socket mode %q", mode)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	listener, err := sockets.NewUnixSocket(path, gid)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, os.FileMode(perm)); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// pluginTLSConfig returns the TLS configuration of the TCP listener, which
+// requires client certificates signed by the CA if one is given.
+func pluginTLSConfig() (*tls.Config, error) {
+	certFile, keyFile := viper.GetString("plugin-tls-cert"), viper.GetString("plugin-tls-key")
+	if certFile == "" && keyFile == "" {
+		log.Warn("serving the docker plugin over tcp without TLS")
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if caFile := viper.GetString("plugin-tls-ca"); caFile != "" {
+		ca, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates in %s", caFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// checkPropagatedMount checks that volumes are mounted within the propagated
+// mount of a managed plugin, as otherwise docker can't see them.
+func checkPropagatedMount(root string, propagated string) error {
+	rel, err := filepath.Rel(filepath.Clean(propagated), filepath.Clean(root))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return fmt.Errorf("volume root %s is not within the propagated mount %s", root, propagated)
+	}
+	return nil
 }
 
 func init() {
 	RootCmd.AddCommand(dockerCmd)
 
-	dockerCmd.Flags().StringP("address", "a", "https://localhost:8200", "vault address")
-	dockerCmd.Flags().BoolP("insecure", "i", false, "skip SSL certificate verification")
+	dockerCmd.Flags().StringP("address", "a", "https://localhost:8200", "vault address (as --vault-address)")
+	dockerCmd.Flags().BoolP("insecure", "i", false, "skip SSL certificate verification (as --tls-skip-verify)")
 	dockerCmd.Flags().StringP("token", "t", "", "vault token")
-	dockerCmd.Flags().StringP("socket", "s", "/run/docker/plugins/vault.sock", "socket address to communicate with docker")
+	addPluginFlags(dockerCmd, "/run/docker/plugins/vault.sock")
+	addFilesystemFlags(dockerCmd)
+	dockerCmd.Flags().String("propagated-mount", "", "propagated mount of the managed plugin, which the volume root must be within")
 }
@@ -0,0 +1,60 @@
+// Copyright © 2016 Asteris, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	log "github.com/wrouesnel/go.log"
+	"github.com/wrouesnel/vaultfs/docker"
+	"github.com/wrouesnel/vaultfs/vaultapi"
+)
+
+// dockerSecretsCmd represents the docker-secrets command
+var dockerSecretsCmd = &cobra.Command{
+	Use:   "docker-secrets",
+	Short: "start the docker swarm secrets plugin server",
+	Long: `Serve the values of swarm secrets created with this plugin as their
+driver from Vault. The data key returned is named by the vault.path and
+vault.key labels of the secret, or otherwise by its name, e.g. secret/app/password
+for the password key of secret/app.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := viper.BindPFlags(cmd.Flags()); err != nil {
+			log.WithError(err).Fatal("could not bind flags")
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		backend, err := newBackend()
+		if err != nil {
+			log.WithError(err).Fatal("could not authenticate to vault")
+		}
+		renewer := vaultapi.NewTokenRenewer(backend, nil)
+		renewer.Start()
+		defer renewer.Stop()
+
+		driver := docker.NewSecretsDriver(backend)
+		servePlugin(docker.NewSecretsHandler(driver), dockerSecretsPluginName)
+	},
+}
+
+// dockerSecretsPluginName is the name the secrets plugin is registered with.
+const dockerSecretsPluginName = "vault-secrets"
+
+func init() {
+	RootCmd.AddCommand(dockerSecretsCmd)
+	addPluginFlags(dockerSecretsCmd, "/run/docker/plugins/vault-secrets.sock")
+}
@@ -0,0 +1,189 @@
+// Copyright © 2016 Asteris, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	log "github.com/wrouesnel/go.log"
+	"github.com/wrouesnel/vaultfs/vaultapi"
+)
+
+// doctorCheck is a single preflight check. It returns a description of what
+// was found, or an error describing the problem and how to fix it.
+type doctorCheck struct {
+	name  string
+	check func() (string, error)
+}
+
+// doctorCmd represents the doctor command
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "check that FUSE and Vault are usable before mounting",
+	Long: `Check that FUSE is available, that Vault is reachable and unsealed, that
+the token (or auth flags) are valid, and the capabilities of the token on the
+root, printing what to do about any problem found. Mounts report most of these
+problems only as I/O errors.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 0 {
+			return errors.New("expected no arguments")
+		}
+
+		if err := viper.BindPFlags(cmd.Flags()); err != nil {
+			log.WithError(err).Fatal("could not bind flags")
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		vaultConfig, err := vaultClientConfig(viper.GetViper())
+		if err != nil {
+			log.WithError(err).Fatal("could not read vault environment")
+		}
+		root := viper.GetString("root")
+
+		reachable := false
+		var backend vaultapi.AuthableLogical
+		checks := []doctorCheck{
+			{"fuse device", checkFuseDevice},
+			{"fusermount", checkFusermount},
+			{"vault connectivity", func() (string, error) {
+				if _, err := sealStatus(vaultConfig); err != nil {
+					return "", fmt.Errorf("could not reach %s: %v (check VAULT_ADDR and TLS settings)", vaultConfig.Address, err)
+				}
+				reachable = true
+				return vaultConfig.Address, nil
+			}},
+			{"seal status", func() (string, error) {
+				if !reachable {
+					return "", errors.New("skipped, vault is unreachable")
+				}
+				status, err := sealStatus(vaultConfig)
+				if err != nil {
+					return "", err
+				}
+				if status.Sealed {
+					return "", errors.New("vault is sealed, unseal it before mounting")
+				}
+				return "unsealed", nil
+			}},
+			{"token", func() (string, error) {
+				if !reachable {
+					return "", errors.New("skipped, vault is unreachable")
+				}
+				authed, err := newBackend()
+				if err != nil {
+					return "", fmt.Errorf("could not authenticate: %v (check --token or the --auth-* flags)", err)
+				}
+				token, err := authed.ReadDynamic("auth/token/lookup-self")
+				if err != nil || token == nil {
+					return "", fmt.Errorf("token is not valid: %v", err)
+				}
+				backend = authed
+				return fmt.Sprintf("%v, ttl %vs, policies %v", token.Data["display_name"],
+					token.Data["ttl"], token.Data["policies"]), nil
+			}},
+			{"capabilities on " + root, func() (string, error) {
+				if backend == nil {
+					return "", errors.New("skipped, no valid token")
+				}
+				capabilities, err := rootCapabilities(backend, root)
+				if err != nil {
+					return "", fmt.Errorf("could not look up capabilities: %v", err)
+				}
+				if len(capabilities) == 0 || (len(capabilities) == 1 && capabilities[0] == "deny") {
+					return "", fmt.Errorf("the token has no access to %s, grant a policy with list and read on %s/*", root, root)
+				}
+				return strings.Join(capabilities, ", "), nil
+			}},
+		}
+
+		failed := false
+		for _, c := range checks {
+			result, err := c.check()
+			if err != nil {
+				failed = true
+				fmt.Printf("FAIL  %s: %v\n", c.name, err)
+				continue
+			}
+			fmt.Printf("ok    %s: %s\n", c.name, result)
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+// checkFuseDevice checks that /dev/fuse exists and can be opened.
+func checkFuseDevice() (string, error) {
+	f, err := os.OpenFile("/dev/fuse", os.O_RDWR, 0)
+	switch {
+	case os.IsNotExist(err):
+		return "", errors.New("/dev/fuse does not exist, load the fuse module (modprobe fuse) or pass --device /dev/fuse to containers")
+	case os.IsPermission(err):
+		return "", errors.New("/dev/fuse can't be opened, check its permissions (or run containers with --cap-add SYS_ADMIN)")
+	case err != nil:
+		return "", err
+	}
+	f.Close()
+	return "/dev/fuse", nil
+}
+
+// checkFusermount checks that a fusermount binary is on the PATH.
+func checkFusermount() (string, error) {
+	for _, name := range []string{"fusermount", "fusermount3"} {
+		if path, err := exec.LookPath(name); err == nil {
+			return path, nil
+		}
+	}
+	return "", errors.New("fusermount is not on the PATH, install fuse (e.g. apt-get install fuse)")
+}
+
+// rootCapabilities returns the capabilities of the backend's token on root.
+func rootCapabilities(backend vaultapi.Logical, root string) ([]string, error) {
+	secret, err := backend.Write("sys/capabilities-self", map[string]interface{}{
+		"paths": []string{root},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return nil, errors.New("no response")
+	}
+
+	// Newer Vault versions key the capabilities by path, older ones return a
+	// single list.
+	raw, found := secret.Data[root]
+	if !found {
+		raw = secret.Data["capabilities"]
+	}
+	items, _ := raw.([]interface{})
+	capabilities := []string{}
+	for _, item := range items {
+		capabilities = append(capabilities, fmt.Sprintf("%v", item))
+	}
+	return capabilities, nil
+}
+
+func init() {
+	RootCmd.AddCommand(doctorCmd)
+	doctorCmd.Flags().StringP("root", "r", "secret", "root path to check the capabilities of")
+}
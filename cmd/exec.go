@@ -0,0 +1,231 @@
+// Copyright © 2016 Asteris, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	log "github.com/wrouesnel/go.log"
+	"github.com/wrouesnel/vaultfs/secretset"
+	"github.com/wrouesnel/vaultfs/vaultapi"
+)
+
+// execSecretsDirEnv names the private directory secret files are written to.
+const execSecretsDirEnv = "VAULTFS_SECRETS_DIR"
+
+// execCmd represents the exec command
+var execCmd = &cobra.Command{
+	Use:   "exec [flags] -- {command} [args...]",
+	Short: "run a command with secrets in its environment",
+	Long: `Read the secrets given with --secret (path=PREFIX) and those listed in the
+config file, and run a command with them in its environment, as PREFIX
+followed by each key. With --files they are instead written to files in a
+private directory, which is removed when the command exits, and NAME_FILE
+holds the filename of each. Secrets of the config file with a file are written
+to that directory too, named by ` + execSecretsDirEnv + `.
+
+The token and the leases of the secrets are renewed for as long as the command
+runs, and the leases are revoked when it exits.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 {
+			return errors.New("expected a command to run")
+		}
+
+		if err := viper.BindPFlags(cmd.Flags()); err != nil {
+			log.WithError(err).Fatal("could not bind flags")
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		spec, err := execSpec()
+		if err != nil {
+			log.WithError(err).Fatal("invalid secrets")
+		}
+		if len(spec.Secrets) == 0 {
+			log.Fatal("no secrets given with --secret or in the config file")
+		}
+
+		backend, err := newBackend()
+		if err != nil {
+			log.WithError(err).Fatal("could not authenticate to vault")
+		}
+		renewer := vaultapi.NewTokenRenewer(backend, nil)
+		renewer.Start()
+
+		leases := &leaseRecorder{Logical: backend, read: make(map[string]*api.Secret)}
+		env, dir, err := execEnviron(spec, leases)
+		releases := []func(){}
+		for _, secret := range leases.secrets {
+			releases = append(releases, vaultapi.HoldLease(backend, secret))
+		}
+		// Cleanup is explicit as exiting with the command's exit code skips
+		// deferred calls.
+		cleanup := func() {
+			for _, release := range releases {
+				release()
+			}
+			renewer.Stop()
+			if dir != "" {
+				os.RemoveAll(dir)
+			}
+		}
+		if err != nil {
+			cleanup()
+			log.WithError(err).Fatal("could not read secrets")
+		}
+
+		code, err := runChild(args, env)
+		cleanup()
+		if err != nil {
+			log.WithError(err).WithField("command", args[0]).Fatal("could not run command")
+		}
+		os.Exit(code)
+	},
+}
+
+// execSpec returns the secret spec of the config file, with the secrets of
+// the secret flag added.
+func execSpec() (*secretset.Spec, error) {
+	spec, err := loadSecretSpec()
+	if err != nil {
+		return nil, err
+	}
+	for _, pair := range viper.GetStringSlice("secret") {
+		idx := strings.Index(pair, "=")
+		if idx < 0 {
+			return nil, errors.New("secret must be given as path=PREFIX: " + pair)
+		}
+		spec.Secrets = append(spec.Secrets, secretset.Secret{Path: pair[:idx], Env: pair[idx+1:]})
+	}
+	return spec, spec.Validate()
+}
+
+// execEnviron reads the secrets of spec through logical and returns the
+// environment of the command, and the private directory secret files were
+// written to, if any.
+func execEnviron(spec *secretset.Spec, logical vaultapi.Logical) ([]string, string, error) {
+	files := viper.GetBool("files")
+	hasFiles := files
+	for _, secret := range spec.Secrets {
+		hasFiles = hasFiles || secret.File != ""
+	}
+
+	env := os.Environ()
+	dir := ""
+	if hasFiles {
+		var err error
+		if dir, err = ioutil.TempDir(viper.GetString("tmpdir"), "vaultfs-exec"); err != nil {
+			return nil, "", err
+		}
+		env = append(env, execSecretsDirEnv+"="+dir)
+		if err := spec.Materialize(logical, dir); err != nil {
+			return nil, dir, err
+		}
+	}
+
+	pairs, err := spec.Environ(logical)
+	if err != nil {
+		return nil, dir, err
+	}
+	if !files {
+		return append(env, pairs...), dir, nil
+	}
+	for _, pair := range pairs {
+		idx := strings.Index(pair, "=")
+		name, value := pair[:idx], pair[idx+1:]
+		filename := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(filename, []byte(value), 0400); err != nil {
+			return nil, dir, err
+		}
+		env = append(env, name+"_FILE="+filename)
+	}
+	return env, dir, nil
+}
+
+// runChild runs the command args with env, passing on signals, and returns its
+// exit code.
+func runChild(args []string, env []string) (int, error) {
+	child := exec.Command(args[0], args[1:]...)
+	child.Env = env
+	child.Stdin = os.Stdin
+	child.Stdout = os.Stdout
+	child.Stderr = os.Stderr
+	if err := child.Start(); err != nil {
+		return 0, err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(signals)
+	go func() {
+		for sig := range signals {
+			child.Process.Signal(sig)
+		}
+	}()
+
+	err := child.Wait()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		status := exitErr.Sys().(syscall.WaitStatus)
+		if status.Signaled() {
+			return 128 + int(status.Signal()), nil
+		}
+		return status.ExitStatus(), nil
+	}
+	return 0, err
+}
+
+// leaseRecorder is a Logical which records the secrets read through it which
+// have leases, so they can be renewed. Each path is read once, so a secret
+// both written to a file and put in the environment has the same value in
+// both.
+type leaseRecorder struct {
+	vaultapi.Logical
+	read    map[string]*api.Secret
+	secrets []*api.Secret
+}
+
+func (l *leaseRecorder) Read(path string) (*api.Secret, error) {
+	if secret, found := l.read[path]; found {
+		return secret, nil
+	}
+	secret, err := l.Logical.Read(path)
+	if err != nil {
+		return nil, err
+	}
+	if secret != nil && secret.LeaseID != "" {
+		l.secrets = append(l.secrets, secret)
+	}
+	l.read[path] = secret
+	return secret, nil
+}
+
+func init() {
+	RootCmd.AddCommand(execCmd)
+	execCmd.Flags().SetInterspersed(false)
+	execCmd.Flags().StringSlice("secret", nil, "secret to inject as path=PREFIX, e.g. secret/app=APP_ (may be repeated)")
+	execCmd.Flags().Bool("files", false, "write secrets to files in a private directory instead, passing their filenames in NAME_FILE")
+	execCmd.Flags().String("tmpdir", "/dev/shm", "directory the private directory is created in")
+}
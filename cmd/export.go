@@ -0,0 +1,91 @@
+// Copyright © 2016 Asteris, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	log "github.com/wrouesnel/go.log"
+	"github.com/wrouesnel/vaultfs/secretset"
+)
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export {vault-path} {directory}",
+	Short: "write the secrets beneath a Vault path into a directory as plain files",
+	Long: `Write every secret beneath a Vault path into a directory, as a directory per
+secret holding a file per key, and exit, or with --watch keep it up to date.
+Files no longer in Vault are removed, so the directory should be dedicated to
+the export, and ideally a tmpfs. This suits init containers and ExecStartPre
+where a persistent mount is more than is needed.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return errors.New("expected exactly two arguments, a Vault path and a target directory")
+		}
+
+		if err := viper.BindPFlags(cmd.Flags()); err != nil {
+			log.WithError(err).Fatal("could not bind flags")
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		mode, err := strconv.ParseUint(viper.GetString("mode"), 8, 32)
+		if err != nil {
+			log.WithField("mode", viper.GetString("mode")).Fatal("mode must be given in octal")
+		}
+		options := secretset.ExportOptions{
+			Mode: os.FileMode(mode) & os.ModePerm,
+			UID:  viper.GetInt("uid"),
+			GID:  viper.GetInt("gid"),
+		}
+
+		backend, err := newBackend()
+		if err != nil {
+			log.WithError(err).Fatal("could not authenticate to vault")
+		}
+
+		interval := viper.GetDuration("watch")
+		for {
+			changed, err := secretset.Export(backend, args[0], args[1], options)
+			switch {
+			case err != nil && interval == 0:
+				log.WithError(err).Fatal("export failed")
+			case err != nil:
+				log.WithError(err).Error("export failed")
+			case changed:
+				log.WithField("path", args[0]).WithField("directory", args[1]).Info("secrets exported")
+			}
+
+			if interval == 0 {
+				return
+			}
+			time.Sleep(interval)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(exportCmd)
+	exportCmd.Flags().String("mode", "0400", "permissions of the exported files, in octal")
+	exportCmd.Flags().Int("uid", -1, "owner of the exported files (default is the current user)")
+	exportCmd.Flags().Int("gid", -1, "group of the exported files (default is the current group)")
+	exportCmd.Flags().Duration("watch", 0, "re-export at this interval, rewriting only what changed, instead of exiting")
+}
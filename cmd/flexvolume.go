@@ -0,0 +1,197 @@
+// Copyright © 2016 Asteris, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// flexVolumeMountTimeout is how long a flexvolume mount waits for the
+// filesystem to appear at the mountpoint.
+const flexVolumeMountTimeout = 30 * time.Second
+
+// flexVolumeSecrets are the keys of the volume's secretRef passed to the mount,
+// with the environment variables they are passed in so they don't appear in
+// its arguments.
+var flexVolumeSecrets = map[string]string{
+	"token":       "TOKEN",
+	"auth-secret": "AUTH_SECRET",
+}
+
+// flexVolumeStatus is the result of a flexvolume call, printed to stdout.
+type flexVolumeStatus struct {
+	Status       string          `json:"status"`
+	Message      string          `json:"message,omitempty"`
+	Capabilities map[string]bool `json:"capabilities,omitempty"`
+}
+
+// flexVolumeCmd represents the flexvolume command
+var flexVolumeCmd = &cobra.Command{
+	Use:   "flexvolume {init|mount mountpoint options|unmount mountpoint}",
+	Short: "act as a kubernetes flexvolume driver",
+	Long: `Implement the kubernetes flexvolume driver calls, to deliver Vault secrets to
+pods as volumes. The volume's options are those of mount.vaultfs, and a
+secretRef may supply the token or auth-secret.
+
+Install vaultfs, or a script running "vaultfs flexvolume", in a vendor~driver
+directory of the kubelet's volume plugin directory.`,
+	DisableFlagParsing: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		status := flexVolume(args)
+		out, _ := json.Marshal(status)
+		fmt.Println(string(out))
+		if status.Status == "Failure" {
+			os.Exit(1)
+		}
+	},
+}
+
+// ExecuteFlexVolume runs vaultfs as a flexvolume driver, when installed as
+// one.
+func ExecuteFlexVolume() {
+	RootCmd.SetArgs(append([]string{"flexvolume"}, os.Args[1:]...))
+	Execute()
+}
+
+// flexVolume handles a flexvolume call.
+func flexVolume(args []string) flexVolumeStatus {
+	if len(args) == 0 {
+		return flexVolumeFailure(fmt.Errorf("expected a driver call"))
+	}
+
+	var err error
+	switch {
+	case args[0] == "init" && len(args) == 1:
+		return flexVolumeStatus{Status: "Success", Capabilities: map[string]bool{"attach": false}}
+	case args[0] == "mount" && len(args) == 3:
+		err = flexVolumeMount(args[1], args[2])
+	case args[0] == "unmount" && len(args) == 2:
+		err = flexVolumeUnmount(args[1])
+	default:
+		return flexVolumeStatus{Status: "Not supported"}
+	}
+	if err != nil {
+		return flexVolumeFailure(err)
+	}
+	return flexVolumeStatus{Status: "Success"}
+}
+
+func flexVolumeFailure(err error) flexVolumeStatus {
+	return flexVolumeStatus{Status: "Failure", Message: err.Error()}
+}
+
+// flexVolumeMount mounts the volume with the JSON options at mountpoint in the
+// background, and waits for it to appear.
+func flexVolumeMount(mountpoint string, optionsJSON string) error {
+	options := map[string]string{}
+	if err := json.Unmarshal([]byte(optionsJSON), &options); err != nil {
+		return fmt.Errorf("invalid options: %s", err)
+	}
+
+	env := os.Environ()
+	mountOptions := []string{}
+	for name, value := range options {
+		if strings.HasPrefix(name, "kubernetes.io/secret/") {
+			key := strings.TrimPrefix(name, "kubernetes.io/secret/")
+			envName, ok := flexVolumeSecrets[key]
+			if !ok {
+				continue
+			}
+			decoded, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return fmt.Errorf("invalid secret %s: %s", key, err)
+			}
+			env = append(env, envName+"="+string(decoded))
+			continue
+		}
+		// Other options set by the kubelet, such as the pod's name and the
+		// filesystem type, have no meaning for vaultfs.
+		if strings.HasPrefix(name, "kubernetes.io/") {
+			continue
+		}
+		mountOptions = append(mountOptions, name+"="+value)
+	}
+	sort.Strings(mountOptions)
+
+	args, err := mountOptionArgs(mountOptions, "", false)
+	if err != nil {
+		return err
+	}
+	args = append(append([]string{"mount", "--daemon"}, args...), mountpoint)
+
+	if err := os.MkdirAll(mountpoint, 0755); err != nil {
+		return err
+	}
+	var output bytes.Buffer
+	mount := exec.Command("/proc/self/exe", args...)
+	mount.Env = env
+	mount.Stdout = &output
+	mount.Stderr = &output
+	if err := mount.Run(); err != nil {
+		return fmt.Errorf("mount failed: %s: %s", err, strings.TrimSpace(output.String()))
+	}
+
+	deadline := time.Now().Add(flexVolumeMountTimeout)
+	for !isMountpoint(mountpoint) {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %s to be mounted", mountpoint)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return nil
+}
+
+// flexVolumeUnmount unmounts the volume at mountpoint as the umount command.
+func flexVolumeUnmount(mountpoint string) error {
+	if !isMountpoint(mountpoint) {
+		return nil
+	}
+
+	output, err := exec.Command("/proc/self/exe", "umount", mountpoint).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("unmount failed: %s: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// isMountpoint reports whether path is mounted over, being on a different
+// device from its parent.
+func isMountpoint(path string) bool {
+	var st, parent syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		// A FUSE mount whose process has died can't be stat'd.
+		return err == syscall.ENOTCONN
+	}
+	if err := syscall.Stat(filepath.Dir(filepath.Clean(path)), &parent); err != nil {
+		return false
+	}
+	return st.Dev != parent.Dev
+}
+
+func init() {
+	RootCmd.AddCommand(flexVolumeCmd)
+}
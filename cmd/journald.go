@@ -0,0 +1,68 @@
+// Copyright © 2016 Asteris, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/coreos/go-systemd/journal"
+	"github.com/sirupsen/logrus"
+	"github.com/wercker/journalhook"
+	"github.com/wrouesnel/go.log"
+)
+
+// journaldFormat is the log-format sending the log to the systemd journal.
+const journaldFormat = "journald"
+
+// logToJournal sends the log to the systemd journal instead of stderr, with
+// the fields of each message as journal fields (e.g. PATH, OP and ERRNO).
+func logToJournal() error {
+	if !journal.Enabled() {
+		return errors.New("the systemd journal is not available")
+	}
+	log.AddHook(&journalHook{identifier: filepath.Base(os.Args[0])})
+	log.SetOutput(ioutil.Discard)
+	return nil
+}
+
+// journalHook sends each message to the journal with journalhook, which makes
+// its fields journal fields, as identifier, and with its source (file.go:123)
+// as the journal's code location.
+type journalHook struct {
+	journalhook.JournalHook
+	identifier string
+}
+
+// Fire implements logrus.Hook
+func (h *journalHook) Fire(entry *logrus.Entry) error {
+	journalEntry := *entry
+	journalEntry.Data = make(logrus.Fields, len(entry.Data)+1)
+	for key, value := range entry.Data {
+		journalEntry.Data[key] = value
+	}
+	journalEntry.Data["SYSLOG_IDENTIFIER"] = h.identifier
+	if source, ok := entry.Data["source"].(string); ok {
+		if i := strings.LastIndex(source, ":"); i >= 0 {
+			delete(journalEntry.Data, "source")
+			journalEntry.Data["CODE_FILE"] = source[:i]
+			journalEntry.Data["CODE_LINE"] = source[i+1:]
+		}
+	}
+	return h.JournalHook.Fire(&journalEntry)
+}
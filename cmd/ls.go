@@ -0,0 +1,67 @@
+// Copyright © 2016 Asteris, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	log "github.com/wrouesnel/go.log"
+)
+
+// lsCmd represents the ls command
+var lsCmd = &cobra.Command{
+	Use:   "ls {path}",
+	Short: "list the keys under a Vault path, without mounting",
+	Long: `List the keys under a Vault path, one per line, through the same backend
+and auth flags as a mount, for debugging connectivity and policies. Keys
+ending in / have further keys beneath them.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("expected exactly one argument, a Vault path")
+		}
+
+		if err := viper.BindPFlags(cmd.Flags()); err != nil {
+			log.WithError(err).Fatal("could not bind flags")
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		backend, err := newBackend()
+		if err != nil {
+			log.WithError(err).Fatal("could not authenticate to vault")
+		}
+
+		secret, err := backend.List(args[0])
+		if err != nil {
+			log.WithError(err).WithField("path", args[0]).Fatal("could not list path")
+		}
+		if secret == nil {
+			log.WithField("path", args[0]).Fatal("path not found")
+		}
+
+		keys, _ := secret.Data["keys"].([]interface{})
+		for _, key := range keys {
+			fmt.Println(key)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(lsCmd)
+}
@@ -16,15 +16,18 @@ package cmd
 
 import (
 	"errors"
+	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"github.com/hashicorp/vault/api"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	log "github.com/wrouesnel/go.log"
-	"github.com/wrouesnel/vaultfs/fs"
+	vaultfs "github.com/wrouesnel/vaultfs/fs"
+	"golang.org/x/net/context"
 )
 
 // mountCmd represents the mount command
@@ -43,21 +46,87 @@ var mountCmd = &cobra.Command{
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
+		if viper.GetBool("daemon") && !isDaemon() {
+			pid, err := daemonize()
+			if err != nil {
+				log.WithError(err).Fatal("could not start daemon")
+			}
+			log.WithField("pid", pid).Info("mounting in the background")
+			return
+		}
+
 		// Read vault config from environment
-		vaultConfig := api.DefaultConfig()
-		if err := vaultConfig.ReadEnvironment(); err != nil {
+		vaultConfig, err := vaultClientConfig(viper.GetViper())
+		if err != nil {
 			log.Fatalln("Error reading vault environment keys:", err)
 		}
 
-		log.Info("Creating FUSE client for Vault server")
+		fs := newMountFS(viper.GetViper(), vaultConfig, args[0])
 
-		fs, err := fs.New(vaultConfig, args[0], viper.GetString("root"),
-			viper.GetString("token"), viper.GetString("auth-method"), viper.GetString("auth-user"),
-			viper.GetString("auth-role"), viper.GetString("auth-secret"))
-		if err != nil {
-			log.WithError(err).Fatal("error creating fs")
+		if address := viper.GetString("debug-listen"); address != "" {
+			serveDebug(address, func() interface{} {
+				return fs.DebugVars(context.Background())
+			})
 		}
 
+		pidFile := viper.GetString("pidfile")
+		if pidFile == "" && viper.GetBool("daemon") {
+			if pidFile, err = defaultPidFile(args[0]); err != nil {
+				log.WithError(err).Fatal("could not place pidfile")
+			}
+		}
+		if pidFile != "" {
+			if err := writePidFile(pidFile); err != nil {
+				log.WithError(err).Fatal("could not write pidfile")
+			}
+			defer os.Remove(pidFile)
+		}
+
+		// dump the request journal and diagnostics on demand
+		go func() {
+			c := make(chan os.Signal, 1)
+			signal.Notify(c, syscall.SIGUSR1)
+
+			for range c {
+				dumpJournal(fs.Journal(), viper.GetString("journal-file"))
+				dumpDiagnostics(fs)
+			}
+		}()
+
+		// reconnect to vault with the rotated client certificate
+		go watchClientCert(viper.GetViper(), func() {
+			vaultConfig, err := vaultClientConfig(viper.GetViper())
+			if err != nil {
+				log.WithError(err).Error("could not read vault configuration")
+				return
+			}
+			reconfigure(fs, viper.GetViper(), vaultConfig)
+		})
+
+		// reconnect to vault with the current config on SIGHUP, or the
+		// control API's reload command
+		fs.SetConfigLoader(func() (*api.Config, vaultfs.Auth, error) {
+			log.Info("reloading configuration")
+			if err := viper.ReadInConfig(); err != nil {
+				log.WithError(err).Warn("could not read config file")
+			}
+			vaultConfig, err := vaultClientConfig(viper.GetViper())
+			if err != nil {
+				return nil, vaultfs.Auth{}, err
+			}
+			return vaultConfig, auth(viper.GetViper()), nil
+		})
+		go func() {
+			c := make(chan os.Signal, 1)
+			signal.Notify(c, syscall.SIGHUP)
+
+			for range c {
+				if err := fs.ReloadConfig(); err != nil {
+					log.WithError(err).Error("could not reconnect to vault, keeping the previous connection")
+				}
+			}
+		}()
+
 		// handle interrupt
 		go func() {
 			c := make(chan os.Signal, 1)
@@ -71,6 +140,9 @@ var mountCmd = &cobra.Command{
 			}
 		}()
 
+		if isDaemon() {
+			fs.SetOnMount(notifyReady)
+		}
 		err = fs.Mount()
 		if err != nil {
 			log.WithError(err).Fatal("could not continue")
@@ -78,7 +150,179 @@ var mountCmd = &cobra.Command{
 	},
 }
 
+// newMountFS creates the filesystem to mount at mountpoint from the mount
+// settings, exiting if they are invalid.
+func newMountFS(settings *viper.Viper, vaultConfig *api.Config, mountpoint string) *vaultfs.VaultFS {
+	log.WithField("mountpoint", mountpoint).Info("Creating FUSE client for Vault server")
+
+	var fs *vaultfs.VaultFS
+	connect := func() error {
+		var err error
+		fs, err = vaultfs.New(fsOptions(settings, vaultConfig, mountpoint))
+		return err
+	}
+	var err error
+	if wait := settings.GetDuration("wait-for-vault"); wait != 0 {
+		err = waitForVault(vaultConfig, wait, connect)
+	} else {
+		err = connect()
+	}
+	if err != nil {
+		log.WithError(err).Fatal("error creating fs")
+	}
+	if err := configureFS(fs, settings); err != nil {
+		log.WithError(err).Fatal("invalid filesystem settings")
+	}
+	if auditLog := settings.GetString("audit-log"); auditLog != "" {
+		w, err := openAuditLog(auditLog)
+		if err != nil {
+			log.WithError(err).Fatal("could not open audit log")
+		}
+		fs.SetAuditLog(w)
+	}
+	if sink := settings.GetString("token-sink"); sink != "" {
+		fs.SetTokenSink(sink)
+	}
+
+	if socketPath := settings.GetString("control-socket"); socketPath != "" {
+		if err := fs.ServeControl(socketPath); err != nil {
+			log.WithError(err).Fatal("could not serve control socket")
+		}
+	}
+	return fs
+}
+
+// fsOptions returns the options of the filesystem to mount at mountpoint, from
+// the global and filesystem settings. They are shared by every frontend: the
+// mount, nfs and docker commands.
+func fsOptions(settings *viper.Viper, vaultConfig *api.Config, mountpoint string) vaultfs.Options {
+	return vaultfs.Options{
+		Vault:       vaultConfig,
+		Mountpoint:  mountpoint,
+		Root:        settings.GetString("root"),
+		Auth:        auth(settings),
+		Cache:       cacheConfig(settings),
+		Limits:      limitConfig(settings),
+		Breaker:     breakerConfig(settings),
+		SizeLimits:  sizeLimits(settings),
+		JournalSize: settings.GetInt("journal-size"),
+		Format:      settings.GetString("format"),
+		Include:     settings.GetStringSlice("include"),
+		Exclude:     settings.GetStringSlice("exclude"),
+		DenyList:    settings.GetStringSlice("deny"),
+		Writable:    !settings.GetBool("read-only"),
+	}
+}
+
+// configureFS applies the filesystem settings beyond its options to fs, as
+// added to a command by addFilesystemFlags.
+func configureFS(fs *vaultfs.VaultFS, settings *viper.Viper) error {
+	fs.SetCacheTimeouts(settings.GetDuration("attr-timeout"), settings.GetDuration("entry-timeout"))
+	fs.SetFixedFileSize(uint64(settings.GetInt64("fixed-file-size")))
+	fs.SetJSONView(settings.GetBool("json-view"))
+	fs.SetDirectIO(settings.GetBool("direct-io"))
+	fs.SetKVSubkeys(settings.GetBool("kv-subkeys"))
+	if err := fs.SetSecretEntries(settings.GetStringSlice("secret-entries")); err != nil {
+		return fmt.Errorf("invalid secret entries: %v", err)
+	}
+	if err := fs.SetEngineMounts(engineMounts(settings)); err != nil {
+		return fmt.Errorf("invalid secrets engine mounts: %v", err)
+	}
+	if err := fs.SetAliases(aliases(settings)); err != nil {
+		return fmt.Errorf("invalid aliases: %v", err)
+	}
+	fs.SetUnionRoots(settings.GetStringSlice("union-root"))
+	fs.SetWritablePaths(settings.GetStringSlice("writable"))
+	if err := fs.SetBase64Keys(settings.GetStringSlice("base64-keys")); err != nil {
+		return fmt.Errorf("invalid base64 keys: %v", err)
+	}
+	fs.SetWrapTTL(settings.GetDuration("wrap-ttl"))
+	if err := fs.SetTemplates(templates(settings)); err != nil {
+		return fmt.Errorf("invalid templates: %v", err)
+	}
+	fs.SetCapabilityModes(settings.GetBool("capability-modes"))
+	fs.SetOwner(uint32(settings.GetInt("uid")), uint32(settings.GetInt("gid")))
+	mountOptions, err := vaultfs.ParseMountOptions(settings.GetStringSlice("options"))
+	if err != nil {
+		return fmt.Errorf("invalid mount options: %v", err)
+	}
+	fs.SetMountOptions(mountOptions)
+	if tokenDir := settings.GetString("tenant-token-dir"); tokenDir != "" {
+		fs.SetTenantTokenDir(tokenDir)
+	}
+	if eventType := settings.GetString("vault-events"); eventType != "" {
+		fs.SetEventSubscription(eventType)
+	}
+
+	prefetch, err := prefetchPaths(settings)
+	if err != nil {
+		return fmt.Errorf("could not read prefetch paths: %v", err)
+	}
+	fs.SetPrefetch(prefetch)
+	fs.SetPrefetchChildren(settings.GetBool("prefetch-children"))
+	fs.SetSlowOpThreshold(settings.GetDuration("log-slow-ops"))
+	if address := settings.GetString("statsd-address"); address != "" {
+		if err := fs.SetStatsd(vaultfs.StatsdConfig{
+			Address:  address,
+			Prefix:   settings.GetString("statsd-prefix"),
+			Tags:     settings.GetStringSlice("statsd-tags"),
+			Interval: settings.GetDuration("statsd-interval"),
+		}); err != nil {
+			return fmt.Errorf("invalid statsd settings: %v", err)
+		}
+	}
+	return nil
+}
+
+// addFilesystemFlags adds the flags of how the filesystem presents Vault to
+// cmd, read by fsOptions and configureFS.
+func addFilesystemFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSlice("union-root", nil, "further root paths to overlay on the root, each shadowing the root and those before it")
+	cmd.Flags().Duration("attr-timeout", vaultfs.DefaultAttrTimeout, "how long the kernel may cache file attributes")
+	cmd.Flags().Duration("entry-timeout", vaultfs.DefaultEntryTimeout, "how long the kernel may cache directory entries, and vaultfs remembers paths found not to exist")
+	cmd.Flags().Int64("fixed-file-size", 0, "report this size for every file instead of the length of its value (0 reports the actual size)")
+	cmd.Flags().String("format", vaultfs.FormatFull, "how secrets are presented: full (the whole API response) or data (only the data keys, as files)")
+	cmd.Flags().StringSlice("secret-entries", nil, "entries exposed in each secret directory (default all of lease_id,lease_duration,renewable,warnings,data,auth,wrap_info)")
+	cmd.Flags().StringSlice("engine", nil, "path=type of a secrets engine mount to expose through specialised files (one of "+strings.Join(vaultfs.EngineTypes(), ", ")+"); defaults to the engines' default paths")
+	cmd.Flags().StringSlice("include", nil, "glob patterns of the Vault paths to expose (default all)")
+	cmd.Flags().StringSlice("exclude", nil, "glob patterns of Vault paths never to expose, e.g. secret/admin")
+	cmd.Flags().StringSlice("deny", nil, "glob patterns of Vault API paths never to request, whatever the token may access, e.g. sys/*,auth/token/*,secret/data/admin/*")
+	cmd.Flags().StringSlice("alias", nil, "path=vault/path to expose a Vault path at path in the mount, e.g. app1=secret/data/teams/payments/app1")
+	cmd.Flags().Bool("read-only", true, "never write to or delete from Vault, and mount the filesystem read-only, whatever the other settings (--read-only=false enables writing)")
+	cmd.Flags().StringSlice("writable", vaultfs.DefaultWritablePaths, "Vault paths under which secret data can be written through the filesystem")
+	cmd.Flags().StringSlice("base64-keys", vaultfs.DefaultBase64Keys, "glob patterns of data keys (or secret/path/key) whose values are base64-decoded before being served")
+	cmd.Flags().StringSlice("template", nil, "path=file of a Go template to render as .templates/path, using {{ secret \"path\" \"key\" }} to insert values")
+	cmd.Flags().Duration("wrap-ttl", vaultfs.DefaultWrapTTL, "TTL of the wrapping tokens returned by .wrap files")
+	cmd.Flags().StringSliceP("options", "o", nil, "fuse mount options, e.g. allow_other,default_permissions,ro")
+	cmd.Flags().Int("uid", os.Getuid(), "owner of every file and directory (default is the mounting user)")
+	cmd.Flags().Int("gid", os.Getgid(), "group of every file and directory (default is the mounting user's group)")
+	cmd.Flags().Bool("capability-modes", false, "derive file modes from the token's capabilities on each path (costs an extra request per path)")
+	cmd.Flags().Bool("json-view", false, "add a secret.json file holding the whole secret as JSON to every secret")
+	cmd.Flags().Bool("direct-io", false, "open files with direct IO so that the kernel page cache never serves a stale value, at the cost of every read reaching vaultfs")
+	cmd.Flags().Int("journal-size", 0, "number of recent Vault requests to record for dumping with SIGUSR1 (0 disables)")
+	cmd.Flags().Bool("kv-subkeys", false, "list KV v2 secrets in the data format from the subkeys endpoint (Vault 1.10+), without reading their values")
+	cmd.Flags().String("vault-events", "", "subscribe to Vault events of this type (e.g. kv*) to invalidate cached secrets when they change")
+	cmd.Flags().String("tenant-token-dir", "", "make requests with the token of the requesting user, read from the file named by their uid in this directory (users without one are denied)")
+	cmd.Flags().String("prefetch", "", "file listing paths (one per line) to read into the cache as soon as mounted")
+	cmd.Flags().StringSlice("prefetch-paths", nil, "paths to read into the cache as soon as mounted")
+	cmd.Flags().Bool("prefetch-children", false, "look up the children of each listed directory concurrently in the background, to speed up ls -l and tree (needs a cache)")
+	cmd.Flags().String("statsd-address", "", "host:port of a statsd server to send operation, request and cache metrics to over UDP (disabled by default)")
+	cmd.Flags().String("statsd-prefix", "vaultfs.", "prefix of the names of the metrics sent to statsd")
+	cmd.Flags().StringSlice("statsd-tags", nil, "tags of every metric sent to statsd, e.g. env:prod (needs a DogStatsD-compatible server)")
+	cmd.Flags().Duration("statsd-interval", vaultfs.DefaultStatsdInterval, "interval between reports of the counters to statsd")
+	cmd.Flags().Duration("log-slow-ops", 0, "log a warning for each filesystem operation taking longer than this, e.g. 500ms (0 disables)")
+}
+
 func init() {
 	RootCmd.AddCommand(mountCmd)
 	mountCmd.Flags().StringP("root", "r", "secret", "list of root paths to mount")
+	addFilesystemFlags(mountCmd)
+	mountCmd.Flags().String("journal-file", "", "file to dump the request journal to on SIGUSR1 (default is to log it)")
+	mountCmd.Flags().String("audit-log", "", "file to write an audit log of accesses to secrets to, with the uid and pid behind each (or \"syslog\")")
+	mountCmd.Flags().String("control-socket", "", "unix socket to serve the control API on, for vaultfs ctl")
+	mountCmd.Flags().Duration("wait-for-vault", 0, "retry connecting at startup while vault is unreachable or sealed, for up to this long (without a value, indefinitely)")
+	mountCmd.Flags().Lookup("wait-for-vault").NoOptDefVal = waitForeverFlag
+	mountCmd.Flags().Bool("daemon", false, "mount in the background, with a pidfile for vaultfs umount (log to syslog or journald, as output is discarded)")
+	mountCmd.Flags().String("pidfile", "", "file to write the pid of the mount process to (default with --daemon is derived from the mountpoint)")
+	mountCmd.Flags().String("token-sink", "", "file to write the current (renewed) Vault token to for use by other tools")
 }
@@ -0,0 +1,146 @@
+// Copyright © 2016 Asteris, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	vaultfs "github.com/wrouesnel/vaultfs/fs"
+)
+
+// mountHelperSpecPrefix prefixes the root path in the device of mount
+// helper invocations, e.g. vault:secret.
+const mountHelperSpecPrefix = "vault:"
+
+// mountHelperIgnored are generic mount(8) options with no meaning for vaultfs.
+var mountHelperIgnored = map[string]bool{
+	"rw": true, "defaults": true, "auto": true, "noauto": true,
+	"user": true, "users": true, "nouser": true, "owner": true, "group": true,
+	"_netdev": true, "nofail": true, "exec": true, "noexec": true,
+	"suid": true, "nosuid": true, "dev": true, "nodev": true,
+	"atime": true, "noatime": true, "relatime": true,
+}
+
+// ExecuteMountHelper runs vaultfs as a mount(8) helper (mount.vaultfs), called
+// as "mount.vaultfs vault:root mountpoint [-sfnv] [-o options]" for fstab
+// entries, autofs maps and systemd mount units. Options naming flags of the
+// mount command are passed as flags, and the rest as FUSE mount options. The
+// filesystem is mounted in the background.
+func ExecuteMountHelper() {
+	args, fake, err := mountHelperArgs(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "mount.vaultfs:", err)
+		os.Exit(1)
+	}
+	if fake {
+		return
+	}
+
+	RootCmd.SetArgs(args)
+	Execute()
+}
+
+// mountHelperArgs converts mount helper arguments into arguments of the mount
+// command, also reporting whether -f (fake) was given.
+func mountHelperArgs(helperArgs []string) ([]string, bool, error) {
+	var positional, options []string
+	var sloppy, fake bool
+	for i := 0; i < len(helperArgs); i++ {
+		arg := helperArgs[i]
+		switch {
+		case arg == "-o":
+			if i+1 == len(helperArgs) {
+				return nil, false, fmt.Errorf("-o requires options")
+			}
+			i++
+			options = append(options, strings.Split(helperArgs[i], ",")...)
+		case strings.HasPrefix(arg, "-o"):
+			options = append(options, strings.Split(arg[2:], ",")...)
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			// Combined flags such as -sv. -n is meaningless as there is no
+			// mtab entry, and -v only affects mount(8) itself.
+			sloppy = sloppy || strings.Contains(arg, "s")
+			fake = fake || strings.Contains(arg, "f")
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	if len(positional) != 2 {
+		return nil, false, fmt.Errorf("usage: mount.vaultfs %sroot mountpoint [-sfnv] [-o options]", mountHelperSpecPrefix)
+	}
+	spec, mountpoint := positional[0], positional[1]
+
+	args, err := mountOptionArgs(options, strings.TrimPrefix(spec, mountHelperSpecPrefix), sloppy)
+	if err != nil {
+		return nil, false, err
+	}
+	args = append([]string{"mount", "--daemon"}, args...)
+	args = append(args, mountpoint)
+
+	return args, fake, nil
+}
+
+// mountOptionArgs converts mount options into flags of the mount command, for
+// mounting root. Options naming flags of the mount command or global flags are
+// passed as flags, and the rest as FUSE mount options. Options which aren't
+// understood are dropped if sloppy.
+func mountOptionArgs(options []string, root string, sloppy bool) ([]string, error) {
+	args := []string{}
+	fuseOptions := []string{}
+	for _, option := range options {
+		if option == "" || mountHelperIgnored[option] || strings.HasPrefix(option, "x-") || strings.HasPrefix(option, "comment=") {
+			continue
+		}
+
+		name, value, hasValue := option, "", false
+		if idx := strings.Index(option, "="); idx >= 0 {
+			name, value, hasValue = option[:idx], option[idx+1:], true
+		}
+
+		flag := mountCmd.Flags().Lookup(strings.Replace(name, "_", "-", -1))
+		if flag == nil {
+			flag = RootCmd.PersistentFlags().Lookup(strings.Replace(name, "_", "-", -1))
+		}
+		switch {
+		case flag == nil:
+			// Sloppy mounts drop options which aren't understood.
+			if _, err := vaultfs.ParseMountOptions([]string{option}); err != nil && sloppy {
+				continue
+			}
+			fuseOptions = append(fuseOptions, option)
+		case flag.Name == "root":
+			root = value
+		case flag.Name == "options" || flag.Name == "daemon":
+			if !sloppy {
+				return nil, fmt.Errorf("option %s can't be given here", name)
+			}
+		case !hasValue && flag.NoOptDefVal != "":
+			args = append(args, "--"+flag.Name)
+		case !hasValue:
+			return nil, fmt.Errorf("option %s requires a value", name)
+		default:
+			args = append(args, "--"+flag.Name+"="+value)
+		}
+	}
+	if root != "" {
+		args = append(args, "--root="+root)
+	}
+	if len(fuseOptions) > 0 {
+		args = append(args, "-o", strings.Join(fuseOptions, ","))
+	}
+	return args, nil
+}
@@ -0,0 +1,156 @@
+// Copyright © 2016 Asteris, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	fusefs "bazil.org/fuse/fs"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	log "github.com/wrouesnel/go.log"
+	vaultfs "github.com/wrouesnel/vaultfs/fs"
+	"github.com/wrouesnel/vaultfs/nfs"
+)
+
+// nfsCmd represents the nfs command
+var nfsCmd = &cobra.Command{
+	Use:   "nfs",
+	Short: "serve the vault FS read-only over NFSv3 instead of mounting it",
+	Long: `Serve the filesystem a mount would present read-only over NFSv3 on TCP, for
+systems which can't run FUSE, to the clients allowed by --allow. It takes the
+filesystem settings of the mount command. There is no portmapper, so clients
+mount with the port given:
+
+  mount -t nfs -o vers=3,proto=tcp,port=2049,mountport=2049,nolock host:/ /mnt
+
+Requests are made as the uid and gid the client sends, which matters with
+--tenant-token-dir, so only trusted clients should be allowed.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 0 {
+			return errors.New("expected no arguments")
+		}
+
+		if err := viper.BindPFlags(cmd.Flags()); err != nil {
+			log.WithError(err).Fatal("could not bind flags")
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		allowed, err := allowedNetworks(viper.GetStringSlice("allow"))
+		if err != nil {
+			log.WithError(err).Fatal("invalid allowed clients")
+		}
+
+		vaultConfig, err := vaultClientConfig(viper.GetViper())
+		if err != nil {
+			log.Fatalln("Error reading vault environment keys:", err)
+		}
+		fs := newMountFS(viper.GetViper(), vaultConfig, "")
+
+		listener, err := net.Listen("tcp", viper.GetString("listen"))
+		if err != nil {
+			log.WithError(err).Fatal("could not listen")
+		}
+
+		// reconnect to vault with the current config on SIGHUP
+		go func() {
+			c := make(chan os.Signal, 1)
+			signal.Notify(c, syscall.SIGHUP)
+
+			for range c {
+				log.Info("reloading configuration")
+				if err := viper.ReadInConfig(); err != nil {
+					log.WithError(err).Warn("could not read config file")
+				}
+				vaultConfig, err := vaultClientConfig(viper.GetViper())
+				if err != nil {
+					log.WithError(err).Error("could not read vault configuration")
+					continue
+				}
+				reconfigure(fs, viper.GetViper(), vaultConfig)
+			}
+		}()
+
+		// handle interrupt
+		go func() {
+			c := make(chan os.Signal, 1)
+			signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+
+			<-c
+			log.Info("stopping")
+			listener.Close()
+		}()
+
+		err = fs.Serve(func(root fusefs.Node) error {
+			server := nfs.NewServer(root, vaultfs.CallerContext, allowed)
+			defer server.Close()
+
+			log.WithField("address", listener.Addr()).WithField("allow", allowed).Info("serving nfs")
+			err := server.Serve(listener)
+			if opErr, ok := err.(*net.OpError); ok && strings.Contains(opErr.Err.Error(), "use of closed network connection") {
+				return nil
+			}
+			return err
+		})
+		if err != nil {
+			log.WithError(err).Fatal("could not continue")
+		}
+	},
+}
+
+// allowedNetworks parses the allowed clients, given as addresses or CIDR
+// networks.
+func allowedNetworks(clients []string) ([]*net.IPNet, error) {
+	networks := []*net.IPNet{}
+	for _, client := range clients {
+		if !strings.Contains(client, "/") {
+			ip := net.ParseIP(client)
+			if ip == nil {
+				return nil, errors.New("invalid address: " + client)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(client)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func init() {
+	RootCmd.AddCommand(nfsCmd)
+	nfsCmd.Flags().StringP("root", "r", "secret", "list of root paths to serve")
+	addFilesystemFlags(nfsCmd)
+	nfsCmd.Flags().String("audit-log", "", "file to write an audit log of accesses to secrets to, with the uid behind each (or \"syslog\")")
+	nfsCmd.Flags().Duration("wait-for-vault", 0, "retry connecting at startup while vault is unreachable or sealed, for up to this long (without a value, indefinitely)")
+	nfsCmd.Flags().Lookup("wait-for-vault").NoOptDefVal = waitForeverFlag
+	nfsCmd.Flags().String("token-sink", "", "file to write the current (renewed) Vault token to for use by other tools")
+	nfsCmd.Flags().String("listen", ":2049", "address to serve NFS on")
+	nfsCmd.Flags().StringSlice("allow", []string{"127.0.0.1", "::1"}, "addresses or CIDR networks of the clients allowed to connect")
+}
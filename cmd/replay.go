@@ -0,0 +1,104 @@
+// Copyright © 2016 Asteris, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	log "github.com/wrouesnel/go.log"
+	"github.com/wrouesnel/vaultfs/vaultapi"
+)
+
+// replayCmd represents the replay command
+var replayCmd = &cobra.Command{
+	Use:   "replay {journal-file}",
+	Short: "re-issue the read and list requests of a dumped request journal against Vault",
+	Long: `Re-issue the read and list requests of a request journal dumped with
+SIGUSR1 against a (test) Vault server, printing the original and replayed
+status and timing of each. Writes, deletes and unwraps are never replayed.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("expected exactly one argument, a journal file")
+		}
+
+		if err := viper.BindPFlags(cmd.Flags()); err != nil {
+			log.WithError(err).Fatal("could not bind flags")
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		f, err := os.Open(args[0])
+		if err != nil {
+			log.WithError(err).Fatal("could not open journal")
+		}
+		entries, err := vaultapi.ReadJournal(f)
+		f.Close()
+		if err != nil {
+			log.WithError(err).Fatal("could not read journal")
+		}
+
+		backend, err := newBackend()
+		if err != nil {
+			log.WithError(err).Fatal("could not authenticate to vault")
+		}
+
+		preserveTiming := viper.GetBool("preserve-timing")
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "METHOD\tPATH\tRECORDED\t\tREPLAYED\t")
+
+		var recordedTotal, replayedTotal time.Duration
+		for i, entry := range entries {
+			if preserveTiming && i > 0 {
+				time.Sleep(entry.Time.Sub(entries[i-1].Time))
+			}
+
+			var replay func(string) (*api.Secret, error)
+			switch entry.Method {
+			case vaultapi.JournalRead:
+				replay = backend.Read
+			case vaultapi.JournalList:
+				replay = backend.List
+			default:
+				fmt.Fprintf(w, "%s\t%s\t%s\t%v\tskipped\t\n", entry.Method, entry.Path, entry.Status, entry.Duration)
+				continue
+			}
+
+			start := time.Now()
+			secret, err := replay(entry.Path)
+			duration := time.Since(start)
+
+			recordedTotal += entry.Duration
+			replayedTotal += duration
+			fmt.Fprintf(w, "%s\t%s\t%s\t%v\t%s\t%v\n", entry.Method, entry.Path,
+				entry.Status, entry.Duration, vaultapi.JournalRequestStatus(secret, err), duration)
+		}
+		fmt.Fprintf(w, "total\t\t\t%v\t\t%v\n", recordedTotal, replayedTotal)
+		w.Flush()
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(replayCmd)
+	replayCmd.Flags().Bool("preserve-timing", false, "wait between requests as long as was recorded in the journal")
+}
@@ -16,6 +16,8 @@ package cmd
 
 import (
 	"os"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -50,14 +52,45 @@ func init() {
 
 	// logging flags
 	RootCmd.PersistentFlags().String("log-level", "info", "log level (one of fatal, error, warn, info, or debug)")
-	RootCmd.PersistentFlags().String("log-format", "stderr:", "log format. Defaults to stderr:. Example: logger:syslog?appname=bob&local=7 or logger:stdout?json=true.")
+	RootCmd.PersistentFlags().String("log-format", "logger:stderr", "log format: logger:stderr or logger:stdout, with ?json=true for JSON, or journald to send structured entries to the systemd journal")
+	RootCmd.PersistentFlags().String("debug-listen", "", "address (e.g. localhost:6060) to serve net/http/pprof and an expvar dump of the cache and auth state on, for diagnosing a running mount (disabled by default; don't expose it)")
+	RootCmd.PersistentFlags().Bool("log-sanitize", false, "hash each component of the Vault paths logged (secret values are never logged)")
+	RootCmd.PersistentFlags().Bool("disable-mlock", false, "do not lock memory with mlockall to keep secrets out of swap, as with Vault's own disable_mlock (for systems where it isn't permitted)")
 
+	RootCmd.PersistentFlags().StringSlice("vault-address", nil, "addresses of the nodes of the Vault cluster, failed over between when unreachable, or a unix:///path/to/socket (default $VAULT_ADDR, which may also list several separated by commas)")
+	RootCmd.PersistentFlags().String("vault-proxy", "", "http://, https:// or socks5:// proxy to connect to Vault through (default $HTTPS_PROXY or $HTTP_PROXY, subject to $NO_PROXY)")
+	RootCmd.PersistentFlags().String("vault-discovery", "", "discover the Vault nodes from consul:<service> (through $CONSUL_HTTP_ADDR) or srv:<dns name> instead of using a fixed address, again whenever they can't be reached")
+	RootCmd.PersistentFlags().String("vault-discovery-scheme", "https", "scheme (http or https) of the discovered Vault nodes")
+	RootCmd.PersistentFlags().String("ca-cert", "", "PEM-encoded CA certificate file to verify the Vault server with (default $VAULT_CACERT)")
+	RootCmd.PersistentFlags().String("ca-path", "", "directory of PEM-encoded CA certificates to verify the Vault server with (default $VAULT_CAPATH)")
+	RootCmd.PersistentFlags().String("client-cert", "", "PEM-encoded client certificate file for TLS (and cert auth) with Vault (default $VAULT_CLIENT_CERT)")
+	RootCmd.PersistentFlags().String("client-key", "", "PEM-encoded private key file of the client certificate (default $VAULT_CLIENT_KEY)")
+	RootCmd.PersistentFlags().Duration("client-cert-reload-interval", time.Minute, "how often to check the client certificate and key files for changes, reconnecting (and re-authenticating) when they are rotated (0 disables)")
+	RootCmd.PersistentFlags().String("tls-server-name", "", "server name to use for SNI when connecting to Vault (default $VAULT_TLS_SERVER_NAME)")
+	RootCmd.PersistentFlags().Bool("tls-skip-verify", false, "do not verify the Vault server's certificate (insecure; default $VAULT_SKIP_VERIFY)")
+	RootCmd.PersistentFlags().String("agent-addr", "", "address of a local Vault Agent (with use_auto_auth_token) to make every request through, using its token instead of authenticating")
 	RootCmd.PersistentFlags().String("auth-method", "", "authentication method to use if no token provided (supported: cert,ldap,approle)")
 	RootCmd.PersistentFlags().String("auth-user", "", "username to use for the specified authentication method (if supported)")
-	RootCmd.PersistentFlags().String("auth-role", "", "approle to use for the specified authentication method (if supported)")
+	RootCmd.PersistentFlags().String("auth-role", "", "approle, or cert role name, to use for the specified authentication method (if supported)")
 	RootCmd.PersistentFlags().String("auth-secret", "", "password or secret to use for an authentication method (if supported by auth-method)")
 	RootCmd.PersistentFlags().StringP("token", "t", "", "The Vault Server token (optional if using certificate auth)")
 
+	// cache flags
+	RootCmd.PersistentFlags().Duration("cache-ttl", 0, "how long Vault responses are cached (0 disables the cache)")
+	RootCmd.PersistentFlags().Int("cache-max-entries", 0, "maximum number of cached Vault responses (0 for unlimited)")
+	RootCmd.PersistentFlags().Duration("cache-stale-while-revalidate", 0, "window past cache-ttl in which stale responses are served while being refreshed")
+	RootCmd.PersistentFlags().Duration("max-staleness", 0, "maximum age of any value served from the cache before it is revalidated with Vault (0 for no bound)")
+	RootCmd.PersistentFlags().Duration("cache-negative-ttl", 0, "how long not-found responses are cached (0 disables negative caching)")
+	RootCmd.PersistentFlags().String("disk-cache-dir", "", "directory to keep the last responses from Vault in, encrypted, to serve (read-only) while Vault is unreachable")
+	RootCmd.PersistentFlags().String("disk-cache-key-file", "", "file to derive the disk cache encryption key from (default is a random key kept in --disk-cache-dir)")
+	RootCmd.PersistentFlags().Int("max-concurrent-requests", 0, "maximum number of requests in flight to Vault at once, outside the qos classes of the config file (0 for unlimited)")
+	RootCmd.PersistentFlags().Float64("rate-limit", 0, "maximum requests per second to Vault, outside the qos classes of the config file (0 for unlimited)")
+	RootCmd.PersistentFlags().Int("rate-limit-burst", 1, "number of requests which may exceed rate-limit at once")
+	RootCmd.PersistentFlags().Int("max-value-size", 0, "maximum size in bytes of a secret value; secrets holding larger ones fail with EFBIG (0 for unlimited)")
+	RootCmd.PersistentFlags().Int("max-dir-entries", 0, "maximum number of entries in a directory; larger directories fail with EFBIG (0 for unlimited)")
+	RootCmd.PersistentFlags().Int("breaker-threshold", 0, "number of consecutive failed requests (unreachable or 5xx) after which requests to Vault fail at once for breaker-cooldown (0 disables)")
+	RootCmd.PersistentFlags().Duration("breaker-cooldown", 30*time.Second, "how long requests to Vault fail at once after breaker-threshold failures")
+
 	if err := viper.BindPFlags(RootCmd.PersistentFlags()); err != nil {
 		log.WithError(err).Fatal("could not bind flags")
 	}
@@ -73,6 +106,8 @@ func initConfig() {
 	viper.AddConfigPath("/etc/vaultfs") // adding sysconfig as the first search path
 	viper.AddConfigPath("$HOME")        // home directory as another path
 	viper.AutomaticEnv()                // read in environment variables that match
+	// e.g. AUTH_METHOD for auth-method, as set for the docker managed plugin.
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
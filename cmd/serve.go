@@ -0,0 +1,213 @@
+// Copyright © 2016 Asteris, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	log "github.com/wrouesnel/go.log"
+	vaultfs "github.com/wrouesnel/vaultfs/fs"
+	"github.com/wrouesnel/vaultfs/vaultapi"
+	"golang.org/x/net/context"
+)
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "mount every filesystem listed in the config file",
+	Long: `Mount every filesystem listed under mounts in the config file from a
+single process. Each mount takes the settings of the mount command (e.g.
+mountpoint, root, token, auth-method, cache-ttl, format) plus an optional Vault
+address, and inherits any it doesn't set from the top level of the config
+file:
+
+  cache-ttl: 30s
+  mounts:
+    - mountpoint: /run/secrets/app
+      root: secret/app
+    - mountpoint: /run/secrets/db
+      root: database
+      auth-method: approle
+      auth-role: db-reader`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 0 {
+			return errors.New("expected no arguments")
+		}
+
+		if err := viper.BindPFlags(cmd.Flags()); err != nil {
+			log.WithError(err).Fatal("could not bind flags")
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		mounts := []map[string]interface{}{}
+		if err := viper.UnmarshalKey("mounts", &mounts); err != nil {
+			log.WithError(err).Fatal("invalid mounts configuration")
+		}
+		if len(mounts) == 0 {
+			log.Fatal("no mounts defined in config")
+		}
+
+		filesystems := []*vaultfs.VaultFS{}
+		journalFiles := []string{}
+		for i, mount := range mounts {
+			settings := mountSettings(mount)
+			mountpoint := settings.GetString("mountpoint")
+			if mountpoint == "" {
+				log.WithField("mount", i).Fatal("mount has no mountpoint")
+			}
+
+			vaultConfig, err := mountVaultConfig(settings)
+			if err != nil {
+				log.Fatalln("Error reading vault environment keys:", err)
+			}
+
+			fs := newMountFS(settings, vaultConfig, mountpoint)
+			filesystems = append(filesystems, fs)
+
+			// reconnect to vault with the rotated client certificate
+			go watchClientCert(settings, func() {
+				vaultConfig, err := mountVaultConfig(settings)
+				if err != nil {
+					log.WithError(err).Error("could not read vault configuration")
+					return
+				}
+				reconfigure(fs, settings, vaultConfig)
+			})
+			journalFiles = append(journalFiles, settings.GetString("journal-file"))
+		}
+
+		if address := viper.GetString("debug-listen"); address != "" {
+			serveDebug(address, func() interface{} {
+				vars := []interface{}{}
+				for _, fs := range filesystems {
+					vars = append(vars, fs.DebugVars(context.Background()))
+				}
+				return vars
+			})
+		}
+
+		// dump the request journals and diagnostics on demand
+		go func() {
+			c := make(chan os.Signal, 1)
+			signal.Notify(c, syscall.SIGUSR1)
+
+			for range c {
+				for i, fs := range filesystems {
+					dumpJournal(fs.Journal(), journalFiles[i])
+				}
+				dumpDiagnostics(filesystems...)
+			}
+		}()
+
+		// reconnect to vault with the current config on SIGHUP. Mounts
+		// can't be added or removed without restarting.
+		go func() {
+			c := make(chan os.Signal, 1)
+			signal.Notify(c, syscall.SIGHUP)
+
+			for range c {
+				log.Info("reloading configuration")
+				if err := viper.ReadInConfig(); err != nil {
+					log.WithError(err).Warn("could not read config file")
+				}
+				reloaded := []map[string]interface{}{}
+				if err := viper.UnmarshalKey("mounts", &reloaded); err != nil || len(reloaded) != len(filesystems) {
+					log.WithError(err).Error("mounts changed, restart to apply")
+					continue
+				}
+				for i, fs := range filesystems {
+					settings := mountSettings(reloaded[i])
+					vaultConfig, err := mountVaultConfig(settings)
+					if err != nil {
+						log.WithError(err).Error("could not read vault configuration")
+						continue
+					}
+					reconfigure(fs, settings, vaultConfig)
+				}
+			}
+		}()
+
+		// handle interrupt
+		go func() {
+			c := make(chan os.Signal, 1)
+			signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+
+			<-c
+			log.Info("stopping")
+			for _, fs := range filesystems {
+				if err := fs.Unmount(); err != nil {
+					log.WithError(err).Error("could not unmount cleanly")
+				}
+			}
+		}()
+
+		// A mount failing doesn't stop the others.
+		var wg sync.WaitGroup
+		for i, fs := range filesystems {
+			wg.Add(1)
+			go func(fs *vaultfs.VaultFS, settings map[string]interface{}) {
+				defer wg.Done()
+				if err := fs.Mount(); err != nil {
+					log.WithError(err).WithField("mountpoint", settings["mountpoint"]).Error("mount failed")
+				}
+			}(fs, mounts[i])
+		}
+		wg.Wait()
+	},
+}
+
+// mountVaultConfig builds the Vault client configuration of a mount, which
+// may set its own address.
+func mountVaultConfig(settings *viper.Viper) (*api.Config, error) {
+	if address := settings.GetString("address"); address != "" {
+		settings.Set("vault-address", vaultapi.SplitAddresses(address))
+	}
+	return vaultClientConfig(settings)
+}
+
+// mountSettings returns the settings of a mount from the config file, with
+// the defaults of the mount command and the top level config for anything the
+// mount doesn't set.
+func mountSettings(mount map[string]interface{}) *viper.Viper {
+	settings := viper.New()
+	for _, flags := range []*pflag.FlagSet{mountCmd.Flags(), RootCmd.PersistentFlags()} {
+		if err := settings.BindPFlags(flags); err != nil {
+			log.WithError(err).Fatal("could not bind flags")
+		}
+	}
+	for _, key := range viper.AllKeys() {
+		if key != "mounts" {
+			settings.SetDefault(key, viper.Get(key))
+		}
+	}
+	for key, value := range mount {
+		settings.Set(key, value)
+	}
+	return settings
+}
+
+func init() {
+	RootCmd.AddCommand(serveCmd)
+}
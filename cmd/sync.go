@@ -0,0 +1,77 @@
+// Copyright © 2016 Asteris, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	log "github.com/wrouesnel/go.log"
+)
+
+// syncCmd represents the sync command
+var syncCmd = &cobra.Command{
+	Use:   "sync {directory}",
+	Short: "write the secrets listed in the config file into a directory",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("expected exactly one argument, a target directory")
+		}
+
+		if err := viper.BindPFlags(cmd.Flags()); err != nil {
+			log.WithError(err).Fatal("could not bind flags")
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		spec, err := loadSecretSpec()
+		if err != nil {
+			log.WithError(err).Fatal("invalid secret spec")
+		}
+		if len(spec.Secrets) == 0 {
+			log.Fatal("no secrets defined in config")
+		}
+
+		backend, err := newBackend()
+		if err != nil {
+			log.WithError(err).Fatal("could not authenticate to vault")
+		}
+
+		interval := viper.GetDuration("interval")
+		for {
+			if err := spec.Materialize(backend, args[0]); err != nil {
+				if interval == 0 {
+					log.WithError(err).Fatal("sync failed")
+				}
+				log.WithError(err).Error("sync failed")
+			} else {
+				log.WithField("directory", args[0]).Info("secrets synced")
+			}
+
+			if interval == 0 {
+				return
+			}
+			time.Sleep(interval)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(syncCmd)
+	syncCmd.Flags().Duration("interval", 0, "re-sync at this interval instead of exiting after the first sync")
+}
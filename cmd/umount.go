@@ -0,0 +1,101 @@
+// Copyright © 2016 Asteris, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	log "github.com/wrouesnel/go.log"
+)
+
+// umountCmd represents the umount command
+var umountCmd = &cobra.Command{
+	Use:   "umount {mountpoint}",
+	Short: "unmount a filesystem mounted with --daemon",
+	Long: `Stop the process serving a mount, found through its pidfile, and wait for
+it to unmount cleanly. If there is no such process the mountpoint is unmounted
+directly. Only a pidfile of the user's own naming a vaultfs process is acted on.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("expected exactly one argument, a mountpoint")
+		}
+
+		if err := viper.BindPFlags(cmd.Flags()); err != nil {
+			log.WithError(err).Fatal("could not bind flags")
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		mountpoint := args[0]
+		pidFile := viper.GetString("pidfile")
+		if pidFile == "" {
+			var err error
+			if pidFile, err = defaultPidFile(mountpoint); err != nil {
+				log.WithError(err).Fatal("could not find pidfile")
+			}
+		}
+
+		pid, err := readPidFile(pidFile)
+		if err != nil && !os.IsNotExist(err) {
+			log.WithError(err).WithField("pidfile", pidFile).Fatal("could not read pidfile")
+		}
+		if err == nil && !isVaultfsProcess(pid) {
+			if syscall.Kill(pid, 0) != syscall.ESRCH {
+				log.WithField("pid", pid).Fatal("the pid in the pidfile is not a vaultfs process")
+			}
+			// The process died without removing its pidfile.
+			os.Remove(pidFile)
+			err = syscall.ESRCH
+		}
+		if err == nil {
+			err = syscall.Kill(pid, syscall.SIGTERM)
+			if err == syscall.ESRCH {
+				// It exited meanwhile.
+				os.Remove(pidFile)
+			} else if err != nil {
+				log.WithError(err).WithField("pid", pid).Fatal("could not signal mount process")
+			}
+		}
+		if err != nil {
+			log.WithField("pidfile", pidFile).Info("no mount process, unmounting directly")
+			if err := fuse.Unmount(mountpoint); err != nil {
+				log.WithError(err).Fatal("could not unmount")
+			}
+			return
+		}
+
+		deadline := time.Now().Add(viper.GetDuration("timeout"))
+		for syscall.Kill(pid, 0) == nil {
+			if time.Now().After(deadline) {
+				log.WithField("pid", pid).Fatal("timed out waiting for the mount process to exit")
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		log.WithField("mountpoint", mountpoint).Info("unmounted")
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(umountCmd)
+	umountCmd.Flags().String("pidfile", "", "pidfile of the mount process (default is derived from the mountpoint)")
+	umountCmd.Flags().Duration("timeout", 30*time.Second, "how long to wait for the mount process to exit")
+}
@@ -16,8 +16,22 @@ package cmd
 
 import (
 	"flag"
+	"io"
+	"io/ioutil"
+	"log/syslog"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
 	"github.com/spf13/viper"
 	"github.com/wrouesnel/go.log"
+	"github.com/wrouesnel/vaultfs/fs"
+	"github.com/wrouesnel/vaultfs/secretset"
+	"github.com/wrouesnel/vaultfs/vaultapi"
+	"golang.org/x/net/context"
 	"golang.org/x/sys/unix"
 )
 
@@ -25,12 +39,21 @@ func initLogging() {
 	if err := flag.Set("log.level", viper.GetString("log-level")); err != nil {
 		log.Errorln("Invalid log-level:", err)
 	}
-	if err := flag.Set("log.format", viper.GetString("log-level")); err != nil {
+	if format := viper.GetString("log-format"); format == journaldFormat {
+		if err := logToJournal(); err != nil {
+			log.WithError(err).Error("could not log to the journal")
+		}
+	} else if err := flag.Set("log.format", format); err != nil {
 		log.Errorln("Invalid log-format:", err)
 	}
+	vaultapi.SetLogSanitize(viper.GetBool("log-sanitize"))
 }
 
 func lockMemory() {
+	if viper.GetBool("disable-mlock") {
+		log.Warn("mlockall disabled: cached secrets may be swapped to disk")
+		return
+	}
 	err := unix.Mlockall(unix.MCL_FUTURE | unix.MCL_CURRENT)
 	switch err {
 	case nil:
@@ -42,3 +65,302 @@ func lockMemory() {
 		log.With("error", err).Warn("could not perform mlockall to prevent swapping memory")
 	}
 }
+
+// cacheConfig builds the response cache configuration from the cache flags.
+func cacheConfig(settings *viper.Viper) vaultapi.CacheConfig {
+	return vaultapi.CacheConfig{
+		MaxEntries:           settings.GetInt("cache-max-entries"),
+		TTL:                  settings.GetDuration("cache-ttl"),
+		StaleWhileRevalidate: settings.GetDuration("cache-stale-while-revalidate"),
+		NegativeTTL:          settings.GetDuration("cache-negative-ttl"),
+		MaxStaleness:         settings.GetDuration("max-staleness"),
+		DiskDir:              settings.GetString("disk-cache-dir"),
+		DiskKeyFile:          settings.GetString("disk-cache-key-file"),
+	}
+}
+
+// limitConfig builds the request limit configuration from the limit flags and
+// the qos classes in the config file.
+func limitConfig(settings *viper.Viper) vaultapi.LimitConfig {
+	config := vaultapi.LimitConfig{
+		Default: vaultapi.QoSClass{
+			Name:          "default",
+			MaxConcurrent: settings.GetInt("max-concurrent-requests"),
+			Rate:          settings.GetFloat64("rate-limit"),
+			Burst:         settings.GetInt("rate-limit-burst"),
+		},
+	}
+	if err := settings.UnmarshalKey("qos", &config.Classes); err != nil {
+		log.WithError(err).Fatal("invalid qos configuration")
+	}
+	return config
+}
+
+// prefetchPaths returns the paths to prefetch: those listed in settings and in
+// the prefetch file, one per line, ignoring blank lines and # comments.
+func prefetchPaths(settings *viper.Viper) ([]string, error) {
+	paths := settings.GetStringSlice("prefetch-paths")
+	file := settings.GetString("prefetch")
+	if file == "" {
+		return paths, nil
+	}
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		paths = append(paths, line)
+	}
+	return paths, nil
+}
+
+// breakerConfig builds the circuit breaker configuration from the breaker
+// flags.
+func breakerConfig(settings *viper.Viper) vaultapi.BreakerConfig {
+	return vaultapi.BreakerConfig{
+		Threshold: settings.GetInt("breaker-threshold"),
+		Cooldown:  settings.GetDuration("breaker-cooldown"),
+	}
+}
+
+// sizeLimits builds the bounds on the responses served from the size flags.
+func sizeLimits(settings *viper.Viper) vaultapi.SizeLimits {
+	return vaultapi.SizeLimits{
+		MaxValueSize: settings.GetInt("max-value-size"),
+		MaxEntries:   settings.GetInt("max-dir-entries"),
+	}
+}
+
+// engineMounts builds the secrets engine mounts from the defaults and the
+// engine flag, which takes path=type pairs. An empty type removes the default
+// engine at that path.
+func engineMounts(settings *viper.Viper) map[string]string {
+	mounts := make(map[string]string)
+	for mount, engineType := range fs.DefaultEngineMounts {
+		mounts[mount] = engineType
+	}
+	for _, pair := range settings.GetStringSlice("engine") {
+		idx := strings.Index(pair, "=")
+		if idx < 0 {
+			log.WithField("engine", pair).Fatal("engine must be given as path=type")
+		}
+		mount, engineType := pair[:idx], pair[idx+1:]
+		if engineType == "" {
+			delete(mounts, mount)
+			continue
+		}
+		mounts[mount] = engineType
+	}
+	return mounts
+}
+
+// templates returns the templates given as path=file, read from their files.
+func templates(settings *viper.Viper) map[string]string {
+	templates := make(map[string]string)
+	for _, pair := range settings.GetStringSlice("template") {
+		idx := strings.Index(pair, "=")
+		if idx < 0 {
+			log.WithField("template", pair).Fatal("template must be given as path=file")
+		}
+		name, file := pair[:idx], pair[idx+1:]
+		text, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.WithField("template", name).WithError(err).Fatal("could not read template")
+		}
+		templates[name] = string(text)
+	}
+	return templates
+}
+
+// aliases returns the aliases given as path=vault/path.
+func aliases(settings *viper.Viper) map[string]string {
+	aliases := make(map[string]string)
+	for _, pair := range settings.GetStringSlice("alias") {
+		idx := strings.Index(pair, "=")
+		if idx < 0 {
+			log.WithField("alias", pair).Fatal("alias must be given as path=vault/path")
+		}
+		aliases[pair[:idx]] = pair[idx+1:]
+	}
+	return aliases
+}
+
+// openAuditLog opens the audit log destination: a file appended to, or syslog.
+func openAuditLog(dest string) (io.Writer, error) {
+	if dest == "syslog" {
+		return syslog.New(syslog.LOG_AUTHPRIV|syslog.LOG_INFO, "vaultfs")
+	}
+	return os.OpenFile(dest, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+}
+
+// vaultTLSConfig returns the TLS configuration for connecting to Vault: that
+// of the environment, with any TLS settings overriding it. It returns false if
+// there are no TLS settings, leaving that of the environment in place.
+func vaultTLSConfig(settings *viper.Viper) (*api.TLSConfig, bool) {
+	override := func(key string, env string) (string, bool) {
+		if value := settings.GetString(key); value != "" {
+			return value, true
+		}
+		return os.Getenv(env), false
+	}
+
+	t := &api.TLSConfig{}
+	var caCertSet, caPathSet, clientCertSet, clientKeySet, serverNameSet bool
+	t.CACert, caCertSet = override("ca-cert", api.EnvVaultCACert)
+	t.CAPath, caPathSet = override("ca-path", api.EnvVaultCAPath)
+	t.ClientCert, clientCertSet = override("client-cert", api.EnvVaultClientCert)
+	t.ClientKey, clientKeySet = override("client-key", api.EnvVaultClientKey)
+	t.TLSServerName, serverNameSet = override("tls-server-name", api.EnvVaultTLSServerName)
+
+	// ReadEnvironment has already rejected an invalid VAULT_SKIP_VERIFY.
+	envInsecure, _ := strconv.ParseBool(os.Getenv(api.EnvVaultInsecure))
+	t.Insecure = envInsecure || settings.GetBool("tls-skip-verify")
+
+	set := caCertSet || caPathSet || clientCertSet || clientKeySet || serverNameSet || t.Insecure != envInsecure
+	return t, set
+}
+
+// vaultClientConfig builds the Vault client configuration from the
+// environment and settings. The address may list several nodes of a cluster,
+// separated by commas, be a discovery address to find them from, or be a unix
+// socket.
+func vaultClientConfig(settings *viper.Viper) (*api.Config, error) {
+	vaultConfig := api.DefaultConfig()
+	if err := vaultConfig.ReadEnvironment(); err != nil {
+		return nil, err
+	}
+	if tlsConfig, ok := vaultTLSConfig(settings); ok {
+		if err := vaultConfig.ConfigureTLS(tlsConfig); err != nil {
+			return nil, err
+		}
+	}
+	// Several addresses are failed over between.
+	if addresses := settings.GetStringSlice("vault-address"); len(addresses) > 0 {
+		vaultConfig.Address = strings.Join(addresses, ",")
+	}
+	if discovery := settings.GetString("vault-discovery"); discovery != "" {
+		address, err := vaultapi.DiscoveryAddress(discovery, settings.GetString("vault-discovery-scheme"))
+		if err != nil {
+			return nil, err
+		}
+		vaultConfig.Address = address
+	}
+	// All requests go through Vault Agent instead, if given.
+	if agent := settings.GetString("agent-addr"); agent != "" {
+		vaultConfig.Address = agent
+	}
+	if err := vaultapi.ConfigureTransport(vaultConfig, settings.GetString("vault-proxy")); err != nil {
+		return nil, err
+	}
+	return vaultConfig, nil
+}
+
+// authMethod returns the auth method of the settings, which is always that of
+// Vault Agent when connecting through one.
+func authMethod(settings *viper.Viper) string {
+	if settings.GetString("agent-addr") != "" {
+		return vaultapi.AuthMethodAgent
+	}
+	return settings.GetString("auth-method")
+}
+
+// auth returns the auth settings.
+func auth(settings *viper.Viper) fs.Auth {
+	return fs.Auth{
+		Token:  settings.GetString("token"),
+		Method: authMethod(settings),
+		User:   settings.GetString("auth-user"),
+		Role:   settings.GetString("auth-role"),
+		Secret: settings.GetString("auth-secret"),
+	}
+}
+
+// reconfigure reconnects fs to Vault with vaultConfig and the auth settings,
+// for SIGHUP.
+func reconfigure(fs *fs.VaultFS, settings *viper.Viper, vaultConfig *api.Config) {
+	if err := fs.Reconfigure(vaultConfig, auth(settings)); err != nil {
+		log.WithError(err).Error("could not reconnect to vault, keeping the previous connection")
+	}
+}
+
+// newBackend creates an authenticated Vault backend from the environment and
+// the global auth flags, for commands which talk to Vault without mounting.
+func newBackend() (vaultapi.AuthableLogical, error) {
+	vaultConfig, err := vaultClientConfig(viper.GetViper())
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.NewBackend(vaultConfig, auth(viper.GetViper()))
+}
+
+// loadSecretSpec reads and validates the secret set spec from the config file.
+func loadSecretSpec() (*secretset.Spec, error) {
+	spec := &secretset.Spec{}
+	if err := viper.UnmarshalKey("secrets", &spec.Secrets); err != nil {
+		return nil, err
+	}
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// dumpJournal writes the request journal to filename as JSON lines, or to the
+// log if filename is empty.
+func dumpJournal(entries []vaultapi.JournalEntry, filename string) {
+	if filename == "" {
+		for _, entry := range entries {
+			vaultapi.SafeLogger(log.Base()).WithFields(log.Fields{
+				"time":     entry.Time,
+				"method":   entry.Method,
+				"path":     entry.Path,
+				"status":   entry.Status,
+				"duration": entry.Duration,
+			}).Info("journal entry")
+		}
+		return
+	}
+
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		log.WithError(err).Error("could not open journal file")
+		return
+	}
+	defer f.Close()
+
+	if err := vaultapi.WriteJournal(f, entries); err != nil {
+		log.WithError(err).Error("could not write journal file")
+		return
+	}
+	log.WithField("file", filename).WithField("entries", len(entries)).Info("dumped request journal")
+}
+
+// diagnosticsTimeout bounds how long a diagnostic dump waits on Vault.
+const diagnosticsTimeout = 5 * time.Second
+
+// dumpDiagnostics logs the stacks of every goroutine and the state of each of
+// filesystems, for diagnosing a hung mount.
+func dumpDiagnostics(filesystems ...*fs.VaultFS) {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	log.WithField("goroutines", runtime.NumGoroutine()).WithField("stacks", string(buf)).Info("diagnostics: goroutine stacks")
+
+	ctx, cancel := context.WithTimeout(context.Background(), diagnosticsTimeout)
+	defer cancel()
+	for _, filesystem := range filesystems {
+		filesystem.LogDiagnostics(ctx)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright © 2016 Asteris, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	log "github.com/wrouesnel/go.log"
+	"github.com/wrouesnel/vaultfs/vaultapi"
+)
+
+// Bounds of the delay between attempts to reach Vault at startup.
+const (
+	waitMinBackoff = time.Second
+	waitMaxBackoff = 30 * time.Second
+)
+
+// waitForeverFlag is the value of --wait-for-vault given without a timeout.
+const waitForeverFlag = "-1s"
+
+// waitForVault calls connect once Vault is reachable and unsealed, retrying
+// with a backoff until it succeeds or timeout passes. A negative timeout
+// waits indefinitely.
+func waitForVault(vaultConfig *api.Config, timeout time.Duration, connect func() error) error {
+	deadline := time.Now().Add(timeout)
+	backoff := waitMinBackoff
+	for {
+		status, err := sealStatus(vaultConfig)
+		switch {
+		case err != nil:
+		case status.Sealed:
+			err = errors.New("vault is sealed")
+		default:
+			err = connect()
+		}
+		if err == nil {
+			return nil
+		}
+
+		if timeout >= 0 && time.Now().Add(backoff).After(deadline) {
+			return err
+		}
+		log.WithError(err).WithField("retry_in", backoff).Warn("waiting for vault")
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > waitMaxBackoff {
+			backoff = waitMaxBackoff
+		}
+	}
+}
+
+// sealStatus returns the seal status of the first reachable node of the
+// cluster at the address of vaultConfig, which may list or discover several.
+func sealStatus(vaultConfig *api.Config) (*api.SealStatusResponse, error) {
+	addresses, err := vaultapi.ResolveAddresses(vaultConfig.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, address := range addresses {
+		client, err := api.NewClient(&api.Config{
+			Address:    address,
+			HttpClient: vaultConfig.HttpClient,
+			MaxRetries: vaultConfig.MaxRetries,
+			Timeout:    vaultConfig.Timeout,
+		})
+		if err != nil {
+			return nil, err
+		}
+		status, err := client.Sys().SealStatus()
+		if err == nil {
+			return status, nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no vault address")
+	}
+	return nil, lastErr
+}
@@ -0,0 +1,70 @@
+// Copyright © 2016 Asteris, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	log "github.com/wrouesnel/go.log"
+)
+
+// writeCmd represents the write command
+var writeCmd = &cobra.Command{
+	Use:   "write {path} {key=value}...",
+	Short: "write a secret to Vault, without mounting",
+	Long: `Write the given keys and values to a Vault path, replacing the data of
+the secret, through the same backend and auth flags as a mount, for
+debugging connectivity and policies.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 2 {
+			return errors.New("expected a Vault path and at least one key=value pair")
+		}
+		for _, pair := range args[1:] {
+			if !strings.Contains(pair, "=") {
+				return errors.New("data must be given as key=value: " + pair)
+			}
+		}
+
+		if err := viper.BindPFlags(cmd.Flags()); err != nil {
+			log.WithError(err).Fatal("could not bind flags")
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		data := make(map[string]interface{})
+		for _, pair := range args[1:] {
+			idx := strings.Index(pair, "=")
+			data[pair[:idx]] = pair[idx+1:]
+		}
+
+		backend, err := newBackend()
+		if err != nil {
+			log.WithError(err).Fatal("could not authenticate to vault")
+		}
+
+		if _, err := backend.Write(args[0], data); err != nil {
+			log.WithError(err).WithField("path", args[0]).Fatal("could not write secret")
+		}
+		log.WithField("path", args[0]).Info("secret written")
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(writeCmd)
+}
@@ -15,7 +15,7 @@
 package docker
 
 import (
-	"github.com/hashicorp/vault/api"
+	"github.com/wrouesnel/vaultfs/fs"
 )
 
 // Config configures the docker volume plugin
@@ -23,11 +23,11 @@ type Config struct {
 	// Root for mount
 	Root string
 
-	// Token and config for Vault
-	Token      string
-	AuthMethod string
-	AuthUser   string
-  	AuthRole   string
-	AuthSecret string
-	Vault      *api.Config
+	// Options of the filesystem of every volume, as of a mount. The
+	// mountpoint, root path and format of each volume replace those given,
+	// and so does the auth if the volume has a token.
+	Options fs.Options
+	// Configure, if set, applies the settings beyond Options to the
+	// filesystem of each volume before it is mounted.
+	Configure func(*fs.VaultFS) error
 }
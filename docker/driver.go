@@ -20,21 +20,19 @@ import (
 	"os"
 	"path"
 	"sync"
+	"syscall"
 
+	"bazil.org/fuse"
 	"github.com/docker/go-plugins-helpers/volume"
 	"github.com/wrouesnel/go.log"
+	"github.com/wrouesnel/vaultfs/fs"
 )
 
-type volumeName struct {
-	name        string
-	connections int
-}
-
 // Driver implements the interface for a Docker volume plugin
 type Driver struct {
 	config  Config
 	servers map[string]*Server
-	volumes map[string]*volumeName
+	volumes map[string]VolumeOptions // by volume name
 	m       *sync.Mutex
 }
 
@@ -43,6 +41,7 @@ func New(config Config) Driver {
 	return Driver{
 		config:  config,
 		servers: map[string]*Server{},
+		volumes: map[string]VolumeOptions{},
 		m:       new(sync.Mutex),
 	}
 }
@@ -56,8 +55,18 @@ func (d Driver) Capabilities(r volume.Request) volume.Response {
 	}
 }
 
-// Create handles volume creation calls
+// Create handles volume creation calls, recording the options of the volume.
+// Volumes created without options mount the Vault path of their name.
 func (d Driver) Create(r volume.Request) volume.Response {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	options, err := ParseVolumeOptions(r.Name, r.Options)
+	if err != nil {
+		log.WithError(err).WithField("name", r.Name).Error("invalid volume options")
+		return volume.Response{Err: err.Error()}
+	}
+	d.volumes[r.Name] = options
 	return volume.Response{}
 }
 
@@ -65,26 +74,42 @@ func (d Driver) Create(r volume.Request) volume.Response {
 func (d Driver) Get(r volume.Request) volume.Response {
 	d.m.Lock()
 	defer d.m.Unlock()
-	m := d.mountpoint(r.Name)
-	if s, ok := d.volumes[m]; ok {
-		return volume.Response{Volume: &volume.Volume{Name: s.name, Mountpoint: d.mountpoint(s.name)}}
+	if _, ok := d.volumes[r.Name]; ok {
+		return volume.Response{Volume: d.volume(r.Name)}
 	}
 
-	return volume.Response{Err: fmt.Sprintf("Unable to find volume mounted on %s", m)}
+	return volume.Response{Err: fmt.Sprintf("Unable to find volume %s", r.Name)}
 }
 
-// List mounted volumes
+// List created volumes
 func (d Driver) List(r volume.Request) volume.Response {
 	d.m.Lock()
 	defer d.m.Unlock()
 	var vols []*volume.Volume
-	for _, v := range d.volumes {
-		vols = append(vols, &volume.Volume{Name: v.name, Mountpoint: d.mountpoint(v.name)})
+	for name := range d.volumes {
+		vols = append(vols, d.volume(name))
 	}
 	return volume.Response{Volumes: vols}
 }
 
-// Remove handles volume removal calls
+// volume describes the volume named name.
+func (d Driver) volume(name string) *volume.Volume {
+	options := d.volumes[name]
+	format := options.Format
+	if format == "" {
+		format = d.config.Options.Format
+	}
+	return &volume.Volume{
+		Name:       name,
+		Mountpoint: d.mountpoint(name),
+		Status: map[string]interface{}{
+			"path":   options.Path,
+			"format": format,
+		},
+	}
+}
+
+// Remove handles volume removal calls. Volumes still mounted can't be removed.
 func (d Driver) Remove(r volume.Request) volume.Response {
 	d.m.Lock()
 	defer d.m.Unlock()
@@ -96,11 +121,10 @@ func (d Driver) Remove(r volume.Request) volume.Response {
 	logger.Debug("got remove request")
 
 	if server, ok := d.servers[mount]; ok {
-		if server.connections <= 1 {
-			logger.Debug("removing server")
-			delete(d.servers, mount)
-		}
+		logger.WithField("users", len(server.users)).Error("volume is in use")
+		return volume.Response{Err: fmt.Sprintf("volume %s is in use", r.Name)}
 	}
+	delete(d.volumes, r.Name)
 
 	return volume.Response{}
 }
@@ -110,7 +134,9 @@ func (d Driver) Path(r volume.Request) volume.Response {
 	return volume.Response{Mountpoint: d.mountpoint(r.Name)}
 }
 
-// Mount handles creating and mounting servers
+// Mount handles creating and mounting servers. Each volume is mounted once,
+// however many containers use it, and mounting it again for the same request
+// ID does nothing.
 func (d Driver) Mount(r volume.MountRequest) volume.Response {
 	d.m.Lock()
 	defer d.m.Unlock()
@@ -118,18 +144,29 @@ func (d Driver) Mount(r volume.MountRequest) volume.Response {
 	mount := d.mountpoint(r.Name)
 	logger := log.WithFields(log.Fields{
 		"name":       r.Name,
+		"id":         r.ID,
 		"mountpoint": mount,
 	})
 	logger.Info("mounting volume")
 
-	server, ok := d.servers[mount]
-	if ok && server.connections > 0 {
-		server.connections++
+	if server, ok := d.servers[mount]; ok {
+		server.users[r.ID] = true
+		logger.WithField("users", len(server.users)).Debug("volume already mounted")
 		return volume.Response{Mountpoint: mount}
 	}
 
 	mountInfo, err := os.Lstat(mount)
 
+	// A previous instance of the plugin may have left a dead FUSE mount.
+	if pathErr, ok := err.(*os.PathError); ok && pathErr.Err == syscall.ENOTCONN {
+		logger.Warn("cleaning up stale mount")
+		if err := fuse.Unmount(mount); err != nil {
+			logger.WithError(err).Error("error unmounting stale mount")
+			return volume.Response{Err: err.Error()}
+		}
+		mountInfo, err = os.Lstat(mount)
+	}
+
 	if os.IsNotExist(err) {
 		if err := os.MkdirAll(mount, os.ModeDir|0444); err != nil {
 			logger.WithError(err).Error("error making mount directory")
@@ -145,19 +182,46 @@ func (d Driver) Mount(r volume.MountRequest) volume.Response {
 		return volume.Response{Err: fmt.Sprintf("%s already exists and is not a directory", mount)}
 	}
 
-	server, err = NewServer(d.config.Vault, mount, d.config.Token, d.config.AuthMethod, d.config.AuthUser, d.config.AuthRole, d.config.AuthSecret, r.Name)
+	// Volumes are normally created first, but fall back to the defaults.
+	options, ok := d.volumes[r.Name]
+	if !ok {
+		if options, err = ParseVolumeOptions(r.Name, nil); err != nil {
+			return volume.Response{Err: err.Error()}
+		}
+	}
+
+	// A token given for the volume replaces the driver's credentials.
+	fsOptions := d.config.Options
+	fsOptions.Mountpoint = mount
+	fsOptions.Root = options.Path
+	if options.Format != "" {
+		fsOptions.Format = options.Format
+	}
+	if options.Token != "" {
+		fsOptions.Auth = fs.Auth{Token: options.Token}
+	}
+
+	server, err := NewServer(fsOptions)
 	if err != nil {
 		logger.WithError(err).Error("error creating server")
 		return volume.Response{Err: err.Error()}
 	}
+	if d.config.Configure != nil {
+		if err := d.config.Configure(server.fs); err != nil {
+			logger.WithError(err).Error("error configuring server")
+			return volume.Response{Err: err.Error()}
+		}
+	}
 
 	go server.Mount()
+	server.users[r.ID] = true
 	d.servers[mount] = server
 
 	return volume.Response{Mountpoint: mount}
 }
 
-// Unmount handles unmounting (but not removing) servers
+// Unmount handles unmounting (but not removing) servers. The volume is only
+// unmounted once no container uses it.
 func (d Driver) Unmount(r volume.UnmountRequest) volume.Response {
 	d.m.Lock()
 	defer d.m.Unlock()
@@ -165,26 +229,30 @@ func (d Driver) Unmount(r volume.UnmountRequest) volume.Response {
 	mount := d.mountpoint(r.Name)
 	logger := log.WithFields(log.Fields{
 		"name":       r.Name,
+		"id":         r.ID,
 		"mountpoint": mount,
 	})
 	logger.Info("unmounting volume")
 
-	if server, ok := d.servers[mount]; ok {
-		logger.WithField("conns", server.connections).Debug("found server")
-		if server.connections == 1 {
-			logger.Debug("unmounting")
-			err := server.Unmount()
-			if err != nil {
-				logger.WithError(err).Error("error unmounting server")
-				return volume.Response{Err: err.Error()}
-			}
-			server.connections--
-		}
-	} else {
+	server, ok := d.servers[mount]
+	if !ok {
 		logger.Error("could not find volume")
 		return volume.Response{Err: fmt.Sprintf("unable to find the volume mounted at %s", mount)}
 	}
 
+	delete(server.users, r.ID)
+	logger.WithField("users", len(server.users)).Debug("found server")
+	if len(server.users) > 0 {
+		return volume.Response{}
+	}
+
+	logger.Debug("unmounting")
+	if err := server.Unmount(); err != nil {
+		logger.WithError(err).Error("error unmounting server")
+		return volume.Response{Err: err.Error()}
+	}
+	delete(d.servers, mount)
+
 	return volume.Response{}
 }
 
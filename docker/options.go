@@ -0,0 +1,81 @@
+// Copyright © 2016 Asteris, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"io/ioutil"
+	"strings"
+
+	"github.com/go-errors/errors"
+	"github.com/wrouesnel/vaultfs/fs"
+)
+
+// Render modes of volumes, given with the mode option.
+const (
+	ModeFull     = "full"
+	ModeDataOnly = "data-only"
+)
+
+// VolumeOptions are the settings of a volume, given as options when it is
+// created (docker volume create -o path=secret/app).
+type VolumeOptions struct {
+	// Path is the Vault path mounted as the root of the volume. The default is
+	// the volume name.
+	Path string
+	// Format is how secrets are presented (fs.FormatFull or fs.FormatData).
+	// The default is that of the driver.
+	Format string
+	// Token, if set, is used instead of the driver's credentials.
+	Token string
+}
+
+// ParseVolumeOptions parses the options a volume named name was created with:
+// path, mode (full or data-only) and token, which is read from a file if it
+// starts with @.
+func ParseVolumeOptions(name string, options map[string]string) (VolumeOptions, error) {
+	opts := VolumeOptions{
+		Path: name,
+	}
+	for key, value := range options {
+		switch key {
+		case "path":
+			opts.Path = strings.Trim(value, "/")
+		case "mode":
+			switch value {
+			case ModeFull:
+				opts.Format = fs.FormatFull
+			case ModeDataOnly:
+				opts.Format = fs.FormatData
+			default:
+				return opts, errors.Errorf("invalid mode %q (one of %s, %s)", value, ModeFull, ModeDataOnly)
+			}
+		case "token":
+			if strings.HasPrefix(value, "@") {
+				token, err := ioutil.ReadFile(strings.TrimPrefix(value, "@"))
+				if err != nil {
+					return opts, errors.WrapPrefix(err, "could not read token file", 0)
+				}
+				value = strings.TrimSpace(string(token))
+			}
+			opts.Token = value
+		default:
+			return opts, errors.Errorf("unknown volume option %q", key)
+		}
+	}
+	if opts.Path == "" {
+		return opts, errors.New("volume has no vault path")
+	}
+	return opts, nil
+}
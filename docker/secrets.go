@@ -0,0 +1,143 @@
+// Copyright © 2016 Asteris, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/docker/go-plugins-helpers/sdk"
+	"github.com/wrouesnel/go.log"
+	"github.com/wrouesnel/vaultfs/vaultapi"
+)
+
+const (
+	secretsManifest = `{"Implements": ["secretprovider"]}`
+	getSecretPath   = "/SecretProvider.GetSecret"
+)
+
+// Labels of swarm secrets read by the secrets driver.
+const (
+	// SecretPathLabel is the Vault path of the secret (default the secret
+	// name without its last component).
+	SecretPathLabel = "vault.path"
+	// SecretKeyLabel is the data key of the secret to return (default the
+	// last component of the secret name).
+	SecretKeyLabel = "vault.key"
+	// SecretReuseLabel, if "false", has the secret fetched again for every
+	// task, e.g. for dynamic secrets.
+	SecretReuseLabel = "vault.reuse"
+)
+
+// SecretRequest is the request of the swarm secrets plugin interface.
+type SecretRequest struct {
+	SecretName    string            `json:",omitempty"`
+	SecretLabels  map[string]string `json:",omitempty"`
+	ServiceID     string            `json:",omitempty"`
+	ServiceName   string            `json:",omitempty"`
+	ServiceLabels map[string]string `json:",omitempty"`
+	TaskID        string            `json:",omitempty"`
+	TaskName      string            `json:",omitempty"`
+	TaskImage     string            `json:",omitempty"`
+}
+
+// SecretResponse is the response of the swarm secrets plugin interface.
+type SecretResponse struct {
+	Value      []byte `json:",omitempty"`
+	Err        string `json:",omitempty"`
+	DoNotReuse bool   `json:",omitempty"`
+}
+
+// SecretsDriver provides the values of swarm secrets from Vault. Docker injects
+// them into the tmpfs of each task as it starts.
+type SecretsDriver struct {
+	backend vaultapi.Logical
+}
+
+// NewSecretsDriver creates a secrets driver reading from backend.
+func NewSecretsDriver(backend vaultapi.Logical) *SecretsDriver {
+	return &SecretsDriver{backend: backend}
+}
+
+// NewSecretsHandler returns the plugin handler serving driver.
+func NewSecretsHandler(driver *SecretsDriver) sdk.Handler {
+	h := sdk.NewHandler(secretsManifest)
+	h.HandleFunc(getSecretPath, func(w http.ResponseWriter, r *http.Request) {
+		var req SecretRequest
+		if err := sdk.DecodeRequest(w, r, &req); err != nil {
+			return
+		}
+		res := driver.GetSecret(req)
+		sdk.EncodeResponse(w, res, res.Err)
+	})
+	return h
+}
+
+// GetSecret returns the value of a secret: a data key of a Vault secret, named
+// by the secret's labels or otherwise by its name (e.g. secret/app/password).
+func (d *SecretsDriver) GetSecret(req SecretRequest) SecretResponse {
+	secretPath, key := path.Dir(req.SecretName), path.Base(req.SecretName)
+	if p, ok := req.SecretLabels[SecretPathLabel]; ok {
+		secretPath, key = p, ""
+	}
+	if k, ok := req.SecretLabels[SecretKeyLabel]; ok {
+		key = k
+	}
+	secretPath = strings.Trim(secretPath, "/")
+
+	logger := log.WithFields(log.Fields{
+		"secret":  req.SecretName,
+		"path":    secretPath,
+		"key":     key,
+		"service": req.ServiceName,
+		"task":    req.TaskName,
+	})
+	if key == "" {
+		logger.Error("secret has no key")
+		return SecretResponse{Err: fmt.Sprintf("no %s label for secret %s", SecretKeyLabel, req.SecretName)}
+	}
+
+	secret, err := d.backend.Read(secretPath)
+	if err != nil {
+		logger.WithError(err).Error("could not read secret")
+		return SecretResponse{Err: err.Error()}
+	}
+	if secret == nil {
+		logger.Error("secret not found")
+		return SecretResponse{Err: fmt.Sprintf("secret %s not found", secretPath)}
+	}
+
+	value, found := vaultapi.SecretData(secret)[key]
+	if !found {
+		logger.Error("key not found")
+		return SecretResponse{Err: fmt.Sprintf("key %s not found in secret %s", key, secretPath)}
+	}
+
+	var content []byte
+	if s, ok := value.(string); ok {
+		content = []byte(s)
+	} else if content, err = json.Marshal(value); err != nil {
+		return SecretResponse{Err: err.Error()}
+	}
+
+	logger.Info("provided secret")
+	return SecretResponse{
+		Value:      content,
+		DoNotReuse: req.SecretLabels[SecretReuseLabel] == "false",
+	}
+}
@@ -15,27 +15,26 @@
 package docker
 
 import (
-	"github.com/hashicorp/vault/api"
 	"github.com/wrouesnel/go.log"
 	"github.com/wrouesnel/vaultfs/fs"
 )
 
-// Server wraps VaultFS and tracks connection counts
+// Server wraps VaultFS and tracks the containers using it
 type Server struct {
-	fs          *fs.VaultFS
-	connections int
-	stopFunc    func()
-	errs        chan error
+	fs       *fs.VaultFS
+	users    map[string]bool // IDs of the mount requests using the volume
+	stopFunc func()
+	errs     chan error
 }
 
 // NewServer returns a new server with initial state
-func NewServer(config *api.Config, mountpoint, token, authMethod, authUser string, authRole string, authSecret string, root string) (*Server, error) {
-	fs, err := fs.New(config, mountpoint, root, token, authMethod, authUser, authRole, authSecret)
+func NewServer(options fs.Options) (*Server, error) {
+	fs, err := fs.New(options)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Server{fs: fs, connections: 1}, nil
+	return &Server{fs: fs, users: map[string]bool{}}, nil
 }
 
 // Mount mounts the wrapped FS on a given mountpoint. It also starts watching
@@ -0,0 +1,98 @@
+// Aliases exposing Vault paths at other paths in the mount, so applications
+// with hardcoded file locations can be pointed at deep Vault paths.
+
+package fs
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+
+	"bazil.org/fuse"
+	"github.com/go-errors/errors"
+)
+
+// maxAliasDepth bounds how many aliases are followed to resolve a path, as
+// aliases may point at other aliases (or, misconfigured, at each other).
+const maxAliasDepth = 8
+
+// SetAliases sets the aliases of the mount, mapping paths in the mount
+// (relative to its root) to the Vault paths exposed there.
+func (v *VaultFS) SetAliases(aliases map[string]string) error {
+	resolved := make(map[string]string)
+	for name, target := range aliases {
+		name = strings.Trim(path.Clean("/"+name), "/")
+		target = strings.Trim(target, "/")
+		if name == "" || target == "" {
+			return errors.Errorf("invalid alias: %q=%q", name, target)
+		}
+		resolved[path.Join(v.root, name)] = target
+	}
+	v.aliases = resolved
+	return nil
+}
+
+// resolveAlias returns the Vault path which the path p is an alias of,
+// following aliases of aliases. It returns ELOOP if they are cyclic or too
+// deeply nested.
+func (v *VaultFS) resolveAlias(p string) (string, bool, error) {
+	p = strings.Trim(p, "/")
+	target, found := v.aliases[p]
+	if !found {
+		return "", false, nil
+	}
+
+	seen := map[string]bool{p: true}
+	for depth := 1; ; depth++ {
+		if seen[target] || depth > maxAliasDepth {
+			v.logger.WithField("alias", p).Warn("alias is cyclic or too deeply nested")
+			return "", true, fuse.Errno(syscall.ELOOP)
+		}
+		seen[target] = true
+
+		next, found := v.aliases[target]
+		if !found {
+			return target, true, nil
+		}
+		target = next
+	}
+}
+
+// isAliasParent returns true if the path p leads to an alias, so must exist
+// even if there is nothing at p in Vault.
+func (v *VaultFS) isAliasParent(p string) bool {
+	p = strings.Trim(p, "/")
+	for name := range v.aliases {
+		if strings.HasPrefix(name, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// aliasEntries returns the entries of the directory at lookupPath which lead
+// to aliases.
+func (v *VaultFS) aliasEntries(lookupPath string) []fuse.Dirent {
+	lookupPath = strings.Trim(lookupPath, "/")
+
+	names := map[string]bool{}
+	for name := range v.aliases {
+		if !strings.HasPrefix(name, lookupPath+"/") {
+			continue
+		}
+		names[strings.SplitN(strings.TrimPrefix(name, lookupPath+"/"), "/", 2)[0]] = true
+	}
+
+	sorted := []string{}
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	entries := []fuse.Dirent{}
+	for _, name := range sorted {
+		entries = append(entries, fuse.Dirent{Name: name, Type: fuse.DT_Dir})
+	}
+	return entries
+}
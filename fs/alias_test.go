@@ -0,0 +1,70 @@
+package fs
+
+import (
+	"fmt"
+	"syscall"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/wrouesnel/vaultfs/vaultapi/vaulttest"
+)
+
+// aliasChain returns n aliases, chain0 to chain<n-1>, each an alias of the
+// next, the last of a Vault path.
+func aliasChain(n int) map[string]string {
+	aliases := map[string]string{}
+	for i := 0; i < n; i++ {
+		aliases[fmt.Sprintf("chain%d", i)] = fmt.Sprintf("secret/chain%d", i+1)
+	}
+	aliases[fmt.Sprintf("chain%d", n-1)] = "secret/teams/app1"
+	return aliases
+}
+
+func TestResolveAlias(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		aliases  map[string]string
+		path     string
+		expected string
+		loop     bool
+	}{
+		{"plain path", map[string]string{"app1": "secret/teams/app1"}, "secret/other", "", false},
+		{"alias", map[string]string{"app1": "secret/teams/app1"}, "secret/app1", "secret/teams/app1", false},
+		{"chain", map[string]string{"short": "secret/app1", "app1": "secret/teams/app1"}, "secret/short", "secret/teams/app1", false},
+		{"longest chain", aliasChain(maxAliasDepth), "secret/chain0", "secret/teams/app1", false},
+		{"self", map[string]string{"self": "secret/self"}, "secret/self", "", true},
+		{"cycle", map[string]string{"a": "secret/b", "b": "secret/a"}, "secret/a", "", true},
+		{"too deep", aliasChain(maxAliasDepth + 1), "secret/chain0", "", true},
+	} {
+		v := newTestFS(t, vaulttest.NewLogical(), WithRoot("secret"))
+		if err := v.SetAliases(c.aliases); err != nil {
+			t.Fatal(err)
+		}
+		target, found, err := v.resolveAlias(c.path)
+		switch {
+		case c.loop:
+			if !found || err != fuse.Errno(syscall.ELOOP) {
+				t.Errorf("%s: expected ELOOP, got %q %v", c.name, target, err)
+			}
+		case err != nil || target != c.expected || found != (c.expected != ""):
+			t.Errorf("%s: expected %q, got %q %v (%v)", c.name, c.expected, target, found, err)
+		}
+	}
+}
+
+func TestAliasLookup(t *testing.T) {
+	backend := vaulttest.NewLogical()
+	backend.Put("secret/teams/app1", map[string]interface{}{"password": "hunter2"})
+	v := newTestFS(t, backend, WithRoot("secret"), WithFormat(FormatData))
+	aliases := map[string]string{"short": "secret/app1", "app1": "secret/teams/app1", "a": "secret/b", "b": "secret/a"}
+	if err := v.SetAliases(aliases); err != nil {
+		t.Fatal(err)
+	}
+
+	if content := readFile(t, lookup(t, root(t, v), "short", "password")); content != "hunter2" {
+		t.Errorf("expected the chain to lead to the secret, got %q", content)
+	}
+	if _, err := lookupErr(root(t, v), "a"); err != fuse.Errno(syscall.ELOOP) {
+		t.Errorf("expected a cyclic alias to fail with ELOOP, got %v", err)
+	}
+}
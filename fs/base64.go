@@ -0,0 +1,80 @@
+// Binary secrets stored base64-encoded in Vault, which are decoded before
+// being served so applications can read keystores, DER certificates and the
+// like directly.
+
+package fs
+
+import (
+	"encoding/base64"
+	"path"
+	"strings"
+
+	"github.com/go-errors/errors"
+)
+
+// DefaultBase64Keys are the patterns of the data keys decoded by default.
+var DefaultBase64Keys = []string{"*_base64"}
+
+// SetBase64Keys sets the glob patterns of the data keys whose values are
+// base64-decoded. Patterns without a slash match key names, and patterns with
+// one match the key's full path (the secret's path and the key). The default
+// is DefaultBase64Keys.
+func (v *VaultFS) SetBase64Keys(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.Errorf("invalid base64 key pattern: %q", pattern)
+		}
+	}
+	v.base64Keys = patterns
+	return nil
+}
+
+// isBase64Key returns true if the value of key in the secret at secretPath is
+// base64-encoded.
+func (v *VaultFS) isBase64Key(secretPath string, key string) bool {
+	for _, pattern := range v.base64Keys {
+		name := key
+		if strings.Contains(pattern, "/") {
+			name = path.Join(strings.Trim(secretPath, "/"), key)
+		}
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeBase64Value decodes the value of key if it is base64-encoded. Values
+// which can't be decoded are served as they are.
+func (v *VaultFS) decodeBase64Value(secretPath string, key string, value string) string {
+	if !v.isBase64Key(secretPath, key) {
+		return value
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		v.logger.WithField("path", secretPath).WithField("key", key).WithError(err).Warn("could not decode base64 value")
+		return value
+	}
+	return string(decoded)
+}
+
+// decodeBase64Data returns a copy of the data of the secret at secretPath with
+// base64-encoded values decoded, including those in nested maps.
+func (v *VaultFS) decodeBase64Data(secretPath string, data map[string]interface{}) map[string]interface{} {
+	if data == nil || len(v.base64Keys) == 0 {
+		return data
+	}
+
+	decoded := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		switch value := value.(type) {
+		case string:
+			decoded[key] = v.decodeBase64Value(secretPath, key, value)
+		case map[string]interface{}:
+			decoded[key] = v.decodeBase64Data(path.Join(secretPath, key), value)
+		default:
+			decoded[key] = value
+		}
+	}
+	return decoded
+}
@@ -0,0 +1,196 @@
+// Benchmarking the filesystem by serving the operations of a tree walk
+// directly, without mounting.
+
+package fs
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"golang.org/x/net/context"
+)
+
+// Operations measured by Bench.
+const (
+	BenchLookup     = "lookup"
+	BenchReadDirAll = "readdir"
+	BenchRead       = "read"
+)
+
+// benchReadSize is the size of the reads of files, that of the kernel's
+// largest.
+const benchReadSize = 128 * 1024
+
+// BenchStats summarises the latencies of one operation of a benchmark.
+type BenchStats struct {
+	Op     string
+	Count  int
+	Errors int
+	Total  time.Duration
+	Min    time.Duration
+	Median time.Duration
+	P99    time.Duration
+	Max    time.Duration
+}
+
+// Throughput returns the operations served per second of their total time.
+func (s BenchStats) Throughput() float64 {
+	if s.Total == 0 {
+		return 0
+	}
+	return float64(s.Count) / s.Total.Seconds()
+}
+
+// benchRecorder collects the latencies of each operation.
+type benchRecorder struct {
+	mu        sync.Mutex
+	latencies map[string][]time.Duration
+	errors    map[string]int
+}
+
+// time calls op, recording its latency as the operation name.
+func (r *benchRecorder) time(name string, op func() error) error {
+	started := time.Now()
+	err := op()
+	elapsed := time.Since(started)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.latencies[name] = append(r.latencies[name], elapsed)
+	if err != nil {
+		r.errors[name]++
+	}
+	return err
+}
+
+func (r *benchRecorder) stats() []BenchStats {
+	stats := []BenchStats{}
+	for _, name := range []string{BenchLookup, BenchReadDirAll, BenchRead} {
+		latencies := r.latencies[name]
+		if len(latencies) == 0 {
+			continue
+		}
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+		s := BenchStats{
+			Op:     name,
+			Count:  len(latencies),
+			Errors: r.errors[name],
+			Min:    latencies[0],
+			Median: latencies[len(latencies)/2],
+			P99:    latencies[len(latencies)*99/100],
+			Max:    latencies[len(latencies)-1],
+		}
+		for _, latency := range latencies {
+			s.Total += latency
+		}
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+// Bench walks the tree beneath the root of the filesystem depth levels deep,
+// as ls -R and cat of every file would, iterations times from each of
+// concurrency goroutines. The operations are served directly, as if for the
+// kernel, so it measures the filesystem and Vault rather than FUSE. Virtual
+// entries (those starting with a dot) and dynamic secrets, whose reads have
+// side effects, are skipped.
+func (v *VaultFS) Bench(ctx context.Context, depth int, iterations int, concurrency int) ([]BenchStats, error) {
+	root, err := v.Root()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &benchRecorder{
+		latencies: make(map[string][]time.Duration),
+		errors:    make(map[string]int),
+	}
+	wg := sync.WaitGroup{}
+	for worker := 0; worker < concurrency; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations && ctx.Err() == nil; i++ {
+				v.benchWalk(ctx, r, root, depth)
+			}
+		}()
+	}
+	wg.Wait()
+	return r.stats(), ctx.Err()
+}
+
+// benchWalk lists node, looks up each of its entries, and reads them if they
+// are files or walks them if they are directories, while depth remains.
+func (v *VaultFS) benchWalk(ctx context.Context, r *benchRecorder, node fs.Node, depth int) {
+	dir, ok := node.(fs.HandleReadDirAller)
+	if !ok || depth <= 0 {
+		return
+	}
+	var dirents []fuse.Dirent
+	if err := r.time(BenchReadDirAll, func() error {
+		var err error
+		dirents, err = dir.ReadDirAll(ctx)
+		return err
+	}); err != nil {
+		return
+	}
+
+	for _, dirent := range dirents {
+		if ctx.Err() != nil {
+			return
+		}
+		if strings.HasPrefix(dirent.Name, ".") {
+			continue
+		}
+
+		var child fs.Node
+		if err := r.time(BenchLookup, func() error {
+			var err error
+			child, err = benchLookup(ctx, node, dirent.Name)
+			return err
+		}); err != nil {
+			continue
+		}
+
+		switch child.(type) {
+		case *DynamicFile:
+		case fs.HandleReadDirAller:
+			v.benchWalk(ctx, r, child, depth-1)
+		case fs.NodeOpener:
+			r.time(BenchRead, func() error { return benchRead(ctx, child) })
+		}
+	}
+}
+
+// benchLookup looks up name in node.
+func benchLookup(ctx context.Context, node fs.Node, name string) (fs.Node, error) {
+	switch n := node.(type) {
+	case fs.NodeRequestLookuper:
+		return n.Lookup(ctx, &fuse.LookupRequest{Name: name}, &fuse.LookupResponse{})
+	case fs.NodeStringLookuper:
+		return n.Lookup(ctx, name)
+	}
+	return nil, fuse.ENOENT
+}
+
+// benchRead opens node and reads its content.
+func benchRead(ctx context.Context, node fs.Node) error {
+	handle, err := node.(fs.NodeOpener).Open(ctx, &fuse.OpenRequest{}, &fuse.OpenResponse{})
+	if err != nil {
+		return err
+	}
+	switch h := handle.(type) {
+	case fs.HandleReadAller:
+		_, err = h.ReadAll(ctx)
+	case fs.HandleReader:
+		err = h.Read(ctx, &fuse.ReadRequest{Size: benchReadSize}, &fuse.ReadResponse{Data: make([]byte, 0, benchReadSize)})
+	}
+	if releaser, ok := handle.(fs.HandleReleaser); ok {
+		releaser.Release(ctx, &fuse.ReleaseRequest{})
+	}
+	return err
+}
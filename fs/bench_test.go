@@ -0,0 +1,146 @@
+package fs
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"bazil.org/fuse/fs"
+	"github.com/wrouesnel/vaultfs/vaultapi"
+	"github.com/wrouesnel/vaultfs/vaultapi/vaulttest"
+	"golang.org/x/net/context"
+)
+
+// benchLatency is the latency of the backend of the benchmarks, roughly that
+// of a Vault on the local network.
+const benchLatency = 500 * time.Microsecond
+
+// benchSecrets is the number of secrets in the directory benchmarked.
+const benchSecrets = 50
+
+// benchBackend returns a backend holding benchSecrets secrets in secret/apps.
+func benchBackend() *vaulttest.Logical {
+	backend := vaulttest.NewLogical()
+	for i := 0; i < benchSecrets; i++ {
+		backend.Put(fmt.Sprintf("secret/apps/app%d", i), map[string]interface{}{
+			"username": fmt.Sprintf("user%d", i),
+			"password": "hunter2",
+		})
+	}
+	backend.SetLatency(benchLatency)
+	return backend
+}
+
+// benchCaches are the cache configurations each benchmark is run with.
+var benchCaches = []struct {
+	name  string
+	cache vaultapi.CacheConfig
+}{
+	{"uncached", vaultapi.CacheConfig{}},
+	{"cached", vaultapi.CacheConfig{TTL: time.Hour, NegativeTTL: time.Hour}},
+}
+
+func BenchmarkLookup(b *testing.B) {
+	for _, c := range benchCaches {
+		b.Run(c.name, func(b *testing.B) {
+			apps, _ := lookupErr(benchRoot(b, c.cache), "apps")
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := lookupErr(apps, fmt.Sprintf("app%d", i%benchSecrets)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkReadDirAll(b *testing.B) {
+	for _, c := range benchCaches {
+		b.Run(c.name, func(b *testing.B) {
+			apps, _ := lookupErr(benchRoot(b, c.cache), "apps")
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := readDirErr(apps); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkRead(b *testing.B) {
+	for _, c := range benchCaches {
+		b.Run(c.name, func(b *testing.B) {
+			root := benchRoot(b, c.cache)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				value, err := lookupErr(root, "apps", fmt.Sprintf("app%d", i%benchSecrets), "data", "password")
+				if err == nil {
+					err = benchRead(context.Background(), value)
+				}
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkReadParallel reads the same value concurrently, as many processes
+// starting at once would, where concurrent requests are shared.
+func BenchmarkReadParallel(b *testing.B) {
+	for _, c := range benchCaches {
+		b.Run(c.name, func(b *testing.B) {
+			root := benchRoot(b, c.cache)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					value, err := lookupErr(root, "apps", "app0", "data", "password")
+					if err == nil {
+						err = benchRead(context.Background(), value)
+					}
+					if err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestBench(t *testing.T) {
+	backend := vaulttest.NewLogical()
+	backend.Put("secret/a", map[string]interface{}{"k": "v"})
+	backend.Put("secret/dir/b", map[string]interface{}{"k": "v"})
+	v := newTestFS(t, backend)
+
+	stats, err := v.Bench(context.Background(), 4, 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]int{}
+	for _, s := range stats {
+		if s.Errors != 0 {
+			t.Errorf("%s: %d errors", s.Op, s.Errors)
+		}
+		if s.Min > s.Median || s.Median > s.P99 || s.P99 > s.Max {
+			t.Errorf("%s: latencies out of order: %+v", s.Op, s)
+		}
+		counts[s.Op] = s.Count
+	}
+	// Each walk reads the root and dir, and the directory, data, auth and
+	// wrap_info of each secret.
+	if counts[BenchReadDirAll] != 4*10 {
+		t.Errorf("expected %d directory reads, got %d", 4*10, counts[BenchReadDirAll])
+	}
+	if counts[BenchRead] == 0 || counts[BenchLookup] <= counts[BenchRead] {
+		t.Errorf("unexpected counts: %v", counts)
+	}
+}
+
+// benchRoot returns the root node of a filesystem over benchBackend with
+// cache.
+func benchRoot(b *testing.B, cache vaultapi.CacheConfig) fs.Node {
+	return root(b, newTestFS(b, benchBackend(), WithCache(cache)))
+}
@@ -0,0 +1,201 @@
+// Capabilities of the token on each path, from sys/capabilities-self, are used
+// to answer access checks, and optionally to report file modes reflecting what
+// can actually be done.
+
+package fs
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/hashicorp/vault/api"
+	"golang.org/x/net/context"
+)
+
+// capabilitiesTTL is how long the capabilities of a path are cached.
+const capabilitiesTTL = time.Minute
+
+// accessLevel summarises the capabilities of the token on a path.
+type accessLevel int
+
+const (
+	// accessDenied means the path can't be read or written.
+	accessDenied accessLevel = iota
+	// accessReadOnly means the path can be read or listed, but not written.
+	accessReadOnly
+	// accessReadWrite means the path can be read and written.
+	accessReadWrite
+)
+
+// fileMode returns the mode of a file with the access level.
+func (a accessLevel) fileMode() os.FileMode {
+	switch a {
+	case accessReadWrite:
+		return 0640
+	case accessReadOnly:
+		return 0440
+	}
+	return 0000
+}
+
+// dirMode returns the mode of a directory with the access level. Denied
+// directories remain traversable, as paths beneath them may be accessible.
+func (a accessLevel) dirMode() os.FileMode {
+	switch a {
+	case accessReadWrite:
+		return 0755
+	case accessReadOnly:
+		return 0555
+	}
+	return 0111
+}
+
+// capabilityEntry is a cached capabilities lookup.
+type capabilityEntry struct {
+	level   accessLevel
+	fetched time.Time
+}
+
+// capabilityCache caches the access level of paths.
+type capabilityCache struct {
+	mu      sync.Mutex
+	entries map[string]capabilityEntry
+}
+
+func newCapabilityCache() *capabilityCache {
+	return &capabilityCache{entries: make(map[string]capabilityEntry)}
+}
+
+// SetCapabilityModes makes file modes reflect the capabilities of the token
+// on each path (at the cost of an extra request per path), instead of fixed
+// modes.
+func (v *VaultFS) SetCapabilityModes(enabled bool) {
+	v.capabilityModes = enabled
+}
+
+// accessLevel returns the access level of the token on path, from the cache
+// if possible. If the capabilities can't be determined, access is assumed to
+// be read-only, matching the fixed modes. In multi-tenant mode it is the
+// access level of the requesting user's token.
+func (v *VaultFS) accessLevel(ctx context.Context, path string) accessLevel {
+	key := path
+	if header, ok := caller(ctx); ok && v.tenants != nil {
+		key = fmt.Sprintf("%d:%s", header.Uid, path)
+	}
+
+	v.capabilities.mu.Lock()
+	entry, found := v.capabilities.entries[key]
+	v.capabilities.mu.Unlock()
+	if found && time.Since(entry.fetched) < capabilitiesTTL {
+		return entry.level
+	}
+
+	secret, err := v.logic(ctx).Write("sys/capabilities-self", map[string]interface{}{
+		"paths": []string{path},
+	})
+	if err != nil || secret == nil {
+		v.log().WithError(err).WithField("path", path).Debug("could not look up capabilities")
+		return accessReadOnly
+	}
+
+	level := capabilityLevel(capabilityList(secret, path))
+	if v.readOnly && level == accessReadWrite {
+		level = accessReadOnly
+	}
+
+	v.capabilities.mu.Lock()
+	v.capabilities.entries[key] = capabilityEntry{level: level, fetched: time.Now()}
+	v.capabilities.mu.Unlock()
+	return level
+}
+
+// capabilityList extracts the capabilities on path from a
+// sys/capabilities-self response. Newer Vault versions key them by path, older
+// ones return a single list.
+func capabilityList(secret *api.Secret, path string) []string {
+	raw, found := secret.Data[path]
+	if !found {
+		raw = secret.Data["capabilities"]
+	}
+	items, _ := raw.([]interface{})
+
+	capabilities := []string{}
+	for _, item := range items {
+		if capability, ok := item.(string); ok {
+			capabilities = append(capabilities, capability)
+		}
+	}
+	return capabilities
+}
+
+// capabilityLevel summarises a list of capabilities.
+func capabilityLevel(capabilities []string) accessLevel {
+	var read, write bool
+	for _, capability := range capabilities {
+		switch capability {
+		case "deny":
+			return accessDenied
+		case "root", "sudo":
+			return accessReadWrite
+		case "read", "list":
+			read = true
+		case "create", "update":
+			write = true
+		}
+	}
+	switch {
+	case read && write:
+		return accessReadWrite
+	case read:
+		return accessReadOnly
+	}
+	return accessDenied
+}
+
+// Statically ensure that the nodes backed by Vault paths implement
+// NodeAccesser
+var _ = fs.NodeAccesser(&SecretDir{})
+var _ = fs.NodeAccesser(&DataDir{})
+var _ = fs.NodeAccesser(&DataValue{})
+
+// Access mask bits (see access(2)).
+const (
+	accessExecute = 1
+	accessWrite   = 2
+	accessRead    = 4
+)
+
+// checkAccess returns EACCES if the access level doesn't permit the accesses
+// in mask. Execute (traversal) is always permitted.
+func (a accessLevel) checkAccess(mask uint32) error {
+	if mask&accessWrite != 0 && a != accessReadWrite {
+		return fuse.Errno(syscall.EACCES)
+	}
+	if mask&accessRead != 0 && a == accessDenied {
+		return fuse.Errno(syscall.EACCES)
+	}
+	return nil
+}
+
+// Access checks the token's capabilities on the path.
+func (s *SecretDir) Access(ctx context.Context, req *fuse.AccessRequest) error {
+	if s.fixed != nil {
+		return nil
+	}
+	return s.fs.accessLevel(ctx, s.lookupPath).checkAccess(req.Mask)
+}
+
+// Access checks the token's capabilities on the secret.
+func (d *DataDir) Access(ctx context.Context, req *fuse.AccessRequest) error {
+	return d.fs.accessLevel(ctx, d.secretPath).checkAccess(req.Mask)
+}
+
+// Access checks the token's capabilities on the secret.
+func (f *DataValue) Access(ctx context.Context, req *fuse.AccessRequest) error {
+	return f.fs.accessLevel(ctx, f.secretPath).checkAccess(req.Mask)
+}
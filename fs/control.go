@@ -0,0 +1,226 @@
+// A control API served on a local unix socket, for managing a long-running
+// mount without killing and remounting it.
+
+package fs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/go-errors/errors"
+	"github.com/wrouesnel/go.log"
+	"golang.org/x/net/context"
+)
+
+// Control API commands, each served at /<command>.
+const (
+	ControlReload  = "reload"
+	ControlReauth  = "reauth"
+	ControlFlush   = "flush"
+	ControlStats   = "stats"
+	ControlUnmount = "unmount"
+)
+
+// ControlStatsResponse is the response of the stats command.
+type ControlStatsResponse struct {
+	Cache          interface{} `json:"cache"`
+	Requests       interface{} `json:"requests"`
+	JournalEntries int         `json:"journal_entries"`
+}
+
+// ServeControl serves the control API on a unix socket at socketPath (which
+// is replaced if it exists) until the filesystem is unmounted. The socket is
+// only accessible to the mounting user: it is made in a directory private to
+// them beside socketPath, and only moved into place once its mode is set.
+func (v *VaultFS) ServeControl(socketPath string) error {
+	dir, err := ioutil.TempDir(filepath.Dir(socketPath), ".vaultfs-control")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	privatePath := filepath.Join(dir, "socket")
+	listener, err := net.Listen("unix", privatePath)
+	if err != nil {
+		return err
+	}
+	// The socket is removed at socketPath when the API is stopped.
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(privatePath, 0600); err != nil {
+		listener.Close()
+		return err
+	}
+	if err := os.Rename(privatePath, socketPath); err != nil {
+		listener.Close()
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+ControlReload, v.controlAction(v.ReloadConfig))
+	mux.HandleFunc("/"+ControlReauth, v.controlAction(v.Reauth))
+	mux.HandleFunc("/"+ControlFlush, v.controlAction(func() error {
+		v.flushCache()
+		return nil
+	}))
+	mux.HandleFunc("/"+ControlStats, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ControlStatsResponse{
+			Cache:          v.CacheStats(),
+			Requests:       v.RequestStats(),
+			JournalEntries: len(v.Journal()),
+		})
+	})
+	mux.HandleFunc("/"+ControlUnmount, v.controlAction(func() error {
+		// Unmount once the response is sent, as it stops the control API.
+		go func() {
+			if err := v.Unmount(); err != nil {
+				v.logger.WithError(err).Error("could not unmount cleanly")
+			}
+		}()
+		return nil
+	}))
+
+	v.control = listener
+	v.controlPath = socketPath
+	go func() {
+		// Serve returns an error once the listener is closed at unmount.
+		err := http.Serve(listener, mux)
+		v.logger.WithError(err).Debug("control API stopped")
+	}()
+	v.logger.WithField("socket", socketPath).Info("serving control API")
+	return nil
+}
+
+// DebugVars returns the state of the filesystem for diagnosing it: its cache
+// and request counters, and the state of Vault and of its token (read from
+// Vault, so it is only as current as the call).
+func (v *VaultFS) DebugVars(ctx context.Context) map[string]interface{} {
+	return map[string]interface{}{
+		"root":       v.root,
+		"mountpoint": v.mountpoint,
+		"cache":      v.CacheStats(),
+		"requests":   v.RequestStats(),
+		"auth":       v.status(ctx),
+	}
+}
+
+// LogDiagnostics logs the state of the filesystem for diagnosing a hung mount
+// in one go: its cache and request counters, the most recent failed requests,
+// and the state of Vault and of its token. Vault is only waited on until ctx
+// is done, as it may be what is hung.
+func (v *VaultFS) LogDiagnostics(ctx context.Context) {
+	logger := v.log()
+	requests := v.RequestStats()
+	logger.WithFields(log.Fields{
+		"cache":    v.CacheStats(),
+		"requests": requests.Requests,
+		"errors":   requests.Errors,
+	}).Info("diagnostics: counters")
+
+	for _, failure := range requests.RecentErrors {
+		logger.WithFields(log.Fields{
+			"failed_at": failure.Time,
+			"method":    failure.Method,
+			"path":      failure.Path,
+		}).WithError(errors.New(failure.Error)).Info("diagnostics: recent error")
+	}
+
+	status := make(chan map[string]interface{}, 1)
+	go func() { status <- v.status(ctx) }()
+	select {
+	case values := <-status:
+		logger.WithFields(log.Fields{
+			"sealed":    values["sealed"],
+			"token_ttl": values["token_ttl"],
+		}).Info("diagnostics: vault")
+	case <-ctx.Done():
+		logger.Warn("diagnostics: vault did not answer in time")
+	}
+}
+
+// controlAction returns a handler performing action on POST requests.
+func (v *VaultFS) controlAction(action func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		v.logger.WithField("command", r.URL.Path).Info("control command")
+		if err := action(); err != nil {
+			v.logger.WithError(err).WithField("command", r.URL.Path).Warn("control command failed")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ReloadConfig connects to Vault again with the settings given by the config
+// loader, as Reconfigure does, or, without one, drops all state derived from
+// Vault as Reload does.
+func (v *VaultFS) ReloadConfig() error {
+	v.configMu.Lock()
+	load := v.configLoader
+	v.configMu.Unlock()
+	if load == nil {
+		v.Reload()
+		return nil
+	}
+
+	config, auth, err := load()
+	if err != nil {
+		return err
+	}
+	return v.Reconfigure(config, auth)
+}
+
+// Reload drops all state derived from Vault (cached responses, capabilities
+// and tenant tokens), so it is read afresh.
+func (v *VaultFS) Reload() {
+	v.flushCache()
+
+	v.capabilities.mu.Lock()
+	v.capabilities.entries = make(map[string]capabilityEntry)
+	v.capabilities.mu.Unlock()
+
+	if v.tenants != nil {
+		v.dropTenants()
+	}
+}
+
+// flushCache drops all cached responses, including those of tenants, and the
+// paths found not to exist.
+func (v *VaultFS) flushCache() {
+	v.flushMissing()
+	if v.cache != nil {
+		v.cache.Flush()
+	}
+	if v.tenants != nil {
+		v.tenants.mu.Lock()
+		for _, t := range v.tenants.tenants {
+			if t.cache != nil {
+				t.cache.Flush()
+			}
+		}
+		v.tenants.mu.Unlock()
+	}
+}
+
+// stopControl stops serving the control API.
+func (v *VaultFS) stopControl() error {
+	if v.control == nil {
+		return nil
+	}
+	listener := v.control
+	v.control = nil
+	if err := listener.Close(); err != nil {
+		return errors.WrapPrefix(err, "could not close control socket", 0)
+	}
+	if err := os.Remove(v.controlPath); err != nil && !os.IsNotExist(err) {
+		return errors.WrapPrefix(err, "could not remove control socket", 0)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package fs
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/wrouesnel/vaultfs/vaultapi/vaulttest"
+)
+
+func TestReauth(t *testing.T) {
+	server := vaulttest.NewServer(testBackend())
+	defer server.Close()
+
+	v, err := New(Options{
+		Vault: &api.Config{Address: server.URL},
+		Auth:  Auth{Method: "ldap", User: "user", Secret: "password"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := v.backend.Token()
+	if err := v.Reauth(); err != nil {
+		t.Fatal(err)
+	}
+	if logins := server.Logins(); logins != 2 {
+		t.Errorf("expected reauth to log in again, got %d logins", logins)
+	}
+	if v.backend.Token() == token {
+		t.Error("expected reauth to replace the token")
+	}
+
+	// A token given directly can't be replaced by logging in.
+	if err := newTestFS(t, testBackend()).Reauth(); err == nil {
+		t.Error("expected reauth with a given token to fail")
+	}
+}
+
+func TestServeControl(t *testing.T) {
+	server := vaulttest.NewServer(testBackend())
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "vaultfs-control")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socketPath := filepath.Join(dir, "control.sock")
+
+	v := newTestFS(t, testBackend())
+	if err := v.ServeControl(socketPath); err != nil {
+		t.Fatal(err)
+	}
+	defer v.stopControl()
+	info, err := os.Stat(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode(); mode&os.ModeSocket == 0 || mode.Perm() != 0600 {
+		t.Errorf("expected a socket only the user can access, got %v", mode)
+	}
+	if names, _ := filepath.Glob(filepath.Join(dir, "*")); len(names) != 1 {
+		t.Errorf("expected only the socket to be left, got %v", names)
+	}
+
+	// reload reconnects with the settings of the config loader.
+	loads := 0
+	v.SetConfigLoader(func() (*api.Config, Auth, error) {
+		loads++
+		return &api.Config{Address: server.URL}, Auth{Method: "ldap", User: "user", Secret: "password"}, nil
+	})
+	client := &http.Client{Transport: &http.Transport{
+		Dial: func(network, address string) (net.Conn, error) {
+			return net.Dial("unix", socketPath)
+		},
+	}}
+	resp, err := client.Post("http://vaultfs/"+ControlReload, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("expected reload to succeed, got %s", resp.Status)
+	}
+	if loads != 1 || server.Logins() != 1 {
+		t.Errorf("expected reload to load the config and log in again, got %d loads and %d logins", loads, server.Logins())
+	}
+	if address := v.vaultConfig().Address; address != server.URL {
+		t.Errorf("expected reload to reconnect to %s, got %s", server.URL, address)
+	}
+}
@@ -0,0 +1,122 @@
+// A write-only file which performs an action in Vault with whatever is
+// written to it.
+
+package fs
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/go-errors/errors"
+	"github.com/wrouesnel/vaultfs/vaultapi"
+	"golang.org/x/net/context"
+)
+
+// Statically ensure that *ControlFile implements the given interface
+var _ = fs.HandleWriter(&ControlFile{})
+var _ = fs.NodeSetattrer(&ControlFile{})
+
+// controlDirName is the directory of control files in a KV v2 secret.
+const controlDirName = ".control"
+
+// ControlFile implements a write-only node which passes each write to an
+// action.
+type ControlFile struct {
+	fs     *VaultFS // root filesystem this node is associated with
+	path   string   // Vault path the action is performed on, for auditing
+	action func(ctx context.Context, content string) error
+}
+
+// NewControlFile returns a new ControlFile node calling action on the Vault
+// path p with the content of every write.
+func NewControlFile(fs *VaultFS, p string, action func(ctx context.Context, content string) error) *ControlFile {
+	return &ControlFile{
+		fs:     fs,
+		path:   p,
+		action: action,
+	}
+}
+
+// Attr sets attrs on the given fuse.Attr
+func (f *ControlFile) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Valid = f.fs.attrTimeout
+	a.Mode = os.FileMode(0200)
+	a.Uid = f.fs.uid
+	a.Gid = f.fs.gid
+
+	return nil
+}
+
+// Setattr accepts (and ignores) the truncation done when opening for writing.
+func (f *ControlFile) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
+	return nil
+}
+
+// Write passes the written content to the action.
+func (f *ControlFile) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	err := f.action(ctx, string(req.Data))
+	f.fs.audit("control", req.Header, f.path, err)
+	if err != nil {
+		opLog(ctx).WithError(err).Warn("control action failed")
+		if _, ok := err.(fuse.Errno); ok {
+			return err
+		}
+		return backendErrno(err)
+	}
+	resp.Size = len(req.Data)
+	return nil
+}
+
+// parseVersions parses a whitespace or comma separated list of secret
+// versions.
+func parseVersions(content string) ([]int, error) {
+	fields := strings.FieldsFunc(content, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	})
+	if len(fields) == 0 {
+		return nil, errors.New("no versions given")
+	}
+
+	versions := []int{}
+	for _, field := range fields {
+		version, err := strconv.Atoi(field)
+		if err != nil || version < 1 {
+			return nil, errors.Errorf("invalid version: %q", field)
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+// newKVv2ControlDir returns the control directory for the KV v2 secret at
+// dataPath. Writing version numbers to its files undeletes or destroys those
+// versions of the secret.
+func newKVv2ControlDir(vfs *VaultFS, dataPath string) *StaticDir {
+	versionFile := func(endpoint string) *ControlFile {
+		actionPath, _ := vaultapi.KVv2Path(dataPath, endpoint)
+		return NewControlFile(vfs, actionPath, func(ctx context.Context, content string) error {
+			versions, err := parseVersions(content)
+			if err != nil {
+				return fuse.Errno(syscall.EINVAL)
+			}
+			if _, err := vfs.logic(ctx).Write(actionPath, map[string]interface{}{"versions": versions}); err != nil {
+				return err
+			}
+			vfs.invalidate(dataPath)
+			opLog(ctx).WithField("path", dataPath).WithField("versions", versions).WithField("endpoint", endpoint).Info("changed secret versions")
+			return nil
+		})
+	}
+
+	return &StaticDir{
+		fs: vfs,
+		children: map[string]fs.Node{
+			"undelete": versionFile("undelete"),
+			"destroy":  versionFile("destroy"),
+		},
+	}
+}
@@ -0,0 +1,71 @@
+// Secrets engines generating credentials (database, aws, consul) create a new
+// lease on every read. Credentials are exposed as files which generate one
+// set per open, keep its lease renewed while the file is open, and revoke it
+// when the file is closed.
+
+package fs
+
+import (
+	"encoding/json"
+	"path"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/wrouesnel/vaultfs/vaultapi"
+	"golang.org/x/net/context"
+)
+
+// credsEngine provides the creds/<role> files of a credential generating
+// mount.
+type credsEngine struct{}
+
+// lookup implements engine.
+func (credsEngine) lookup(vfs *VaultFS, mount string, relPath string) (fs.Node, error) {
+	if relPath != "creds" {
+		return nil, nil
+	}
+
+	credsFile := func(ctx context.Context, role string) (fs.Node, error) {
+		credsPath := path.Join(mount, "creds", role)
+		return NewDynamicFile(vfs, credsPath, false, func(ctx context.Context, req fuse.Header, input []byte) ([]byte, error) {
+			secret, err := vfs.logic(ctx).ReadDynamic(credsPath)
+			if err != nil {
+				return nil, err
+			}
+			if secret == nil {
+				return nil, fuse.ENOENT
+			}
+
+			content, err := json.MarshalIndent(map[string]interface{}{
+				"lease_id":       secret.LeaseID,
+				"lease_duration": secret.LeaseDuration,
+				"data":           secret.Data,
+			}, "", "  ")
+			if err != nil {
+				return nil, err
+			}
+
+			if secret.LeaseID != "" {
+				opLog(ctx).WithField("path", credsPath).WithField("lease_id", secret.LeaseID).Info("generated credentials")
+				onRelease(ctx, vaultapi.HoldLease(vfs.logic(ctx), secret))
+			}
+			return append(content, '\n'), nil
+		}), nil
+	}
+
+	listRoles := func(ctx context.Context) ([]fuse.Dirent, error) {
+		return vfs.listKeys(ctx, path.Join(mount, "roles"), fuse.DT_File)
+	}
+
+	return NewLookupDir(vfs, credsFile, listRoles), nil
+}
+
+// entries implements engine.
+func (credsEngine) entries(relPath string) []fuse.Dirent {
+	if relPath != "" {
+		return nil
+	}
+	return []fuse.Dirent{
+		{Name: "creds", Type: fuse.DT_Dir},
+	}
+}
@@ -0,0 +1,394 @@
+// A writable view of the data of a secret, for paths where writing is enabled
+// (by default cubbyhole/). Each data key is a file, and changes are written
+// back to Vault when a file is closed.
+
+package fs
+
+import (
+	"encoding/base64"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/hashicorp/vault/api"
+	"github.com/wrouesnel/vaultfs/vaultapi"
+	"golang.org/x/net/context"
+)
+
+// Statically ensure that *DataDir, *DataValue and *dataHandle implement those
+// interfaces
+var _ = fs.HandleReadDirAller(&DataDir{})
+var _ = fs.NodeRequestLookuper(&DataDir{})
+var _ = fs.NodeCreater(&DataDir{})
+var _ = fs.NodeRemover(&DataDir{})
+var _ = fs.NodeOpener(&DataValue{})
+var _ = fs.NodeSetattrer(&DataValue{})
+var _ = fs.HandleReader(&dataHandle{})
+var _ = fs.HandleWriter(&dataHandle{})
+var _ = fs.HandleFlusher(&dataHandle{})
+var _ = fs.HandleReleaser(&dataHandle{})
+
+// DefaultWritablePaths are the paths under which secrets can be written by
+// default.
+var DefaultWritablePaths = []string{"cubbyhole"}
+
+// SetWritablePaths sets the Vault paths under which the data of secrets can be
+// modified through the filesystem. The default is DefaultWritablePaths.
+func (v *VaultFS) SetWritablePaths(paths []string) {
+	writable := []string{}
+	for _, p := range paths {
+		writable = append(writable, strings.Trim(p, "/"))
+	}
+	v.writable = writable
+}
+
+// isWritable returns true if the data of the secret at secretPath can be
+// modified. Nothing is writable in read-only mode.
+func (v *VaultFS) isWritable(secretPath string) bool {
+	if v.readOnly {
+		return false
+	}
+	secretPath = strings.Trim(secretPath, "/")
+	for _, prefix := range v.writable {
+		if secretPath == prefix || strings.HasPrefix(secretPath, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// updateSecretData reads the secret at secretPath, applies update to a copy of
+// its data, and writes it back.
+func (v *VaultFS) updateSecretData(ctx context.Context, secretPath string, update func(data map[string]interface{})) error {
+	// Read around the cache, as a stale copy would lose other changes.
+	secret, err := v.logic(ctx).ReadDynamic(secretPath)
+	if err != nil {
+		return err
+	}
+
+	data := make(map[string]interface{})
+	for k, value := range vaultapi.SecretData(secret) {
+		data[k] = value
+	}
+	update(data)
+
+	if _, err := v.logic(ctx).Write(secretPath, vaultapi.WriteData(secretPath, data)); err != nil {
+		return err
+	}
+	opLog(ctx).WithField("path", secretPath).Info("updated secret")
+	return nil
+}
+
+// DataDir implements a writable directory of the data keys of a secret.
+type DataDir struct {
+	fs         *VaultFS // root filesystem this node is associated with
+	secretPath string   // Vault path of the secret
+	values     map[string]interface{}
+	meta       secretMeta // metadata of the secret
+}
+
+// NewDataDir returns a new DataDir for the secret at secretPath with the given
+// data.
+func NewDataDir(fs *VaultFS, secretPath string, values map[string]interface{}) *DataDir {
+	return &DataDir{
+		fs:         fs,
+		secretPath: secretPath,
+		values:     values,
+	}
+}
+
+// newSecretDataDir returns a new DataDir for secret, read from secretPath.
+func (v *VaultFS) newSecretDataDir(secretPath string, secret *api.Secret) *DataDir {
+	dataDir := NewDataDir(v, secretPath, vaultapi.SecretData(secret))
+	dataDir.meta = newSecretMeta(secretPath, secret)
+	return dataDir
+}
+
+// Attr sets attrs on the given fuse.Attr
+func (d *DataDir) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Valid = d.fs.attrTimeout
+	a.Mode = os.ModeDir | os.FileMode(0755)
+	if d.fs.capabilityModes {
+		a.Mode = os.ModeDir | d.fs.accessLevel(ctx, d.secretPath).dirMode()
+	}
+	a.Uid = d.fs.uid
+	a.Gid = d.fs.gid
+	d.meta.setTimes(a)
+
+	return nil
+}
+
+// Lookup looks up a data key. String values are writable files, while other
+// values are presented read-only, as in a StaticDir.
+func (d *DataDir) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.LookupResponse) (fs.Node, error) {
+	name := req.Name
+	setOpPath(ctx, path.Join(d.secretPath, name))
+
+	resp.EntryValid = d.fs.entryTimeout
+	return d.child(name)
+}
+
+// child returns the node for a data key.
+func (d *DataDir) child(name string) (fs.Node, error) {
+	value, found := d.values[name]
+	if !found {
+		return nil, fuse.ENOENT
+	}
+	if s, ok := value.(string); ok {
+		node := NewDataValue(d.fs, d.secretPath, name, s)
+		node.meta = d.meta.child(name)
+		return node, nil
+	}
+
+	static, err := NewStaticDir(d.fs, d.fs.decodeBase64Data(d.secretPath, map[string]interface{}{name: value}))
+	if err != nil {
+		return nil, fuse.EIO
+	}
+	static.setMeta(d.meta)
+	return static.children[name], nil
+}
+
+// ReadDirAll enumerates the data keys.
+func (d *DataDir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	setOpPath(ctx, d.secretPath)
+
+	static, err := NewStaticDir(d.fs, d.values)
+	if err != nil {
+		return []fuse.Dirent{}, fuse.EIO
+	}
+	return static.ReadDirAll(ctx)
+}
+
+// Create adds a new data key. It is written to Vault when the file is closed.
+func (d *DataDir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.CreateResponse) (fs.Node, fs.Handle, error) {
+	setOpPath(ctx, path.Join(d.secretPath, req.Name))
+
+	if _, found := d.values[req.Name]; found && req.Flags&fuse.OpenExclusive != 0 {
+		return nil, nil, fuse.EEXIST
+	}
+
+	node := NewDataValue(d.fs, d.secretPath, req.Name, "")
+	node.meta = d.meta.child(req.Name)
+	h := &dataHandle{value: node, dirty: true}
+	node.handles[h] = true
+	resp.Flags |= fuse.OpenDirectIO
+	return node, h, nil
+}
+
+// Remove deletes a data key from the secret.
+func (d *DataDir) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
+	setOpPath(ctx, path.Join(d.secretPath, req.Name))
+
+	if _, found := d.values[req.Name]; !found {
+		return fuse.ENOENT
+	}
+
+	err := d.fs.updateSecretData(ctx, d.secretPath, func(data map[string]interface{}) {
+		delete(data, req.Name)
+	})
+	d.fs.audit("remove", req.Header, d.meta.child(req.Name).path, err)
+	if err != nil {
+		opLog(ctx).WithError(err).Warn("could not remove secret key")
+		return backendErrno(err)
+	}
+	delete(d.values, req.Name)
+	return nil
+}
+
+// DataValue implements a writable file holding the value of a data key.
+type DataValue struct {
+	fs         *VaultFS // root filesystem this node is associated with
+	secretPath string   // Vault path of the secret
+	key        string   // data key within the secret
+	base64     bool     // the value is stored base64-encoded
+
+	meta secretMeta // metadata of the secret (optional)
+
+	mu      sync.Mutex
+	value   []byte
+	handles map[*dataHandle]bool // open handles, truncated along with the value
+}
+
+// NewDataValue returns a new DataValue for key of the secret at secretPath.
+// Base64-encoded values are decoded, and encoded again when stored.
+func NewDataValue(fs *VaultFS, secretPath string, key string, value string) *DataValue {
+	return &DataValue{
+		fs:         fs,
+		secretPath: secretPath,
+		key:        key,
+		base64:     fs.isBase64Key(secretPath, key),
+		value:      []byte(fs.decodeBase64Value(secretPath, key, value)),
+		handles:    make(map[*dataHandle]bool),
+	}
+}
+
+// Attr sets attrs on the given fuse.Attr
+func (f *DataValue) Attr(ctx context.Context, a *fuse.Attr) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	a.Valid = f.fs.attrTimeout
+	a.Mode = os.FileMode(0660)
+	if f.fs.capabilityModes {
+		a.Mode = f.fs.accessLevel(ctx, f.secretPath).fileMode()
+	}
+	a.Uid = f.fs.uid
+	a.Gid = f.fs.gid
+	a.Size = f.fs.fileSize(len(f.value))
+	f.meta.setTimes(a)
+
+	return nil
+}
+
+// Setattr handles truncation. Truncating an open file (e.g. opening it with
+// O_TRUNC) truncates its handles, which write the value when flushed, while
+// truncating a file which isn't open writes the truncated value immediately.
+func (f *DataValue) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
+	if !req.Valid.Size() {
+		return nil
+	}
+
+	f.mu.Lock()
+	if req.Size > uint64(len(f.value)) {
+		f.mu.Unlock()
+		return fuse.Errno(syscall.EINVAL)
+	}
+	handles := []*dataHandle{}
+	for h := range f.handles {
+		handles = append(handles, h)
+	}
+	f.mu.Unlock()
+
+	for _, h := range handles {
+		h.truncate(req.Size)
+	}
+	if req.Valid.Handle() {
+		return nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	value := f.value[:req.Size]
+	err := f.store(ctx, value)
+	f.fs.audit("write", req.Header, f.meta.path, err)
+	if err != nil {
+		return err
+	}
+	f.value = value
+	return nil
+}
+
+// store writes value to Vault. f.mu must be held.
+func (f *DataValue) store(ctx context.Context, value []byte) error {
+	stored := string(value)
+	if f.base64 {
+		stored = base64.StdEncoding.EncodeToString(value)
+	}
+	if err := f.fs.updateSecretData(ctx, f.secretPath, func(data map[string]interface{}) {
+		data[f.key] = stored
+	}); err != nil {
+		opLog(ctx).WithError(err).Warn("could not write secret key")
+		return backendErrno(err)
+	}
+	return nil
+}
+
+// Open returns a handle buffering changes to the value.
+func (f *DataValue) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.fs.audit("read", req.Header, f.meta.path, nil)
+
+	h := &dataHandle{value: f}
+	if req.Flags&fuse.OpenTruncate == 0 {
+		h.buffer = append([]byte{}, f.value...)
+	}
+	f.handles[h] = true
+	resp.Flags |= fuse.OpenDirectIO
+	return h, nil
+}
+
+// dataHandle buffers changes to a DataValue until it is flushed.
+type dataHandle struct {
+	value *DataValue
+
+	mu     sync.Mutex
+	buffer []byte
+	dirty  bool
+}
+
+// Read returns the buffered value.
+func (h *dataHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return readContent(req, resp, h.buffer)
+}
+
+// Write changes the buffered value.
+func (h *dataHandle) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	if req.Offset < 0 {
+		return fuse.Errno(syscall.EINVAL)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	end := int(req.Offset) + len(req.Data)
+	if end > len(h.buffer) {
+		h.buffer = append(h.buffer, make([]byte, end-len(h.buffer))...)
+	}
+	copy(h.buffer[req.Offset:], req.Data)
+	h.dirty = true
+	resp.Size = len(req.Data)
+	return nil
+}
+
+// Flush writes the buffered value to Vault if it was changed.
+func (h *dataHandle) Flush(ctx context.Context, req *fuse.FlushRequest) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.dirty {
+		return nil
+	}
+
+	h.value.mu.Lock()
+	defer h.value.mu.Unlock()
+
+	value := append([]byte{}, h.buffer...)
+	err := h.value.store(ctx, value)
+	h.value.fs.audit("write", req.Header, h.value.meta.path, err)
+	if err != nil {
+		return err
+	}
+	h.value.value = value
+	h.dirty = false
+	return nil
+}
+
+// truncate shortens the buffered value to size.
+func (h *dataHandle) truncate(size uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if size < uint64(len(h.buffer)) {
+		h.buffer = h.buffer[:size]
+	}
+	h.dirty = true
+}
+
+// Release forgets the handle.
+func (h *dataHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	h.value.mu.Lock()
+	defer h.value.mu.Unlock()
+
+	delete(h.value.handles, h)
+	return nil
+}
@@ -0,0 +1,237 @@
+// A file whose content is generated from Vault separately for every open
+// handle, optionally from input written to the handle first.
+
+package fs
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"golang.org/x/net/context"
+)
+
+// Statically ensure that *DynamicFile and *dynamicHandle implement those
+// interfaces
+var _ = fs.NodeOpener(&DynamicFile{})
+var _ = fs.NodeSetattrer(&DynamicFile{})
+var _ = fs.HandleReader(&dynamicHandle{})
+var _ = fs.HandleWriter(&dynamicHandle{})
+var _ = fs.HandleFlusher(&dynamicHandle{})
+var _ = fs.HandleReleaser(&dynamicHandle{})
+
+// generateFunc produces the content of a DynamicFile handle from the input
+// written to it (nil if nothing was written).
+type generateFunc func(ctx context.Context, req fuse.Header, input []byte) ([]byte, error)
+
+// DynamicFile implements a node which generates its content when a handle is
+// first read, and keeps it stable for the lifetime of the handle.
+type DynamicFile struct {
+	fs       *VaultFS // root filesystem this node is associated with
+	path     string   // Vault path the content is generated from, for auditing
+	mode     os.FileMode
+	generate generateFunc
+
+	mu        sync.Mutex
+	generated time.Time // when content (e.g. a lease) was last generated
+}
+
+// NewDynamicFile returns a new DynamicFile node generating its content from
+// the Vault path p ("" if it doesn't access Vault). Writable files pass
+// whatever is written to a handle to generate when it is next read.
+func NewDynamicFile(fs *VaultFS, p string, writable bool, generate generateFunc) *DynamicFile {
+	mode := os.FileMode(0440)
+	if writable {
+		mode = os.FileMode(0660)
+	}
+	return &DynamicFile{
+		fs:       fs,
+		path:     p,
+		mode:     mode,
+		generate: generate,
+	}
+}
+
+// Attr sets attrs on the given fuse.Attr. The size is unknown until the
+// content is generated, so handles use direct IO. The timestamps are those of
+// the last generation.
+func (f *DynamicFile) Attr(ctx context.Context, a *fuse.Attr) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	a.Valid = f.fs.attrTimeout
+	a.Mode = f.mode
+	a.Uid = f.fs.uid
+	a.Gid = f.fs.gid
+	a.Size = 0
+	a.Mtime = f.generated
+	a.Ctime = f.generated
+
+	return nil
+}
+
+// Setattr accepts (and ignores) the truncation done when opening for writing.
+func (f *DynamicFile) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
+	return nil
+}
+
+// Open returns a new handle with its own content.
+func (f *DynamicFile) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	resp.Flags |= fuse.OpenDirectIO
+	return &dynamicHandle{file: f}, nil
+}
+
+// dynamicHandle holds the state of an open DynamicFile.
+type dynamicHandle struct {
+	file *DynamicFile
+
+	mu        sync.Mutex
+	input     []byte
+	output    []byte
+	generated bool
+	release   func() // called when the handle is released, if set
+}
+
+// Read generates the content on the first read, and serves it from then on.
+func (h *dynamicHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.generated {
+		if err := h.generateLocked(ctx, req.Header); err != nil {
+			return err
+		}
+	}
+
+	return readContent(req, resp, h.output)
+}
+
+// Write records input for the next generation. Writing after the content was
+// generated starts a new input.
+func (h *dynamicHandle) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	if h.file.mode&0200 == 0 {
+		return fuse.EPERM
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.generated {
+		h.input = nil
+		h.output = nil
+		h.generated = false
+	}
+	if req.Offset != int64(len(h.input)) {
+		return fuse.Errno(syscall.EINVAL)
+	}
+	h.input = append(h.input, req.Data...)
+	resp.Size = len(req.Data)
+	return nil
+}
+
+// Flush generates the content from any input written but not yet read back, so
+// writing alone (e.g. with a shell redirect) performs the operation, and its
+// errors are reported by close.
+func (h *dynamicHandle) Flush(ctx context.Context, req *fuse.FlushRequest) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.generated || len(h.input) == 0 {
+		return nil
+	}
+	return h.generateLocked(ctx, req.Header)
+}
+
+// generateLocked generates the content of the handle from its input.
+func (h *dynamicHandle) generateLocked(ctx context.Context, header fuse.Header) error {
+	output, err := h.file.generate(withHandle(ctx, h), header, h.input)
+	if h.file.path != "" {
+		h.file.fs.audit("generate", header, h.file.path, err)
+	}
+	if err != nil {
+		opLog(ctx).WithError(err).Warn("could not generate file content")
+		if errno, ok := err.(fuse.Errno); ok {
+			return errno
+		}
+		return backendErrno(err)
+	}
+	h.output = output
+	h.generated = true
+
+	h.file.mu.Lock()
+	h.file.generated = time.Now()
+	h.file.mu.Unlock()
+	return nil
+}
+
+// Release runs any cleanup registered while generating the content.
+func (h *dynamicHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.release != nil {
+		h.release()
+		h.release = nil
+	}
+	return nil
+}
+
+// handleKey is the context key for the handle content is generated for.
+type handleKey struct{}
+
+// withHandle returns a context carrying h, so generateFuncs can register
+// cleanup with onRelease.
+func withHandle(ctx context.Context, h *dynamicHandle) context.Context {
+	return context.WithValue(ctx, handleKey{}, h)
+}
+
+// onRelease registers fn to be called when the handle the content in ctx is
+// being generated for is released. The handle lock is already held.
+func onRelease(ctx context.Context, fn func()) {
+	if h, ok := ctx.Value(handleKey{}).(*dynamicHandle); ok {
+		h.release = fn
+	}
+}
+
+// resultStore holds the most recent result of an operation for each user, so
+// a result written through one file can be read back from a paired file.
+type resultStore struct {
+	mu      sync.Mutex
+	results map[string][]byte
+}
+
+func newResultStore() *resultStore {
+	return &resultStore{results: make(map[string][]byte)}
+}
+
+func (r *resultStore) key(uid uint32, name string) string {
+	return fmt.Sprintf("%d:%s", uid, name)
+}
+
+// set stores the result of name for uid.
+func (r *resultStore) set(uid uint32, name string, result []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.results[r.key(uid, name)] = result
+}
+
+// get returns the result of name for uid.
+func (r *resultStore) get(uid uint32, name string) ([]byte, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result, found := r.results[r.key(uid, name)]
+	return result, found
+}
+
+// resultFile returns a file which reads back the result of name stored for
+// the calling user (empty if there is none).
+func (r *resultStore) resultFile(vfs *VaultFS, name string) *DynamicFile {
+	return NewDynamicFile(vfs, "", false, func(ctx context.Context, req fuse.Header, input []byte) ([]byte, error) {
+		result, _ := r.get(req.Uid, name)
+		return result, nil
+	})
+}
@@ -0,0 +1,112 @@
+// Secrets engines other than KV are exposed through specialised nodes for the
+// paths where plain reads and lists don't make sense.
+
+package fs
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/go-errors/errors"
+)
+
+// engine provides the specialised nodes of a type of secrets engine.
+type engine interface {
+	// lookup returns the node for relPath within the engine mounted at mount,
+	// or nil if relPath has no special handling.
+	lookup(vfs *VaultFS, mount string, relPath string) (fs.Node, error)
+	// entries returns the specialised nodes in the directory at relPath
+	// (which is "" for the mount itself), to list beside anything in Vault.
+	entries(relPath string) []fuse.Dirent
+}
+
+// engines are the supported secrets engines by type.
+var engines = map[string]engine{
+	"transit": transitEngine{},
+	"pki":     pkiEngine{},
+	"totp":    totpEngine{},
+	"ssh":     sshEngine{},
+
+	"database": credsEngine{},
+	"aws":      credsEngine{},
+	"consul":   credsEngine{},
+}
+
+// DefaultEngineMounts maps the default mount path of each supported secrets
+// engine to its type.
+var DefaultEngineMounts = map[string]string{
+	"transit": "transit",
+	"pki":     "pki",
+	"totp":    "totp",
+	"ssh":     "ssh",
+
+	"database": "database",
+	"aws":      "aws",
+	"consul":   "consul",
+}
+
+// SetEngineMounts sets which paths secrets engines with specialised nodes are
+// mounted at, as a map of mount path to engine type. The default is
+// DefaultEngineMounts.
+func (v *VaultFS) SetEngineMounts(mounts map[string]string) error {
+	engineMounts := make(map[string]string)
+	for mount, engineType := range mounts {
+		if _, found := engines[engineType]; !found {
+			return errors.Errorf("unsupported secrets engine type: %s", engineType)
+		}
+		engineMounts[strings.Trim(mount, "/")] = engineType
+	}
+	v.engineMounts = engineMounts
+	return nil
+}
+
+// EngineTypes returns the supported secrets engine types.
+func EngineTypes() []string {
+	types := []string{}
+	for engineType := range engines {
+		types = append(types, engineType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// engineLookup returns the specialised node for lookupPath if it is within a
+// secrets engine mount, or nil if the path should be handled as a secret.
+func (v *VaultFS) engineLookup(lookupPath string) (fs.Node, error) {
+	lookupPath = strings.Trim(lookupPath, "/")
+	for mount, engineType := range v.engineMounts {
+		if !strings.HasPrefix(lookupPath, mount+"/") {
+			continue
+		}
+		return engines[engineType].lookup(v, mount, strings.TrimPrefix(lookupPath, mount+"/"))
+	}
+	return nil, nil
+}
+
+// engineEntries returns the specialised nodes of a secrets engine in the
+// directory at lookupPath.
+func (v *VaultFS) engineEntries(lookupPath string) []fuse.Dirent {
+	lookupPath = strings.Trim(lookupPath, "/")
+	for mount, engineType := range v.engineMounts {
+		var entries []fuse.Dirent
+		if lookupPath == mount {
+			entries = engines[engineType].entries("")
+		} else if strings.HasPrefix(lookupPath, mount+"/") {
+			entries = engines[engineType].entries(strings.TrimPrefix(lookupPath, mount+"/"))
+		} else {
+			continue
+		}
+
+		visible := []fuse.Dirent{}
+		for _, entry := range entries {
+			if v.pathVisible(path.Join(lookupPath, entry.Name)) {
+				visible = append(visible, entry)
+			}
+		}
+		return visible
+	}
+	return nil
+}
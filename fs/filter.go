@@ -0,0 +1,86 @@
+// Include and exclude filters constraining which Vault paths are visible
+// through the mount.
+
+package fs
+
+import (
+	"path"
+	"strings"
+
+	"github.com/go-errors/errors"
+)
+
+// SetPathFilters sets the glob patterns of the Vault paths visible through the
+// mount. If any include patterns are given, only paths matching one of them
+// (and their parent directories, so they can be reached) are visible. Paths
+// matching an exclude pattern are never visible. Patterns match whole paths,
+// and a path matching a pattern also matches everything beneath it.
+func (v *VaultFS) SetPathFilters(include []string, exclude []string) error {
+	for _, pattern := range append(append([]string{}, include...), exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.Errorf("invalid path pattern: %q", pattern)
+		}
+	}
+	v.include = trimPatterns(include)
+	v.exclude = trimPatterns(exclude)
+	return nil
+}
+
+func trimPatterns(patterns []string) []string {
+	trimmed := []string{}
+	for _, pattern := range patterns {
+		trimmed = append(trimmed, strings.Trim(pattern, "/"))
+	}
+	return trimmed
+}
+
+// pathVisible returns true if the Vault path p is visible through the mount.
+func (v *VaultFS) pathVisible(p string) bool {
+	p = strings.Trim(p, "/")
+
+	for _, pattern := range v.exclude {
+		if matchPathOrParent(pattern, p) {
+			return false
+		}
+	}
+
+	if len(v.include) == 0 {
+		return true
+	}
+	for _, pattern := range v.include {
+		if matchPathOrParent(pattern, p) || matchAncestor(pattern, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPathOrParent returns true if pattern matches p or any of its parents.
+func matchPathOrParent(pattern string, p string) bool {
+	parts := strings.Split(p, "/")
+	for i := len(parts); i > 0; i-- {
+		if matched, _ := path.Match(pattern, strings.Join(parts[:i], "/")); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAncestor returns true if p is a parent of paths which pattern could
+// match.
+func matchAncestor(pattern string, p string) bool {
+	if p == "" {
+		return true
+	}
+	parts := strings.Split(p, "/")
+	patternParts := strings.Split(pattern, "/")
+	if len(parts) >= len(patternParts) {
+		return false
+	}
+	for i, part := range parts {
+		if matched, _ := path.Match(patternParts[i], part); !matched {
+			return false
+		}
+	}
+	return true
+}
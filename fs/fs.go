@@ -15,6 +15,11 @@
 package fs
 
 import (
+	"net"
+	"os"
+	"sync"
+	"time"
+
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
 	"github.com/go-errors/errors"
@@ -22,6 +27,7 @@ import (
 	"github.com/wrouesnel/go.log"
 
 	"github.com/wrouesnel/vaultfs/vaultapi"
+	"golang.org/x/net/context"
 	"gopkg.in/AlecAivazis/survey.v1"
 )
 
@@ -30,81 +36,742 @@ import (
 // manage access to backend keys in vault (i.e. error handling, failover and
 // re-auth attempts.
 type VaultFS struct {
+	backend    *vaultapi.SwappableLogical // authenticated backend underlying logical
+	authed     vaultapi.AuthableLogical   // backend as requests through the mount reach it
 	logical    vaultapi.Logical
+	denyList   []string                 // patterns of the Vault paths never requested
+	sizeLimits vaultapi.SizeLimits      // bounds of the values and listings served
+	readOnly   bool                     // never modify Vault, and mount read-only
+	cache      *vaultapi.CachedLogical  // nil if caching is disabled
+	journal    *vaultapi.JournalLogical // request journal (holds no entries if disabled)
+	limits     *vaultapi.LimitedLogical // nil if limits are disabled
+	breaker    *vaultapi.BreakerLogical // nil if the circuit breaker is disabled
 	root       string
-	conn       *fuse.Conn
+	connMu     sync.Mutex
+	conn       *fuse.Conn    // current connection to the kernel (nil until mounted)
+	stopping   chan struct{} // closed once unmounted by Unmount
+	stopOnce   sync.Once
+	onMount    func() // called once first mounted (optional)
 	mountpoint string
 	logger     log.Logger // Context aware logger
+
+	attrTimeout  time.Duration   // how long the kernel may cache node attributes
+	entryTimeout time.Duration   // how long the kernel may cache name lookups
+	fixedSize    uint64          // size reported for every file, or 0 for the actual size
+	jsonView     bool            // expose each secret as JSON beside its data directory
+	directIO     bool            // open every file with direct IO, bypassing the page cache
+	format       string          // how secrets are presented (FormatFull or FormatData)
+	hidden       map[string]bool // secret directory entries which are not exposed
+
+	engineMounts map[string]string // secrets engine types by mount path
+	writable     []string          // paths under which secret data can be written
+	base64Keys   []string          // patterns of data keys which are base64-decoded
+	templates    *StaticDir        // rendered templates (nil if there are none)
+	include      []string          // patterns of the visible paths (empty for all)
+	exclude      []string          // patterns of paths which are never visible
+	aliases      map[string]string // Vault paths exposed at other paths, by path
+	unionRoots   []string          // roots overlaid on root, lowest precedence first
+	auditLog     *auditLog         // log of accesses to secrets (optional)
+	tenants      *tenantStore      // per-user backends (nil unless multi-tenant)
+	cacheConfig  vaultapi.CacheConfig
+	control      net.Listener  // control API socket (nil if not serving)
+	controlPath  string        // where the control API socket is
+	results      *resultStore  // per-user results of write-then-read files
+	wrapTTL      time.Duration // TTL of wrapping tokens returned by .wrap files
+	unwrapped    *unwrapStore  // secrets unwrapped through .unwrap
+
+	mountOptions []fuse.MountOption // extra options to mount with
+
+	uid uint32 // owner reported for every node
+	gid uint32 // group reported for every node
+
+	seal sealState // whether Vault is sealed
+
+	capabilityModes bool             // derive modes from the token's capabilities
+	capabilities    *capabilityCache // access levels by path
+	missing         *missingCache    // paths found not to exist
+
+	configMu   sync.Mutex
+	config     *api.Config
+	authMethod string // of the current backend, empty for a given token
+	tokenSink  string // file to share the current token through (optional)
+	renewer    *vaultapi.TokenRenewer
+	eventType  string // Vault event types to invalidate the cache on (optional)
+	subscriber *vaultapi.EventSubscriber
+
+	configLoader func() (*api.Config, Auth, error) // reads the settings ReloadConfig uses (optional, guarded by configMu)
+
+	prefetchPaths    []string // read into the cache once mounted
+	prefetchChildren bool     // look up the children of listed directories
+	kvSubkeys        bool     // list KV v2 secrets from their subkeys
+
+	slowOpThreshold time.Duration // beyond which operations are warned of (optional)
+	statsd          *statsdClient // where metrics are sent (nil if not)
+}
+
+// Formats in which secrets can be presented.
+const (
+	// FormatFull presents the full API response of a secret: lease_id,
+	// renewable, warnings, data/, auth/ and wrap_info/.
+	FormatFull = "full"
+	// FormatData presents only the data keys of a secret, as files directly
+	// in the secret's directory.
+	FormatData = "data"
+)
+
+// Default kernel cache timeouts, matching those of bazil.org/fuse.
+const (
+	DefaultAttrTimeout  = time.Minute
+	DefaultEntryTimeout = time.Minute
+)
+
+// New returns a new VaultFS configured by options, changed by opts. Settings
+// beyond Options are made with the Set methods before mounting.
+func New(options Options, opts ...Option) (*VaultFS, error) {
+	for _, opt := range opts {
+		opt(&options)
+	}
+	config := options.Vault
+	if config == nil {
+		config = api.DefaultConfig()
+		if err := config.ReadEnvironment(); err != nil {
+			return nil, err
+		}
+	}
+	if options.Root == "" {
+		options.Root = "secret"
+	}
+	logger := options.Logger
+	if logger == nil {
+		logger = log.Base()
+	}
+	logger = vaultapi.SafeLogger(logger)
+
+	preAuthBackend := options.Backend
+	if preAuthBackend == nil {
+		var err error
+		if preAuthBackend, err = NewBackend(config, options.Auth); err != nil {
+			return nil, err
+		}
+	}
+	backend := vaultapi.NewSwappableLogical(preAuthBackend)
+
+	v := &VaultFS{
+		backend:    backend,
+		authed:     backend,
+		logical:    backend,
+		denyList:   options.DenyList,
+		sizeLimits: options.SizeLimits,
+		readOnly:   !options.Writable,
+		root:       options.Root,
+		mountpoint: options.Mountpoint,
+		logger:     logger.WithField("address", config.Address),
+		config:     config,
+		authMethod: options.Auth.Method,
+
+		attrTimeout:  DefaultAttrTimeout,
+		entryTimeout: DefaultEntryTimeout,
+		format:       FormatFull,
+		engineMounts: DefaultEngineMounts,
+		writable:     DefaultWritablePaths,
+		base64Keys:   DefaultBase64Keys,
+		stopping:     make(chan struct{}),
+		results:      newResultStore(),
+		wrapTTL:      DefaultWrapTTL,
+		unwrapped:    newUnwrapStore(),
+		capabilities: newCapabilityCache(),
+		missing:      newMissingCache(),
+		uid:          uint32(os.Getuid()),
+		gid:          uint32(os.Getgid()),
+	}
+	if options.Format != "" {
+		if err := v.SetFormat(options.Format); err != nil {
+			return nil, err
+		}
+	}
+	if err := v.SetPathFilters(options.Include, options.Exclude); err != nil {
+		return nil, err
+	}
+
+	// The journal, limits and circuit breaker are shared by the backends of
+	// the mount and of every tenant, so they count and bound all the requests
+	// the mount makes together. The journal is always present to count
+	// requests, but only keeps entries if journalSize is positive.
+	v.journal = vaultapi.NewJournalLogical(nil, options.JournalSize)
+	if options.Limits.Enabled() {
+		v.limits = vaultapi.NewLimitedLogical(nil, options.Limits)
+	}
+	if options.Breaker.Enabled() {
+		v.breaker = vaultapi.NewBreakerLogical(nil, options.Breaker)
+	}
+	var err error
+	if v.authed, v.logical, err = v.layers(backend); err != nil {
+		return nil, err
+	}
+
+	// The disk cache serves the last responses while the backend is failing,
+	// including while the circuit is open.
+	if options.Cache.DiskDir != "" {
+		disk, err := vaultapi.NewDiskCachedLogical(v.logical, options.Cache.DiskDir, options.Cache.DiskKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		v.logical = disk
+	}
+
+	v.cacheConfig = options.Cache
+	if options.Cache.Enabled() {
+		v.cache = vaultapi.NewCachedLogical(v.logical, options.Cache)
+		v.logical = v.cache
+	}
+
+	return v, nil
+}
+
+// layers wraps backend in the layers between the response caches and Vault,
+// for the mount and for each tenant alike. It returns backend guarded by
+// read-only mode and the deny-list, for requests which bypass the other
+// layers, and the logical to cache responses from.
+func (v *VaultFS) layers(backend vaultapi.AuthableLogical) (vaultapi.AuthableLogical, vaultapi.Logical, error) {
+	// Read-only mode and the deny-list sit beneath everything else, so that
+	// whatever part of the filesystem makes a request to modify Vault, or
+	// for a denied path, it never reaches Vault. The journal records such
+	// requests as failed.
+	if v.readOnly {
+		backend = vaultapi.NewReadOnlyLogical(backend)
+	}
+	if len(v.denyList) > 0 {
+		denied, err := vaultapi.NewDenyListLogical(backend, v.denyList)
+		if err != nil {
+			return nil, nil, err
+		}
+		backend = denied
+	}
+
+	// The journal records requests which actually reach the backend, so sits
+	// beneath the cache. Limits apply to requests which miss the cache, but
+	// time spent waiting for them is not journalled.
+	var logical vaultapi.Logical = v.journal.WithBackend(backend)
+
+	// Responses over the size limits are dropped before they are cached.
+	if v.sizeLimits.Enabled() {
+		logical = vaultapi.NewSizeLimitedLogical(logical, v.sizeLimits)
+	}
+
+	if v.limits != nil {
+		logical = v.limits.WithBackend(logical)
+	}
+
+	// The circuit breaker sits above the limits so that requests fail at once
+	// while it is open, but beneath the cache so cached responses are still
+	// served.
+	if v.breaker != nil {
+		logical = v.breaker.WithBackend(logical)
+	}
+	return backend, logical, nil
+}
+
+// CacheStats returns the current response cache counters. All counters are
+// zero if caching is disabled.
+func (v *VaultFS) CacheStats() vaultapi.CacheStats {
+	if v.cache == nil {
+		return vaultapi.CacheStats{}
+	}
+	return v.cache.Stats()
+}
+
+// Journal returns the recorded backend requests, oldest first. It is empty if
+// the request journal is disabled.
+func (v *VaultFS) Journal() []vaultapi.JournalEntry {
+	return v.journal.Entries()
+}
+
+// RequestStats returns counters of the requests made to Vault.
+func (v *VaultFS) RequestStats() vaultapi.JournalStats {
+	return v.journal.Stats()
 }
 
-// New returns a new VaultFS
-func New(config *api.Config, mountpoint string, root string, token string, authMethod string, authUser string, authSecret string) (*VaultFS, error) {
-	client, err := api.NewClient(config)
+// NewBackend creates an authenticated Vault backend from the given auth
+// settings, prompting for a password if one is needed and not supplied.
+func NewBackend(config *api.Config, auth Auth) (vaultapi.AuthableLogical, error) {
+	// Several comma separated addresses, or a discovery address, are the
+	// nodes of a cluster, to fail over between. The client's address is set
+	// once they are known.
+	cluster := vaultapi.IsClusterAddress(config.Address)
+	clientConfig := config
+	if cluster {
+		clientConfig = &api.Config{
+			HttpClient: config.HttpClient,
+			MaxRetries: config.MaxRetries,
+			Timeout:    config.Timeout,
+		}
+	}
+	client, err := api.NewClient(clientConfig)
 	if err != nil {
 		return nil, err
 	}
 
 	// Prompt for a password if none is specified.
-	if authMethod == "ldap" {
+	authSecret := auth.Secret
+	if auth.Method == "ldap" {
 		if authSecret == "" {
 			passwordQuery := &survey.Password{
 				Message: "Enter Password (will be hidden):",
 			}
-			if err := survey.AskOne(passwordQuery, &authSecret ,nil) ; err != nil {
+			if err := survey.AskOne(passwordQuery, &authSecret, nil); err != nil {
 				return nil, err
 			}
 		}
 	}
 
 	// preAuthBackend is used to authenticate
-	preAuthBackend := vaultapi.NewVaultLogicalBackend(client, token, authMethod, authUser, authSecret)
+	var preAuthBackend vaultapi.AuthableLogical
+	if cluster {
+		preAuthBackend, err = vaultapi.NewFailoverVaultLogicalBackend(client, config.HttpClient, config.Address, auth.Token, auth.Method, auth.User, auth.Role, authSecret)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		preAuthBackend = vaultapi.NewVaultLogicalBackend(client, auth.Token, auth.Method, auth.User, auth.Role, authSecret)
+	}
 
 	if err := preAuthBackend.Auth(); err != nil {
 		return nil, err
 	}
 
-	return &VaultFS{
-		logical:    preAuthBackend,
-		root:       root,
-		mountpoint: mountpoint,
-		logger:     log.WithField("address", config.Address),
-	}, nil
+	return preAuthBackend, nil
+}
+
+// Reconfigure connects to Vault again with new settings (e.g. a new address or
+// TLS material) and authenticates, without unmounting. Requests in progress
+// complete with the previous connection, and everything read through it is
+// dropped. If authenticating fails the previous connection remains in use.
+func (v *VaultFS) Reconfigure(config *api.Config, auth Auth) error {
+	backend, err := NewBackend(config, auth)
+	if err != nil {
+		return err
+	}
+
+	v.configMu.Lock()
+	v.config = config
+	v.authMethod = auth.Method
+	v.configMu.Unlock()
+
+	v.backend.Swap(backend)
+	v.Reload()
+	v.logger.WithField("address", config.Address).Info("reconnected to vault")
+	return nil
+}
+
+// SetConfigLoader makes ReloadConfig, and so the control API's reload
+// command, reconnect to Vault with the settings returned by load, e.g. read
+// from the config file again.
+func (v *VaultFS) SetConfigLoader(load func() (*api.Config, Auth, error)) {
+	v.configMu.Lock()
+	defer v.configMu.Unlock()
+	v.configLoader = load
+}
+
+// Reauth logs in to Vault again for a fresh token. It fails for a token given
+// directly, or Vault Agent's, as logging in can't replace them.
+func (v *VaultFS) Reauth() error {
+	v.configMu.Lock()
+	method := v.authMethod
+	v.configMu.Unlock()
+	if !vaultapi.CanLogin(method) {
+		if method == "" {
+			method = "token"
+		}
+		return errors.Errorf("the %s auth method can't log in again", method)
+	}
+	return v.backend.Auth()
+}
+
+// vaultConfig returns the current Vault client configuration.
+func (v *VaultFS) vaultConfig() *api.Config {
+	v.configMu.Lock()
+	defer v.configMu.Unlock()
+	return v.config
+}
+
+// SetTokenSink makes the filesystem write its current Vault token to sinkPath
+// while mounted, so other local tooling can share the same session. Must be
+// called before Mount.
+func (v *VaultFS) SetTokenSink(sinkPath string) {
+	v.tokenSink = sinkPath
+}
+
+// SetOnMount makes Mount call fn once the filesystem is mounted, before
+// serving it, e.g. to report that a background mount has started. Must be
+// called before Mount.
+func (v *VaultFS) SetOnMount(fn func()) {
+	v.onMount = fn
+}
+
+// SetCacheTimeouts sets how long the kernel may cache node attributes and
+// name lookups before asking the filesystem again. Lower values trade Vault
+// load for freshness.
+func (v *VaultFS) SetCacheTimeouts(attrTimeout time.Duration, entryTimeout time.Duration) {
+	v.attrTimeout = attrTimeout
+	v.entryTimeout = entryTimeout
+}
+
+// SetOwner sets the owner and group reported for every node. The default is
+// the user running the filesystem.
+func (v *VaultFS) SetOwner(uid uint32, gid uint32) {
+	v.uid = uid
+	v.gid = gid
+}
+
+// SetFixedFileSize makes every file report the given size instead of the
+// length of its value, for compatibility with tools which pre-allocate based on
+// size. Reads still end at the real end of the value. Zero restores reporting
+// the actual size.
+func (v *VaultFS) SetFixedFileSize(size uint64) {
+	v.fixedSize = size
+}
+
+// SetDirectIO makes every file be opened with direct IO, so that the kernel
+// never serves reads from its page cache and each open reads the value the
+// filesystem has now, at the cost of every read reaching the filesystem. Files
+// whose content changes on every open (dynamic and writable ones) always use
+// direct IO.
+func (v *VaultFS) SetDirectIO(enabled bool) {
+	v.directIO = enabled
+}
+
+// fileSize returns the size to report for a file with the given content
+// length.
+func (v *VaultFS) fileSize(length int) uint64 {
+	if v.fixedSize != 0 {
+		return v.fixedSize
+	}
+	return uint64(length)
+}
+
+// SetFormat sets how secrets are presented: FormatFull or FormatData.
+func (v *VaultFS) SetFormat(format string) error {
+	if _, ok := secretRenderers[format]; !ok {
+		return errors.Errorf("unknown format: %s", format)
+	}
+	v.format = format
+	return nil
+}
+
+// SetSecretEntries restricts the entries exposed in a secret directory (in the
+// full format) to those named, e.g. to hide auth/ and its client token. An
+// empty list exposes every entry.
+func (v *VaultFS) SetSecretEntries(names []string) error {
+	if len(names) == 0 {
+		v.hidden = nil
+		return nil
+	}
+
+	visible := make(map[string]bool)
+	for _, name := range names {
+		if _, found := secretDirEntrys[name]; !found {
+			return errors.Errorf("unknown secret entry: %s", name)
+		}
+		visible[name] = true
+	}
+
+	v.hidden = make(map[string]bool)
+	for name := range secretDirEntrys {
+		if !visible[name] {
+			v.hidden[name] = true
+		}
+	}
+	return nil
+}
+
+// secretEntryVisible returns true if the named secret directory entry is
+// exposed.
+func (v *VaultFS) secretEntryVisible(name string) bool {
+	return !v.hidden[name]
+}
+
+// visibleSecret returns a copy of secret with the fields of hidden entries
+// removed, for views which render the whole secret.
+func (v *VaultFS) visibleSecret(secret *api.Secret) *api.Secret {
+	visible := *secret
+	if v.hidden["lease_id"] {
+		visible.LeaseID = ""
+	}
+	if v.hidden["lease_duration"] {
+		visible.LeaseDuration = 0
+	}
+	if v.hidden["renewable"] {
+		visible.Renewable = false
+	}
+	if v.hidden["warnings"] {
+		visible.Warnings = nil
+	}
+	if v.hidden["data"] {
+		visible.Data = nil
+	}
+	if v.hidden["auth"] {
+		visible.Auth = nil
+	}
+	if v.hidden["wrap_info"] {
+		visible.WrapInfo = nil
+	}
+	return &visible
+}
+
+// SetJSONView adds a secret.json file to every secret directory, containing
+// the whole secret as indented JSON so it can be consumed with a single read.
+func (v *VaultFS) SetJSONView(enabled bool) {
+	v.jsonView = enabled
+}
+
+// SetEventSubscription makes the filesystem subscribe to Vault events matching
+// eventType (e.g. "kv*") while mounted, and invalidate cached responses for
+// the secrets they concern. Must be called before Mount.
+func (v *VaultFS) SetEventSubscription(eventType string) {
+	v.eventType = eventType
+}
+
+// onEvent is called by the event subscriber for every changed secret.
+func (v *VaultFS) onEvent(path string) {
+	v.invalidate(path)
+}
+
+// invalidate drops any cached responses for path.
+func (v *VaultFS) invalidate(path string) {
+	v.forgetMissing(path)
+	if v.cache != nil {
+		v.cache.Invalidate(path)
+	}
+	if v.tenants != nil {
+		v.invalidateTenants(path)
+	}
+}
+
+// onToken is called by the token renewer whenever the token changes.
+func (v *VaultFS) onToken(token string) {
+	if v.tokenSink == "" {
+		return
+	}
+	if err := writeTokenSink(v.tokenSink, token); err != nil {
+		v.logger.WithError(err).WithField("sink", v.tokenSink).Error("could not write token sink")
+		return
+	}
+	v.logger.WithField("sink", v.tokenSink).Debug("wrote token sink")
 }
 
 func (v *VaultFS) log() log.Logger {
-	return log.WithFields(log.Fields{
+	return v.logger.WithFields(log.Fields{
 		"vault_root": v.root,
 		"mountpoint": v.mountpoint,
 	})
 }
 
-// logic provides wrapped access to the Vault api.Logical backend.
-// It manages automatically re-authing sessions.
-func (v *VaultFS) logic() vaultapi.Logical {
-	return v.logical
+// logic provides wrapped access to the Vault api.Logical backend for the
+// request being served with ctx. It manages automatically re-authing sessions.
+// In multi-tenant mode it is the backend of the requesting user. Requests
+// return vaultapi.ErrInterrupted once ctx is done, e.g. when the kernel
+// interrupts the request being served.
+func (v *VaultFS) logic(ctx context.Context) vaultapi.Logical {
+	if v.tenants == nil {
+		return vaultapi.NewInterruptibleLogical(ctx, v.logical)
+	}
+	t, err := v.tenantFor(ctx)
+	if err != nil {
+		v.logger.WithError(err).Warn("denying request")
+		return vaultapi.NewDeniedLogical(err)
+	}
+	return vaultapi.NewInterruptibleLogical(ctx, t.logical)
 }
 
-// Mount the FS at the given mountpoint
+// authBackend returns the authenticated backend for the request being served
+// with ctx, as logic does.
+func (v *VaultFS) authBackend(ctx context.Context) vaultapi.AuthableLogical {
+	if v.tenants == nil {
+		return v.authed
+	}
+	t, err := v.tenantFor(ctx)
+	if err != nil {
+		v.logger.WithError(err).Warn("denying request")
+		return vaultapi.NewDeniedLogical(err)
+	}
+	return t.backend
+}
+
+// Mount the FS at the given mountpoint, and serve it until it is unmounted,
+// whether by Unmount or externally. The background work of serving it is
+// stopped on return, whether or not it was mounted.
 func (v *VaultFS) Mount() error {
-	var err error
-	v.conn, err = fuse.Mount(
-		v.mountpoint,
+	defer v.stop()
+	if err := v.mount(); err != nil {
+		return err
+	}
+
+	v.start()
+	if v.onMount != nil {
+		v.onMount()
+	}
+
+	// Serve until unmounted, remounting if the connection to the kernel
+	// fails (e.g. the transport breaks), so a transient failure doesn't leave
+	// a dead mountpoint.
+	requests := newRequestLog(v)
+	backoff := remountMinBackoff
+	for {
+		log.Debug("starting to serve")
+		started := time.Now()
+		server := fs.New(v.connection(), &fs.Config{
+			WithContext: requests.context,
+			Debug:       requests.debug,
+		})
+		err := server.Serve(v)
+		if err == nil || v.isStopping() {
+			return err
+		}
+		v.logger.WithError(err).Error("filesystem connection failed, remounting")
+
+		if time.Since(started) > remountMaxBackoff {
+			backoff = remountMinBackoff
+		}
+		v.connection().Close()
+		for {
+			// Clear the dead mountpoint, which is left behind if the
+			// connection failed without unmounting.
+			fuse.Unmount(v.mountpoint)
+
+			select {
+			case <-v.stopping:
+				return nil
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > remountMaxBackoff {
+				backoff = remountMaxBackoff
+			}
+
+			err := v.mount()
+			if err == nil {
+				break
+			}
+			v.logger.WithError(err).Error("could not remount")
+		}
+		v.logger.Info("remounted")
+	}
+}
+
+// Bounds of the delay between attempts to remount after the connection to the
+// kernel fails.
+const (
+	remountMinBackoff = time.Second
+	remountMaxBackoff = time.Minute
+)
+
+// mount mounts the filesystem, without serving it.
+func (v *VaultFS) mount() error {
+	options := append([]fuse.MountOption{
 		fuse.FSName("vault"),
 		fuse.VolumeName("vault"),
-	)
+	}, v.mountOptions...)
+	if v.readOnly {
+		options = append(options, fuse.ReadOnly())
+	}
+	conn, err := fuse.Mount(v.mountpoint, options...)
+	if err != nil {
+		return err
+	}
+	v.logger.Debug("created conn")
+
+	v.connMu.Lock()
+	v.conn = conn
+	v.connMu.Unlock()
+	return nil
+}
 
-	v.log().Debug("created conn")
+// connection returns the current connection to the kernel.
+func (v *VaultFS) connection() *fuse.Conn {
+	v.connMu.Lock()
+	defer v.connMu.Unlock()
+	return v.conn
+}
+
+// isStopping reports whether the filesystem has been unmounted by Unmount, or
+// stopped.
+func (v *VaultFS) isStopping() bool {
+	select {
+	case <-v.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// Serve serves the filesystem with serve, e.g. over NFS, instead of mounting
+// it. The token is renewed, events subscribed to and paths prefetched as for a
+// mount until serve returns.
+func (v *VaultFS) Serve(serve func(root fs.Node) error) error {
+	root, err := v.Root()
 	if err != nil {
 		return err
 	}
 
-	log.Debug("starting to serve")
-	return fs.Serve(v.conn, v)
+	v.start()
+	defer v.stop()
+	return serve(root)
+}
+
+// start starts the background work of serving the filesystem.
+func (v *VaultFS) start() {
+	v.renewer = vaultapi.NewTokenRenewer(v.backend, v.onToken)
+	v.renewer.Start()
+
+	if v.eventType != "" {
+		if v.cache == nil {
+			v.logger.Warn("vault event subscription has no effect without a cache")
+		} else {
+			v.subscriber = vaultapi.NewEventSubscriber(v.vaultConfig(), v.backend.Token, v.eventType, v.onEvent)
+			v.subscriber.Start()
+		}
+	}
+
+	go v.prefetch()
+
+	if v.statsd != nil {
+		go v.reportStats()
+	}
+}
+
+// stop stops the background work of serving the filesystem.
+func (v *VaultFS) stop() {
+	v.stopOnce.Do(func() { close(v.stopping) })
+
+	if v.cache != nil {
+		v.logger.WithField("stats", v.CacheStats()).Info("cache statistics at unmount")
+	}
+
+	if v.subscriber != nil {
+		v.subscriber.Stop()
+		v.subscriber = nil
+	}
+
+	if v.renewer != nil {
+		v.renewer.Stop()
+		v.renewer = nil
+	}
+
+	if err := v.stopControl(); err != nil {
+		v.logger.WithError(err).Warn("could not stop control API")
+	}
+	// Zero the cached secrets rather than leave them in memory.
+	v.flushCache()
+	if v.tenants != nil {
+		v.dropTenants()
+	}
 }
 
-// Unmount the FS
+// Unmount the FS. If it can't be unmounted (e.g. while busy) it is left
+// mounted and served as before; otherwise Mount returns, having stopped the
+// background work of serving it.
 func (v *VaultFS) Unmount() error {
-	if v.conn == nil {
+	conn := v.connection()
+	if conn == nil {
 		return errors.New("not mounted")
 	}
 
@@ -112,16 +779,17 @@ func (v *VaultFS) Unmount() error {
 	if err != nil {
 		return err
 	}
+	v.stopOnce.Do(func() { close(v.stopping) })
 
-	err = v.conn.Close()
+	err = conn.Close()
 	if err != nil {
 		return err
 	}
 
 	v.logger.Debug("closed connection, waiting for ready")
-	<-v.conn.Ready
-	if v.conn.MountError != nil {
-		return v.conn.MountError
+	<-conn.Ready
+	if conn.MountError != nil {
+		return conn.MountError
 	}
 
 	return nil
@@ -130,5 +798,61 @@ func (v *VaultFS) Unmount() error {
 // Root returns the struct that does the actual work
 func (v *VaultFS) Root() (fs.Node, error) {
 	v.logger.Debug("returning root")
-	return NewSecretDir(v, v.root)
+	root, err := NewSecretDir(v, v.root)
+	if err != nil {
+		return nil, err
+	}
+	if len(v.unionRoots) == 0 {
+		root.root = true
+		return root, nil
+	}
+
+	union := &UnionDir{fs: v, layers: []*SecretDir{root}}
+	for _, unionRoot := range v.unionRoots {
+		layer, err := NewSecretDir(v, unionRoot)
+		if err != nil {
+			return nil, err
+		}
+		union.layers = append(union.layers, layer)
+	}
+	// The virtual entries at the root take precedence over every layer.
+	union.layers[len(union.layers)-1].root = true
+	return union, nil
+}
+
+// rootEntries returns the virtual entries at the root of the filesystem.
+func (v *VaultFS) rootEntries() []fuse.Dirent {
+	entries := []fuse.Dirent{
+		{Name: unwrapFileName, Type: fuse.DT_File},
+		{Name: unwrappedDirName, Type: fuse.DT_Dir},
+		{Name: sysDirName, Type: fuse.DT_Dir},
+		{Name: tokenDirName, Type: fuse.DT_Dir},
+		{Name: statusDirName, Type: fuse.DT_Dir},
+	}
+	if v.templates != nil {
+		entries = append(entries, fuse.Dirent{Name: templatesDirName, Type: fuse.DT_Dir})
+	}
+	return entries
+}
+
+// rootLookup returns the virtual entry at the root of the filesystem with the
+// given name, or nil if there is none.
+func (v *VaultFS) rootLookup(ctx context.Context, name string) (fs.Node, error) {
+	switch name {
+	case unwrapFileName:
+		return v.unwrapFile(), nil
+	case unwrappedDirName:
+		return &unwrappedDir{fs: v}, nil
+	case sysDirName:
+		return v.sysDir(), nil
+	case tokenDirName:
+		return v.tokenDir(ctx)
+	case statusDirName:
+		return v.statusDir(), nil
+	case templatesDirName:
+		if v.templates != nil {
+			return v.templates, nil
+		}
+	}
+	return nil, nil
 }
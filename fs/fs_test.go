@@ -0,0 +1,46 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"bazil.org/fuse"
+)
+
+func TestMountStopsOnFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vaultfs-mount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	v := newTestFS(t, testBackend())
+	if err := v.ServeControl(filepath.Join(dir, "control.sock")); err != nil {
+		t.Fatal(err)
+	}
+	v.mountpoint = filepath.Join(dir, "missing")
+	if err := v.Mount(); err == nil {
+		t.Fatal("expected mounting on a missing mountpoint to fail")
+	}
+	if v.control != nil || !v.isStopping() {
+		t.Error("expected a failed mount to stop serving")
+	}
+}
+
+func TestUnmountFailureKeepsServing(t *testing.T) {
+	v := newTestFS(t, testBackend())
+	v.mountpoint = t.TempDir()
+	v.conn = &fuse.Conn{}
+	v.start()
+	defer v.stop()
+
+	// Nothing is mounted there, as when a mount is busy, so unmounting fails.
+	if err := v.Unmount(); err == nil {
+		t.Fatal("expected unmounting what isn't mounted to fail")
+	}
+	if v.renewer == nil || v.isStopping() {
+		t.Error("expected a filesystem which couldn't be unmounted to keep serving")
+	}
+}
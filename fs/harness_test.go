@@ -0,0 +1,172 @@
+package fs
+
+import (
+	"os/exec"
+	"sort"
+	"syscall"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/hashicorp/vault/api"
+	"github.com/wrouesnel/vaultfs/vaultapi/vaulttest"
+	"golang.org/x/net/context"
+)
+
+// newTestFS returns a filesystem presenting secret/ of backend, configured by
+// opts.
+func newTestFS(t testing.TB, backend *vaulttest.Logical, opts ...Option) *VaultFS {
+	t.Helper()
+	v, err := New(Options{Vault: api.DefaultConfig(), Backend: backend}, opts...)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return v
+}
+
+// root returns the root node of v.
+func root(t testing.TB, v *VaultFS) fs.Node {
+	t.Helper()
+	node, err := v.Root()
+	if err != nil {
+		t.Fatalf("Root: %v", err)
+	}
+	return node
+}
+
+// lookupErr looks up the path of names beneath node as the kernel would.
+func lookupErr(node fs.Node, names ...string) (fs.Node, error) {
+	for _, name := range names {
+		var err error
+		switch n := node.(type) {
+		case fs.NodeRequestLookuper:
+			node, err = n.Lookup(context.Background(), &fuse.LookupRequest{Name: name}, &fuse.LookupResponse{})
+		case fs.NodeStringLookuper:
+			node, err = n.Lookup(context.Background(), name)
+		default:
+			return nil, fuse.Errno(syscall.ENOTDIR)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+// lookup looks up the path of names beneath node, failing the test if it
+// can't be.
+func lookup(t *testing.T, node fs.Node, names ...string) fs.Node {
+	t.Helper()
+	node, err := lookupErr(node, names...)
+	if err != nil {
+		t.Fatalf("lookup %v: %v", names, err)
+	}
+	return node
+}
+
+// readDirErr returns the sorted names of the entries of node.
+func readDirErr(node fs.Node) ([]string, error) {
+	dir, ok := node.(fs.HandleReadDirAller)
+	if !ok {
+		return nil, fuse.Errno(syscall.ENOTDIR)
+	}
+	dirents, err := dir.ReadDirAll(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, dirent := range dirents {
+		names = append(names, dirent.Name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// readDir returns the sorted names of the entries of node, failing the test
+// if it can't be read.
+func readDir(t *testing.T, node fs.Node) []string {
+	t.Helper()
+	names, err := readDirErr(node)
+	if err != nil {
+		t.Fatalf("ReadDirAll: %v", err)
+	}
+	return names
+}
+
+// readFile opens node and reads its content, failing the test if it can't.
+func readFile(t *testing.T, node fs.Node) string {
+	t.Helper()
+	var handle fs.Handle = node
+	if opener, ok := node.(fs.NodeOpener); ok {
+		var err error
+		if handle, err = opener.Open(context.Background(), &fuse.OpenRequest{}, &fuse.OpenResponse{}); err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+	}
+	switch h := handle.(type) {
+	case fs.HandleReadAller:
+		content, err := h.ReadAll(context.Background())
+		if err != nil {
+			t.Fatalf("ReadAll: %v", err)
+		}
+		return string(content)
+	case fs.HandleReader:
+		resp := &fuse.ReadResponse{Data: make([]byte, 0, 1<<20)}
+		if err := h.Read(context.Background(), &fuse.ReadRequest{Size: 1 << 20}, resp); err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		return string(resp.Data)
+	}
+	t.Fatalf("%T can't be read", handle)
+	return ""
+}
+
+// attr returns the attributes of node.
+func attr(t *testing.T, node fs.Node) fuse.Attr {
+	t.Helper()
+	a := fuse.Attr{}
+	if err := node.Attr(context.Background(), &a); err != nil {
+		t.Fatalf("Attr: %v", err)
+	}
+	return a
+}
+
+// mounted mounts v in a temporary directory until the end of the test, and
+// returns the mountpoint. The test is skipped if FUSE can't be mounted.
+func mounted(t *testing.T, v *VaultFS) string {
+	t.Helper()
+	if _, err := exec.LookPath("fusermount"); err != nil {
+		t.Skip("FUSE is not available: ", err)
+	}
+
+	v.mountpoint = t.TempDir()
+	served := make(chan error, 1)
+	go func() { served <- v.Mount() }()
+
+	deadline := time.After(10 * time.Second)
+	for v.connection() == nil {
+		select {
+		case err := <-served:
+			t.Skip("could not mount: ", err)
+		case <-deadline:
+			t.Fatal("timed out mounting")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	conn := v.connection()
+	<-conn.Ready
+	if conn.MountError != nil {
+		t.Skip("could not mount: ", conn.MountError)
+	}
+
+	t.Cleanup(func() {
+		if err := v.Unmount(); err != nil {
+			t.Errorf("Unmount: %v", err)
+		}
+		if err := <-served; err != nil {
+			t.Errorf("Mount: %v", err)
+		}
+	})
+	return v.mountpoint
+}
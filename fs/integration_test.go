@@ -0,0 +1,333 @@
+//go:build integration
+// +build integration
+
+package fs
+
+// The integration tests run against a real Vault dev server:
+//
+//   go test -tags integration ./fs/
+//
+// The server is the one at VAULTFS_TEST_VAULT_ADDR, with the root token
+// VAULTFS_TEST_VAULT_TOKEN, if set. Otherwise one is started with the vault
+// binary, or with docker, and the tests are skipped if neither is available.
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/go-errors/errors"
+	"github.com/hashicorp/vault/api"
+)
+
+// integrationImage is the image the dev server is run from with docker.
+const integrationImage = "hashicorp/vault:latest"
+
+// integrationPolicy lets the limited token read kv1/app, read and list
+// kv1/team, and nothing else.
+const integrationPolicy = `
+path "kv1/app" {
+  capabilities = ["read"]
+}
+path "kv1/team/" {
+  capabilities = ["list"]
+}
+path "kv1/team/*" {
+  capabilities = ["read"]
+}
+`
+
+// integration is the Vault the tests run against.
+var integration struct {
+	address      string
+	rootToken    string
+	limitedToken string
+	err          error // why there is none
+}
+
+func TestMain(m *testing.M) {
+	stop, err := startVault()
+	if err == nil {
+		err = provisionVault()
+	}
+	integration.err = err
+
+	code := m.Run()
+	if stop != nil {
+		stop()
+	}
+	os.Exit(code)
+}
+
+// startVault starts a dev server, unless one is given by the environment, and
+// returns how to stop it.
+func startVault() (func(), error) {
+	if address := os.Getenv("VAULTFS_TEST_VAULT_ADDR"); address != "" {
+		integration.address = address
+		integration.rootToken = os.Getenv("VAULTFS_TEST_VAULT_TOKEN")
+		return nil, waitForVault()
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+	integration.address = fmt.Sprintf("http://127.0.0.1:%d", port)
+	integration.rootToken = "vaultfs-test-root"
+
+	var stop func()
+	if _, err := exec.LookPath("vault"); err == nil {
+		cmd := exec.Command("vault", "server", "-dev",
+			"-dev-root-token-id="+integration.rootToken,
+			fmt.Sprintf("-dev-listen-address=127.0.0.1:%d", port))
+		if err := cmd.Start(); err != nil {
+			return nil, err
+		}
+		stop = func() {
+			cmd.Process.Kill()
+			cmd.Wait()
+		}
+	} else if _, err := exec.LookPath("docker"); err == nil {
+		out, err := exec.Command("docker", "run", "--rm", "--detach", "--cap-add", "IPC_LOCK",
+			"--publish", fmt.Sprintf("127.0.0.1:%d:8200", port),
+			"--env", "VAULT_DEV_ROOT_TOKEN_ID="+integration.rootToken,
+			"--env", "VAULT_DEV_LISTEN_ADDRESS=0.0.0.0:8200",
+			integrationImage).Output()
+		if err != nil {
+			return nil, errors.WrapPrefix(err, "could not start the vault container", 0)
+		}
+		container := strings.TrimSpace(string(out))
+		stop = func() {
+			exec.Command("docker", "rm", "--force", container).Run()
+		}
+	} else {
+		return nil, errors.New("neither vault nor docker is available to run a dev server")
+	}
+
+	if err := waitForVault(); err != nil {
+		stop()
+		return nil, err
+	}
+	return stop, nil
+}
+
+// waitForVault waits for the server to be initialised and unsealed.
+func waitForVault() error {
+	client, err := integrationClient(integration.rootToken)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		health, err := client.Sys().Health()
+		if err == nil && health.Initialized && !health.Sealed {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.WrapPrefix(fmt.Errorf("%v", err), "vault did not become ready", 0)
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+// provisionVault mounts KV version 1 at kv1/ beside the dev server's KV
+// version 2 at secret/, writes secrets to both, and creates a token limited by
+// integrationPolicy.
+func provisionVault() error {
+	client, err := integrationClient(integration.rootToken)
+	if err != nil {
+		return err
+	}
+	logical := client.Logical()
+
+	if _, err := logical.Write("sys/mounts/kv1", map[string]interface{}{
+		"type":    "kv",
+		"options": map[string]interface{}{"version": "1"},
+	}); err != nil {
+		return err
+	}
+	for path, data := range map[string]map[string]interface{}{
+		"kv1/app":         {"password": "hunter2"},
+		"kv1/team/a":      {"value": "a"},
+		"kv1/team/b":      {"value": "b"},
+		"kv1/private/key": {"value": "private"},
+		"secret/data/app": {"data": map[string]interface{}{"password": "v2-hunter2"}},
+	} {
+		if _, err := logical.Write(path, data); err != nil {
+			return errors.WrapPrefix(err, "could not write "+path, 0)
+		}
+	}
+
+	if err := client.Sys().PutPolicy("vaultfs-limited", integrationPolicy); err != nil {
+		return err
+	}
+	secret, err := client.Auth().Token().Create(&api.TokenCreateRequest{
+		Policies: []string{"vaultfs-limited"},
+		NoParent: true,
+	})
+	if err != nil {
+		return err
+	}
+	integration.limitedToken = secret.Auth.ClientToken
+	return nil
+}
+
+// integrationClient returns a client of the server using token.
+func integrationClient(token string) (*api.Client, error) {
+	client, err := api.NewClient(integrationConfig())
+	if err != nil {
+		return nil, err
+	}
+	client.SetToken(token)
+	return client, nil
+}
+
+func integrationConfig() *api.Config {
+	config := api.DefaultConfig()
+	config.Address = integration.address
+	return config
+}
+
+// newIntegrationFS returns a filesystem presenting kv1/ of the server with
+// token, skipping the test if there is no server.
+func newIntegrationFS(t *testing.T, token string, opts ...Option) *VaultFS {
+	t.Helper()
+	if integration.err != nil {
+		t.Skip("no vault server: ", integration.err)
+	}
+	v, err := New(Options{
+		Vault: integrationConfig(),
+		Root:  "kv1",
+		Auth:  Auth{Token: token},
+	}, opts...)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return v
+}
+
+func TestIntegrationListing(t *testing.T) {
+	root := root(t, newIntegrationFS(t, integration.rootToken))
+
+	names := readDir(t, root)
+	for _, name := range []string{"app", "team", "private"} {
+		if !contains(names, name) {
+			t.Errorf("root is missing %s: %v", name, names)
+		}
+	}
+	if names := readDir(t, lookup(t, root, "team")); strings.Join(names, ",") != "a,b" {
+		t.Errorf("unexpected entries of a directory: %v", names)
+	}
+	if names := readDir(t, lookup(t, root, "app")); !contains(names, "data") || !contains(names, "lease_id") {
+		t.Errorf("unexpected entries of a secret: %v", names)
+	}
+}
+
+func TestIntegrationRead(t *testing.T) {
+	v := newIntegrationFS(t, integration.rootToken)
+	if err := v.SetAliases(map[string]string{"v2app": "secret/data/app"}); err != nil {
+		t.Fatal(err)
+	}
+	root := root(t, v)
+
+	if content := readFile(t, lookup(t, root, "app", "data", "password")); content != "hunter2" {
+		t.Errorf("expected hunter2, got %q", content)
+	}
+	if content := readFile(t, lookup(t, root, "team", "b", "data", "value")); content != "b" {
+		t.Errorf("expected b, got %q", content)
+	}
+
+	v2app := lookup(t, root, "v2app")
+	if content := readFile(t, lookup(t, v2app, "data", "data", "password")); content != "v2-hunter2" {
+		t.Errorf("expected v2-hunter2, got %q", content)
+	}
+	if names := readDir(t, v2app); !contains(names, controlDirName) {
+		t.Errorf("KV v2 secret is missing its control directory: %v", names)
+	}
+}
+
+func TestIntegrationPermissions(t *testing.T) {
+	root := root(t, newIntegrationFS(t, integration.limitedToken))
+
+	// kv1/ can't be read or listed, but can be traversed to what can.
+	if content := readFile(t, lookup(t, root, "app", "data", "password")); content != "hunter2" {
+		t.Errorf("expected hunter2, got %q", content)
+	}
+	if names := readDir(t, lookup(t, root, "team")); strings.Join(names, ",") != "a,b" {
+		t.Errorf("unexpected entries of a listable directory: %v", names)
+	}
+	if content := readFile(t, lookup(t, root, "team", "a", "data", "value")); content != "a" {
+		t.Errorf("expected a, got %q", content)
+	}
+
+	private := lookup(t, root, "private")
+	if mode := attr(t, private).Mode; mode != os.ModeDir|0111 {
+		t.Errorf("expected a denied directory to be traversable only, got %v", mode)
+	}
+	if _, err := readDirErr(private); err != fuse.Errno(syscall.EACCES) {
+		t.Errorf("expected EACCES listing a denied directory, got %v", err)
+	}
+}
+
+func TestIntegrationErrors(t *testing.T) {
+	dir := root(t, newIntegrationFS(t, integration.rootToken))
+
+	if _, err := lookupErr(dir, "team", "missing"); err != fuse.ENOENT {
+		t.Errorf("expected ENOENT looking up a missing secret, got %v", err)
+	}
+	if _, err := lookupErr(dir, "app", "missing"); err != fuse.ENOENT {
+		t.Errorf("expected ENOENT looking up a missing key, got %v", err)
+	}
+
+	// Unreachable servers are I/O errors, not missing secrets.
+	v := newIntegrationFS(t, integration.rootToken, WithVault(&api.Config{
+		Address:    "http://127.0.0.1:1",
+		HttpClient: integrationConfig().HttpClient,
+	}))
+	if _, err := lookupErr(root(t, v), "app"); err != fuse.EIO {
+		t.Errorf("expected EIO looking up through an unreachable server, got %v", err)
+	}
+}
+
+func TestIntegrationMount(t *testing.T) {
+	mountpoint := mounted(t, newIntegrationFS(t, integration.limitedToken))
+
+	content, err := ioutil.ReadFile(filepath.Join(mountpoint, "app", "data", "password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "hunter2" {
+		t.Errorf("expected hunter2, got %q", content)
+	}
+
+	infos, err := ioutil.ReadDir(filepath.Join(mountpoint, "team"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "a,b" {
+		t.Errorf("unexpected entries of a directory: %v", names)
+	}
+
+	if _, err := ioutil.ReadDir(filepath.Join(mountpoint, "private")); !os.IsPermission(err) {
+		t.Errorf("expected permission denied listing a denied directory, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(mountpoint, "team", "missing")); !os.IsNotExist(err) {
+		t.Errorf("expected a missing secret not to exist, got %v", err)
+	}
+}
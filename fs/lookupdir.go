@@ -0,0 +1,89 @@
+// A directory whose entries are generated on demand, for trees which can't be
+// discovered by listing Vault.
+
+package fs
+
+import (
+	"os"
+	"strings"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"golang.org/x/net/context"
+)
+
+// Statically ensure that *LookupDir implement those interface
+var _ = fs.HandleReadDirAller(&LookupDir{})
+var _ = fs.NodeRequestLookuper(&LookupDir{})
+
+// LookupDir implements a directory whose children are produced by functions.
+type LookupDir struct {
+	fs     *VaultFS // root filesystem this node is associated with
+	lookup func(ctx context.Context, name string) (fs.Node, error)
+	list   func(ctx context.Context) ([]fuse.Dirent, error) // nil if the directory can't be listed
+}
+
+// NewLookupDir returns a new LookupDir. lookup returns the child for a name,
+// or nil if there is none. list enumerates the children, and may be nil.
+func NewLookupDir(fs *VaultFS, lookup func(ctx context.Context, name string) (fs.Node, error), list func(ctx context.Context) ([]fuse.Dirent, error)) *LookupDir {
+	return &LookupDir{
+		fs:     fs,
+		lookup: lookup,
+		list:   list,
+	}
+}
+
+// Attr sets attrs on the given fuse.Attr
+func (d *LookupDir) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Valid = d.fs.attrTimeout
+	a.Mode = os.ModeDir | os.FileMode(0555)
+	a.Uid = d.fs.uid
+	a.Gid = d.fs.gid
+
+	return nil
+}
+
+// Lookup looks up a path
+func (d *LookupDir) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.LookupResponse) (fs.Node, error) {
+	resp.EntryValid = d.fs.entryTimeout
+
+	node, err := d.lookup(ctx, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if node == nil {
+		return nil, fuse.ENOENT
+	}
+	return node, nil
+}
+
+// ReadDirAll enumerates the directory, if it can be.
+func (d *LookupDir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	if d.list == nil {
+		return []fuse.Dirent{}, nil
+	}
+	return d.list(ctx)
+}
+
+// listKeys lists listPath in Vault, returning its keys as entries of type typ.
+func (v *VaultFS) listKeys(ctx context.Context, listPath string, typ fuse.DirentType) ([]fuse.Dirent, error) {
+	secret, err := v.logic(ctx).List(listPath)
+	if err != nil {
+		return nil, backendErrno(err)
+	}
+
+	dirs := []fuse.Dirent{}
+	if secret == nil {
+		return dirs, nil
+	}
+	keys, _ := secret.Data["keys"].([]interface{})
+	for _, key := range keys {
+		if name, ok := key.(string); ok {
+			dirs = append(dirs, fuse.Dirent{
+				Name: strings.TrimRight(name, "/"),
+				Type: typ,
+			})
+		}
+	}
+	return dirs, nil
+}
@@ -0,0 +1,104 @@
+// Metadata of a secret which is presented on the nodes rendering it, as
+// extended attributes and timestamps.
+
+package fs
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/hashicorp/vault/api"
+	"github.com/wrouesnel/vaultfs/vaultapi"
+)
+
+// secretMeta is the metadata of a secret.
+type secretMeta struct {
+	path   string            // Vault path of the secret, and key of the node within it
+	xattrs map[string]string // extended attributes
+	mtime  time.Time         // when the secret last changed (zero if unknown)
+	crtime time.Time         // when the secret was created (zero if unknown)
+}
+
+// newSecretMeta returns the metadata of secret, read from secretPath. KV version 2 secrets have
+// their timestamps in their metadata, both in reads of the data (where
+// created_time is the creation of the current version) and of the metadata
+// itself.
+func newSecretMeta(secretPath string, secret *api.Secret) secretMeta {
+	meta := secretMeta{path: secretPath, xattrs: make(map[string]string)}
+	if secret == nil {
+		return meta
+	}
+
+	if secret.LeaseID != "" {
+		meta.xattrs[xattrPrefix+"lease_id"] = secret.LeaseID
+	}
+	meta.xattrs[xattrPrefix+"lease_duration"] = fmt.Sprintf("%d", secret.LeaseDuration)
+
+	// Marks values served from the disk cache while Vault is unreachable.
+	if fetched, stale := vaultapi.StaleSince(secret); stale {
+		meta.xattrs[xattrPrefix+"stale"] = "true"
+		meta.xattrs[xattrPrefix+"fetched_time"] = fetched.Format(time.RFC3339)
+	}
+
+	if metadata, ok := vaultapi.KVv2Metadata(secret); ok {
+		meta.xattrs[xattrPrefix+"version"] = fmt.Sprintf("%v", metadata["version"])
+		if created, ok := metadata["created_time"].(string); ok {
+			meta.xattrs[xattrPrefix+"created_time"] = created
+			meta.mtime = parseVaultTime(created)
+		}
+	} else if _, found := secret.Data["current_version"]; found {
+		// The metadata endpoint, as read for deleted secrets.
+		meta.crtime = parseVaultTime(secret.Data["created_time"])
+		meta.mtime = parseVaultTime(secret.Data["updated_time"])
+	}
+	return meta
+}
+
+// parseVaultTime parses a timestamp returned by Vault, returning the zero time
+// if it is missing or invalid.
+func parseVaultTime(value interface{}) time.Time {
+	s, ok := value.(string)
+	if !ok {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// setTimes sets the timestamps of a, if they are known.
+func (m secretMeta) setTimes(a *fuse.Attr) {
+	if m.mtime.IsZero() {
+		return
+	}
+	a.Mtime = m.mtime
+	a.Ctime = m.mtime
+	a.Atime = m.mtime
+	a.Crtime = m.crtime
+	if m.crtime.IsZero() {
+		a.Crtime = m.mtime
+	}
+}
+
+// child returns the metadata of the node named name beneath the one with m.
+func (m secretMeta) child(name string) secretMeta {
+	m.path = path.Join(m.path, name)
+	return m
+}
+
+// setMeta sets the metadata of the tree.
+func (s *StaticDir) setMeta(meta secretMeta) {
+	s.meta = meta
+	for name, child := range s.children {
+		switch node := child.(type) {
+		case *StaticValue:
+			node.meta = meta.child(name)
+		case *StaticDir:
+			node.setMeta(meta.child(name))
+		}
+	}
+}
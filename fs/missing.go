@@ -0,0 +1,95 @@
+// Paths found not to exist are remembered for the entry timeout, as the
+// kernel would remember a negative entry, so that repeated lookups of a
+// missing path (which take a read, a listing and, for KV v2, a read of the
+// metadata) don't reach Vault each time.
+
+package fs
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// maxMissing bounds the paths remembered as missing. Once reached, those
+// which expired are dropped, and then all of them if none had.
+const maxMissing = 4096
+
+// missingCache holds when paths were found not to exist.
+type missingCache struct {
+	mu      sync.Mutex
+	entries map[string]time.Time
+}
+
+func newMissingCache() *missingCache {
+	return &missingCache{entries: make(map[string]time.Time)}
+}
+
+// missingKey returns the key of p in the missing cache. In multi-tenant
+// mode each user's token may see different paths, so they are kept apart.
+func (v *VaultFS) missingKey(ctx context.Context, p string) string {
+	if header, ok := caller(ctx); ok && v.tenants != nil {
+		return fmt.Sprintf("%d:%s", header.Uid, p)
+	}
+	return p
+}
+
+// isMissing returns true if p was found not to exist within the entry
+// timeout.
+func (v *VaultFS) isMissing(ctx context.Context, p string) bool {
+	if v.entryTimeout <= 0 {
+		return false
+	}
+	v.missing.mu.Lock()
+	defer v.missing.mu.Unlock()
+	found, ok := v.missing.entries[v.missingKey(ctx, p)]
+	return ok && time.Since(found) < v.entryTimeout
+}
+
+// setMissing records that p was found not to exist.
+func (v *VaultFS) setMissing(ctx context.Context, p string) {
+	if v.entryTimeout <= 0 {
+		return
+	}
+	v.missing.mu.Lock()
+	defer v.missing.mu.Unlock()
+	if len(v.missing.entries) >= maxMissing {
+		for key, found := range v.missing.entries {
+			if time.Since(found) >= v.entryTimeout {
+				delete(v.missing.entries, key)
+			}
+		}
+		if len(v.missing.entries) >= maxMissing {
+			v.missing.entries = make(map[string]time.Time)
+		}
+	}
+	v.missing.entries[v.missingKey(ctx, p)] = time.Now()
+}
+
+// forgetMissing drops p and its parents from the missing paths, for every
+// user, as they may have been created.
+func (v *VaultFS) forgetMissing(p string) {
+	paths := map[string]bool{}
+	for p = strings.Trim(p, "/"); p != "." && p != ""; p = path.Dir(p) {
+		paths[p] = true
+	}
+
+	v.missing.mu.Lock()
+	defer v.missing.mu.Unlock()
+	for key := range v.missing.entries {
+		if paths[key[strings.Index(key, ":")+1:]] || paths[key] {
+			delete(v.missing.entries, key)
+		}
+	}
+}
+
+// flushMissing drops every path remembered as missing.
+func (v *VaultFS) flushMissing() {
+	v.missing.mu.Lock()
+	defer v.missing.mu.Unlock()
+	v.missing.entries = make(map[string]time.Time)
+}
@@ -0,0 +1,83 @@
+// Mount options given in the usual -o name[=value],... form are converted into
+// bazil.org/fuse mount options.
+
+package fs
+
+import (
+	"strconv"
+	"strings"
+
+	"bazil.org/fuse"
+	"github.com/go-errors/errors"
+)
+
+// ParseMountOptions converts comma separated mount options (e.g.
+// "allow_other,default_permissions") into fuse mount options. Only options
+// supported by bazil.org/fuse are accepted.
+func ParseMountOptions(options []string) ([]fuse.MountOption, error) {
+	mountOptions := []fuse.MountOption{}
+	for _, group := range options {
+		for _, option := range strings.Split(group, ",") {
+			option = strings.TrimSpace(option)
+			if option == "" {
+				continue
+			}
+
+			name, value := option, ""
+			if idx := strings.Index(option, "="); idx >= 0 {
+				name, value = option[:idx], option[idx+1:]
+			}
+
+			mountOption, err := parseMountOption(name, value)
+			if err != nil {
+				return nil, err
+			}
+			mountOptions = append(mountOptions, mountOption)
+		}
+	}
+	return mountOptions, nil
+}
+
+func parseMountOption(name string, value string) (fuse.MountOption, error) {
+	switch name {
+	case "allow_other":
+		return fuse.AllowOther(), nil
+	case "allow_root":
+		return fuse.AllowRoot(), nil
+	case "allow_dev":
+		return fuse.AllowDev(), nil
+	case "allow_suid":
+		return fuse.AllowSUID(), nil
+	case "default_permissions":
+		return fuse.DefaultPermissions(), nil
+	case "ro":
+		return fuse.ReadOnly(), nil
+	case "async_read":
+		return fuse.AsyncRead(), nil
+	case "writeback_cache":
+		return fuse.WritebackCache(), nil
+	case "nonempty":
+		return fuse.AllowNonEmptyMount(), nil
+	case "fsname":
+		return fuse.FSName(value), nil
+	case "subtype":
+		return fuse.Subtype(value), nil
+	case "volname":
+		return fuse.VolumeName(value), nil
+	case "daemon_timeout":
+		return fuse.DaemonTimeout(value), nil
+	case "max_readahead":
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return nil, errors.Errorf("invalid max_readahead: %q", value)
+		}
+		return fuse.MaxReadahead(uint32(n)), nil
+	}
+	return nil, errors.Errorf("unsupported mount option: %s", name)
+}
+
+// SetMountOptions sets additional options to mount the filesystem with. Must
+// be called before Mount.
+func (v *VaultFS) SetMountOptions(options []fuse.MountOption) {
+	v.mountOptions = options
+}
@@ -0,0 +1,174 @@
+package fs
+
+import (
+	"github.com/hashicorp/vault/api"
+	"github.com/wrouesnel/go.log"
+	"github.com/wrouesnel/vaultfs/vaultapi"
+	"golang.org/x/net/context"
+)
+
+// Auth is how to authenticate to Vault.
+type Auth struct {
+	// Token is the Vault token to use, if already had. It is used as is
+	// when Method is empty.
+	Token string
+	// Method is the auth method to log in with (e.g. approle, ldap, cert),
+	// or vaultapi.AuthMethodAgent for requests made through Vault Agent.
+	Method string
+	// User, Role and Secret are the credentials of Method: e.g. the user
+	// and password of ldap, or the role and secret id of approle.
+	User   string
+	Role   string
+	Secret string
+}
+
+// Options configures a filesystem created with New or mounted with Mount.
+// The zero value presents secret/ of the Vault in the environment, with the
+// token in the environment.
+type Options struct {
+	// Vault is the Vault client configuration. The default is read from the
+	// environment, as the vault CLI does.
+	Vault *api.Config
+	// Mountpoint is where the filesystem is mounted.
+	Mountpoint string
+	// Root is the Vault path presented at the root, secret by default.
+	Root string
+	// Auth is how to authenticate to Vault.
+	Auth Auth
+	// Backend, if set, makes the requests to Vault instead of a client
+	// connecting with Vault and Auth, e.g. an in-memory one for tests.
+	Backend vaultapi.AuthableLogical
+
+	// Cache, Limits and Breaker configure the response cache, the limits on
+	// requests to Vault and the circuit breaker. Each is disabled by its zero
+	// value.
+	Cache   vaultapi.CacheConfig
+	Limits  vaultapi.LimitConfig
+	Breaker vaultapi.BreakerConfig
+	// SizeLimits bounds the values and listings served, which fail with
+	// EFBIG beyond them (zero values are unbounded).
+	SizeLimits vaultapi.SizeLimits
+	// JournalSize is the number of recent requests to Vault recorded (0
+	// disables the journal).
+	JournalSize int
+
+	// Format is how secrets are presented, FormatFull by default.
+	Format string
+	// Include and Exclude are glob patterns of the Vault paths exposed, and
+	// of those never exposed.
+	Include []string
+	Exclude []string
+	// DenyList is glob patterns of the Vault paths never requested, even if
+	// the token has access to them (see vaultapi.NewDenyListLogical).
+	DenyList []string
+	// Writable allows requests which modify Vault. Otherwise they are
+	// refused and the filesystem is mounted read-only (see
+	// vaultapi.NewReadOnlyLogical).
+	Writable bool
+
+	// Logger logs the messages of the filesystem, the standard logger by
+	// default.
+	Logger log.Logger
+}
+
+// Option changes Options.
+type Option func(*Options)
+
+// WithVault sets the Vault client configuration.
+func WithVault(config *api.Config) Option {
+	return func(o *Options) { o.Vault = config }
+}
+
+// WithRoot sets the Vault path presented at the root.
+func WithRoot(root string) Option {
+	return func(o *Options) { o.Root = root }
+}
+
+// WithAuth sets how to authenticate to Vault.
+func WithAuth(auth Auth) Option {
+	return func(o *Options) { o.Auth = auth }
+}
+
+// WithToken authenticates to Vault with an existing token.
+func WithToken(token string) Option {
+	return func(o *Options) { o.Auth = Auth{Token: token} }
+}
+
+// WithBackend makes the requests to Vault with backend.
+func WithBackend(backend vaultapi.AuthableLogical) Option {
+	return func(o *Options) { o.Backend = backend }
+}
+
+// WithCache enables the response cache.
+func WithCache(cache vaultapi.CacheConfig) Option {
+	return func(o *Options) { o.Cache = cache }
+}
+
+// WithLimits limits the requests made to Vault.
+func WithLimits(limits vaultapi.LimitConfig) Option {
+	return func(o *Options) { o.Limits = limits }
+}
+
+// WithBreaker enables the circuit breaker.
+func WithBreaker(breaker vaultapi.BreakerConfig) Option {
+	return func(o *Options) { o.Breaker = breaker }
+}
+
+// WithSizeLimits bounds the values and listings served.
+func WithSizeLimits(limits vaultapi.SizeLimits) Option {
+	return func(o *Options) { o.SizeLimits = limits }
+}
+
+// WithFormat sets how secrets are presented.
+func WithFormat(format string) Option {
+	return func(o *Options) { o.Format = format }
+}
+
+// WithPathFilters sets the glob patterns of the Vault paths exposed, and of
+// those never exposed.
+func WithPathFilters(include []string, exclude []string) Option {
+	return func(o *Options) { o.Include, o.Exclude = include, exclude }
+}
+
+// WithDenyList sets the glob patterns of the Vault paths never requested.
+func WithDenyList(patterns []string) Option {
+	return func(o *Options) { o.DenyList = patterns }
+}
+
+// WithWritable allows requests which modify Vault.
+func WithWritable(writable bool) Option {
+	return func(o *Options) { o.Writable = writable }
+}
+
+// WithLogger sets the logger of the filesystem.
+func WithLogger(logger log.Logger) Option {
+	return func(o *Options) { o.Logger = logger }
+}
+
+// Mount mounts a filesystem configured by options, changed by opts, and serves
+// it until ctx is done, when it is unmounted. Filesystems needing settings
+// beyond Options are created with New and mounted with MountContext.
+func Mount(ctx context.Context, options Options, opts ...Option) error {
+	v, err := New(options, opts...)
+	if err != nil {
+		return err
+	}
+	return v.MountContext(ctx)
+}
+
+// MountContext mounts the filesystem and serves it until ctx is done, when it
+// is unmounted.
+func (v *VaultFS) MountContext(ctx context.Context) error {
+	served := make(chan error, 1)
+	go func() { served <- v.Mount() }()
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+	if err := v.Unmount(); err != nil {
+		return err
+	}
+	return <-served
+}
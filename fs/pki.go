@@ -0,0 +1,114 @@
+// The PKI secrets engine issues certificates. Reading a certificate file
+// issues a new certificate, and the CA certificate and CRL are exposed as
+// files.
+
+package fs
+
+import (
+	"path"
+	"strings"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"golang.org/x/net/context"
+)
+
+// pemSuffix is the suffix of certificate files.
+const pemSuffix = ".pem"
+
+// pkiEngine provides the ca.pem, crl.pem and issue/<role>/<common_name>.pem
+// files of a PKI mount.
+type pkiEngine struct{}
+
+// lookup implements engine.
+func (pkiEngine) lookup(vfs *VaultFS, mount string, relPath string) (fs.Node, error) {
+	switch relPath {
+	case "ca.pem":
+		return vfs.pkiCertFile(path.Join(mount, "cert", "ca")), nil
+	case "crl.pem":
+		return vfs.pkiCertFile(path.Join(mount, "cert", "crl")), nil
+	case "issue":
+		return vfs.pkiIssueDir(mount), nil
+	}
+	return nil, nil
+}
+
+// entries implements engine.
+func (pkiEngine) entries(relPath string) []fuse.Dirent {
+	if relPath != "" {
+		return nil
+	}
+	return []fuse.Dirent{
+		{Name: "ca.pem", Type: fuse.DT_File},
+		{Name: "crl.pem", Type: fuse.DT_File},
+		{Name: "issue", Type: fuse.DT_Dir},
+	}
+}
+
+// pkiCertFile returns a file holding the certificate read from certPath.
+func (v *VaultFS) pkiCertFile(certPath string) *DynamicFile {
+	return NewDynamicFile(v, certPath, false, func(ctx context.Context, req fuse.Header, input []byte) ([]byte, error) {
+		secret, err := v.logic(ctx).Read(certPath)
+		if err != nil {
+			return nil, err
+		}
+		if secret == nil {
+			return nil, fuse.ENOENT
+		}
+		certificate, _ := secret.Data["certificate"].(string)
+		return []byte(strings.TrimSpace(certificate) + "\n"), nil
+	})
+}
+
+// pkiIssueDir returns the issue/ directory, holding a directory for each role.
+// Reading <role>/<common_name>.pem issues a new certificate (once per open)
+// and returns the certificate, its CA chain and its private key.
+func (v *VaultFS) pkiIssueDir(mount string) *LookupDir {
+	roleDir := func(ctx context.Context, role string) (fs.Node, error) {
+		return NewLookupDir(v, func(ctx context.Context, name string) (fs.Node, error) {
+			if !strings.HasSuffix(name, pemSuffix) || name == pemSuffix {
+				return nil, nil
+			}
+			commonName := strings.TrimSuffix(name, pemSuffix)
+			return NewDynamicFile(v, path.Join(mount, "issue", role), false, func(ctx context.Context, req fuse.Header, input []byte) ([]byte, error) {
+				secret, err := v.logic(ctx).Write(path.Join(mount, "issue", role), map[string]interface{}{
+					"common_name": commonName,
+				})
+				if err != nil {
+					return nil, err
+				}
+				if secret == nil {
+					return nil, fuse.EIO
+				}
+				return pkiBundle(secret.Data), nil
+			}), nil
+		}, nil), nil
+	}
+
+	listRoles := func(ctx context.Context) ([]fuse.Dirent, error) {
+		return v.listKeys(ctx, path.Join(mount, "roles"), fuse.DT_Dir)
+	}
+
+	return NewLookupDir(v, roleDir, listRoles)
+}
+
+// pkiBundle concatenates the PEM blocks of an issued certificate.
+func pkiBundle(data map[string]interface{}) []byte {
+	blocks := []string{}
+	if certificate, ok := data["certificate"].(string); ok {
+		blocks = append(blocks, strings.TrimSpace(certificate))
+	}
+	if chain, ok := data["ca_chain"].([]interface{}); ok && len(chain) > 0 {
+		for _, ca := range chain {
+			if ca, ok := ca.(string); ok {
+				blocks = append(blocks, strings.TrimSpace(ca))
+			}
+		}
+	} else if issuingCA, ok := data["issuing_ca"].(string); ok {
+		blocks = append(blocks, strings.TrimSpace(issuingCA))
+	}
+	if privateKey, ok := data["private_key"].(string); ok {
+		blocks = append(blocks, strings.TrimSpace(privateKey))
+	}
+	return []byte(strings.Join(blocks, "\n") + "\n")
+}
@@ -0,0 +1,103 @@
+// Prefetching reads paths into the cache ahead of their first access, so
+// their readers don't wait on Vault.
+
+package fs
+
+import (
+	"path"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// prefetchWorkers bounds the number of concurrent prefetch requests.
+const prefetchWorkers = 8
+
+// SetPrefetch sets paths, relative to the root, which are read into the cache
+// as soon as the filesystem is mounted. Must be called before Mount.
+func (v *VaultFS) SetPrefetch(paths []string) {
+	v.prefetchPaths = paths
+}
+
+// prefetch reads the prefetch paths, as looking them up would: each is read,
+// and listed if it isn't a secret.
+func (v *VaultFS) prefetch() {
+	if len(v.prefetchPaths) == 0 {
+		return
+	}
+	if v.cache == nil {
+		v.logger.Warn("prefetching has no effect without a cache")
+		return
+	}
+
+	paths := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < prefetchWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for lookupPath := range paths {
+				log := v.logger.WithField("path", lookupPath)
+				secret, err := v.logical.Read(lookupPath)
+				if err == nil && secret == nil {
+					_, err = v.logical.List(lookupPath)
+				}
+				if err != nil {
+					log.WithError(err).Warn("could not prefetch")
+					continue
+				}
+				log.Debug("prefetched")
+			}
+		}()
+	}
+
+	for _, p := range v.prefetchPaths {
+		lookupPath := path.Join(v.root, strings.Trim(p, "/"))
+		if !v.pathVisible(lookupPath) {
+			continue
+		}
+		paths <- lookupPath
+	}
+	close(paths)
+	wg.Wait()
+	v.logger.WithField("paths", len(v.prefetchPaths)).Info("prefetched")
+}
+
+// SetPrefetchChildren makes listing a directory look up its children in the
+// background, concurrently, so that the lookups which usually follow (e.g. by
+// ls -l or tree) are answered from the cache (or join the requests in flight)
+// rather than made one by one. It has no effect without a cache.
+func (v *VaultFS) SetPrefetchChildren(prefetch bool) {
+	if prefetch && v.cache == nil {
+		v.logger.Warn("prefetching children has no effect without a cache")
+		return
+	}
+	v.prefetchChildren = prefetch
+}
+
+// prefetchChildren looks up the children of s named by names in the
+// background, with at most prefetchWorkers at once.
+func (s *SecretDir) prefetchChildren(ctx context.Context, names []string) {
+	if !s.fs.prefetchChildren || len(names) == 0 {
+		return
+	}
+
+	// The lookups outlive the request, but are made on behalf of the same
+	// caller.
+	ctx = detach(ctx)
+	go func() {
+		sem := make(chan struct{}, prefetchWorkers)
+		var wg sync.WaitGroup
+		for _, name := range names {
+			sem <- struct{}{}
+			wg.Add(1)
+			go func(childPath string) {
+				defer func() { <-sem; wg.Done() }()
+				s.lookup(ctx, childPath)
+			}(path.Join(s.lookupPath, name))
+		}
+		wg.Wait()
+		s.log(ctx).WithField("children", len(names)).Debug("prefetched children")
+	}()
+}
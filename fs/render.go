@@ -0,0 +1,187 @@
+package fs
+
+import (
+	"fmt"
+	"strings"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/hashicorp/vault/api"
+	"github.com/wrouesnel/vaultfs/vaultapi"
+	"golang.org/x/net/context"
+)
+
+// SecretRenderer presents a secret read by a SecretDir: the entries of its
+// directory and the node of each. The entries every format shares (the JSON
+// view, the wrapping file and the KV v2 control directory) are added by the
+// SecretDir, so a renderer only provides those of its view.
+type SecretRenderer interface {
+	// Entries returns the entries of the directory of secret.
+	Entries(ctx context.Context, s *SecretDir, secret *api.Secret) ([]fuse.Dirent, error)
+	// Lookup returns the node of the entry name of secret, or fuse.ENOENT.
+	Lookup(ctx context.Context, s *SecretDir, secret *api.Secret, name string) (fs.Node, error)
+}
+
+// secretRenderers are the renderers of each format.
+var secretRenderers = map[string]SecretRenderer{
+	FormatFull: fullRenderer{},
+	FormatData: dataRenderer{},
+}
+
+// renderer returns the renderer of the format of the filesystem.
+func (v *VaultFS) renderer() SecretRenderer {
+	return secretRenderers[v.format]
+}
+
+// Static map of directory items found under a non-listable secret
+var secretDirEntrys = map[string]fuse.Dirent{
+	"lease_id": {
+		Name:  "lease_id",
+		Inode: 0,
+		Type:  fuse.DT_File,
+	},
+	// LeaseDuration
+	"lease_duration": {
+		Name:  "lease_duration",
+		Inode: 0,
+		Type:  fuse.DT_File,
+	},
+	// "Renewable" file is always empty
+	"renewable": {
+		Name:  "renewable",
+		Inode: 0,
+		Type:  fuse.DT_File,
+	},
+	// Data is a directory
+	"data": {
+		Name:  "data",
+		Inode: 0,
+		Type:  fuse.DT_Dir,
+	},
+	// Warnings is a file.
+	"warnings": {
+		Name:  "warnings",
+		Inode: 0,
+		Type:  fuse.DT_File,
+	},
+	// Auth is a directory
+	"auth": {
+		Name:  "auth",
+		Inode: 0,
+		Type:  fuse.DT_Dir,
+	},
+	// WrapInfo is a directory
+	"wrap_info": {
+		Name:  "wrap_info",
+		Inode: 0,
+		Type:  fuse.DT_Dir,
+	},
+}
+
+// fullRenderer presents the whole API response of a secret (FormatFull), with
+// its data under data/.
+type fullRenderer struct{}
+
+func (fullRenderer) Entries(ctx context.Context, s *SecretDir, secret *api.Secret) ([]fuse.Dirent, error) {
+	dirs := []fuse.Dirent{}
+	for k, v := range secretDirEntrys {
+		if s.fs.secretEntryVisible(k) {
+			dirs = append(dirs, v)
+		}
+	}
+	return dirs, nil
+}
+
+func (fullRenderer) Lookup(ctx context.Context, s *SecretDir, secret *api.Secret, name string) (fs.Node, error) {
+	// Lookup which node in the fixed list...
+	dir, found := secretDirEntrys[name]
+	if !found || !s.fs.secretEntryVisible(name) {
+		s.log(ctx).WithField("name", name).Debugln("SecretDir.lookupSecret not valid for Secret.")
+		return nil, fuse.ENOENT
+	}
+
+	// Return a value node if a file, else one of the specialized directories
+	switch dir.Name {
+	case "lease_id":
+		return NewValue(s.fs, secret.LeaseID)
+	case "lease_duration":
+		return NewValue(s.fs, fmt.Sprintf("%v", secret.LeaseDuration))
+	case "renewable":
+		return NewValue(s.fs, fmt.Sprintf("%v", secret.Renewable))
+	case "warnings":
+		return NewValue(s.fs, strings.Join(secret.Warnings, "\n"))
+	case "data":
+		if s.fs.isWritable(s.lookupPath) {
+			return s.fs.newSecretDataDir(s.lookupPath, secret), nil
+		}
+		// Non-string values are rendered as JSON, and nested maps as
+		// subdirectories.
+		return s.dataTree(secret)
+	case "auth":
+		if secret.Auth == nil {
+			return NewStaticDir(s.fs, nil)
+		}
+
+		authDir := make(map[string]interface{})
+		authDir["client_token"] = secret.Auth.ClientToken
+		authDir["accessor"] = secret.Auth.Accessor
+		authDir["policies"] = strings.Join(secret.Auth.Policies, "\n")
+
+		metadata := make(map[string]interface{})
+		for k, v := range secret.Auth.Metadata {
+			metadata[k] = v
+		}
+		authDir["metadata"] = metadata
+		authDir["lease_duration"] = fmt.Sprintf("%v", secret.Auth.LeaseDuration)
+		authDir["renewable"] = fmt.Sprintf("%v", secret.Auth.Renewable)
+
+		return NewStaticDir(s.fs, authDir)
+	case "wrap_info":
+		if secret.WrapInfo == nil {
+			return NewStaticDir(s.fs, nil)
+		}
+
+		wrapInfo := make(map[string]interface{})
+		wrapInfo["token"] = secret.WrapInfo.Token
+		wrapInfo["ttl"] = fmt.Sprintf("%v", secret.WrapInfo.TTL)
+		wrapInfo["creation_time"] = secret.WrapInfo.CreationTime.String()
+		wrapInfo["wrapped_accessor"] = secret.WrapInfo.WrappedAccessor
+
+		return NewStaticDir(s.fs, wrapInfo)
+	}
+
+	return nil, fuse.ENOENT
+}
+
+// dataRenderer presents only the data keys of a secret (FormatData), as files
+// directly in its directory.
+type dataRenderer struct{}
+
+func (dataRenderer) Entries(ctx context.Context, s *SecretDir, secret *api.Secret) ([]fuse.Dirent, error) {
+	data := secret.Data
+	if s.fs.isWritable(s.lookupPath) {
+		data = vaultapi.SecretData(secret)
+	}
+	dataDir, err := NewStaticDir(s.fs, data)
+	if err != nil {
+		s.log(ctx).WithError(err).Error("could not render secret data")
+		return nil, fuse.EIO
+	}
+	return dataDir.ReadDirAll(ctx)
+}
+
+func (dataRenderer) Lookup(ctx context.Context, s *SecretDir, secret *api.Secret, name string) (fs.Node, error) {
+	if s.fs.isWritable(s.lookupPath) {
+		return s.fs.newSecretDataDir(s.lookupPath, secret).child(name)
+	}
+	dataDir, err := s.dataTree(secret)
+	if err != nil {
+		s.log(ctx).WithError(err).Error("could not render secret data")
+		return nil, fuse.EIO
+	}
+	child, found := dataDir.children[name]
+	if !found {
+		return nil, fuse.ENOENT
+	}
+	return child, nil
+}
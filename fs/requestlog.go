@@ -0,0 +1,172 @@
+// Request-scoped logging of the FUSE operations served. Each operation is
+// given an ID, and its beginning and end are logged with it at debug level, so
+// the messages logged while serving it can be correlated.
+
+package fs
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/wrouesnel/go.log"
+	"github.com/wrouesnel/vaultfs/vaultapi"
+	"golang.org/x/net/context"
+)
+
+// operationKey is the context key for the operation being served.
+type operationKey struct{}
+
+// operation is a FUSE request being served.
+type operation struct {
+	id      uint64
+	name    string
+	header  fuse.Header
+	started time.Time
+	logger  log.Logger // with the ID of the operation
+
+	mu   sync.Mutex
+	path string // the Vault path the operation is about, once known
+}
+
+// requestLog tracks the operations being served, to log their ends.
+type requestLog struct {
+	v      *VaultFS
+	nextID uint64 // accessed atomically
+
+	mu       sync.Mutex
+	inflight map[fuse.RequestID]*operation
+}
+
+func newRequestLog(v *VaultFS) *requestLog {
+	return &requestLog{
+		v:        v,
+		inflight: make(map[fuse.RequestID]*operation),
+	}
+}
+
+// SetSlowOpThreshold sets the duration beyond which operations are logged as
+// warnings. Zero disables the warnings.
+func (v *VaultFS) SetSlowOpThreshold(threshold time.Duration) {
+	v.slowOpThreshold = threshold
+}
+
+// context starts the operation of serving req, adding it to the context of
+// serving it. It is the WithContext hook of the server.
+func (l *requestLog) context(ctx context.Context, req fuse.Request) context.Context {
+	header := *req.Hdr()
+	op := &operation{
+		id:      atomic.AddUint64(&l.nextID, 1),
+		name:    operationName(req),
+		header:  header,
+		started: time.Now(),
+	}
+	op.logger = l.v.logger.WithField("request_id", op.id)
+	op.logger.WithFields(log.Fields{
+		"op":   op.name,
+		"node": header.Node,
+		"pid":  header.Pid,
+		"uid":  header.Uid,
+	}).Debug("begin")
+
+	l.mu.Lock()
+	l.inflight[header.ID] = op
+	l.mu.Unlock()
+
+	return context.WithValue(requestContext(ctx, req), operationKey{}, op)
+}
+
+// debug ends the operations whose responses are logged by the server. It is
+// the Debug hook of the server, which is given the response of every request
+// just before it is sent.
+func (l *requestLog) debug(msg interface{}) {
+	// The server's messages are of unexported types, so their fields are
+	// read by name.
+	value := reflect.ValueOf(msg)
+	if value.Kind() != reflect.Struct || value.Type().Name() != "response" {
+		return
+	}
+	id := fuse.RequestID(value.FieldByName("Request").FieldByName("ID").Uint())
+	errno := value.FieldByName("Errno").String()
+
+	l.mu.Lock()
+	op := l.inflight[id]
+	delete(l.inflight, id)
+	l.mu.Unlock()
+	if op != nil {
+		l.end(op, errno)
+	}
+}
+
+// end logs the end of op, as a warning if it was slow.
+func (l *requestLog) end(op *operation, errno string) {
+	elapsed := time.Since(op.started)
+	logger := op.logger.WithFields(log.Fields{
+		"op":       op.name,
+		"path":     op.vaultPath(),
+		"duration": elapsed,
+		"pid":      op.header.Pid,
+		"uid":      op.header.Uid,
+	})
+	if errno != "" {
+		logger = logger.WithField("errno", errno)
+	}
+	if l.v.statsd != nil {
+		l.v.statsd.observeOp(op.name, elapsed, errno != "")
+	}
+	if threshold := l.v.slowOpThreshold; threshold > 0 && elapsed >= threshold {
+		logger.Warn("slow operation")
+		return
+	}
+	logger.Debug("end")
+}
+
+func (op *operation) vaultPath() string {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	return op.path
+}
+
+// operationName returns the name of the operation of req, e.g. Lookup for a
+// LookupRequest.
+func operationName(req fuse.Request) string {
+	name := reflect.Indirect(reflect.ValueOf(req)).Type().Name()
+	return strings.TrimSuffix(name, "Request")
+}
+
+// detached is the context of a request without its cancellation, for work
+// on behalf of the request which outlives it.
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
+
+// detach returns ctx without its cancellation, keeping its values.
+func detach(ctx context.Context) context.Context {
+	return detached{ctx}
+}
+
+// opLog returns the logger of the operation being served with ctx, or the
+// default logger if it isn't a FUSE operation. Either redacts secret values.
+func opLog(ctx context.Context) log.Logger {
+	if op, ok := ctx.Value(operationKey{}).(*operation); ok {
+		return op.logger
+	}
+	return vaultapi.SafeLogger(log.Base())
+}
+
+// setOpPath records the Vault path the operation being served with ctx is
+// about, to be logged at its end.
+func setOpPath(ctx context.Context, path string) {
+	if op, ok := ctx.Value(operationKey{}).(*operation); ok {
+		op.mu.Lock()
+		op.path = path
+		op.mu.Unlock()
+	}
+}
@@ -0,0 +1,89 @@
+// While Vault is sealed requests fail with EAGAIN rather than EIO, and the
+// seal status is polled so the mount recovers as soon as it is unsealed.
+
+package fs
+
+import (
+	"sync"
+	"syscall"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/hashicorp/errwrap"
+	"github.com/wrouesnel/vaultfs/vaultapi"
+	"golang.org/x/net/context"
+)
+
+// sealPollInterval is how often the seal status is checked while sealed.
+const sealPollInterval = 5 * time.Second
+
+// sealState tracks whether Vault has been found to be sealed.
+type sealState struct {
+	mu     sync.Mutex
+	sealed bool
+}
+
+// noteBackendError records that Vault is sealed if err says so, and starts
+// polling for it to be unsealed.
+func (v *VaultFS) noteBackendError(err error) {
+	if !errwrap.ContainsType(err, vaultapi.ErrSealed{}) {
+		return
+	}
+
+	v.seal.mu.Lock()
+	defer v.seal.mu.Unlock()
+	if v.seal.sealed {
+		return
+	}
+	v.seal.sealed = true
+	v.logger.Warn("vault is sealed, failing requests with EAGAIN until it is unsealed")
+	go v.pollSeal()
+}
+
+// pollSeal polls the seal status until Vault is unsealed or the filesystem is
+// unmounted.
+func (v *VaultFS) pollSeal() {
+	ticker := time.NewTicker(sealPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-v.stopping:
+			return
+		case <-ticker.C:
+		}
+
+		body, err := v.backend.ReadRaw("sys/seal-status", nil)
+		if err != nil {
+			v.logger.WithError(err).Debug("could not read seal status")
+			continue
+		}
+		if sealed, ok := body["sealed"].(bool); ok && !sealed {
+			v.seal.mu.Lock()
+			v.seal.sealed = false
+			v.seal.mu.Unlock()
+			v.logger.Info("vault is unsealed")
+			return
+		}
+	}
+}
+
+// isSealed reports whether Vault was last found to be sealed.
+func (v *VaultFS) isSealed() bool {
+	v.seal.mu.Lock()
+	defer v.seal.mu.Unlock()
+	return v.seal.sealed
+}
+
+// unavailable returns the error for the request being served with ctx, which
+// couldn't reach Vault: EINTR if it was interrupted, EAGAIN while Vault is
+// sealed, and EIO otherwise.
+func (v *VaultFS) unavailable(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return fuse.EINTR
+	}
+	if v.isSealed() {
+		return fuse.Errno(syscall.EAGAIN)
+	}
+	return fuse.EIO
+}
@@ -4,10 +4,11 @@
 package fs
 
 import (
-	"fmt"
+	"encoding/json"
 	"os"
 	"path"
 	"strings"
+	"syscall"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
@@ -21,52 +22,15 @@ import (
 
 // Statically ensure that *SecretDir implement those interface
 var _ = fs.HandleReadDirAller(&SecretDir{})
-var _ = fs.NodeStringLookuper(&SecretDir{})
-
-// Static map of directory items found under a non-listable secret
-var secretDirEntrys = map[string]fuse.Dirent{
-	"lease_id": {
-		Name:  "lease_id",
-		Inode: 0,
-		Type:  fuse.DT_File,
-	},
-	// LeaseDuration
-	"lease_duration": {
-		Name:  "lease_duration",
-		Inode: 0,
-		Type:  fuse.DT_File,
-	},
-	// "Renewable" file is always empty
-	"renewable": {
-		Name:  "renewable",
-		Inode: 0,
-		Type:  fuse.DT_File,
-	},
-	// Data is a directory
-	"data": {
-		Name:  "data",
-		Inode: 0,
-		Type:  fuse.DT_Dir,
-	},
-	// Warnings is a file.
-	"warnings": {
-		Name:  "warnings",
-		Inode: 0,
-		Type:  fuse.DT_File,
-	},
-	// Auth is a directory
-	"auth": {
-		Name:  "auth",
-		Inode: 0,
-		Type:  fuse.DT_Dir,
-	},
-	// WrapInfo is a directory
-	"wrap_info": {
-		Name:  "wrap_info",
-		Inode: 0,
-		Type:  fuse.DT_Dir,
-	},
-}
+var _ = fs.NodeRequestLookuper(&SecretDir{})
+
+// secretJSONName is the optional file in a secret directory holding the whole
+// secret as JSON.
+const secretJSONName = "secret.json"
+
+// selfDirName is the reserved entry in a directory-like secret which is also
+// a secret in its own right, exposing that secret's own data.
+const selfDirName = ".self"
 
 // SecretType is returned from internal lookup functions to track
 // possibly changing key types.
@@ -87,6 +51,33 @@ const (
 	// SecretTypeSecret returned if a key is read'able, and should have
 	// secret-like behavior
 	SecretTypeSecret
+	// SecretTypeSecretDirectory returned if a key is both read'able and
+	// list'able. It behaves like a directory, with the secret itself under
+	// selfDirName.
+	SecretTypeSecretDirectory
+	// SecretTypeDeleted returned if a key is a KV version 2 secret whose
+	// latest version is deleted. Only its control files are available.
+	SecretTypeDeleted
+	// SecretTypeTooLarge returned if a key holds a value or listing over the
+	// size limits.
+	SecretTypeTooLarge
+)
+
+// listing is how the listing of a directory presents one of its keys, which
+// spares requests to look the key up.
+type listing int
+
+const (
+	// listingUnknown if the key wasn't listed, or not by a directory.
+	listingUnknown listing = iota
+	// listingSecret if the key is a secret with no keys beneath it, so needn't
+	// be listed.
+	listingSecret
+	// listingDirectory if the key has keys beneath it, but isn't a secret
+	// itself, so needn't be read.
+	listingDirectory
+	// listingBoth if the key is a secret with keys beneath it.
+	listingBoth
 )
 
 // SecretDir implements Node and Handle
@@ -95,11 +86,16 @@ const (
 type SecretDir struct {
 	fs         *VaultFS // root filesystem this node is associated with
 	lookupPath string   // Vault Path used to find this key.
+	secretOnly bool     // Only treat the key as a secret (for selfDirName)
+	listed     listing  // How the parent's listing presents the key
+	root       bool     // The root of the filesystem, holding virtual entries
+
+	fixed *api.Secret // Secret presented instead of reading lookupPath (optional)
 }
 
 // NewSecretDir creates a SecretDir node linked to the given secret and vault API.
 func NewSecretDir(fs *VaultFS, lookupPath string) (*SecretDir, error) {
-	log := log.WithField("root", lookupPath)
+	log := vaultapi.SafeLogger(log.Base()).WithField("root", lookupPath)
 	log.Debug("NewSecret")
 
 	if lookupPath == "" {
@@ -119,24 +115,57 @@ func NewSecretDir(fs *VaultFS, lookupPath string) (*SecretDir, error) {
 	}, nil
 }
 
-func (s *SecretDir) log() log.Logger {
-	return log.WithField("root", s.lookupPath)
+// log returns the logger of the operation being served with ctx.
+func (s *SecretDir) log(ctx context.Context) log.Logger {
+	return opLog(ctx).WithField("root", s.lookupPath)
 }
 
 // Does a lookup for the given lookup path, determines the type of key it
 // currently is, and returns the associated secret.
 func (s *SecretDir) lookup(ctx context.Context, lookupPath string) (SecretType, *api.Secret) {
-	log := s.log().WithField("path", lookupPath)
+	log := s.log(ctx).WithField("path", lookupPath)
 	log.Debug("Handling SecretDir.lookup")
 
-	// TODO: handle context cancellation
-	secret, err := s.fs.logic().Read(lookupPath)
+	if s.fixed != nil {
+		return SecretTypeSecret, s.fixed
+	}
+
+	if !s.fs.pathVisible(lookupPath) {
+		log.Debug("Lookup of filtered path")
+		return SecretTypeNonExistent, nil
+	}
+	if s.fs.isMissing(ctx, lookupPath) {
+		log.Debug("Lookup of path recently found not to exist")
+		return SecretTypeNonExistent, nil
+	}
+
+	// The parent's listing tells whether the key is a secret, and whether it
+	// has keys beneath it.
+	listed := listingUnknown
+	if lookupPath == s.lookupPath {
+		listed = s.listed
+	}
+
+	var secret *api.Secret
+	var err error
+	if listed != listingDirectory {
+		secret, err = s.fs.logic(ctx).Read(lookupPath)
+	}
+	if errwrap.ContainsType(err, vaultapi.ErrInterrupted{}) {
+		log.Debug("Lookup interrupted")
+		return SecretTypeBackendError, nil
+	}
+	if errwrap.ContainsType(err, vaultapi.ErrTooLarge{}) {
+		log.WithError(err).Warn("Secret over the size limits")
+		return SecretTypeTooLarge, nil
+	}
 	if err != nil {
 		// Was this just permission denied (in which case fall through to directory listing)
 		// Note: the error handling in the vault client library *sucks*
 		if !errwrap.ContainsType(err, vaultapi.ErrPermissionDenied{}) {
 			// Connection level errors won't recover further down.
-			s.log().WithError(err).Error("Backend inaccessible")
+			s.log(ctx).WithError(err).Error("Backend inaccessible")
+			s.fs.noteBackendError(err)
 			return SecretTypeBackendError, nil
 		}
 		// Permission denied - continue to try listing (which might be allowed).
@@ -145,16 +174,36 @@ func (s *SecretDir) lookup(ctx context.Context, lookupPath string) (SecretType,
 
 	// Literal secret was found (not found still requires us to try list below)
 	if secret != nil {
+		if s.secretOnly || listed == listingSecret {
+			log.Debugln("Lookup succeeded for file-like secret")
+			return SecretTypeSecret, secret
+		}
+		// Vault allows keys beneath a secret, so check whether it's also
+		// directory-like to keep them reachable.
+		dirSecret, err := s.fs.logic(ctx).List(lookupPath)
+		if err == nil && dirSecret != nil {
+			log.Debugln("Lookup succeeded for secret which is also directory-like")
+			return SecretTypeSecretDirectory, dirSecret
+		}
 		log.Debugln("Lookup succeeded for file-like secret")
 		return SecretTypeSecret, secret
 	}
 
 	// Not a secret (or permission denied). Try listing to see if directory-like.
-	dirSecret, err := s.fs.logic().List(lookupPath)
+	dirSecret, err := s.fs.logic(ctx).List(lookupPath)
+	if errwrap.ContainsType(err, vaultapi.ErrInterrupted{}) {
+		log.Debug("Lookup interrupted")
+		return SecretTypeBackendError, nil
+	}
+	if errwrap.ContainsType(err, vaultapi.ErrTooLarge{}) {
+		log.WithError(err).Warn("Directory over the size limits")
+		return SecretTypeTooLarge, nil
+	}
 	if err != nil {
 		if !errwrap.ContainsType(err, vaultapi.ErrPermissionDenied{}) {
 			// Connection level errors won't recover further down.
 			log.WithError(err).Error("Error reading key")
+			s.fs.noteBackendError(err)
 			return SecretTypeBackendError, nil
 		}
 		log.WithError(err).Info("Permission denied (directory)")
@@ -166,98 +215,111 @@ func (s *SecretDir) lookup(ctx context.Context, lookupPath string) (SecretType,
 		return SecretTypeDirectory, dirSecret
 	}
 
+	// A deleted KV v2 secret still has metadata, and can be recovered.
+	if metadataPath, ok := vaultapi.KVv2MetadataPath(lookupPath); ok {
+		metadata, err := s.fs.logic(ctx).Read(metadataPath)
+		if err == nil && metadata != nil {
+			log.Debugln("Lookup found deleted KV v2 secret")
+			return SecretTypeDeleted, metadata
+		}
+	}
+
 	// Key was not found
+	s.fs.setMissing(ctx, lookupPath)
 	return SecretTypeNonExistent, nil
 }
 
 // Does a lookup for the static subkeys of a Secret-type secret.
 func (s *SecretDir) lookupSecret(ctx context.Context, secret *api.Secret, name string) (fs.Node, error) {
-	log := s.log().WithField("name", name)
-	// Lookup which node in the fixed list...
-	dir, found := secretDirEntrys[name]
-	if !found {
-		log.Debugln("SecretDir.lookupSecret not valid for Secret.")
-		return nil, fuse.ENOENT
-	}
+	log := s.log(ctx).WithField("name", name)
 
-	// Return a value node if a file, else one of the specialized directories
-	switch dir.Name {
-	case "lease_id":
-		return NewValue(secret.LeaseID)
-	case "lease_duration":
-		return NewValue(fmt.Sprintf("%v", secret.LeaseDuration))
-	case "renewable":
-		return NewValue(fmt.Sprintf("%v", secret.Renewable))
-	case "warnings":
-		return NewValue(strings.Join(secret.Warnings, "\n"))
-	case "data":
-		subdir := make(map[string]interface{})
-		for filename, data := range secret.Data {
-			if value, ok := data.(string); !ok {
-				log.WithField("name", name).
-					WithField("childname", filename).
-					Errorf("Not a string in backend - ignoring: %T", data)
-			} else {
-				subdir[filename] = value
-			}
-		}
-		return NewStaticDir(subdir)
-	case "auth":
-		if secret.Auth == nil {
-			return NewStaticDir(nil)
+	if name == secretJSONName && s.fs.jsonView {
+		content, err := json.MarshalIndent(s.fs.visibleSecret(secret), "", "  ")
+		if err != nil {
+			log.WithError(err).Error("could not encode secret as JSON")
+			return nil, fuse.EIO
 		}
+		return NewValue(s.fs, string(content)+"\n")
+	}
 
-		authDir := make(map[string]interface{})
-		authDir["client_token"] = secret.Auth.ClientToken
-		authDir["accessor"] = secret.Auth.Accessor
-		authDir["policies"] = strings.Join(secret.Auth.Policies, "\n")
+	if name == wrapFileName && s.fixed == nil {
+		return s.fs.wrapFile(s.lookupPath), nil
+	}
 
-		metadata := make(map[string]interface{})
-		for k, v := range secret.Auth.Metadata {
-			metadata[k] = v
-		}
-		authDir["metadata"] = metadata
-		authDir["lease_duration"] = fmt.Sprintf("%v", secret.Auth.LeaseDuration)
-		authDir["renewable"] = fmt.Sprintf("%v", secret.Auth.Renewable)
-
-		return NewStaticDir(authDir)
-	case "wrap_info":
-		if secret.WrapInfo == nil {
-			return NewStaticDir(nil)
+	if name == controlDirName {
+		if _, ok := vaultapi.KVv2Metadata(secret); ok {
+			return newKVv2ControlDir(s.fs, s.lookupPath), nil
 		}
+	}
 
-		wrapInfo := make(map[string]interface{})
-		wrapInfo["token"] = secret.WrapInfo.Token
-		wrapInfo["ttl"] = fmt.Sprintf("%v", secret.WrapInfo.TTL)
-		wrapInfo["creation_time"] = secret.WrapInfo.CreationTime.String()
-		wrapInfo["wrapped_accessor"] = secret.WrapInfo.WrappedAccessor
+	return s.fs.renderer().Lookup(ctx, s, secret, name)
+}
 
-		return NewStaticDir(wrapInfo)
+// dataTree renders the data of secret as a StaticDir. Unless the secret is
+// fixed, its values are read again when they are opened.
+func (s *SecretDir) dataTree(secret *api.Secret) (*StaticDir, error) {
+	dataDir, err := NewStaticDir(s.fs, s.fs.decodeBase64Data(s.lookupPath, secret.Data))
+	if err != nil {
+		return nil, err
 	}
-
-	return nil, fuse.ENOENT
+	dataDir.setMeta(newSecretMeta(s.lookupPath, secret))
+
+	if s.fixed == nil {
+		dataDir.setRefresh(func(ctx context.Context) (*StaticDir, error) {
+			secretType, secret := s.lookup(ctx, s.lookupPath)
+			switch secretType {
+			case SecretTypeBackendError:
+				return nil, s.fs.unavailable(ctx)
+			case SecretTypeTooLarge:
+				return nil, errTooLarge
+			case SecretTypeSecret, SecretTypeSecretDirectory:
+			default:
+				return nil, fuse.ENOENT
+			}
+			current, err := NewStaticDir(s.fs, s.fs.decodeBase64Data(s.lookupPath, secret.Data))
+			if err != nil {
+				return nil, err
+			}
+			current.setMeta(newSecretMeta(s.lookupPath, secret))
+			return current, nil
+		})
+	}
+	return dataDir, nil
 }
 
 // Attr returns attributes about this Secret
 func (s *SecretDir) Attr(ctx context.Context, a *fuse.Attr) error {
-	s.log().Debugln("Handling SecretDir.Attr")
+	setOpPath(ctx, s.lookupPath)
 
-	a.Uid = 0
-	a.Gid = 0
+	a.Valid = s.fs.attrTimeout
+	a.Uid = s.fs.uid
+	a.Gid = s.fs.gid
 
-	currentSecretType, _ := s.lookup(ctx, s.lookupPath)
+	currentSecretType, currentSecret := s.lookup(ctx, s.lookupPath)
 
 	switch currentSecretType {
 	case SecretTypeBackendError:
-		return fuse.EIO
+		return s.fs.unavailable(ctx)
 	case SecretTypeNonExistent:
-		return fuse.ENOENT
+		// Secrets engine mounts (and the root) may have nothing readable
+		// themselves.
+		if len(s.extraEntries()) == 0 {
+			return fuse.ENOENT
+		}
+		a.Mode = os.ModeDir | os.FileMode(0555)
 	case SecretTypeInaccessible:
 		a.Mode = os.ModeDir | os.FileMode(0111)
-	case SecretTypeDirectory, SecretTypeSecret:
+	case SecretTypeTooLarge:
+		// Presented so that it can be removed, while reading it fails.
+		a.Mode = os.ModeDir | os.FileMode(0555)
+	case SecretTypeDirectory, SecretTypeSecret, SecretTypeSecretDirectory, SecretTypeDeleted:
 		a.Mode = os.ModeDir | os.FileMode(0555)
+		if s.fs.capabilityModes && s.fixed == nil {
+			a.Mode = os.ModeDir | s.fs.accessLevel(ctx, s.lookupPath).dirMode()
+		}
+		newSecretMeta(s.lookupPath, currentSecret).setTimes(a)
 	default:
-		log.Error("BUG: unknown secret type found.")
+		s.log(ctx).Error("BUG: unknown secret type found.")
 		return fuse.EIO
 	}
 
@@ -269,36 +331,85 @@ func (s *SecretDir) Attr(ctx context.Context, a *fuse.Attr) error {
 // unpopulated secret dir, which allows traversing further down the tree.
 // But, if we can access it, and confirm it doesn't exist, we return ENOENT
 // instead.
-func (s *SecretDir) Lookup(ctx context.Context, name string) (fs.Node, error) {
-	log := s.log().WithField("name", name)
-	log.Debugln("Handling SecretDir.Lookup")
+func (s *SecretDir) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.LookupResponse) (fs.Node, error) {
+	name := req.Name
+	log := s.log(ctx).WithField("name", name)
+
+	resp.EntryValid = s.fs.entryTimeout
 
 	// Check what type of node we are at the moment
 	childLookupPath := path.Join(s.lookupPath, name)
+	setOpPath(ctx, childLookupPath)
+
+	if s.root {
+		if node, err := s.fs.rootLookup(ctx, name); node != nil || err != nil {
+			return node, err
+		}
+	}
+
+	if s.fixed == nil && !s.fs.pathVisible(childLookupPath) {
+		return nil, fuse.ENOENT
+	}
+
+	if s.fixed == nil {
+		target, found, err := s.fs.resolveAlias(childLookupPath)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			return NewSecretDir(s.fs, target)
+		}
+		if s.fs.isAliasParent(childLookupPath) {
+			return NewSecretDir(s.fs, childLookupPath)
+		}
+	}
+
+	// Secrets engines may handle the path specially.
+	if node, err := s.fs.engineLookup(childLookupPath); node != nil || err != nil {
+		return node, err
+	}
 	currentSecretType, currentSecret := s.lookup(ctx, s.lookupPath)
 
 	switch currentSecretType {
 	case SecretTypeBackendError:
-		return nil, fuse.EIO
+		return nil, s.fs.unavailable(ctx)
+	case SecretTypeTooLarge:
+		return nil, errTooLarge
 	case SecretTypeNonExistent:
 		return nil, fuse.ENOENT
 	case SecretTypeInaccessible:
 		// Inaccessible is just a directory we *assume* exists.
 		return NewSecretDir(s.fs, childLookupPath)
-	case SecretTypeDirectory:
-		// Directory type - so do another lookup.
-		childSecretType, _ := s.lookup(ctx, childLookupPath)
+	case SecretTypeDirectory, SecretTypeSecretDirectory:
+		if currentSecretType == SecretTypeSecretDirectory && name == selfDirName {
+			self, err := NewSecretDir(s.fs, s.lookupPath)
+			if err != nil {
+				return nil, fuse.EIO
+			}
+			self.secretOnly = true
+			return self, nil
+		}
+
+		// Directory type - so do another lookup, which the listing spares
+		// a request of if the child is in it.
+		child, err := NewSecretDir(s.fs, childLookupPath)
+		if err != nil {
+			return nil, fuse.EIO
+		}
+		child.listed = listedAs(currentSecret, name)
+		childSecretType, _ := child.lookup(ctx, childLookupPath)
 		switch childSecretType {
 		case SecretTypeBackendError:
-			return nil, fuse.EIO
+			return nil, s.fs.unavailable(ctx)
 		case SecretTypeNonExistent:
 			return nil, fuse.ENOENT
 		// Important: note that for *child* secrets here, SecretTypeSecret is
 		// is treated exactly the same.
-		case SecretTypeInaccessible, SecretTypeDirectory, SecretTypeSecret:
+		case SecretTypeInaccessible, SecretTypeDirectory, SecretTypeSecret, SecretTypeSecretDirectory, SecretTypeDeleted, SecretTypeTooLarge:
 			// Inaccessible is just a directory we *assume* exists
-			// so is exactly like a directory.
-			return NewSecretDir(s.fs, childLookupPath)
+			// so is exactly like a directory. Those over the size limits
+			// exist, so can be removed, but fail to be read.
+			return child, nil
 		default:
 			log.Error("BUG: unknown secret type found.")
 			return nil, fuse.EIO
@@ -306,12 +417,43 @@ func (s *SecretDir) Lookup(ctx context.Context, name string) (fs.Node, error) {
 	case SecretTypeSecret:
 		// We are being a secret. Call out to secretLookup.
 		return s.lookupSecret(ctx, currentSecret, name)
+	case SecretTypeDeleted:
+		if name == controlDirName {
+			return newKVv2ControlDir(s.fs, s.lookupPath), nil
+		}
+		return nil, fuse.ENOENT
 	default:
 		log.Error("BUG: unknown secret type found.")
 		return nil, fuse.EIO
 	}
 }
 
+// listedAs returns how the listing of a directory, dirSecret, presents name.
+func listedAs(dirSecret *api.Secret, name string) listing {
+	if dirSecret == nil {
+		return listingUnknown
+	}
+	keys, _ := dirSecret.Data["keys"].([]interface{})
+	secret, dir := false, false
+	for _, key := range keys {
+		switch key {
+		case name:
+			secret = true
+		case name + "/":
+			dir = true
+		}
+	}
+	switch {
+	case secret && dir:
+		return listingBoth
+	case secret:
+		return listingSecret
+	case dir:
+		return listingDirectory
+	}
+	return listingUnknown
+}
+
 func (s *SecretDir) readDirAllDirSecret(ctx context.Context, secret *api.Secret) ([]fuse.Dirent, error) {
 	// Nil secret == 404, so it wasn't found.
 	if secret == nil {
@@ -325,7 +467,7 @@ func (s *SecretDir) readDirAllDirSecret(ctx context.Context, secret *api.Secret)
 
 	keys, found := secret.Data["keys"]
 	if !found {
-		s.log().Error("Directory-like secret had no \"keys\" field.")
+		s.log(ctx).Error("Directory-like secret had no \"keys\" field.")
 		return []fuse.Dirent{}, nil
 	}
 
@@ -335,18 +477,22 @@ func (s *SecretDir) readDirAllDirSecret(ctx context.Context, secret *api.Secret)
 
 	keylist, ok := keys.([]interface{})
 	if !ok {
-		s.log().Error("Directory-like secret keys field was not a list.")
+		s.log(ctx).Error("Directory-like secret keys field was not a list.")
 		return []fuse.Dirent{}, nil
 	}
 
 	dirs := []fuse.Dirent{}
+	names := []string{}
 	for _, value := range keylist {
 		// Ensure we don't have a trailing /
 		rawName, ok := value.(string)
 		if !ok {
-			s.log().Error("Value from backend for directory-like secret was not a string!")
+			s.log(ctx).Error("Value from backend for directory-like secret was not a string!")
 		}
 		secretName := strings.TrimRight(rawName, "/")
+		if !s.fs.pathVisible(path.Join(s.lookupPath, secretName)) {
+			continue
+		}
 
 		d := fuse.Dirent{
 			Name:  secretName,
@@ -354,38 +500,117 @@ func (s *SecretDir) readDirAllDirSecret(ctx context.Context, secret *api.Secret)
 			Type:  fuse.DT_Dir,
 		}
 		dirs = append(dirs, d)
+		names = append(names, secretName)
 	}
 
+	s.prefetchChildren(ctx, names)
 	return dirs, nil
 }
 
 func (s *SecretDir) readDirAllSecret(ctx context.Context, secret *api.Secret) ([]fuse.Dirent, error) {
+	dirs, err := s.fs.renderer().Entries(ctx, s, secret)
+	if err != nil {
+		return []fuse.Dirent{}, err
+	}
+
+	_, kv2 := vaultapi.KVv2Metadata(secret)
+	return append(dirs, s.secretFileEntries(kv2)...), nil
+}
+
+// secretFileEntries returns the entries added to the keys of a secret: the
+// JSON view, the wrapping file and, for KV version 2 secrets, the control
+// directory.
+func (s *SecretDir) secretFileEntries(kv2 bool) []fuse.Dirent {
 	dirs := []fuse.Dirent{}
+	if s.fs.jsonView {
+		dirs = append(dirs, fuse.Dirent{
+			Name: secretJSONName,
+			Type: fuse.DT_File,
+		})
+	}
 
-	for _, v := range secretDirEntrys {
-		dirs = append(dirs, v)
+	if s.fixed == nil {
+		dirs = append(dirs, fuse.Dirent{
+			Name: wrapFileName,
+			Type: fuse.DT_File,
+		})
 	}
 
-	return dirs, nil
+	if kv2 {
+		dirs = append(dirs, fuse.Dirent{
+			Name: controlDirName,
+			Type: fuse.DT_Dir,
+		})
+	}
+	return dirs
 }
 
 // ReadDirAll returns a list of secrets in this directory
 func (s *SecretDir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
-	s.log().Debugln("handling SecretDir.ReadDirAll call")
+	setOpPath(ctx, s.lookupPath)
+
+	dirs, err := s.readDirAll(ctx)
+
+	if extra := s.extraEntries(); len(extra) > 0 {
+		if err == fuse.ENOENT || err == fuse.Errno(syscall.EACCES) {
+			dirs, err = []fuse.Dirent{}, nil
+		}
+		if err == nil {
+			dirs = append(dirs, extra...)
+		}
+	}
+
+	return dirs, err
+}
+
+// extraEntries returns the virtual entries of this directory, which don't
+// exist in Vault: those added by secrets engines and aliases, and at the root.
+func (s *SecretDir) extraEntries() []fuse.Dirent {
+	extra := s.fs.engineEntries(s.lookupPath)
+	if s.fixed == nil {
+		extra = append(extra, s.fs.aliasEntries(s.lookupPath)...)
+	}
+	if s.root {
+		extra = append(extra, s.fs.rootEntries()...)
+	}
+	return extra
+}
+
+func (s *SecretDir) readDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	if dirs, ok := s.readDirAllSubkeys(ctx); ok {
+		return dirs, nil
+	}
 
 	currentSecretType, secret := s.lookup(ctx, s.lookupPath)
 
 	switch currentSecretType {
 	case SecretTypeBackendError:
-		return []fuse.Dirent{}, fuse.EIO
+		return []fuse.Dirent{}, s.fs.unavailable(ctx)
+	case SecretTypeTooLarge:
+		return []fuse.Dirent{}, errTooLarge
 	case SecretTypeNonExistent:
 		return []fuse.Dirent{}, fuse.ENOENT
 	case SecretTypeInaccessible:
-		return []fuse.Dirent{}, nil
+		// The directory can only be traversed.
+		return []fuse.Dirent{}, fuse.Errno(syscall.EACCES)
 	case SecretTypeDirectory:
 		return s.readDirAllDirSecret(ctx, secret)
+	case SecretTypeSecretDirectory:
+		dirs, err := s.readDirAllDirSecret(ctx, secret)
+		if err != nil {
+			return dirs, err
+		}
+		return append(dirs, fuse.Dirent{
+			Name: selfDirName,
+			Type: fuse.DT_Dir,
+		}), nil
 	case SecretTypeSecret:
 		return s.readDirAllSecret(ctx, secret)
+	case SecretTypeDeleted:
+		return []fuse.Dirent{{
+			Name: controlDirName,
+			Type: fuse.DT_Dir,
+		}}, nil
 	default:
 		log.Error("BUG: unknown secret type found.")
 		return []fuse.Dirent{}, fuse.EIO
@@ -0,0 +1,355 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/go-errors/errors"
+	"github.com/hashicorp/vault/api"
+	"github.com/wrouesnel/vaultfs/vaultapi"
+	"github.com/wrouesnel/vaultfs/vaultapi/vaulttest"
+	"golang.org/x/net/context"
+)
+
+// testBackend returns a backend holding a secret of each type beneath secret/
+// and kv/.
+func testBackend() *vaulttest.Logical {
+	backend := vaulttest.NewLogical()
+	backend.Put("secret/app", map[string]interface{}{"password": "hunter2", "port": 5432})
+	backend.Put("secret/dir/a", map[string]interface{}{"value": "a"})
+	backend.Put("secret/dir/b", map[string]interface{}{"value": "b"})
+	backend.Put("secret/both", map[string]interface{}{"own": "self"})
+	backend.Put("secret/both/child", map[string]interface{}{"value": "child"})
+	backend.Put("secret/denied/hidden", map[string]interface{}{"value": "hidden"})
+	backend.Put("secret/broken", map[string]interface{}{"value": "broken"})
+	backend.Deny("secret/denied")
+	backend.Fail("secret/broken", errors.New("connection refused"))
+	backend.PutKVv2("kv/data/live", map[string]interface{}{"value": "live"})
+	backend.PutKVv2("kv/data/gone", map[string]interface{}{"value": "gone"})
+	backend.Delete("kv/data/gone")
+	return backend
+}
+
+func TestSecretDirLookupTypes(t *testing.T) {
+	v := newTestFS(t, testBackend())
+	dir, err := NewSecretDir(v, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, expected := range map[string]SecretType{
+		"secret":         SecretTypeDirectory,
+		"secret/app":     SecretTypeSecret,
+		"secret/dir":     SecretTypeDirectory,
+		"secret/both":    SecretTypeSecretDirectory,
+		"secret/denied":  SecretTypeInaccessible,
+		"secret/missing": SecretTypeNonExistent,
+		"secret/broken":  SecretTypeBackendError,
+		"kv/data/live":   SecretTypeSecret,
+		"kv/data/gone":   SecretTypeDeleted,
+	} {
+		if secretType, _ := dir.lookup(context.Background(), path); secretType != expected {
+			t.Errorf("%s: expected secret type %d, got %d", path, expected, secretType)
+		}
+	}
+}
+
+func TestSecretDirReadDirAll(t *testing.T) {
+	root := root(t, newTestFS(t, testBackend()))
+
+	names := readDir(t, root)
+	for _, name := range []string{"app", "dir", "both", "denied", "broken"} {
+		if !contains(names, name) {
+			t.Errorf("root is missing %s: %v", name, names)
+		}
+	}
+
+	if names := readDir(t, lookup(t, root, "dir")); !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Errorf("unexpected entries of a directory: %v", names)
+	}
+	if names := readDir(t, lookup(t, root, "both")); !reflect.DeepEqual(names, []string{selfDirName, "child"}) {
+		t.Errorf("unexpected entries of a secret directory: %v", names)
+	}
+
+	names = readDir(t, lookup(t, root, "app"))
+	for _, name := range []string{"lease_id", "lease_duration", "renewable", "warnings", "data", "auth", "wrap_info", wrapFileName} {
+		if !contains(names, name) {
+			t.Errorf("secret is missing %s: %v", name, names)
+		}
+	}
+	if names := readDir(t, lookup(t, root, "app", "data")); !reflect.DeepEqual(names, []string{"password", "port"}) {
+		t.Errorf("unexpected entries of secret data: %v", names)
+	}
+}
+
+func TestSecretDirRead(t *testing.T) {
+	root := root(t, newTestFS(t, testBackend()))
+
+	if content := readFile(t, lookup(t, root, "app", "data", "password")); content != "hunter2" {
+		t.Errorf("expected hunter2, got %q", content)
+	}
+	if content := readFile(t, lookup(t, root, "app", "data", "port")); content != "5432" {
+		t.Errorf("expected 5432, got %q", content)
+	}
+	if content := readFile(t, lookup(t, root, "dir", "a", "data", "value")); content != "a" {
+		t.Errorf("expected a, got %q", content)
+	}
+	if content := readFile(t, lookup(t, root, "both", selfDirName, "data", "own")); content != "self" {
+		t.Errorf("expected self, got %q", content)
+	}
+}
+
+func TestSecretDirLookupRequests(t *testing.T) {
+	backend := testBackend()
+	dir := lookup(t, root(t, newTestFS(t, backend)), "dir")
+
+	// The listing of dir says that it isn't a secret, and that a is a secret
+	// with no keys beneath it, so each takes one request.
+	reads, lists := backend.Requests(vaulttest.OpRead), backend.Requests(vaulttest.OpList)
+	lookup(t, dir, "a")
+	if reads, lists := backend.Requests(vaulttest.OpRead)-reads, backend.Requests(vaulttest.OpList)-lists; reads != 1 || lists != 1 {
+		t.Errorf("expected a read of the secret and a listing of the directory, got %d reads and %d listings", reads, lists)
+	}
+}
+
+func TestSecretDirMissing(t *testing.T) {
+	backend := testBackend()
+	v := newTestFS(t, backend, WithRoot("kv/data"))
+	root := root(t, v)
+
+	if _, err := lookupErr(root, "new"); err != fuse.ENOENT {
+		t.Fatalf("expected ENOENT, got %v", err)
+	}
+	// Only the root is looked up again.
+	requests := backend.Requests("")
+	if _, err := lookupErr(root, "new"); err != fuse.ENOENT {
+		t.Fatalf("expected ENOENT, got %v", err)
+	}
+	if requests := backend.Requests("") - requests; requests != 2 {
+		t.Errorf("expected the missing secret not to be looked up again, got %d requests", requests)
+	}
+
+	backend.PutKVv2("kv/data/new", map[string]interface{}{"value": "new"})
+	v.invalidate("kv/data/new")
+	if content := readFile(t, lookup(t, root, "new", "data", "data", "value")); content != "new" {
+		t.Errorf("expected the secret once invalidated, got %q", content)
+	}
+}
+
+func TestSecretDirReadsCurrentValue(t *testing.T) {
+	backend := testBackend()
+	root := root(t, newTestFS(t, backend))
+
+	value := lookup(t, root, "app", "data", "password")
+	backend.Put("secret/app", map[string]interface{}{"password": "changed"})
+	if content := readFile(t, value); content != "changed" {
+		t.Errorf("expected the changed value, got %q", content)
+	}
+}
+
+func TestSecretDirValueRefresh(t *testing.T) {
+	backend := testBackend()
+	root := root(t, newTestFS(t, backend))
+	value := lookup(t, root, "app", "data", "password").(*StaticValue)
+
+	// A value whose size changed is opened with direct IO, as the kernel may
+	// hold the old size.
+	backend.Put("secret/app", map[string]interface{}{"password": "a longer password"})
+	resp := &fuse.OpenResponse{}
+	if _, err := value.Open(context.Background(), &fuse.OpenRequest{}, resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Flags&fuse.OpenDirectIO == 0 {
+		t.Error("expected a value which grew to be opened with direct IO")
+	}
+	if size := attr(t, value).Size; size != uint64(len("a longer password")) {
+		t.Errorf("expected the size of the value opened, got %d", size)
+	}
+
+	// Attributes are served while the value is refreshed.
+	backend.SetLatency(time.Second)
+	opened := make(chan struct{})
+	go func() {
+		defer close(opened)
+		value.Open(context.Background(), &fuse.OpenRequest{}, &fuse.OpenResponse{})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	start := time.Now()
+	attr(t, value)
+	if elapsed := time.Since(start); elapsed >= 500*time.Millisecond {
+		t.Errorf("expected Attr not to wait for the refresh, took %v", elapsed)
+	}
+	<-opened
+}
+
+func TestSecretDirDataFormat(t *testing.T) {
+	root := root(t, newTestFS(t, testBackend(), WithFormat(FormatData)))
+
+	names := readDir(t, lookup(t, root, "app"))
+	if !contains(names, "password") || !contains(names, "port") || contains(names, "data") {
+		t.Errorf("unexpected entries of a secret in the data format: %v", names)
+	}
+	if content := readFile(t, lookup(t, root, "app", "password")); content != "hunter2" {
+		t.Errorf("expected hunter2, got %q", content)
+	}
+}
+
+func TestSecretDirErrors(t *testing.T) {
+	root := root(t, newTestFS(t, testBackend()))
+
+	if _, err := lookupErr(root, "missing"); err != fuse.ENOENT {
+		t.Errorf("expected ENOENT looking up a missing secret, got %v", err)
+	}
+	if _, err := lookupErr(root, "app", "missing"); err != fuse.ENOENT {
+		t.Errorf("expected ENOENT looking up a missing key, got %v", err)
+	}
+	if _, err := lookupErr(root, "broken"); err != fuse.EIO {
+		t.Errorf("expected EIO looking up a failing secret, got %v", err)
+	}
+
+	// Inaccessible paths can be traversed, but not listed.
+	denied := lookup(t, root, "denied")
+	if mode := attr(t, denied).Mode; mode != os.ModeDir|0111 {
+		t.Errorf("expected an inaccessible directory to be traversable only, got %v", mode)
+	}
+	if _, err := readDirErr(denied); err != fuse.Errno(syscall.EACCES) {
+		t.Errorf("expected EACCES listing an inaccessible directory, got %v", err)
+	}
+	if content := readFile(t, lookup(t, denied, "hidden", "data", "value")); content != "hidden" {
+		t.Errorf("expected hidden, got %q", content)
+	}
+}
+
+func TestSecretDirKVv2(t *testing.T) {
+	root := root(t, newTestFS(t, testBackend(), WithRoot("kv/data")))
+
+	if content := readFile(t, lookup(t, root, "live", "data", "data", "value")); content != "live" {
+		t.Errorf("expected live, got %q", content)
+	}
+	if names := readDir(t, lookup(t, root, "live")); !contains(names, controlDirName) {
+		t.Errorf("KV v2 secret is missing its control directory: %v", names)
+	}
+	if names := readDir(t, lookup(t, root, "gone")); !reflect.DeepEqual(names, []string{controlDirName}) {
+		t.Errorf("expected only the control directory of a deleted secret, got %v", names)
+	}
+}
+
+func TestSecretDirPathFilters(t *testing.T) {
+	root := root(t, newTestFS(t, testBackend(), WithPathFilters(nil, []string{"secret/app"})))
+
+	if names := readDir(t, root); contains(names, "app") {
+		t.Errorf("excluded secret is listed: %v", names)
+	}
+	if _, err := lookupErr(root, "app"); err != fuse.ENOENT {
+		t.Errorf("expected ENOENT looking up an excluded secret, got %v", err)
+	}
+}
+
+func TestSecretDirDenyList(t *testing.T) {
+	root := root(t, newTestFS(t, testBackend(), WithDenyList([]string{"secret/dir/*"})))
+
+	if names := readDir(t, root); contains(names, "dir") {
+		t.Errorf("denied path is listed: %v", names)
+	}
+	// Denied secrets are refused as by Vault, so are traversable only.
+	if mode := attr(t, lookup(t, root, "dir", "a")).Mode; mode != os.ModeDir|0111 {
+		t.Errorf("expected a denied secret to be inaccessible, got %v", mode)
+	}
+	if content := readFile(t, lookup(t, root, "app", "data", "password")); content != "hunter2" {
+		t.Errorf("expected hunter2, got %q", content)
+	}
+}
+
+func TestSecretDirSizeLimits(t *testing.T) {
+	listing := root(t, newTestFS(t, testBackend(), WithSizeLimits(vaultapi.SizeLimits{MaxEntries: 2})))
+	if _, err := readDirErr(listing); err != fuse.Errno(syscall.EFBIG) {
+		t.Errorf("expected EFBIG listing a directory over the limit, got %v", err)
+	}
+
+	root := root(t, newTestFS(t, testBackend(), WithSizeLimits(vaultapi.SizeLimits{MaxValueSize: 4})))
+	// Secrets over the limit can be looked up, to be removed, but not read.
+	app := lookup(t, root, "app")
+	if _, err := lookupErr(app, "data"); err != fuse.Errno(syscall.EFBIG) {
+		t.Errorf("expected EFBIG reading a value over the limit, got %v", err)
+	}
+	if content := readFile(t, lookup(t, root, "dir", "a", "data", "value")); content != "a" {
+		t.Errorf("expected a, got %q", content)
+	}
+}
+
+func TestSecretDirInterrupt(t *testing.T) {
+	backend := testBackend()
+	r := root(t, newTestFS(t, backend)).(*SecretDir)
+	backend.SetLatency(time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := r.Lookup(ctx, &fuse.LookupRequest{Name: "app"}, &fuse.LookupResponse{}); err != fuse.EINTR {
+		t.Errorf("expected EINTR looking up an interrupted secret, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("expected the interrupted lookup to return at once, took %v", elapsed)
+	}
+
+	// Dynamic reads create leases, so are waited for rather than abandoned.
+	backend.SetLatency(50 * time.Millisecond)
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if secret, err := r.fs.logic(ctx).ReadDynamic("secret/app"); err != nil || secret == nil {
+		t.Errorf("expected an interrupted dynamic read to complete, got %v, %v", secret, err)
+	}
+}
+
+func TestSecretDirReadOnly(t *testing.T) {
+	backend := testBackend()
+	// Filesystems are read-only unless made writable.
+	v, err := New(Options{Vault: api.DefaultConfig(), Backend: backend})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := root(t, v).(*SecretDir)
+
+	if err := r.Remove(context.Background(), &fuse.RemoveRequest{Name: "app", Dir: true}); err != fuse.Errno(syscall.EROFS) {
+		t.Errorf("expected EROFS removing a secret, got %v", err)
+	}
+	if _, err := r.Mkdir(context.Background(), &fuse.MkdirRequest{Name: "new"}); err != fuse.Errno(syscall.EROFS) {
+		t.Errorf("expected EROFS creating a secret, got %v", err)
+	}
+	if secret, _ := backend.Read("secret/app"); secret == nil {
+		t.Error("expected the secret to survive in Vault")
+	}
+	if content := readFile(t, lookup(t, r, "app", "data", "password")); content != "hunter2" {
+		t.Errorf("expected hunter2, got %q", content)
+	}
+}
+
+func TestMountedRead(t *testing.T) {
+	mountpoint := mounted(t, newTestFS(t, testBackend()))
+
+	content, err := ioutil.ReadFile(filepath.Join(mountpoint, "app", "data", "password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "hunter2" {
+		t.Errorf("expected hunter2, got %q", content)
+	}
+	if _, err := os.Stat(filepath.Join(mountpoint, "missing")); !os.IsNotExist(err) {
+		t.Errorf("expected a missing secret not to exist, got %v", err)
+	}
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
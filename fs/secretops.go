@@ -0,0 +1,199 @@
+// Modifying operations on the secret tree, mapping filesystem calls onto
+// writes and deletes in Vault.
+
+package fs
+
+import (
+	"path"
+	"syscall"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/api"
+	"github.com/wrouesnel/vaultfs/vaultapi"
+	"golang.org/x/net/context"
+)
+
+// Statically ensure that *SecretDir implement those interface
+var _ = fs.NodeRemover(&SecretDir{})
+var _ = fs.NodeMkdirer(&SecretDir{})
+var _ = fs.NodeRenamer(&SecretDir{})
+var _ = fs.NodeCreater(&SecretDir{})
+
+// errTooLarge is returned for secrets and directories over the size limits.
+var errTooLarge = fuse.Errno(syscall.EFBIG)
+
+// backendErrno converts an error from Vault into the errno returned to the
+// caller. Requests while Vault is sealed fail with EAGAIN, as they can be
+// retried once it is unsealed, and those refused in read-only mode with
+// EROFS.
+func backendErrno(err error) error {
+	if errwrap.ContainsType(err, vaultapi.ErrPermissionDenied{}) {
+		return fuse.Errno(syscall.EACCES)
+	}
+	if errwrap.ContainsType(err, vaultapi.ErrReadOnly{}) {
+		return fuse.Errno(syscall.EROFS)
+	}
+	if errwrap.ContainsType(err, vaultapi.ErrTooLarge{}) {
+		return errTooLarge
+	}
+	if errwrap.ContainsType(err, vaultapi.ErrInterrupted{}) {
+		return fuse.EINTR
+	}
+	if errwrap.ContainsType(err, vaultapi.ErrSealed{}) {
+		return fuse.Errno(syscall.EAGAIN)
+	}
+	return fuse.EIO
+}
+
+// Remove deletes a secret beneath this directory. Secrets appear as
+// directories, so this is normally reached via rmdir (or rm -r). Directory-like
+// keys only exist while they have children, so are never removed directly. On
+// KV version 2 mounts the delete is a soft-delete of the latest version.
+func (s *SecretDir) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
+	name := req.Name
+	log := s.log(ctx).WithField("name", name)
+	setOpPath(ctx, path.Join(s.lookupPath, name))
+
+	currentSecretType, currentSecret := s.lookup(ctx, s.lookupPath)
+	switch currentSecretType {
+	case SecretTypeBackendError:
+		return s.fs.unavailable(ctx)
+	case SecretTypeTooLarge:
+		return errTooLarge
+	case SecretTypeNonExistent:
+		return fuse.ENOENT
+	case SecretTypeSecret:
+		// Data keys can be removed from writable secrets in the data format,
+		// but otherwise the entries of a secret are fixed.
+		if dataDir := s.writableDataDir(currentSecret); dataDir != nil {
+			return dataDir.Remove(ctx, req)
+		}
+		return fuse.EPERM
+	}
+
+	childLookupPath := path.Join(s.lookupPath, name)
+	childSecretType, _ := s.lookup(ctx, childLookupPath)
+	switch childSecretType {
+	case SecretTypeBackendError:
+		return s.fs.unavailable(ctx)
+	case SecretTypeNonExistent:
+		return fuse.ENOENT
+	case SecretTypeDirectory, SecretTypeSecretDirectory:
+		return fuse.Errno(syscall.ENOTEMPTY)
+	}
+
+	// Inaccessible secrets may still be deletable, so let Vault decide, and
+	// secrets over the size limits can be deleted to be rid of them.
+	_, err := s.fs.logic(ctx).Delete(childLookupPath)
+	s.fs.audit("remove", req.Header, childLookupPath, err)
+	if err != nil {
+		log.WithError(err).Warn("could not delete secret")
+		return backendErrno(err)
+	}
+
+	log.WithField("path", childLookupPath).Info("deleted secret")
+	return nil
+}
+
+// writableDataDir returns the writable data directory which this secret
+// directory presents in the data format, or nil if it is not writable.
+func (s *SecretDir) writableDataDir(secret *api.Secret) *DataDir {
+	if s.fs.format != FormatData || !s.fs.isWritable(s.lookupPath) {
+		return nil
+	}
+	return s.fs.newSecretDataDir(s.lookupPath, secret)
+}
+
+// Create adds a data key to a writable secret in the data format.
+func (s *SecretDir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.CreateResponse) (fs.Node, fs.Handle, error) {
+	setOpPath(ctx, path.Join(s.lookupPath, req.Name))
+
+	currentSecretType, currentSecret := s.lookup(ctx, s.lookupPath)
+	switch currentSecretType {
+	case SecretTypeBackendError:
+		return nil, nil, s.fs.unavailable(ctx)
+	case SecretTypeTooLarge:
+		return nil, nil, errTooLarge
+	case SecretTypeSecret:
+		if dataDir := s.writableDataDir(currentSecret); dataDir != nil {
+			return dataDir.Create(ctx, req, resp)
+		}
+	}
+	return nil, nil, fuse.EPERM
+}
+
+// Mkdir creates an empty placeholder secret beneath this directory, which can
+// then be populated or have further keys created beneath it.
+func (s *SecretDir) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fs.Node, error) {
+	name := req.Name
+	log := s.log(ctx).WithField("name", name)
+
+	childLookupPath := path.Join(s.lookupPath, name)
+	setOpPath(ctx, childLookupPath)
+	childSecretType, _ := s.lookup(ctx, childLookupPath)
+	switch childSecretType {
+	case SecretTypeBackendError:
+		return nil, s.fs.unavailable(ctx)
+	case SecretTypeDirectory, SecretTypeSecret, SecretTypeSecretDirectory, SecretTypeTooLarge:
+		return nil, fuse.EEXIST
+	}
+
+	_, err := s.fs.logic(ctx).Write(childLookupPath, vaultapi.WriteData(childLookupPath, nil))
+	s.fs.audit("mkdir", req.Header, childLookupPath, err)
+	if err != nil {
+		log.WithError(err).Warn("could not create secret")
+		return nil, backendErrno(err)
+	}
+
+	s.fs.forgetMissing(childLookupPath)
+	log.WithField("path", childLookupPath).Info("created secret")
+	return NewSecretDir(s.fs, childLookupPath)
+}
+
+// Rename moves a secret by reading it, writing it to the new path and deleting
+// the original. This is not atomic: a failed delete leaves both copies.
+// Directory-like keys can't be moved as a whole.
+func (s *SecretDir) Rename(ctx context.Context, req *fuse.RenameRequest, newDir fs.Node) error {
+	log := s.log(ctx).WithField("old_name", req.OldName).WithField("new_name", req.NewName)
+
+	target, ok := newDir.(*SecretDir)
+	if !ok {
+		return fuse.EPERM
+	}
+
+	oldPath := path.Join(s.lookupPath, req.OldName)
+	newPath := path.Join(target.lookupPath, req.NewName)
+	setOpPath(ctx, oldPath)
+
+	oldSecretType, secret := s.lookup(ctx, oldPath)
+	switch oldSecretType {
+	case SecretTypeBackendError:
+		return s.fs.unavailable(ctx)
+	case SecretTypeTooLarge:
+		return errTooLarge
+	case SecretTypeNonExistent, SecretTypeDeleted:
+		return fuse.ENOENT
+	case SecretTypeInaccessible, SecretTypeDirectory, SecretTypeSecretDirectory:
+		return fuse.EPERM
+	}
+
+	data := vaultapi.SecretData(secret)
+	_, err := s.fs.logic(ctx).Write(newPath, vaultapi.WriteData(newPath, data))
+	s.fs.audit("write", req.Header, newPath, err)
+	if err != nil {
+		log.WithError(err).Warn("could not write secret to new path")
+		return backendErrno(err)
+	}
+	s.fs.forgetMissing(newPath)
+	_, err = s.fs.logic(ctx).Delete(oldPath)
+	s.fs.audit("remove", req.Header, oldPath, err)
+	if err != nil {
+		log.WithError(err).Warn("could not delete secret from old path")
+		return backendErrno(err)
+	}
+
+	log.WithField("from", oldPath).WithField("to", newPath).Info("moved secret")
+	return nil
+}
@@ -0,0 +1,105 @@
+// The SSH secrets engine issues one-time passwords and signs public keys with
+// its CA. OTPs are read from a file named after the target host, and public
+// keys are signed by writing them to a file.
+
+package fs
+
+import (
+	"path"
+	"strings"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"golang.org/x/net/context"
+)
+
+// sshEngine provides the creds/<role>/<ip> and sign/<role>/ files of an SSH
+// mount.
+type sshEngine struct{}
+
+// lookup implements engine.
+func (sshEngine) lookup(vfs *VaultFS, mount string, relPath string) (fs.Node, error) {
+	listRoles := func(ctx context.Context) ([]fuse.Dirent, error) {
+		return vfs.listKeys(ctx, path.Join(mount, "roles"), fuse.DT_Dir)
+	}
+
+	switch relPath {
+	case "creds":
+		return NewLookupDir(vfs, func(ctx context.Context, role string) (fs.Node, error) {
+			return NewLookupDir(vfs, func(ctx context.Context, ip string) (fs.Node, error) {
+				return vfs.sshOTPFile(mount, role, ip), nil
+			}, nil), nil
+		}, listRoles), nil
+	case "sign":
+		return NewLookupDir(vfs, func(ctx context.Context, role string) (fs.Node, error) {
+			return vfs.sshSignDir(mount, role), nil
+		}, listRoles), nil
+	}
+	return nil, nil
+}
+
+// entries implements engine.
+func (sshEngine) entries(relPath string) []fuse.Dirent {
+	if relPath != "" {
+		return nil
+	}
+	return []fuse.Dirent{
+		{Name: "creds", Type: fuse.DT_Dir},
+		{Name: "sign", Type: fuse.DT_Dir},
+	}
+}
+
+// sshOTPFile returns a file which generates an OTP for logging in to ip with
+// role (once per open).
+func (v *VaultFS) sshOTPFile(mount string, role string, ip string) *DynamicFile {
+	return NewDynamicFile(v, path.Join(mount, "creds", role), false, func(ctx context.Context, req fuse.Header, input []byte) ([]byte, error) {
+		secret, err := v.logic(ctx).Write(path.Join(mount, "creds", role), map[string]interface{}{
+			"ip": ip,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if secret == nil {
+			return nil, fuse.EIO
+		}
+		key, ok := secret.Data["key"].(string)
+		if !ok {
+			return nil, fuse.EIO
+		}
+		return []byte(key + "\n"), nil
+	})
+}
+
+// sshSignDir returns the directory for signing keys with role. Writing a
+// public key to public_key signs it, and the certificate can be read back from
+// the same handle or from signed_key.
+func (v *VaultFS) sshSignDir(mount string, role string) *StaticDir {
+	resultName := path.Join(mount, "sign", role)
+
+	sign := func(ctx context.Context, req fuse.Header, input []byte) ([]byte, error) {
+		secret, err := v.logic(ctx).Write(path.Join(mount, "sign", role), map[string]interface{}{
+			"public_key": strings.TrimSpace(string(input)),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if secret == nil {
+			return nil, fuse.EIO
+		}
+		signedKey, ok := secret.Data["signed_key"].(string)
+		if !ok {
+			return nil, fuse.EIO
+		}
+		output := []byte(strings.TrimSpace(signedKey) + "\n")
+		v.results.set(req.Uid, resultName, output)
+		return output, nil
+	}
+
+	return &StaticDir{
+		fs: v,
+		children: map[string]fs.Node{
+			"public_key": NewDynamicFile(v, path.Join(mount, "sign", role), true, sign),
+			"signed_key": v.results.resultFile(v, resultName),
+		},
+	}
+}
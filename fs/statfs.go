@@ -0,0 +1,45 @@
+// Filesystem statistics, for df and tools which check the filesystem before
+// using it. Vault has no notion of capacity, so the counts reflect what is
+// currently cached, with nominal free space where secrets can be written.
+
+package fs
+
+import (
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"golang.org/x/net/context"
+)
+
+// Statically ensure that *VaultFS implements the given interface
+var _ = fs.FSStatfser(&VaultFS{})
+
+const (
+	statfsBlockSize = 4096
+	// statfsNameLen is the longest name reported, that of most local
+	// filesystems. Vault itself has no limit.
+	statfsNameLen = 255
+	// statfsFree is the number of free blocks and files reported when some
+	// paths are writable, so tools checking for free space don't refuse to
+	// write.
+	statfsFree = 1 << 20
+)
+
+// Statfs reports the filesystem statistics.
+func (v *VaultFS) Statfs(ctx context.Context, req *fuse.StatfsRequest, resp *fuse.StatfsResponse) error {
+	used := uint64(v.CacheStats().Entries)
+
+	var free uint64
+	if len(v.writable) > 0 {
+		free = statfsFree
+	}
+
+	resp.Bsize = statfsBlockSize
+	resp.Frsize = statfsBlockSize
+	resp.Namelen = statfsNameLen
+	resp.Blocks = used + free
+	resp.Bfree = free
+	resp.Bavail = free
+	resp.Files = used + free
+	resp.Ffree = free
+	return nil
+}
@@ -4,30 +4,33 @@
 package fs
 
 import (
+	"encoding/json"
 	"os"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
 	"github.com/go-errors/errors"
-	log "github.com/wrouesnel/go.log"
 	"golang.org/x/net/context"
 )
 
-// Statically ensure that *SecretDir implement those interface
-var _ = fs.HandleReadDirAller(&SecretDir{})
-var _ = fs.NodeStringLookuper(&SecretDir{})
+// Statically ensure that *StaticDir implement those interface
+var _ = fs.HandleReadDirAller(&StaticDir{})
+var _ = fs.NodeRequestLookuper(&StaticDir{})
 
 // StaticDir implements a fuse directory structure with static content.
 type StaticDir struct {
+	fs       *VaultFS           // root filesystem this node is associated with
 	children map[string]fs.Node // Static children of this node
+	meta     secretMeta         // metadata of the secret the tree is from (optional)
 }
 
 // NewStaticDir generates a new static directory tree of arbitrary depth from
-// the supplied map.
-func NewStaticDir(values map[string]interface{}) (*StaticDir, error) {
-	// Validate the provided subdirectory tree (only allowed types are strings
-	// and more maps.
+// the supplied map. Strings become files with their value as content, maps
+// become subdirectories, and any other value becomes a file containing its
+// JSON encoding.
+func NewStaticDir(vfs *VaultFS, values map[string]interface{}) (*StaticDir, error) {
 	newDir := &StaticDir{
+		fs:       vfs,
 		children: make(map[string]fs.Node),
 	}
 
@@ -45,19 +48,27 @@ func NewStaticDir(values map[string]interface{}) (*StaticDir, error) {
 		// Recurse and build the tree
 		switch v := content.(type) {
 		case string:
-			subfile, err := NewValue(v)
+			subfile, err := NewValue(vfs, v)
 			if err != nil {
 				return nil, errors.WrapPrefix(err, "error generating subdirectory tree: %v", 0)
 			}
 			newDir.children[filename] = subfile
 		case map[string]interface{}:
-			subDir, err := NewStaticDir(v)
+			subDir, err := NewStaticDir(vfs, v)
 			if err != nil {
 				return nil, errors.WrapPrefix(err, "error generating subdirectory tree: %v", 0)
 			}
 			newDir.children[filename] = subDir
 		default:
-			return nil, errors.Errorf("invalid type for static directory: %v", v)
+			encoded, err := json.Marshal(v)
+			if err != nil {
+				return nil, errors.WrapPrefix(err, "error encoding value for static directory", 0)
+			}
+			subfile, err := NewValue(vfs, string(encoded))
+			if err != nil {
+				return nil, errors.WrapPrefix(err, "error generating subdirectory tree: %v", 0)
+			}
+			newDir.children[filename] = subfile
 		}
 	}
 
@@ -66,17 +77,23 @@ func NewStaticDir(values map[string]interface{}) (*StaticDir, error) {
 
 // Attr sets attrs on the given fuse.Attr
 func (s *StaticDir) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Valid = s.fs.attrTimeout
 	a.Mode = os.ModeDir | os.FileMode(0555)
-	a.Uid = 0
-	a.Gid = 0
+	a.Uid = s.fs.uid
+	a.Gid = s.fs.gid
+	s.meta.setTimes(a)
 
 	return nil
 }
 
 // Lookup looks up a path
-func (s *StaticDir) Lookup(ctx context.Context, name string) (fs.Node, error) {
-	log := log.WithField("name", name)
-	log.Debugln("handling StaticDir.Lookup")
+func (s *StaticDir) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.LookupResponse) (fs.Node, error) {
+	name := req.Name
+	if s.meta.path != "" {
+		setOpPath(ctx, s.meta.child(name).path)
+	}
+
+	resp.EntryValid = s.fs.entryTimeout
 
 	// Lookup which node in the static list
 	dir, found := s.children[name]
@@ -89,7 +106,9 @@ func (s *StaticDir) Lookup(ctx context.Context, name string) (fs.Node, error) {
 // ReadDirAll enumerates the static content as files if a StaticValue or
 // direcotries if another StaticDir.
 func (s *StaticDir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
-	log.Debugln("handling StaticDir.ReadDirAll call")
+	if s.meta.path != "" {
+		setOpPath(ctx, s.meta.path)
+	}
 
 	dirs := []fuse.Dirent{}
 
@@ -100,13 +119,13 @@ func (s *StaticDir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 				Name: k,
 				Type: fuse.DT_Dir,
 			})
-		case *StaticValue:
+		case *StaticValue, *ControlFile, *DynamicFile:
 			dirs = append(dirs, fuse.Dirent{
 				Name: k,
 				Type: fuse.DT_File,
 			})
 		default:
-			log.Errorln("Unknown filetype in static directory structure!")
+			opLog(ctx).Errorln("Unknown filetype in static directory structure!")
 		}
 	}
 
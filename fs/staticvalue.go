@@ -4,53 +4,167 @@ package fs
 
 import (
 	"os"
+	"sync"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
+	"bazil.org/fuse/fuseutil"
 	"github.com/go-errors/errors"
 	"golang.org/x/net/context"
 )
 
 // Statically ensure that *file implements the given interface
 var _ = fs.HandleReader(&StaticValue{})
+var _ = fs.NodeOpener(&StaticValue{})
+
+// refreshFunc fetches the current content of a StaticValue, as the node it
+// would be looked up as now.
+type refreshFunc func(ctx context.Context) (*StaticValue, error)
 
 // StaticValue implements a node which always serves the same bytes.
 type StaticValue struct {
+	fs      *VaultFS    // root filesystem this node is associated with
+	refresh refreshFunc // refreshes the value on open (optional)
+
+	mu    sync.Mutex
 	value []byte
+	meta  secretMeta // metadata of the secret the value is from (optional)
 }
 
 // NewValue returns a new Value node (a file with static content)
-func NewValue(value string) (*StaticValue, error) {
+func NewValue(fs *VaultFS, value string) (*StaticValue, error) {
 	return &StaticValue{
+		fs:    fs,
 		value: []byte(value),
 	}, nil
 }
 
 // Attr sets attrs on the given fuse.Attr
 func (f *StaticValue) Attr(ctx context.Context, a *fuse.Attr) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	a.Valid = f.fs.attrTimeout
 	a.Mode = os.FileMode(0440)
-	a.Uid = 0
-	a.Gid = 0
-	a.Size = uint64(len(f.value))
+	a.Uid = f.fs.uid
+	a.Gid = f.fs.gid
+	a.Size = f.fs.fileSize(len(f.value))
+	f.meta.setTimes(a)
 
 	return nil
 }
 
+// Open returns a snapshot of the value, so every read of the handle sees the
+// same content even if the secret changes while it is open. Values which can
+// be refreshed are fetched again first (without holding up Attr and Read on
+// the node meanwhile), so each open sees the current value. The kernel may
+// still hold the size of the previous value, so a value whose size changed is
+// opened with direct IO, for reads not to be cut short at the old size.
+func (f *StaticValue) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	var current *StaticValue
+	if f.refresh != nil {
+		var err error
+		current, err = f.refresh(ctx)
+		if err != nil {
+			f.fs.audit("read", req.Header, f.metaPath(), err)
+			opLog(ctx).WithError(err).Warn("could not refresh value")
+			if errno, ok := err.(fuse.Errno); ok {
+				return nil, errno
+			}
+			return nil, backendErrno(err)
+		}
+	}
+
+	f.mu.Lock()
+	resized := false
+	if current != nil {
+		resized = len(current.value) != len(f.value)
+		f.value = current.value
+		f.meta = current.meta
+	}
+	snapshot := &StaticValue{
+		fs:    f.fs,
+		value: f.value,
+		meta:  f.meta,
+	}
+	f.mu.Unlock()
+
+	if snapshot.meta.path != "" {
+		f.fs.audit("read", req.Header, snapshot.meta.path, nil)
+	}
+	if f.fs.directIO || (resized && f.fs.fixedSize == 0) {
+		resp.Flags |= fuse.OpenDirectIO
+	}
+	return snapshot, nil
+}
+
+// metaPath returns the path of the secret the value is from, if known.
+func (f *StaticValue) metaPath() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.meta.path
+}
+
 // Read simply returns the statically stored content of the node.
 func (f *StaticValue) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
-	if uint64(req.Offset) > uint64(len(f.value)) {
-		return errors.New("offset greater than file size")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return readContent(req, resp, f.value)
+}
+
+// readContent answers a read of content, which is the whole content of the
+// file. Values may span many reads (the kernel reads at most 128KB at a time),
+// and reads past the end are EOF, as the reported size may be larger than the
+// content.
+func readContent(req *fuse.ReadRequest, resp *fuse.ReadResponse, content []byte) error {
+	if req.Offset < 0 {
+		return errors.New("negative read offset")
 	}
+	fuseutil.HandleRead(req, resp, content)
+	return nil
+}
 
-	// File empty.
-	if len(f.value) == 0 {
-		resp.Data = resp.Data[:0]
-		return nil
+// setRefresh makes the values of the tree refresh from the tree returned by
+// read when they are opened.
+func (s *StaticDir) setRefresh(read func(ctx context.Context) (*StaticDir, error)) {
+	s.setRefreshKeys(read, nil)
+}
+
+func (s *StaticDir) setRefreshKeys(read func(ctx context.Context) (*StaticDir, error), keys []string) {
+	for name, child := range s.children {
+		childKeys := append(append([]string{}, keys...), name)
+		switch node := child.(type) {
+		case *StaticValue:
+			node.refresh = func(ctx context.Context) (*StaticValue, error) {
+				tree, err := read(ctx)
+				if err != nil {
+					return nil, err
+				}
+				return tree.value(childKeys)
+			}
+		case *StaticDir:
+			node.setRefreshKeys(read, childKeys)
+		}
 	}
+}
 
-	// Just copy the part of the value we wanted and return it.
-	dst := resp.Data[0:req.Size]
-	copiedBytes := copy(dst, f.value[req.Offset:])
-	resp.Data = resp.Data[:copiedBytes]
-	return nil
+// value returns the value at the given path of keys in the tree, or ENOENT if
+// there is none.
+func (s *StaticDir) value(keys []string) (*StaticValue, error) {
+	var node fs.Node = s
+	for _, key := range keys {
+		dir, ok := node.(*StaticDir)
+		if !ok {
+			return nil, fuse.ENOENT
+		}
+		if node, ok = dir.children[key]; !ok {
+			return nil, fuse.ENOENT
+		}
+	}
+	value, ok := node.(*StaticValue)
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+	return value, nil
 }
@@ -0,0 +1,173 @@
+// Metrics sent to a statsd server, for shops without Prometheus: the latency
+// of each FUSE operation served, and the counters of the requests made to
+// Vault and of the cache which /.vaultfs/stats reports.
+
+package fs
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-errors/errors"
+)
+
+// DefaultStatsdInterval is the default interval between reports of the
+// counters to statsd.
+const DefaultStatsdInterval = 10 * time.Second
+
+// statsdPacketSize is the largest packet sent, which fits in the MTU of most
+// networks.
+const statsdPacketSize = 1432
+
+// StatsdConfig configures sending metrics to a statsd server.
+type StatsdConfig struct {
+	Address  string        // host:port of the server, sent to over UDP
+	Prefix   string        // prepended to the name of every metric, e.g. vaultfs.
+	Tags     []string      // DogStatsD tags of every metric, e.g. env:prod (optional)
+	Interval time.Duration // between reports of the counters (default DefaultStatsdInterval)
+}
+
+// statsdClient batches metrics into packets to a statsd server.
+type statsdClient struct {
+	conn     net.Conn
+	prefix   string
+	tags     string // suffix of every metric holding the tags, if any
+	interval time.Duration
+
+	mu   sync.Mutex
+	buf  bytes.Buffer
+	last map[string]uint64 // counters as last reported, to send increments
+}
+
+// SetStatsd sends metrics to the statsd server of config while mounted. Tags
+// are sent in the DogStatsD format, which plain statsd servers don't accept.
+// Must be called before Mount.
+func (v *VaultFS) SetStatsd(config StatsdConfig) error {
+	if config.Address == "" {
+		return errors.New("no statsd address")
+	}
+	conn, err := net.Dial("udp", config.Address)
+	if err != nil {
+		return err
+	}
+	c := &statsdClient{
+		conn:     conn,
+		prefix:   config.Prefix,
+		interval: config.Interval,
+		last:     make(map[string]uint64),
+	}
+	if c.interval <= 0 {
+		c.interval = DefaultStatsdInterval
+	}
+	if len(config.Tags) > 0 {
+		c.tags = "|#" + strings.Join(config.Tags, ",")
+	}
+	v.statsd = c
+	return nil
+}
+
+// add queues a metric, sending the queued metrics if a packet is full.
+func (c *statsdClient) add(name string, value string, kind string) {
+	line := fmt.Sprintf("%s%s:%s|%s%s", c.prefix, name, value, kind, c.tags)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.buf.Len() > 0 && c.buf.Len()+1+len(line) > statsdPacketSize {
+		c.flushLocked()
+	}
+	if c.buf.Len() > 0 {
+		c.buf.WriteByte('\n')
+	}
+	c.buf.WriteString(line)
+}
+
+// timing queues the duration of an operation.
+func (c *statsdClient) timing(name string, d time.Duration) {
+	c.add(name, fmt.Sprintf("%.3f", d.Seconds()*1000), "ms")
+}
+
+// count queues the increment of the cumulative counter name since it was last
+// reported.
+func (c *statsdClient) count(name string, total uint64) {
+	c.mu.Lock()
+	increment := total - c.last[name]
+	c.last[name] = total
+	c.mu.Unlock()
+	if increment > 0 {
+		c.add(name, fmt.Sprintf("%d", increment), "c")
+	}
+}
+
+// gauge queues the current value of name.
+func (c *statsdClient) gauge(name string, value int) {
+	c.add(name, fmt.Sprintf("%d", value), "g")
+}
+
+// flush sends the queued metrics.
+func (c *statsdClient) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flushLocked()
+}
+
+func (c *statsdClient) flushLocked() {
+	if c.buf.Len() == 0 {
+		return
+	}
+	// Metrics are best effort: a server which isn't listening loses them.
+	c.conn.Write(c.buf.Bytes())
+	c.buf.Reset()
+}
+
+// observeOp records an operation served, and whether it failed.
+func (c *statsdClient) observeOp(name string, elapsed time.Duration, failed bool) {
+	name = "fuse." + strings.ToLower(name)
+	c.timing(name, elapsed)
+	if failed {
+		c.add(name+".errors", "1", "c")
+	}
+}
+
+// reportStats sends the counters to statsd every interval until unmounted.
+func (v *VaultFS) reportStats() {
+	ticker := time.NewTicker(v.statsd.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-v.stopping:
+			v.sendStats()
+			return
+		case <-ticker.C:
+			v.sendStats()
+		}
+	}
+}
+
+// sendStats sends the request and cache counters to statsd, with the
+// operations queued since the last report.
+func (v *VaultFS) sendStats() {
+	c := v.statsd
+
+	requests := v.RequestStats()
+	for method, count := range requests.Requests {
+		c.count("vault.requests."+strings.ToLower(method), count)
+	}
+	c.count("vault.errors", requests.Errors)
+
+	if v.cache != nil {
+		cache := v.CacheStats()
+		c.count("cache.hits", cache.Hits)
+		c.count("cache.misses", cache.Misses)
+		c.count("cache.stale", cache.Stale)
+		c.count("cache.negative", cache.Negative)
+		c.count("cache.evictions", cache.Evictions)
+		c.count("cache.revalidations", cache.Revalidations)
+		c.count("cache.unchanged", cache.Unchanged)
+		c.gauge("cache.entries", cache.Entries)
+	}
+	c.flush()
+}
@@ -0,0 +1,69 @@
+package fs
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wrouesnel/vaultfs/vaultapi"
+	"github.com/wrouesnel/vaultfs/vaultapi/vaulttest"
+)
+
+func TestStatsd(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	backend := vaulttest.NewLogical()
+	backend.Put("secret/app", map[string]interface{}{"password": "hunter2"})
+	v := newTestFS(t, backend, WithCache(vaultapi.CacheConfig{TTL: time.Hour}))
+	if err := v.SetStatsd(StatsdConfig{
+		Address: server.LocalAddr().String(),
+		Prefix:  "vaultfs.",
+		Tags:    []string{"env:test"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if content := readFile(t, lookup(t, root(t, v), "app", "data", "password")); content != "hunter2" {
+			t.Fatalf("expected hunter2, got %q", content)
+		}
+	}
+	v.statsd.observeOp("Lookup", 1500*time.Microsecond, true)
+	v.sendStats()
+
+	metrics := readStatsd(t, server)
+	for _, expected := range []string{
+		"vaultfs.fuse.lookup:1.500|ms|#env:test",
+		"vaultfs.fuse.lookup.errors:1|c|#env:test",
+		"vaultfs.cache.hits:",
+		"vaultfs.cache.misses:",
+		"vaultfs.cache.entries:",
+	} {
+		if !strings.Contains(metrics, expected) {
+			t.Errorf("expected %s in:\n%s", expected, metrics)
+		}
+	}
+
+	// Only increments are sent, so unchanged counters aren't sent again.
+	v.sendStats()
+	if metrics := readStatsd(t, server); strings.Contains(metrics, "cache.misses") {
+		t.Errorf("expected no unchanged counters, got:\n%s", metrics)
+	}
+}
+
+// readStatsd returns the content of the next packet received by server.
+func readStatsd(t *testing.T, server net.PacketConn) string {
+	t.Helper()
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, statsdPacketSize)
+	n, _, err := server.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
@@ -0,0 +1,112 @@
+// The /.vaultfs/ directory reports the state of the mount itself, so health
+// checks can be done with cat from wherever the mount is visible.
+
+package fs
+
+import (
+	"fmt"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"golang.org/x/net/context"
+)
+
+// statusDirName is the root directory describing the mount.
+const statusDirName = ".vaultfs"
+
+// Entries of /.vaultfs/.
+const (
+	statusName = "status"
+	statsName  = "stats"
+	flushName  = "flush"
+)
+
+// statusUnknown is reported for state which couldn't be read from Vault.
+const statusUnknown = "unknown"
+
+// statusDir returns the /.vaultfs/ directory.
+func (v *VaultFS) statusDir() *LookupDir {
+	lookup := func(ctx context.Context, name string) (fs.Node, error) {
+		switch name {
+		case statusName:
+			return NewStaticDir(v, v.status(ctx))
+		case statsName:
+			return NewStaticDir(v, v.stats())
+		case flushName:
+			return NewControlFile(v, statusDirName+"/"+flushName, func(ctx context.Context, content string) error {
+				v.flushCache()
+				return nil
+			}), nil
+		}
+		return nil, nil
+	}
+
+	list := func(ctx context.Context) ([]fuse.Dirent, error) {
+		return []fuse.Dirent{
+			{Name: statusName, Type: fuse.DT_Dir},
+			{Name: statsName, Type: fuse.DT_Dir},
+			{Name: flushName, Type: fuse.DT_File},
+		}, nil
+	}
+
+	return NewLookupDir(v, lookup, list)
+}
+
+// status returns the files of /.vaultfs/status/. It never fails: state which
+// can't be read is reported as unknown, so the files can always be checked.
+func (v *VaultFS) status(ctx context.Context) map[string]interface{} {
+	values := map[string]interface{}{
+		"sealed":          statusUnknown,
+		"token_ttl":       statusUnknown,
+		"last_error":      "",
+		"last_error_time": "",
+	}
+
+	if body, err := v.authBackend(ctx).ReadRaw("sys/seal-status", nil); err == nil {
+		if sealed, ok := body["sealed"].(bool); ok {
+			values["sealed"] = fmt.Sprintf("%t", sealed)
+		}
+	} else {
+		v.log().WithError(err).Debug("could not read seal status")
+	}
+	values["state"] = "ok"
+	if v.isSealed() {
+		values["state"] = "sealed, requests fail with EAGAIN until vault is unsealed"
+	}
+
+	if secret, err := v.logic(ctx).ReadDynamic("auth/token/lookup-self"); err == nil && secret != nil {
+		if ttl, found := secret.Data["ttl"]; found {
+			values["token_ttl"] = fmt.Sprintf("%v", ttl)
+		}
+	} else if err != nil {
+		v.log().WithError(err).Debug("could not look up token")
+	}
+
+	stats := v.RequestStats()
+	if stats.LastError != "" {
+		values["last_error"] = stats.LastError
+		values["last_error_time"] = stats.LastErrorTime.Format(time.RFC3339)
+	}
+	return values
+}
+
+// stats returns the files of /.vaultfs/stats/.
+func (v *VaultFS) stats() map[string]interface{} {
+	values := make(map[string]interface{})
+
+	requests := v.RequestStats()
+	for method, count := range requests.Requests {
+		values["requests_"+method] = fmt.Sprintf("%d", count)
+	}
+	values["request_errors"] = fmt.Sprintf("%d", requests.Errors)
+
+	cache := v.CacheStats()
+	values["cache_hits"] = fmt.Sprintf("%d", cache.Hits)
+	values["cache_misses"] = fmt.Sprintf("%d", cache.Misses)
+	values["cache_stale"] = fmt.Sprintf("%d", cache.Stale)
+	values["cache_negative"] = fmt.Sprintf("%d", cache.Negative)
+	values["cache_evictions"] = fmt.Sprintf("%d", cache.Evictions)
+	values["cache_entries"] = fmt.Sprintf("%d", cache.Entries)
+	return values
+}
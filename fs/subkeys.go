@@ -0,0 +1,70 @@
+// Listing KV version 2 secrets through the subkeys endpoint, which returns
+// the names of their keys without their values.
+
+package fs
+
+import (
+	"bazil.org/fuse"
+	"github.com/wrouesnel/vaultfs/vaultapi"
+	"golang.org/x/net/context"
+)
+
+// SetKVSubkeys makes listing KV version 2 secrets in the data format read the
+// names of their keys from the subkeys endpoint (Vault 1.10 and later),
+// rather than reading their values. Values are still read when the keys are
+// looked up.
+func (v *VaultFS) SetKVSubkeys(subkeys bool) {
+	v.kvSubkeys = subkeys
+}
+
+// readDirAllSubkeys lists this secret from its subkeys, if it is a KV
+// version 2 secret which can be. It returns false to list it from the secret
+// itself otherwise, including if it is also directory-like.
+func (s *SecretDir) readDirAllSubkeys(ctx context.Context) ([]fuse.Dirent, bool) {
+	if !s.fs.kvSubkeys || s.fs.format != FormatData || s.fixed != nil || !s.fs.pathVisible(s.lookupPath) {
+		return nil, false
+	}
+	subkeysPath, ok := vaultapi.KVv2Path(s.lookupPath, "subkeys")
+	if !ok {
+		return nil, false
+	}
+
+	log := s.log(ctx).WithField("path", subkeysPath)
+	secret, err := s.fs.logic(ctx).Read(subkeysPath)
+	if err != nil || secret == nil {
+		log.WithError(err).Debug("could not read subkeys")
+		return nil, false
+	}
+	subkeys, ok := secret.Data["subkeys"].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	if !s.secretOnly {
+		if dirSecret, err := s.fs.logic(ctx).List(s.lookupPath); err != nil || dirSecret != nil {
+			return nil, false
+		}
+	}
+
+	// The subkeys have the shape of the data, with null values, so render
+	// the same entries. Unwritable secrets are presented as read, with the
+	// data beneath their metadata.
+	data := subkeys
+	if !s.fs.isWritable(s.lookupPath) {
+		data = map[string]interface{}{
+			"data":     subkeys,
+			"metadata": secret.Data["metadata"],
+		}
+	}
+	dataDir, err := NewStaticDir(s.fs, data)
+	if err != nil {
+		log.WithError(err).Debug("could not render subkeys")
+		return nil, false
+	}
+	dirs, err := dataDir.ReadDirAll(ctx)
+	if err != nil {
+		return nil, false
+	}
+
+	log.Debug("listed secret from its subkeys")
+	return append(dirs, s.secretFileEntries(true)...), true
+}
@@ -0,0 +1,70 @@
+// The /.sys/ tree exposes read-only cluster state from Vault's sys/ endpoints,
+// read afresh whenever an entry is looked up.
+
+package fs
+
+import (
+	"net/url"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"golang.org/x/net/context"
+)
+
+// sysDirName is the root directory holding cluster state.
+const sysDirName = ".sys"
+
+// sysEndpoint is a sys/ endpoint exposed under /.sys/.
+type sysEndpoint struct {
+	path   string
+	params url.Values
+}
+
+// sysEndpoints are the entries of /.sys/ by name.
+var sysEndpoints = map[string]sysEndpoint{
+	// Report the status of every node as success, so standbys and sealed
+	// nodes can be inspected too.
+	"health": {"sys/health", url.Values{
+		"standbyok":     {"true"},
+		"perfstandbyok": {"true"},
+		"sealedcode":    {"200"},
+		"uninitcode":    {"200"},
+	}},
+	"seal-status": {"sys/seal-status", nil},
+	"mounts":      {"sys/mounts", nil},
+	"policies":    {"sys/policy", nil},
+	"audit":       {"sys/audit", nil},
+}
+
+// sysDir returns the /.sys/ directory.
+func (v *VaultFS) sysDir() *LookupDir {
+	lookup := func(ctx context.Context, name string) (fs.Node, error) {
+		endpoint, found := sysEndpoints[name]
+		if !found {
+			return nil, nil
+		}
+
+		body, err := v.authBackend(ctx).ReadRaw(endpoint.path, endpoint.params)
+		if err != nil {
+			v.log().WithError(err).WithField("path", endpoint.path).Warn("could not read sys endpoint")
+			return nil, backendErrno(err)
+		}
+
+		// Newer Vault versions duplicate the response under data beside
+		// request metadata.
+		if data, ok := body["data"].(map[string]interface{}); ok {
+			body = data
+		}
+		return NewStaticDir(v, body)
+	}
+
+	list := func(ctx context.Context) ([]fuse.Dirent, error) {
+		dirs := []fuse.Dirent{}
+		for name := range sysEndpoints {
+			dirs = append(dirs, fuse.Dirent{Name: name, Type: fuse.DT_Dir})
+		}
+		return dirs, nil
+	}
+
+	return NewLookupDir(v, lookup, list)
+}
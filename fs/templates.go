@@ -0,0 +1,117 @@
+// Virtual files rendered from Go templates, consul-template style, so
+// applications can read composite configuration files (e.g. a database.yml
+// holding credentials from several secrets) straight from the mount.
+
+package fs
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/go-errors/errors"
+	"github.com/wrouesnel/vaultfs/vaultapi"
+	"golang.org/x/net/context"
+)
+
+// templatesDirName is the directory at the root of the mount holding the
+// rendered templates.
+const templatesDirName = ".templates"
+
+// SetTemplates sets the templates rendered under templatesDirName, keyed by
+// their path within it. Templates can use the function `secret "path" "key"`
+// to insert a value from Vault.
+func (v *VaultFS) SetTemplates(templates map[string]string) error {
+	if len(templates) == 0 {
+		v.templates = nil
+		return nil
+	}
+
+	root := &StaticDir{fs: v, children: make(map[string]fs.Node)}
+	for name, text := range templates {
+		cleaned := path.Clean(strings.Trim(name, "/"))
+		if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+			return errors.Errorf("invalid template path: %q", name)
+		}
+
+		// The secret function is bound to the caller for each render, in
+		// templateFile.
+		tmpl, err := template.New(cleaned).Option("missingkey=error").Funcs(template.FuncMap{
+			"secret": func(string, string) (string, error) { return "", nil },
+		}).Parse(text)
+		if err != nil {
+			return errors.WrapPrefix(err, fmt.Sprintf("invalid template %s", name), 0)
+		}
+
+		// Create the directories leading to the template.
+		dir := root
+		parts := strings.Split(cleaned, "/")
+		for _, part := range parts[:len(parts)-1] {
+			child, found := dir.children[part]
+			if !found {
+				child = &StaticDir{fs: v, children: make(map[string]fs.Node)}
+				dir.children[part] = child
+			}
+			subDir, ok := child.(*StaticDir)
+			if !ok {
+				return errors.Errorf("template path collides with another template: %q", name)
+			}
+			dir = subDir
+		}
+		if _, found := dir.children[parts[len(parts)-1]]; found {
+			return errors.Errorf("template path collides with another template: %q", name)
+		}
+		dir.children[parts[len(parts)-1]] = v.templateFile(tmpl)
+	}
+
+	v.templates = root
+	return nil
+}
+
+// templateFile returns a file which renders tmpl each time it is opened.
+func (v *VaultFS) templateFile(tmpl *template.Template) *DynamicFile {
+	return NewDynamicFile(v, path.Join(templatesDirName, tmpl.Name()), false, func(ctx context.Context, req fuse.Header, input []byte) ([]byte, error) {
+		// Values are read as the user the template is rendered for.
+		render, err := tmpl.Clone()
+		if err != nil {
+			return nil, err
+		}
+		render.Funcs(template.FuncMap{
+			"secret": func(secretPath string, key string) (string, error) {
+				return v.templateSecret(ctx, secretPath, key)
+			},
+		})
+
+		var rendered bytes.Buffer
+		if err := render.Execute(&rendered, nil); err != nil {
+			v.logger.WithField("template", tmpl.Name()).WithError(err).Warn("could not render template")
+			return nil, fuse.EIO
+		}
+		return rendered.Bytes(), nil
+	})
+}
+
+// templateSecret implements the secret template function, returning the value
+// of key in the secret at secretPath.
+func (v *VaultFS) templateSecret(ctx context.Context, secretPath string, key string) (string, error) {
+	secret, err := v.logic(ctx).Read(secretPath)
+	if err != nil {
+		return "", err
+	}
+	if secret == nil {
+		return "", errors.Errorf("secret not found: %s", secretPath)
+	}
+
+	value, found := vaultapi.SecretData(secret)[key]
+	if !found {
+		return "", errors.Errorf("secret %s has no key %s", secretPath, key)
+	}
+	if s, ok := value.(string); ok {
+		return v.decodeBase64Value(secretPath, key, s), nil
+	}
+	return fmt.Sprintf("%v", value), nil
+}
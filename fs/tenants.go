@@ -0,0 +1,240 @@
+// Multi-tenant mode, in which each request is made with a Vault token of the
+// user making it, so a shared mount enforces every user's own policies rather
+// than those of the mount's token.
+
+package fs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/go-errors/errors"
+	"github.com/hashicorp/vault/api"
+	"github.com/wrouesnel/vaultfs/vaultapi"
+	"golang.org/x/net/context"
+)
+
+// callerKey is the context key for the header of the request being served.
+type callerKey struct{}
+
+// requestContext adds the header of req to the context of serving it, so the
+// caller can be identified.
+func requestContext(ctx context.Context, req fuse.Request) context.Context {
+	return context.WithValue(ctx, callerKey{}, *req.Hdr())
+}
+
+// CallerContext returns the context of serving a request made by uid and gid
+// other than through FUSE, such as over NFS.
+func CallerContext(ctx context.Context, uid uint32, gid uint32) context.Context {
+	return context.WithValue(ctx, callerKey{}, fuse.Header{Uid: uid, Gid: gid})
+}
+
+// caller returns the header of the request being served with ctx, if any.
+func caller(ctx context.Context) (fuse.Header, bool) {
+	header, ok := ctx.Value(callerKey{}).(fuse.Header)
+	return header, ok
+}
+
+// tenant holds the backend used for a user.
+type tenant struct {
+	token   string
+	modTime time.Time // of the token file the token was read from
+	backend vaultapi.AuthableLogical
+	logical vaultapi.Logical
+	cache   *vaultapi.CachedLogical // nil if caching is disabled
+	renewer *vaultapi.TokenRenewer
+}
+
+// close stops renewing the tenant's token and zeroes its cached secrets, once
+// it is no longer used.
+func (t *tenant) close() {
+	t.renewer.Stop()
+	if t.cache != nil {
+		t.cache.Flush()
+	}
+}
+
+// tenantStore holds the backends of the users of the mount.
+type tenantStore struct {
+	tokenDir string
+
+	mu      sync.Mutex
+	tenants map[uint32]*tenant
+}
+
+// SetTenantTokenDir enables multi-tenant mode, in which requests are made with
+// the token of the requesting user, read from the file named by their uid in
+// tokenDir. The file must belong to the user and be accessible to no one else.
+// Users without a token are denied access. Must be called before Mount.
+func (v *VaultFS) SetTenantTokenDir(tokenDir string) {
+	v.tenants = &tenantStore{
+		tokenDir: tokenDir,
+		tenants:  make(map[uint32]*tenant),
+	}
+}
+
+// checkTokenFile returns an error unless info is of a regular file belonging
+// to uid which no one else can access, so that no other user can have planted
+// the token in it or read it.
+func checkTokenFile(info os.FileInfo, uid uint32) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !info.Mode().IsRegular() || !ok || stat.Uid != uid || info.Mode().Perm()&0077 != 0 {
+		return errors.Errorf("token file for uid %d must be a file of theirs with mode 0600", uid)
+	}
+	return nil
+}
+
+// tenant returns the backend to use for requests by uid. The token file is
+// checked for changes on every call, so tokens can be replaced while mounted.
+// A new backend is made without holding the store's lock, so that logging in
+// for one user doesn't hold up the others.
+func (v *VaultFS) tenant(uid uint32) (*tenant, error) {
+	tokenPath := filepath.Join(v.tenants.tokenDir, fmt.Sprintf("%d", uid))
+	info, err := os.Lstat(tokenPath)
+	if err != nil {
+		return nil, errors.Errorf("no token for uid %d", uid)
+	}
+	if err := checkTokenFile(info, uid); err != nil {
+		return nil, err
+	}
+
+	v.tenants.mu.Lock()
+	t, found := v.tenants.tenants[uid]
+	v.tenants.mu.Unlock()
+	if found && t.modTime.Equal(info.ModTime()) {
+		return t, nil
+	}
+
+	t, err = v.newTenant(uid, tokenPath)
+	if err != nil {
+		return nil, err
+	}
+
+	v.tenants.mu.Lock()
+	current, found := v.tenants.tenants[uid]
+	if found && !current.modTime.Before(t.modTime) {
+		// Another request loaded the token meanwhile.
+		v.tenants.mu.Unlock()
+		t.close()
+		return current, nil
+	}
+	v.tenants.tenants[uid] = t
+	v.tenants.mu.Unlock()
+
+	if found {
+		current.close()
+	}
+	v.logger.WithField("uid", uid).Info("loaded tenant token")
+	return t, nil
+}
+
+// newTenant makes the backend of uid from the token in tokenPath.
+func (v *VaultFS) newTenant(uid uint32, tokenPath string) (*tenant, error) {
+	f, err := os.OpenFile(tokenPath, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return nil, errors.Errorf("could not read token for uid %d: %v", uid, err)
+	}
+	defer f.Close()
+	// The file may have been replaced since it was checked.
+	info, err := f.Stat()
+	if err != nil {
+		return nil, errors.Errorf("could not read token for uid %d: %v", uid, err)
+	}
+	if err := checkTokenFile(info, uid); err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, errors.Errorf("could not read token for uid %d: %v", uid, err)
+	}
+	token := strings.TrimSpace(string(content))
+	if token == "" {
+		return nil, errors.Errorf("empty token for uid %d", uid)
+	}
+
+	backend, err := NewBackend(v.tenantConfig(), Auth{Token: token})
+	if err != nil {
+		return nil, err
+	}
+	t := &tenant{
+		token:   token,
+		modTime: info.ModTime(),
+	}
+	if t.backend, t.logical, err = v.layers(backend); err != nil {
+		return nil, err
+	}
+	// Cached responses must never be shared between users.
+	if v.cacheConfig.Enabled() {
+		t.cache = vaultapi.NewCachedLogical(t.logical, v.cacheConfig)
+		t.logical = t.cache
+	}
+	t.renewer = vaultapi.NewTokenRenewer(t.backend, nil)
+	t.renewer.Start()
+	return t, nil
+}
+
+// tenantConfig returns the Vault config of a tenant's client: the mount's,
+// with a transport of its own, since the api package sets up the transport of
+// every client made with it and fails if it already has been.
+func (v *VaultFS) tenantConfig() *api.Config {
+	config := v.vaultConfig()
+	tenantConfig := &api.Config{
+		Address:    config.Address,
+		HttpClient: config.HttpClient,
+		MaxRetries: config.MaxRetries,
+		Timeout:    config.Timeout,
+	}
+	if config.HttpClient == nil {
+		return tenantConfig
+	}
+	if transport, ok := config.HttpClient.Transport.(*http.Transport); ok {
+		transport = transport.Clone()
+		transport.TLSNextProto = nil
+		client := *config.HttpClient
+		client.Transport = transport
+		tenantConfig.HttpClient = &client
+	}
+	return tenantConfig
+}
+
+// dropTenants closes the backends of every tenant, so that their tokens are
+// read afresh when next used.
+func (v *VaultFS) dropTenants() {
+	v.tenants.mu.Lock()
+	tenants := v.tenants.tenants
+	v.tenants.tenants = make(map[uint32]*tenant)
+	v.tenants.mu.Unlock()
+
+	for _, t := range tenants {
+		t.close()
+	}
+}
+
+// tenantFor returns the tenant making the request being served with ctx, or
+// an error if there is none. It must only be called in multi-tenant mode.
+func (v *VaultFS) tenantFor(ctx context.Context) (*tenant, error) {
+	header, ok := caller(ctx)
+	if !ok {
+		return nil, errors.New("request has no caller")
+	}
+	return v.tenant(header.Uid)
+}
+
+// invalidateTenants drops cached responses for path from every tenant's cache.
+func (v *VaultFS) invalidateTenants(path string) {
+	v.tenants.mu.Lock()
+	defer v.tenants.mu.Unlock()
+	for _, t := range v.tenants.tenants {
+		if t.cache != nil {
+			t.cache.Invalidate(path)
+		}
+	}
+}
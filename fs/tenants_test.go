@@ -0,0 +1,125 @@
+package fs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/hashicorp/vault/api"
+	"github.com/wrouesnel/vaultfs/vaultapi"
+	"github.com/wrouesnel/vaultfs/vaultapi/vaulttest"
+	"golang.org/x/net/context"
+)
+
+func TestTenantLayers(t *testing.T) {
+	backend := testBackend()
+	server := vaulttest.NewServer(backend)
+	defer server.Close()
+
+	tokenDir, err := ioutil.TempDir("", "vaultfs-tenants")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tokenDir)
+	uid := uint32(os.Getuid())
+	if err := ioutil.WriteFile(filepath.Join(tokenDir, fmt.Sprint(uid)), []byte(vaulttest.Token), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := New(Options{
+		Vault:   &api.Config{Address: server.URL},
+		Backend: backend,
+		Limits:  vaultapi.LimitConfig{Default: vaultapi.QoSClass{MaxConcurrent: 1}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.SetTenantTokenDir(tokenDir)
+	r := root(t, v).(*SecretDir)
+	ctx := CallerContext(context.Background(), uid, uid)
+
+	// Tenants' requests go through the mount's journal and limits.
+	before := v.RequestStats().Requests[vaultapi.JournalRead]
+	if _, err := r.Lookup(ctx, &fuse.LookupRequest{Name: "app"}, &fuse.LookupResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if after := v.RequestStats().Requests[vaultapi.JournalRead]; after <= before {
+		t.Errorf("expected the tenant's reads to be journalled, got %d before and %d after", before, after)
+	}
+	if err := r.Remove(ctx, &fuse.RemoveRequest{Name: "app", Dir: true}); err != fuse.Errno(syscall.EROFS) {
+		t.Errorf("expected EROFS removing a secret as a tenant, got %v", err)
+	}
+}
+
+func TestTenantTokens(t *testing.T) {
+	backend := testBackend()
+	server := vaulttest.NewServer(backend)
+	defer server.Close()
+
+	tokenDir, err := ioutil.TempDir("", "vaultfs-tenants")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tokenDir)
+	uid := uint32(os.Getuid())
+	tokenPath := filepath.Join(tokenDir, fmt.Sprint(uid))
+	if err := ioutil.WriteFile(tokenPath, []byte(vaulttest.Token), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := New(Options{
+		Vault:   &api.Config{Address: server.URL},
+		Backend: backend,
+		Cache:   vaultapi.CacheConfig{TTL: time.Minute},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.SetTenantTokenDir(tokenDir)
+	defer v.stop()
+	r := root(t, v).(*SecretDir)
+	ctx := CallerContext(context.Background(), uid, uid)
+
+	if _, err := r.Lookup(ctx, &fuse.LookupRequest{Name: "app"}, &fuse.LookupResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	first, err := v.tenant(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.cache.Stats().Entries == 0 {
+		t.Fatal("expected the tenant's reads to be cached")
+	}
+
+	// A replaced tenant's cached secrets are dropped.
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(tokenPath, later, later); err != nil {
+		t.Fatal(err)
+	}
+	second, err := v.tenant(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second == first {
+		t.Error("expected a changed token file to replace the tenant")
+	}
+	if entries := first.cache.Stats().Entries; entries != 0 {
+		t.Errorf("expected the replaced tenant's cache to be flushed, got %d entries", entries)
+	}
+
+	// Token files others can read are refused.
+	if err := os.Chmod(tokenPath, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.tenant(uid); err == nil {
+		t.Error("expected a token file readable by others to be refused")
+	}
+	if secretType, _ := r.lookup(ctx, "secret/app"); secretType != SecretTypeInaccessible {
+		t.Errorf("expected a secret to be inaccessible with a token file readable by others, got type %d", secretType)
+	}
+}
@@ -0,0 +1,48 @@
+// The /.token/ directory shows the identity the mount is using, from
+// auth/token/lookup-self.
+
+package fs
+
+import (
+	"fmt"
+	"strings"
+
+	"bazil.org/fuse"
+	"golang.org/x/net/context"
+)
+
+// tokenDirName is the root directory describing the current token.
+const tokenDirName = ".token"
+
+// tokenFields are the lookup-self fields exposed in /.token/.
+var tokenFields = []string{"display_name", "policies", "ttl", "accessor", "expire_time"}
+
+// tokenDir returns the /.token/ directory, read afresh on every lookup.
+func (v *VaultFS) tokenDir(ctx context.Context) (*StaticDir, error) {
+	secret, err := v.logic(ctx).ReadDynamic("auth/token/lookup-self")
+	if err != nil {
+		v.log().WithError(err).Warn("could not look up token")
+		return nil, backendErrno(err)
+	}
+	if secret == nil {
+		return nil, fuse.ENOENT
+	}
+
+	values := make(map[string]interface{})
+	for _, field := range tokenFields {
+		switch value := secret.Data[field].(type) {
+		case nil:
+			// Tokens without an expiry have no expire_time.
+			values[field] = ""
+		case []interface{}:
+			lines := []string{}
+			for _, item := range value {
+				lines = append(lines, fmt.Sprintf("%v", item))
+			}
+			values[field] = strings.Join(lines, "\n")
+		default:
+			values[field] = fmt.Sprintf("%v", value)
+		}
+	}
+	return NewStaticDir(v, values)
+}
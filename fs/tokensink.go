@@ -0,0 +1,35 @@
+// The token sink shares the token obtained by the filesystem with other
+// local tooling by writing it to a file.
+
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// writeTokenSink atomically replaces the file at sinkPath with token. The
+// file is only ever readable by the owner.
+func writeTokenSink(sinkPath string, token string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(sinkPath), "."+filepath.Base(sinkPath))
+	if err != nil {
+		return err
+	}
+	// TempFile creates with 0600, but be explicit about the guarantee.
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if _, err := tmp.WriteString(token); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), sinkPath)
+}
@@ -0,0 +1,56 @@
+// The TOTP secrets engine generates time-based one-time passwords. The current
+// code of each key is exposed as a file.
+
+package fs
+
+import (
+	"path"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"golang.org/x/net/context"
+)
+
+// totpEngine provides the code/<key> files of a TOTP mount.
+type totpEngine struct{}
+
+// lookup implements engine.
+func (totpEngine) lookup(vfs *VaultFS, mount string, relPath string) (fs.Node, error) {
+	if relPath != "code" {
+		return nil, nil
+	}
+
+	codeFile := func(ctx context.Context, key string) (fs.Node, error) {
+		return NewDynamicFile(vfs, path.Join(mount, "code", key), false, func(ctx context.Context, req fuse.Header, input []byte) ([]byte, error) {
+			// Codes change every period, so are never cached.
+			secret, err := vfs.logic(ctx).ReadDynamic(path.Join(mount, "code", key))
+			if err != nil {
+				return nil, err
+			}
+			if secret == nil {
+				return nil, fuse.ENOENT
+			}
+			code, ok := secret.Data["code"].(string)
+			if !ok {
+				return nil, fuse.EIO
+			}
+			return []byte(code + "\n"), nil
+		}), nil
+	}
+
+	listKeys := func(ctx context.Context) ([]fuse.Dirent, error) {
+		return vfs.listKeys(ctx, path.Join(mount, "keys"), fuse.DT_File)
+	}
+
+	return NewLookupDir(vfs, codeFile, listKeys), nil
+}
+
+// entries implements engine.
+func (totpEngine) entries(relPath string) []fuse.Dirent {
+	if relPath != "" {
+		return nil
+	}
+	return []fuse.Dirent{
+		{Name: "code", Type: fuse.DT_Dir},
+	}
+}
@@ -0,0 +1,87 @@
+// The transit secrets engine encrypts and decrypts data with named keys. Each
+// key is presented as a directory of files to write data to and read the
+// result back from.
+
+package fs
+
+import (
+	"encoding/base64"
+	"path"
+	"strings"
+	"syscall"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"golang.org/x/net/context"
+)
+
+// transitEngine provides the keys/<key>/ directories of a transit mount.
+type transitEngine struct{}
+
+// lookup implements engine. Writing plaintext to keys/<key>/encrypt makes the
+// ciphertext readable from the same handle and from keys/<key>/ciphertext,
+// and writing ciphertext to keys/<key>/decrypt does the same for plaintext.
+func (transitEngine) lookup(vfs *VaultFS, mount string, relPath string) (fs.Node, error) {
+	parts := strings.Split(relPath, "/")
+	if len(parts) != 2 || parts[0] != "keys" {
+		return nil, nil
+	}
+	key := parts[1]
+	resultName := path.Join(mount, key)
+
+	encrypt := func(ctx context.Context, req fuse.Header, input []byte) ([]byte, error) {
+		secret, err := vfs.logic(ctx).Write(path.Join(mount, "encrypt", key), map[string]interface{}{
+			"plaintext": base64.StdEncoding.EncodeToString(input),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if secret == nil {
+			return nil, fuse.EIO
+		}
+		ciphertext, ok := secret.Data["ciphertext"].(string)
+		if !ok {
+			return nil, fuse.EIO
+		}
+		output := []byte(ciphertext + "\n")
+		vfs.results.set(req.Uid, resultName+":ciphertext", output)
+		return output, nil
+	}
+
+	decrypt := func(ctx context.Context, req fuse.Header, input []byte) ([]byte, error) {
+		secret, err := vfs.logic(ctx).Write(path.Join(mount, "decrypt", key), map[string]interface{}{
+			"ciphertext": strings.TrimSpace(string(input)),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if secret == nil {
+			return nil, fuse.EIO
+		}
+		encoded, ok := secret.Data["plaintext"].(string)
+		if !ok {
+			return nil, fuse.EIO
+		}
+		output, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fuse.Errno(syscall.EBADMSG)
+		}
+		vfs.results.set(req.Uid, resultName+":plaintext", output)
+		return output, nil
+	}
+
+	return &StaticDir{
+		fs: vfs,
+		children: map[string]fs.Node{
+			"encrypt":    NewDynamicFile(vfs, path.Join(mount, "encrypt", key), true, encrypt),
+			"decrypt":    NewDynamicFile(vfs, path.Join(mount, "decrypt", key), true, decrypt),
+			"ciphertext": vfs.results.resultFile(vfs, resultName+":ciphertext"),
+			"plaintext":  vfs.results.resultFile(vfs, resultName+":plaintext"),
+		},
+	}, nil
+}
+
+// entries implements engine.
+func (transitEngine) entries(relPath string) []fuse.Dirent {
+	return nil
+}
@@ -0,0 +1,134 @@
+// A directory overlaying the same directory of several Vault roots, so layered
+// configuration (e.g. secret/common beneath secret/team-x) appears as a single
+// tree. Entries of later roots shadow those of earlier roots, except that
+// directories present in several roots are merged.
+
+package fs
+
+import (
+	"os"
+	"syscall"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"golang.org/x/net/context"
+)
+
+// Statically ensure that *UnionDir implement those interface
+var _ = fs.HandleReadDirAller(&UnionDir{})
+var _ = fs.NodeRequestLookuper(&UnionDir{})
+
+// SetUnionRoots sets further Vault paths to overlay on the root of the mount,
+// in order of precedence: each shadows the root and the paths before it.
+func (v *VaultFS) SetUnionRoots(roots []string) {
+	v.unionRoots = roots
+}
+
+// UnionDir implements a directory merging the directories of several layers.
+type UnionDir struct {
+	fs     *VaultFS     // root filesystem this node is associated with
+	layers []*SecretDir // the merged directories, lowest precedence first
+}
+
+// Attr sets attrs on the given fuse.Attr, from the highest layer which has
+// them.
+func (u *UnionDir) Attr(ctx context.Context, a *fuse.Attr) error {
+	var err error
+	for i := len(u.layers) - 1; i >= 0; i-- {
+		if err = u.layers[i].Attr(ctx, a); err == nil {
+			return nil
+		}
+	}
+	// Directories leading to a layer are reachable even if they can't be
+	// read.
+	if err == fuse.ENOENT {
+		a.Valid = u.fs.attrTimeout
+		a.Mode = os.ModeDir | os.FileMode(0555)
+		a.Uid = u.fs.uid
+		a.Gid = u.fs.gid
+		return nil
+	}
+	return err
+}
+
+// Lookup looks up name in each layer, highest first. Files and secrets shadow
+// everything beneath them, while directories are merged with directories of
+// the same name in lower layers.
+func (u *UnionDir) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.LookupResponse) (fs.Node, error) {
+	dirs := []*SecretDir{}
+	for i := len(u.layers) - 1; i >= 0; i-- {
+		node, err := u.layers[i].Lookup(ctx, req, resp)
+		if err == fuse.ENOENT {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		dir, ok := node.(*SecretDir)
+		if !ok || !dir.mergeable(ctx) {
+			if len(dirs) == 0 {
+				return node, nil
+			}
+			break
+		}
+		// Prepend, to keep the lowest layer first.
+		dirs = append([]*SecretDir{dir}, dirs...)
+	}
+
+	switch len(dirs) {
+	case 0:
+		return nil, fuse.ENOENT
+	case 1:
+		return dirs[0], nil
+	default:
+		return &UnionDir{fs: u.fs, layers: dirs}, nil
+	}
+}
+
+// ReadDirAll merges the entries of every layer.
+func (u *UnionDir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	merged := []fuse.Dirent{}
+	index := map[string]int{}
+	var lastErr error
+	readable := false
+	for _, layer := range u.layers {
+		dirs, err := layer.ReadDirAll(ctx)
+		if err != nil {
+			if err != fuse.ENOENT && err != fuse.Errno(syscall.EACCES) {
+				return []fuse.Dirent{}, err
+			}
+			lastErr = err
+			continue
+		}
+		readable = true
+
+		for _, d := range dirs {
+			if i, found := index[d.Name]; found {
+				merged[i] = d
+				continue
+			}
+			index[d.Name] = len(merged)
+			merged = append(merged, d)
+		}
+	}
+
+	if !readable {
+		return []fuse.Dirent{}, lastErr
+	}
+	return merged, nil
+}
+
+// mergeable returns true if the directory can be merged with the same
+// directory of other layers, i.e. it isn't a secret.
+func (s *SecretDir) mergeable(ctx context.Context) bool {
+	if s.fixed != nil {
+		return false
+	}
+	secretType, _ := s.lookup(ctx, s.lookupPath)
+	switch secretType {
+	case SecretTypeDirectory, SecretTypeInaccessible, SecretTypeNonExistent:
+		return true
+	}
+	return false
+}
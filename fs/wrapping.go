@@ -0,0 +1,181 @@
+// Response wrapping hands off secrets through single-use tokens. Every secret
+// has a .wrap file which returns a wrapping token for it, and writing a
+// wrapping token to the root .unwrap file makes its contents available under
+// .unwrapped/.
+
+package fs
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/hashicorp/vault/api"
+	"golang.org/x/net/context"
+)
+
+// Statically ensure that *unwrappedDir implement those interface
+var _ = fs.HandleReadDirAller(&unwrappedDir{})
+var _ = fs.NodeRequestLookuper(&unwrappedDir{})
+var _ = fs.NodeRemover(&unwrappedDir{})
+
+const (
+	// wrapFileName is the file in each secret returning a wrapping token.
+	wrapFileName = ".wrap"
+	// unwrapFileName is the root file wrapping tokens are written to.
+	unwrapFileName = ".unwrap"
+	// unwrappedDirName is the root directory holding unwrapped secrets.
+	unwrappedDirName = ".unwrapped"
+)
+
+// DefaultWrapTTL is the default TTL of wrapping tokens returned by .wrap files.
+const DefaultWrapTTL = 5 * time.Minute
+
+// SetWrapTTL sets the TTL of wrapping tokens returned by .wrap files.
+func (v *VaultFS) SetWrapTTL(ttl time.Duration) {
+	v.wrapTTL = ttl
+}
+
+// wrapFile returns the .wrap file of the secret at secretPath, which reads the
+// secret wrapped (once per open) and returns the wrapping token.
+func (v *VaultFS) wrapFile(secretPath string) *DynamicFile {
+	return NewDynamicFile(v, secretPath, false, func(ctx context.Context, req fuse.Header, input []byte) ([]byte, error) {
+		secret, err := v.logic(ctx).ReadWrapped(secretPath, v.wrapTTL)
+		if err != nil {
+			return nil, err
+		}
+		if secret == nil || secret.WrapInfo == nil {
+			return nil, fuse.ENOENT
+		}
+		opLog(ctx).WithField("path", secretPath).Info("wrapped secret")
+		return []byte(secret.WrapInfo.Token + "\n"), nil
+	})
+}
+
+// unwrapped is a secret unwrapped by a user.
+type unwrapped struct {
+	uid    uint32
+	secret *api.Secret
+}
+
+// unwrapStore holds the secrets unwrapped through the .unwrap file.
+type unwrapStore struct {
+	mu      sync.Mutex
+	next    int
+	secrets map[string]unwrapped
+}
+
+func newUnwrapStore() *unwrapStore {
+	return &unwrapStore{secrets: make(map[string]unwrapped)}
+}
+
+// add stores secret for uid, returning its name in .unwrapped/.
+func (u *unwrapStore) add(uid uint32, secret *api.Secret) string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.next++
+	name := strconv.Itoa(u.next)
+	u.secrets[name] = unwrapped{uid: uid, secret: secret}
+	return name
+}
+
+// unwrapFile returns the root .unwrap file. Writing a wrapping token to it
+// unwraps the token, and reading back the same handle returns the path the
+// contents are available at.
+func (v *VaultFS) unwrapFile() *DynamicFile {
+	return NewDynamicFile(v, "sys/wrapping/unwrap", true, func(ctx context.Context, req fuse.Header, input []byte) ([]byte, error) {
+		token := strings.TrimSpace(string(input))
+		if token == "" {
+			return nil, fuse.Errno(syscall.EINVAL)
+		}
+		secret, err := v.logic(ctx).Unwrap(token)
+		if err != nil {
+			return nil, err
+		}
+		if secret == nil {
+			return nil, fuse.ENOENT
+		}
+		name := v.unwrapped.add(req.Uid, secret)
+		opLog(ctx).WithField("name", name).WithField("uid", req.Uid).Info("unwrapped secret")
+		return []byte(fmt.Sprintf("%s/%s\n", unwrappedDirName, name)), nil
+	})
+}
+
+// unwrappedDir implements the .unwrapped directory. Each unwrapped secret is
+// only accessible to the user who unwrapped it, and can be removed with rmdir.
+type unwrappedDir struct {
+	fs *VaultFS
+}
+
+// Attr sets attrs on the given fuse.Attr
+func (d *unwrappedDir) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Valid = d.fs.attrTimeout
+	a.Mode = os.ModeDir | os.FileMode(0755)
+	a.Uid = d.fs.uid
+	a.Gid = d.fs.gid
+
+	return nil
+}
+
+// Lookup returns an unwrapped secret, presented like any other secret.
+func (d *unwrappedDir) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.LookupResponse) (fs.Node, error) {
+	d.fs.unwrapped.mu.Lock()
+	entry, found := d.fs.unwrapped.secrets[req.Name]
+	d.fs.unwrapped.mu.Unlock()
+
+	if !found {
+		return nil, fuse.ENOENT
+	}
+	if entry.uid != req.Uid {
+		return nil, fuse.Errno(syscall.EACCES)
+	}
+
+	// Entries never change, but access is per-user.
+	resp.EntryValid = 0
+	return &SecretDir{
+		fs:         d.fs,
+		lookupPath: unwrappedDirName + "/" + req.Name,
+		fixed:      entry.secret,
+	}, nil
+}
+
+// ReadDirAll lists the unwrapped secrets.
+func (d *unwrappedDir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	d.fs.unwrapped.mu.Lock()
+	defer d.fs.unwrapped.mu.Unlock()
+
+	names := []string{}
+	for name := range d.fs.unwrapped.secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	dirs := []fuse.Dirent{}
+	for _, name := range names {
+		dirs = append(dirs, fuse.Dirent{Name: name, Type: fuse.DT_Dir})
+	}
+	return dirs, nil
+}
+
+// Remove forgets an unwrapped secret.
+func (d *unwrappedDir) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
+	d.fs.unwrapped.mu.Lock()
+	defer d.fs.unwrapped.mu.Unlock()
+
+	entry, found := d.fs.unwrapped.secrets[req.Name]
+	if !found {
+		return fuse.ENOENT
+	}
+	if entry.uid != req.Uid {
+		return fuse.Errno(syscall.EACCES)
+	}
+	delete(d.fs.unwrapped.secrets, req.Name)
+	return nil
+}
@@ -0,0 +1,68 @@
+// Vault metadata of secrets is exposed as extended attributes on their data
+// files, rather than as extra files in the tree.
+
+package fs
+
+import (
+	"sort"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"golang.org/x/net/context"
+)
+
+// Statically ensure that the data files implement the xattr interfaces
+var _ = fs.NodeGetxattrer(&StaticValue{})
+var _ = fs.NodeListxattrer(&StaticValue{})
+var _ = fs.NodeGetxattrer(&DataValue{})
+var _ = fs.NodeListxattrer(&DataValue{})
+
+// xattrPrefix is the namespace of the extended attributes.
+const xattrPrefix = "user.vault."
+
+// getxattr answers a Getxattr request from xattrs.
+func getxattr(xattrs map[string]string, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	value, found := xattrs[req.Name]
+	if !found {
+		return fuse.ErrNoXattr
+	}
+	resp.Xattr = []byte(value)
+	return nil
+}
+
+// listxattr answers a Listxattr request from xattrs.
+func listxattr(xattrs map[string]string, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	names := []string{}
+	for name := range xattrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	resp.Append(names...)
+	return nil
+}
+
+// Getxattr returns a Vault metadata attribute of the secret.
+func (f *StaticValue) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return getxattr(f.meta.xattrs, req, resp)
+}
+
+// Listxattr lists the Vault metadata attributes of the secret.
+func (f *StaticValue) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return listxattr(f.meta.xattrs, req, resp)
+}
+
+// Getxattr returns a Vault metadata attribute of the secret.
+func (f *DataValue) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	return getxattr(f.meta.xattrs, req, resp)
+}
+
+// Listxattr lists the Vault metadata attributes of the secret.
+func (f *DataValue) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	return listxattr(f.meta.xattrs, req, resp)
+}
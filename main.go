@@ -14,8 +14,25 @@
 
 package main
 
-import "github.com/wrouesnel/vaultfs/cmd"
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/wrouesnel/vaultfs/cmd"
+)
 
 func main() {
+	// Installed as /sbin/mount.vaultfs, vaultfs is a mount(8) helper.
+	if filepath.Base(os.Args[0]) == "mount.vaultfs" {
+		cmd.ExecuteMountHelper()
+		return
+	}
+	// Installed in a vendor~driver directory of the kubelet's volume plugin
+	// directory, vaultfs is a flexvolume driver.
+	if strings.Contains(filepath.Base(filepath.Dir(os.Args[0])), "~") {
+		cmd.ExecuteFlexVolume()
+		return
+	}
 	cmd.Execute()
 }
@@ -0,0 +1,63 @@
+package nfs
+
+// MOUNT v3 status codes.
+const (
+	mnt3OK        = 0
+	mnt3ErrNoEnt  = 2
+	mnt3ErrIO     = 5
+	mnt3ErrAccess = 13
+	mnt3ErrNotDir = 20
+)
+
+// mountProcedures are the procedures of the MOUNT v3 protocol, by number.
+// The whole tree is exported as /, and any directory within it can be
+// mounted.
+var mountProcedures = []procedure{
+	0: func(*Server, *request, *xdrReader, *xdrWriter) {},
+	1: (*Server).mnt,
+	2: func(s *Server, req *request, args *xdrReader, w *xdrWriter) {
+		// Mounts aren't recorded.
+		w.bool(false)
+	},
+	3: func(s *Server, req *request, args *xdrReader, w *xdrWriter) {
+		args.string(1024)
+	},
+	4: func(*Server, *request, *xdrReader, *xdrWriter) {},
+	5: func(s *Server, req *request, args *xdrReader, w *xdrWriter) {
+		w.bool(true)
+		w.string("/")
+		w.bool(false) // no groups
+		w.bool(false)
+	},
+}
+
+func (s *Server) mnt(req *request, args *xdrReader, w *xdrWriter) {
+	dirPath := args.string(1024)
+	if args.err != nil {
+		return
+	}
+
+	h, e, err := s.walk(req, dirPath)
+	if err != nil {
+		switch status(err) {
+		case nfs3ErrNoEnt:
+			w.uint32(mnt3ErrNoEnt)
+		case nfs3ErrAccess, nfs3ErrPerm:
+			w.uint32(mnt3ErrAccess)
+		case nfs3ErrNotDir:
+			w.uint32(mnt3ErrNotDir)
+		default:
+			w.uint32(mnt3ErrIO)
+		}
+		return
+	}
+	if !isDir(req, e) {
+		w.uint32(mnt3ErrNotDir)
+		return
+	}
+
+	w.uint32(mnt3OK)
+	w.opaque(h[:])
+	w.uint32(1) // auth flavors
+	w.uint32(authSys)
+}
@@ -0,0 +1,491 @@
+package nfs
+
+import (
+	"os"
+	"path"
+	"syscall"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+)
+
+// NFSv3 status codes.
+const (
+	nfs3OK             = 0
+	nfs3ErrPerm        = 1
+	nfs3ErrNoEnt       = 2
+	nfs3ErrIO          = 5
+	nfs3ErrAccess      = 13
+	nfs3ErrNotDir      = 20
+	nfs3ErrIsDir       = 21
+	nfs3ErrInval       = 22
+	nfs3ErrFBig        = 27
+	nfs3ErrROFS        = 30
+	nfs3ErrNameTooLong = 63
+	nfs3ErrStale       = 70
+	nfs3ErrBadHandle   = 10001
+	nfs3ErrBadCookie   = 10003
+	nfs3ErrNotSupp     = 10004
+	nfs3ErrTooSmall    = 10005
+)
+
+// NFSv3 file types.
+const (
+	nf3Reg = 1
+	nf3Dir = 2
+	nf3Lnk = 5
+)
+
+// ACCESS procedure bits.
+const (
+	access3Read    = 0x01
+	access3Lookup  = 0x02
+	access3Execute = 0x20
+)
+
+// Sizes advertised by FSINFO.
+const (
+	maxRead    = 64 << 10
+	maxDirRead = 64 << 10
+)
+
+// fsid is the file system id of every node.
+const fsid = 0x7661756c74 // "vault"
+
+// nfsProcedures are the procedures of NFSv3, by number. Those modifying the
+// tree fail as the server is read-only.
+var nfsProcedures = []procedure{
+	0:  func(*Server, *request, *xdrReader, *xdrWriter) {},
+	1:  (*Server).getattr,
+	2:  readOnly(2),
+	3:  (*Server).lookupProc,
+	4:  (*Server).access,
+	5:  (*Server).readlink,
+	6:  (*Server).read,
+	7:  readOnly(2),
+	8:  readOnly(2),
+	9:  readOnly(2),
+	10: readOnly(2),
+	11: readOnly(2),
+	12: readOnly(2),
+	13: readOnly(2),
+	14: readOnly(4),
+	15: readOnly(3),
+	16: (*Server).readdir,
+	17: (*Server).readdirplus,
+	18: (*Server).fsstat,
+	19: (*Server).fsinfo,
+	20: (*Server).pathconf,
+	21: readOnly(2),
+}
+
+// readOnly returns a procedure failing with NFS3ERR_ROFS, followed by the
+// given number of absent attributes, which make up the weak cache
+// consistency data of its failure results.
+func readOnly(absent int) procedure {
+	return func(s *Server, req *request, args *xdrReader, w *xdrWriter) {
+		w.uint32(nfs3ErrROFS)
+		for i := 0; i < absent; i++ {
+			w.bool(false)
+		}
+	}
+}
+
+// status returns the NFSv3 status of an error returned by a node.
+func status(err error) uint32 {
+	errno, ok := err.(fuse.ErrorNumber)
+	if !ok {
+		return nfs3ErrIO
+	}
+	switch syscall.Errno(errno.Errno()) {
+	case syscall.EPERM:
+		return nfs3ErrPerm
+	case syscall.ENOENT:
+		return nfs3ErrNoEnt
+	case syscall.EACCES:
+		return nfs3ErrAccess
+	case syscall.ENOTDIR:
+		return nfs3ErrNotDir
+	case syscall.EISDIR:
+		return nfs3ErrIsDir
+	case syscall.EINVAL:
+		return nfs3ErrInval
+	case syscall.EFBIG:
+		return nfs3ErrFBig
+	case syscall.EROFS:
+		return nfs3ErrROFS
+	case syscall.ENAMETOOLONG:
+		return nfs3ErrNameTooLong
+	case syscall.ENOSYS, syscall.ENOTSUP:
+		return nfs3ErrNotSupp
+	}
+	return nfs3ErrIO
+}
+
+// writeFattr encodes attr as the fattr3 of the node with handle h.
+func writeFattr(w *xdrWriter, h handle, attr fuse.Attr) {
+	switch {
+	case attr.Mode.IsDir():
+		w.uint32(nf3Dir)
+	case attr.Mode&os.ModeSymlink != 0:
+		w.uint32(nf3Lnk)
+	default:
+		w.uint32(nf3Reg)
+	}
+	w.uint32(uint32(attr.Mode.Perm()))
+	nlink := attr.Nlink
+	if nlink == 0 {
+		nlink = 1
+	}
+	w.uint32(nlink)
+	w.uint32(attr.Uid)
+	w.uint32(attr.Gid)
+	w.uint64(attr.Size)
+	w.uint64(attr.Blocks * 512)
+	w.uint32(0) // rdev
+	w.uint32(0)
+	w.uint64(fsid)
+	w.uint64(h.fileID())
+	for _, t := range []int64{attr.Atime.UnixNano(), attr.Mtime.UnixNano(), attr.Ctime.UnixNano()} {
+		w.uint32(uint32(t / 1e9))
+		w.uint32(uint32(t % 1e9))
+	}
+}
+
+// writePostOpAttr encodes the attributes of e, if they can be had.
+func (s *Server) writePostOpAttr(w *xdrWriter, req *request, h handle, e *entry) {
+	if e == nil {
+		w.bool(false)
+		return
+	}
+	attr, err := s.attr(req, h, e)
+	if err != nil {
+		w.bool(false)
+		return
+	}
+	w.bool(true)
+	writeFattr(w, h, attr)
+}
+
+// isDir reports whether e is a directory.
+func isDir(req *request, e *entry) bool {
+	var attr fuse.Attr
+	return e.node.Attr(req.ctx, &attr) == nil && attr.Mode.IsDir()
+}
+
+func (s *Server) getattr(req *request, args *xdrReader, w *xdrWriter) {
+	h, e, stat := s.resolve(args.opaque(64))
+	if args.err != nil {
+		return
+	}
+	if stat != nfs3OK {
+		w.uint32(stat)
+		return
+	}
+	attr, err := s.attr(req, h, e)
+	if err != nil {
+		w.uint32(status(err))
+		return
+	}
+	w.uint32(nfs3OK)
+	writeFattr(w, h, attr)
+}
+
+func (s *Server) lookupProc(req *request, args *xdrReader, w *xdrWriter) {
+	dh, dir, stat := s.resolve(args.opaque(64))
+	name := args.string(255)
+	if args.err != nil {
+		return
+	}
+	if stat != nfs3OK {
+		w.uint32(stat)
+		w.bool(false)
+		return
+	}
+	if !isDir(req, dir) {
+		w.uint32(nfs3ErrNotDir)
+		s.writePostOpAttr(w, req, dh, dir)
+		return
+	}
+
+	h, e, err := s.lookup(req, dir, name)
+	if err != nil {
+		w.uint32(status(err))
+		s.writePostOpAttr(w, req, dh, dir)
+		return
+	}
+	w.uint32(nfs3OK)
+	w.opaque(h[:])
+	s.writePostOpAttr(w, req, h, e)
+	s.writePostOpAttr(w, req, dh, dir)
+}
+
+func (s *Server) access(req *request, args *xdrReader, w *xdrWriter) {
+	h, e, stat := s.resolve(args.opaque(64))
+	requested := args.uint32()
+	if args.err != nil {
+		return
+	}
+	if stat != nfs3OK {
+		w.uint32(stat)
+		w.bool(false)
+		return
+	}
+
+	// Nothing can be modified, and what can be read is up to the node.
+	granted := uint32(0)
+	check := func(bits uint32, mask uint32) {
+		if requested&bits == 0 {
+			return
+		}
+		if accesser, ok := e.node.(fs.NodeAccesser); ok {
+			if accesser.Access(req.ctx, &fuse.AccessRequest{Header: req.header, Mask: mask}) != nil {
+				return
+			}
+		}
+		granted |= requested & bits
+	}
+	check(access3Read, 4)
+	check(access3Lookup|access3Execute, 1)
+
+	w.uint32(nfs3OK)
+	s.writePostOpAttr(w, req, h, e)
+	w.uint32(granted)
+}
+
+func (s *Server) readlink(req *request, args *xdrReader, w *xdrWriter) {
+	args.opaque(64)
+	if args.err != nil {
+		return
+	}
+	w.uint32(nfs3ErrInval)
+	w.bool(false)
+}
+
+func (s *Server) read(req *request, args *xdrReader, w *xdrWriter) {
+	h, e, stat := s.resolve(args.opaque(64))
+	offset := args.uint64()
+	count := args.uint32()
+	if args.err != nil {
+		return
+	}
+	if stat != nfs3OK {
+		w.uint32(stat)
+		w.bool(false)
+		return
+	}
+	if isDir(req, e) {
+		w.uint32(nfs3ErrIsDir)
+		s.writePostOpAttr(w, req, h, e)
+		return
+	}
+
+	data, err := s.content(req, h, e)
+	if err != nil {
+		w.uint32(status(err))
+		w.bool(false)
+		return
+	}
+	if count > maxRead {
+		count = maxRead
+	}
+	var chunk []byte
+	if offset < uint64(len(data)) {
+		chunk = data[offset:]
+		if uint64(len(chunk)) > uint64(count) {
+			chunk = chunk[:count]
+		}
+	}
+
+	w.uint32(nfs3OK)
+	s.writePostOpAttr(w, req, h, e)
+	w.uint32(uint32(len(chunk)))
+	w.bool(offset+uint64(len(chunk)) >= uint64(len(data)))
+	w.opaque(chunk)
+}
+
+// dirEntries returns the entries of the directory with file handle fh for
+// READDIR and READDIRPLUS, writing the failure result if they can't be had.
+func (s *Server) dirEntries(req *request, fh []byte, cookie uint64, w *xdrWriter) (handle, *entry, []fuse.Dirent, bool) {
+	dh, dir, stat := s.resolve(fh)
+	if stat != nfs3OK {
+		w.uint32(stat)
+		w.bool(false)
+		return dh, nil, nil, false
+	}
+	if !isDir(req, dir) {
+		w.uint32(nfs3ErrNotDir)
+		s.writePostOpAttr(w, req, dh, dir)
+		return dh, nil, nil, false
+	}
+	entries, err := s.readDir(req, dir)
+	if err != nil {
+		w.uint32(status(err))
+		s.writePostOpAttr(w, req, dh, dir)
+		return dh, nil, nil, false
+	}
+	if cookie > uint64(len(entries)) {
+		w.uint32(nfs3ErrBadCookie)
+		s.writePostOpAttr(w, req, dh, dir)
+		return dh, nil, nil, false
+	}
+	return dh, dir, entries, true
+}
+
+func (s *Server) readdir(req *request, args *xdrReader, w *xdrWriter) {
+	fh := args.opaque(64)
+	cookie := args.uint64()
+	args.fixed(8) // cookie verifier
+	count := args.uint32()
+	if args.err != nil {
+		return
+	}
+	dh, dir, entries, ok := s.dirEntries(req, fh, cookie, w)
+	if !ok {
+		return
+	}
+
+	body := &xdrWriter{}
+	eof := true
+	for i := cookie; i < uint64(len(entries)); i++ {
+		name := entries[i].Name
+		size := 4 + 8 + 4 + len(name) + pad(len(name)) + 8
+		// Leave room for the attributes, verifier and end of the list.
+		if body.Len()+size+128 > int(count) {
+			eof = false
+			break
+		}
+		body.bool(true)
+		body.uint64(handleOf(path.Join(dir.path, name)).fileID())
+		body.string(name)
+		body.uint64(i + 1)
+	}
+	if cookie < uint64(len(entries)) && body.Len() == 0 {
+		w.uint32(nfs3ErrTooSmall)
+		s.writePostOpAttr(w, req, dh, dir)
+		return
+	}
+
+	w.uint32(nfs3OK)
+	s.writePostOpAttr(w, req, dh, dir)
+	w.fixed(make([]byte, 8))
+	w.Write(body.Bytes())
+	w.bool(false)
+	w.bool(eof)
+}
+
+func (s *Server) readdirplus(req *request, args *xdrReader, w *xdrWriter) {
+	fh := args.opaque(64)
+	cookie := args.uint64()
+	args.fixed(8) // cookie verifier
+	args.uint32() // dircount
+	maxCount := args.uint32()
+	if args.err != nil {
+		return
+	}
+	dh, dir, entries, ok := s.dirEntries(req, fh, cookie, w)
+	if !ok {
+		return
+	}
+
+	body := &xdrWriter{}
+	eof := true
+	for i := cookie; i < uint64(len(entries)); i++ {
+		name := entries[i].Name
+		item := &xdrWriter{}
+		item.bool(true)
+		item.uint64(handleOf(path.Join(dir.path, name)).fileID())
+		item.string(name)
+		item.uint64(i + 1)
+		if h, e, err := s.lookup(req, dir, name); err == nil {
+			s.writePostOpAttr(item, req, h, e)
+			item.bool(true)
+			item.opaque(h[:])
+		} else {
+			item.bool(false)
+			item.bool(false)
+		}
+
+		// Leave room for the attributes, verifier and end of the list.
+		if body.Len()+item.Len()+128 > int(maxCount) {
+			eof = false
+			break
+		}
+		body.Write(item.Bytes())
+	}
+	if cookie < uint64(len(entries)) && body.Len() == 0 {
+		w.uint32(nfs3ErrTooSmall)
+		s.writePostOpAttr(w, req, dh, dir)
+		return
+	}
+
+	w.uint32(nfs3OK)
+	s.writePostOpAttr(w, req, dh, dir)
+	w.fixed(make([]byte, 8))
+	w.Write(body.Bytes())
+	w.bool(false)
+	w.bool(eof)
+}
+
+func (s *Server) fsstat(req *request, args *xdrReader, w *xdrWriter) {
+	h, e, stat := s.resolve(args.opaque(64))
+	if args.err != nil {
+		return
+	}
+	if stat != nfs3OK {
+		w.uint32(stat)
+		w.bool(false)
+		return
+	}
+	w.uint32(nfs3OK)
+	s.writePostOpAttr(w, req, h, e)
+	for i := 0; i < 6; i++ {
+		w.uint64(0) // bytes and files: total, free, available
+	}
+	w.uint32(0) // invarsec
+}
+
+func (s *Server) fsinfo(req *request, args *xdrReader, w *xdrWriter) {
+	h, e, stat := s.resolve(args.opaque(64))
+	if args.err != nil {
+		return
+	}
+	if stat != nfs3OK {
+		w.uint32(stat)
+		w.bool(false)
+		return
+	}
+	w.uint32(nfs3OK)
+	s.writePostOpAttr(w, req, h, e)
+	w.uint32(maxRead) // rtmax
+	w.uint32(maxRead) // rtpref
+	w.uint32(4096)    // rtmult
+	w.uint32(maxRead) // wtmax
+	w.uint32(maxRead) // wtpref
+	w.uint32(4096)    // wtmult
+	w.uint32(maxDirRead)
+	w.uint64(maxContent)
+	w.uint32(0) // time_delta
+	w.uint32(1)
+	w.uint32(0x8) // FSF3_HOMOGENEOUS
+}
+
+func (s *Server) pathconf(req *request, args *xdrReader, w *xdrWriter) {
+	h, e, stat := s.resolve(args.opaque(64))
+	if args.err != nil {
+		return
+	}
+	if stat != nfs3OK {
+		w.uint32(stat)
+		w.bool(false)
+		return
+	}
+	w.uint32(nfs3OK)
+	s.writePostOpAttr(w, req, h, e)
+	w.uint32(1)   // linkmax
+	w.uint32(255) // name_max
+	w.bool(true)  // no_trunc
+	w.bool(true)  // chown_restricted
+	w.bool(false) // case_insensitive
+	w.bool(true)  // case_preserving
+}
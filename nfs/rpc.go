@@ -0,0 +1,149 @@
+package nfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// ONC RPC (RFC 5531) message constants.
+const (
+	rpcVersion = 2
+
+	msgCall  = 0
+	msgReply = 1
+
+	replyAccepted = 0
+	replyDenied   = 1
+
+	acceptSuccess      = 0
+	acceptProgUnavail  = 1
+	acceptProgMismatch = 2
+	acceptProcUnavail  = 3
+	acceptGarbageArgs  = 4
+
+	rejectRPCMismatch = 0
+
+	authNone = 0
+	authSys  = 1
+)
+
+// maxRecord bounds the size of a request, which are all small for a read-only
+// server.
+const maxRecord = 1 << 20
+
+// rpcCall is a decoded RPC call.
+type rpcCall struct {
+	xid  uint32
+	prog uint32
+	vers uint32
+	proc uint32
+	// uid and gid are the credentials of AUTH_SYS calls, or nobody.
+	uid  uint32
+	gid  uint32
+	args *xdrReader
+}
+
+// nobody is the uid and gid of calls without AUTH_SYS credentials.
+const nobody = 65534
+
+// readRecord reads an RPC message from r, joining the fragments of its record
+// marking (RFC 5531 section 11).
+func readRecord(r io.Reader) ([]byte, error) {
+	var record []byte
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return nil, err
+		}
+		marker := binary.BigEndian.Uint32(header[:])
+		length := int(marker & 0x7fffffff)
+		if len(record)+length > maxRecord {
+			return nil, errors.New("rpc record too large")
+		}
+		fragment := make([]byte, length)
+		if _, err := io.ReadFull(r, fragment); err != nil {
+			return nil, err
+		}
+		record = append(record, fragment...)
+		if marker&0x80000000 != 0 {
+			return record, nil
+		}
+	}
+}
+
+// writeRecord writes an RPC message to w as a single fragment.
+func writeRecord(w io.Writer, message []byte) error {
+	record := make([]byte, 4, 4+len(message))
+	binary.BigEndian.PutUint32(record, 0x80000000|uint32(len(message)))
+	_, err := w.Write(append(record, message...))
+	return err
+}
+
+// parseCall decodes the header of an RPC call. A nil call with a nil error
+// is returned for messages which aren't calls, and are ignored. A call of
+// another RPC version is returned with a reply rejecting it.
+func parseCall(message []byte) (*rpcCall, []byte, error) {
+	r := &xdrReader{buf: message}
+	call := &rpcCall{xid: r.uint32(), uid: nobody, gid: nobody}
+	if r.uint32() != msgCall {
+		return nil, nil, r.err
+	}
+	if r.uint32() != rpcVersion {
+		w := replyHeader(call.xid, replyDenied)
+		w.uint32(rejectRPCMismatch)
+		w.uint32(rpcVersion)
+		w.uint32(rpcVersion)
+		return call, w.Bytes(), r.err
+	}
+	call.prog = r.uint32()
+	call.vers = r.uint32()
+	call.proc = r.uint32()
+
+	flavor := r.uint32()
+	cred := r.opaque(400)
+	r.uint32() // verifier flavor
+	r.opaque(400)
+	if r.err != nil {
+		return nil, nil, r.err
+	}
+
+	if flavor == authSys {
+		c := &xdrReader{buf: cred}
+		c.uint32() // stamp
+		c.string(255)
+		uid, gid := c.uint32(), c.uint32()
+		if c.err == nil {
+			call.uid, call.gid = uid, gid
+		}
+	}
+	call.args = r
+	return call, nil, nil
+}
+
+// replyHeader starts a reply to the call xid.
+func replyHeader(xid uint32, stat uint32) *xdrWriter {
+	w := &xdrWriter{}
+	w.uint32(xid)
+	w.uint32(msgReply)
+	w.uint32(stat)
+	return w
+}
+
+// acceptedReply starts an accepted reply to the call xid with stat.
+func acceptedReply(xid uint32, stat uint32) *xdrWriter {
+	w := replyHeader(xid, replyAccepted)
+	w.uint32(authNone)
+	w.uint32(0)
+	w.uint32(stat)
+	return w
+}
+
+// mismatchReply is the reply to a call of an unsupported version of a
+// supported program.
+func mismatchReply(xid uint32, version uint32) []byte {
+	w := acceptedReply(xid, acceptProgMismatch)
+	w.uint32(version)
+	w.uint32(version)
+	return w.Bytes()
+}
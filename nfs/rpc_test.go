@@ -0,0 +1,203 @@
+package nfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// record frames fragments with RPC record marking, the last one ending the
+// record.
+func record(fragments ...[]byte) []byte {
+	var b []byte
+	for i, fragment := range fragments {
+		marker := uint32(len(fragment))
+		if i == len(fragments)-1 {
+			marker |= 0x80000000
+		}
+		b = binary.BigEndian.AppendUint32(b, marker)
+		b = append(b, fragment...)
+	}
+	return b
+}
+
+func TestReadRecord(t *testing.T) {
+	oversized := binary.BigEndian.AppendUint32(nil, 0x80000000|(maxRecord+1))
+	for _, c := range []struct {
+		name     string
+		input    []byte
+		expected []byte
+		fails    bool
+	}{
+		{"single fragment", record([]byte("call")), []byte("call"), false},
+		{"fragments", record([]byte("ca"), []byte("ll")), []byte("call"), false},
+		{"empty", nil, nil, true},
+		{"truncated marker", []byte{0x80, 0}, nil, true},
+		{"truncated fragment", record([]byte("call"))[:6], nil, true},
+		{"missing last fragment", record([]byte("ca"), []byte("ll"))[:6], nil, true},
+		{"oversized fragment", oversized, nil, true},
+		{"oversized record", append(binary.BigEndian.AppendUint32(nil, maxRecord), make([]byte, maxRecord)...), nil, true},
+	} {
+		message, err := readRecord(bytes.NewReader(c.input))
+		if c.fails {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %q", c.name, message)
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(message, c.expected) {
+			t.Errorf("%s: expected %q, got %q (%v)", c.name, c.expected, message, err)
+		}
+	}
+}
+
+func TestWriteRecord(t *testing.T) {
+	var b bytes.Buffer
+	if err := writeRecord(&b, []byte("reply")); err != nil {
+		t.Fatal(err)
+	}
+	message, err := readRecord(&b)
+	if err != nil || string(message) != "reply" {
+		t.Errorf("expected the reply to read back, got %q (%v)", message, err)
+	}
+	if _, err := readRecord(&b); err != io.EOF {
+		t.Errorf("expected a single record, got %v", err)
+	}
+}
+
+// callHeader encodes the header of a call of prog, vers and proc, with the
+// credentials flavor and body given.
+func callHeader(xid, rpcVers, prog, vers, proc, flavor uint32, cred []byte) *xdrWriter {
+	w := &xdrWriter{}
+	w.uint32(xid)
+	w.uint32(msgCall)
+	w.uint32(rpcVers)
+	w.uint32(prog)
+	w.uint32(vers)
+	w.uint32(proc)
+	w.uint32(flavor)
+	w.opaque(cred)
+	w.uint32(authNone)
+	w.opaque(nil)
+	return w
+}
+
+// authSysCred encodes AUTH_SYS credentials of uid and gid.
+func authSysCred(uid, gid uint32) []byte {
+	w := &xdrWriter{}
+	w.uint32(0) // stamp
+	w.string("client")
+	w.uint32(uid)
+	w.uint32(gid)
+	w.uint32(0) // groups
+	return w.Bytes()
+}
+
+func TestParseCall(t *testing.T) {
+	sys := callHeader(1, rpcVersion, nfsProgram, nfsVersion, 1, authSys, authSysCred(1000, 100))
+	sys.uint32(42) // arguments
+	call, reply, err := parseCall(sys.Bytes())
+	if err != nil || call == nil || reply != nil {
+		t.Fatalf("expected a call, got %v %v %v", call, reply, err)
+	}
+	if call.xid != 1 || call.prog != nfsProgram || call.vers != nfsVersion || call.proc != 1 || call.uid != 1000 || call.gid != 100 {
+		t.Errorf("expected the header decoded, got %+v", call)
+	}
+	if arg := call.args.uint32(); arg != 42 || call.args.err != nil {
+		t.Errorf("expected the arguments to follow, got %d (%v)", arg, call.args.err)
+	}
+
+	call, _, err = parseCall(callHeader(2, rpcVersion, nfsProgram, nfsVersion, 1, authNone, nil).Bytes())
+	if err != nil || call.uid != nobody || call.gid != nobody {
+		t.Errorf("expected calls without credentials to be made as nobody, got %+v (%v)", call, err)
+	}
+
+	// Malformed credentials are ignored, not trusted in part.
+	call, _, err = parseCall(callHeader(3, rpcVersion, nfsProgram, nfsVersion, 1, authSys, authSysCred(0, 0)[:12]).Bytes())
+	if err != nil || call.uid != nobody || call.gid != nobody {
+		t.Errorf("expected truncated credentials to be made as nobody, got %+v (%v)", call, err)
+	}
+
+	call, reply, err = parseCall(callHeader(4, 3, nfsProgram, nfsVersion, 1, authNone, nil).Bytes())
+	if err != nil || call == nil || reply == nil {
+		t.Fatalf("expected a rejection of RPC version 3, got %v %v %v", call, reply, err)
+	}
+	r := &xdrReader{buf: reply}
+	if xid, msg, stat, reject := r.uint32(), r.uint32(), r.uint32(), r.uint32(); xid != 4 || msg != msgReply || stat != replyDenied || reject != rejectRPCMismatch {
+		t.Errorf("expected an RPC mismatch, got %v", reply)
+	}
+
+	replyMessage := &xdrWriter{}
+	replyMessage.uint32(5)
+	replyMessage.uint32(msgReply)
+	if call, reply, err := parseCall(replyMessage.Bytes()); call != nil || reply != nil || err != nil {
+		t.Errorf("expected replies to be ignored, got %v %v %v", call, reply, err)
+	}
+}
+
+func TestParseCallMalformed(t *testing.T) {
+	oversizedCred := callHeader(1, rpcVersion, nfsProgram, nfsVersion, 1, authSys, make([]byte, 401))
+	message := callHeader(1, rpcVersion, nfsProgram, nfsVersion, 1, authSys, authSysCred(1000, 100)).Bytes()
+	for _, c := range []struct {
+		name    string
+		message []byte
+	}{
+		{"oversized credentials", oversizedCred.Bytes()},
+		{"credentials longer than the message", message[:len(message)-12]},
+		{"missing verifier", message[:len(message)-8]},
+		{"missing verifier body", message[:len(message)-4]},
+	} {
+		if call, _, err := parseCall(c.message); err == nil {
+			t.Errorf("%s: expected an error, got %+v", c.name, call)
+		}
+	}
+
+	// No prefix of a call decodes without error, or panics.
+	for n := 0; n < len(message); n++ {
+		if call, reply, err := parseCall(message[:n]); err == nil && reply == nil && call != nil {
+			t.Errorf("expected the call truncated to %d bytes to fail, got %+v", n, call)
+		}
+	}
+}
+
+func TestXDRReader(t *testing.T) {
+	w := &xdrWriter{}
+	w.opaque([]byte("abcde"))
+	w.uint64(1 << 40)
+	w.string("name")
+	r := &xdrReader{buf: w.Bytes()}
+	if b := r.opaque(5); string(b) != "abcde" {
+		t.Errorf("expected the opaque data, got %q", b)
+	}
+	if v := r.uint64(); v != 1<<40 {
+		t.Errorf("expected the padding to be skipped, got %d", v)
+	}
+	if s := r.string(4); s != "name" || r.err != nil || len(r.buf) != 0 {
+		t.Errorf("expected the string, got %q (%v)", s, r.err)
+	}
+
+	for _, c := range []struct {
+		name string
+		read func(r *xdrReader)
+		buf  []byte
+	}{
+		{"truncated uint32", func(r *xdrReader) { r.uint32() }, []byte{0, 0, 1}},
+		{"truncated uint64", func(r *xdrReader) { r.uint64() }, make([]byte, 7)},
+		{"opaque over its bound", func(r *xdrReader) { r.opaque(4) }, w.Bytes()},
+		{"opaque longer than the buffer", func(r *xdrReader) { r.opaque(1 << 20) }, []byte{0x7f, 0xff, 0xff, 0xff, 'a'}},
+		{"opaque of negative length", func(r *xdrReader) { r.opaque(1 << 31) }, []byte{0xff, 0xff, 0xff, 0xff}},
+		{"missing padding", func(r *xdrReader) { r.opaque(8) }, []byte{0, 0, 0, 1, 'a'}},
+		{"truncated fixed", func(r *xdrReader) { r.fixed(handleSize) }, make([]byte, handleSize-1)},
+	} {
+		r := &xdrReader{buf: c.buf}
+		c.read(r)
+		if r.err == nil {
+			t.Errorf("%s: expected an error", c.name)
+		}
+		// Reads after an error return zero values.
+		if v := r.uint32(); v != 0 || r.err == nil {
+			t.Errorf("%s: expected later reads to fail, got %d", c.name, v)
+		}
+	}
+}
@@ -0,0 +1,505 @@
+// Package nfs serves a FUSE node tree read-only over NFSv3 (RFC 1813), so
+// systems which can't run FUSE can mount it over the network. Only TCP is
+// served, without a portmapper, so clients mount with the port given, e.g.
+//
+//	mount -t nfs -o vers=3,proto=tcp,port=2049,mountport=2049,nolock host:/ /mnt
+//
+// File handles are only valid for the life of the server, and only for as long
+// as they are among the maxEntries most recently used: clients see stale
+// handles after it restarts, and must remount, and look a name up again once
+// its handle is dropped.
+package nfs
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"net"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	log "github.com/wrouesnel/go.log"
+	"golang.org/x/net/context"
+)
+
+// RPC programs served.
+const (
+	nfsProgram   = 100003
+	nfsVersion   = 3
+	mountProgram = 100005
+	mountVersion = 3
+)
+
+// contentTTL is how long the content of a file read is kept for further
+// reads, as NFS has no open or close. Files which generate their content
+// (such as credentials) are then released, revoking any lease, and generate
+// it anew on the next read.
+const contentTTL = 30 * time.Second
+
+// maxContent bounds the size of a file served.
+const maxContent = 16 << 20
+
+// maxEntries bounds the file handles held, the least recently used being
+// dropped first.
+const maxEntries = 1 << 16
+
+// maxConcurrent bounds the calls served at once on a connection.
+const maxConcurrent = 16
+
+// handleSize is the size of file handles, a hash of the path.
+const handleSize = 16
+
+type handle [handleSize]byte
+
+// handleOf returns the file handle of the node at p.
+func handleOf(p string) handle {
+	var h handle
+	sum := sha256.Sum256([]byte(p))
+	copy(h[:], sum[:])
+	return h
+}
+
+// fileID returns the file id (inode number) of the node with handle h.
+func (h handle) fileID() uint64 {
+	return binary.BigEndian.Uint64(h[:8])
+}
+
+// entry is a node handed out to clients.
+type entry struct {
+	handle handle
+	path   string
+	node   fs.Node
+}
+
+// contentKey identifies the content of a file read by a user.
+type contentKey struct {
+	handle handle
+	uid    uint32
+}
+
+// content is the content of a file read.
+type content struct {
+	data    []byte
+	release func()
+	read    time.Time
+}
+
+// request is the caller of a call.
+type request struct {
+	ctx    context.Context
+	header fuse.Header
+}
+
+// Server serves a node tree over NFSv3.
+type Server struct {
+	root    handle
+	context func(ctx context.Context, uid uint32, gid uint32) context.Context
+	allowed []*net.IPNet
+
+	mu       sync.Mutex
+	rootNode *entry
+	entries  map[handle]*list.Element
+	lru      *list.List // front is most recently used
+	contents map[contentKey]*content
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewServer creates a server of the tree at root to the clients within
+// allowed. callerContext, if not nil, returns the context of serving a call
+// from uid and gid.
+func NewServer(root fs.Node, callerContext func(ctx context.Context, uid uint32, gid uint32) context.Context, allowed []*net.IPNet) *Server {
+	s := &Server{
+		root:     handleOf("/"),
+		context:  callerContext,
+		allowed:  allowed,
+		entries:  make(map[handle]*list.Element),
+		lru:      list.New(),
+		contents: make(map[contentKey]*content),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	s.rootNode = &entry{handle: s.root, path: "/", node: root}
+	go s.expireContents()
+	return s
+}
+
+// Serve accepts connections on l until it is closed.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		if !s.isAllowed(conn.RemoteAddr()) {
+			log.WithField("client", conn.RemoteAddr()).Warn("refusing nfs client")
+			conn.Close()
+			continue
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// Close releases the content of every file read.
+func (s *Server) Close() {
+	close(s.stop)
+	<-s.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, c := range s.contents {
+		if c.release != nil {
+			c.release()
+		}
+		delete(s.contents, key)
+	}
+}
+
+// isAllowed reports whether the client at addr may connect.
+func (s *Server) isAllowed(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, network := range s.allowed {
+		if network.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// serveConn serves the calls made on conn until it is closed.
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	log := log.WithField("client", conn.RemoteAddr())
+	log.Debug("nfs client connected")
+
+	var writeMu sync.Mutex
+	slots := make(chan struct{}, maxConcurrent)
+	for {
+		message, err := readRecord(conn)
+		if err != nil {
+			log.WithError(err).Debug("nfs client disconnected")
+			return
+		}
+
+		slots <- struct{}{}
+		go func() {
+			defer func() { <-slots }()
+
+			reply := s.handleMessage(message)
+			if reply == nil {
+				return
+			}
+			writeMu.Lock()
+			defer writeMu.Unlock()
+			if err := writeRecord(conn, reply); err != nil {
+				log.WithError(err).Debug("could not write nfs reply")
+				conn.Close()
+			}
+		}()
+	}
+}
+
+// procedure handles a call, decoding its arguments from args and encoding its
+// results to w.
+type procedure func(s *Server, req *request, args *xdrReader, w *xdrWriter)
+
+// handleMessage handles an RPC message, returning the reply if any.
+func (s *Server) handleMessage(message []byte) []byte {
+	call, reply, err := parseCall(message)
+	if err != nil || call == nil || reply != nil {
+		return reply
+	}
+
+	var procedures []procedure
+	switch {
+	case call.prog == nfsProgram && call.vers == nfsVersion:
+		procedures = nfsProcedures
+	case call.prog == mountProgram && call.vers == mountVersion:
+		procedures = mountProcedures
+	case call.prog == nfsProgram:
+		return mismatchReply(call.xid, nfsVersion)
+	case call.prog == mountProgram:
+		return mismatchReply(call.xid, mountVersion)
+	default:
+		return acceptedReply(call.xid, acceptProgUnavail).Bytes()
+	}
+	if int(call.proc) >= len(procedures) || procedures[call.proc] == nil {
+		return acceptedReply(call.xid, acceptProcUnavail).Bytes()
+	}
+
+	req := &request{
+		ctx:    context.Background(),
+		header: fuse.Header{Uid: call.uid, Gid: call.gid},
+	}
+	if s.context != nil {
+		req.ctx = s.context(req.ctx, call.uid, call.gid)
+	}
+
+	results := &xdrWriter{}
+	procedures[call.proc](s, req, call.args, results)
+	if call.args.err != nil {
+		return acceptedReply(call.xid, acceptGarbageArgs).Bytes()
+	}
+	w := acceptedReply(call.xid, acceptSuccess)
+	w.Write(results.Bytes())
+	return w.Bytes()
+}
+
+// resolve returns the entry of a file handle.
+func (s *Server) resolve(fh []byte) (handle, *entry, uint32) {
+	var h handle
+	if len(fh) != handleSize {
+		return h, nil, nfs3ErrBadHandle
+	}
+	copy(h[:], fh)
+
+	if h == s.root {
+		return h, s.rootNode, nfs3OK
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	elem, found := s.entries[h]
+	if !found {
+		return h, nil, nfs3ErrStale
+	}
+	s.lru.MoveToFront(elem)
+	return h, elem.Value.(*entry), nfs3OK
+}
+
+// hold records e as handed out, dropping the least recently used entries
+// beyond maxEntries.
+func (s *Server) hold(e *entry) {
+	if e.handle == s.root {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elem, found := s.entries[e.handle]; found {
+		elem.Value = e
+		s.lru.MoveToFront(elem)
+		return
+	}
+	s.entries[e.handle] = s.lru.PushFront(e)
+	for s.lru.Len() > maxEntries {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.entries, oldest.Value.(*entry).handle)
+	}
+}
+
+// unknownSize is the size reported of files which don't know theirs until
+// read (such as those generating credentials), so clients read them rather
+// than take them for empty. The reply to the read carries their actual size.
+const unknownSize = maxContent
+
+// attr returns the attributes of e, without reading it: the size of files is
+// that of the content held from a recent read by the caller, or else the one
+// the node reports.
+func (s *Server) attr(req *request, h handle, e *entry) (fuse.Attr, error) {
+	attr := fuse.Attr{
+		Nlink: 1,
+		Atime: startTime,
+		Mtime: startTime,
+		Ctime: startTime,
+	}
+	if err := e.node.Attr(req.ctx, &attr); err != nil {
+		return attr, err
+	}
+	if attr.Mode.IsRegular() {
+		if data, held := s.heldContent(req, h); held {
+			attr.Size = uint64(len(data))
+		} else if attr.Size == 0 {
+			attr.Size = unknownSize
+		}
+	}
+	return attr, nil
+}
+
+// startTime is the time of nodes which don't have one.
+var startTime = time.Now()
+
+// lookup looks up name in the directory e.
+func (s *Server) lookup(req *request, e *entry, name string) (handle, *entry, error) {
+	switch name {
+	case ".":
+		return handleOf(e.path), e, nil
+	case "..":
+		return s.walk(req, path.Dir(e.path))
+	}
+	if name == "" || strings.Contains(name, "/") {
+		return handle{}, nil, fuse.ENOENT
+	}
+
+	var node fs.Node
+	var err error
+	switch n := e.node.(type) {
+	case fs.NodeRequestLookuper:
+		node, err = n.Lookup(req.ctx, &fuse.LookupRequest{Header: req.header, Name: name}, &fuse.LookupResponse{})
+	case fs.NodeStringLookuper:
+		node, err = n.Lookup(req.ctx, name)
+	default:
+		err = fuse.Errno(syscall.ENOTDIR)
+	}
+	if err != nil {
+		return handle{}, nil, err
+	}
+
+	p := path.Join(e.path, name)
+	child := &entry{handle: handleOf(p), path: p, node: node}
+	s.hold(child)
+	return child.handle, child, nil
+}
+
+// walk looks up the path p from the root.
+func (s *Server) walk(req *request, p string) (handle, *entry, error) {
+	h, e, _ := s.resolve(s.root[:])
+	for _, name := range strings.Split(strings.Trim(path.Clean(p), "/"), "/") {
+		if name == "" {
+			continue
+		}
+		var err error
+		if h, e, err = s.lookup(req, e, name); err != nil {
+			return h, nil, err
+		}
+	}
+	return h, e, nil
+}
+
+// open opens the node of e, returning the handle and a func releasing it.
+func (s *Server) open(req *request, e *entry, dir bool) (fs.Handle, func(), error) {
+	opener, ok := e.node.(fs.NodeOpener)
+	if !ok {
+		return e.node, func() {}, nil
+	}
+	flags := fuse.OpenReadOnly
+	if dir {
+		flags |= fuse.OpenDirectory
+	}
+	h, err := opener.Open(req.ctx, &fuse.OpenRequest{Header: req.header, Dir: dir, Flags: flags}, &fuse.OpenResponse{})
+	if err != nil {
+		return nil, nil, err
+	}
+	release := func() {
+		if releaser, ok := h.(fs.HandleReleaser); ok {
+			releaser.Release(req.ctx, &fuse.ReleaseRequest{Header: req.header, Dir: dir, Flags: flags})
+		}
+	}
+	return h, release, nil
+}
+
+// heldContent returns the content of the file with handle h held from a
+// recent read by the caller, if any.
+func (s *Server) heldContent(req *request, h handle) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, found := s.contents[contentKey{handle: h, uid: req.header.Uid}]
+	if !found {
+		return nil, false
+	}
+	return c.data, true
+}
+
+// content returns the content of the file e for the caller, reading it if
+// it isn't held from a recent read.
+func (s *Server) content(req *request, h handle, e *entry) ([]byte, error) {
+	if data, held := s.heldContent(req, h); held {
+		return data, nil
+	}
+	key := contentKey{handle: h, uid: req.header.Uid}
+
+	fh, release, err := s.open(req, e, false)
+	if err != nil {
+		return nil, err
+	}
+	data, err := readContent(req, fh)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, found := s.contents[key]; found {
+		// Read concurrently, keep the first.
+		release()
+		return c.data, nil
+	}
+	s.contents[key] = &content{data: data, release: release, read: time.Now()}
+	return data, nil
+}
+
+// readContent reads the whole content of the file handle fh.
+func readContent(req *request, fh fs.Handle) ([]byte, error) {
+	switch h := fh.(type) {
+	case fs.HandleReadAller:
+		return h.ReadAll(req.ctx)
+	case fs.HandleReader:
+		const chunk = 64 << 10
+		var data []byte
+		for {
+			if len(data) > maxContent {
+				return nil, fuse.Errno(syscall.EFBIG)
+			}
+			resp := &fuse.ReadResponse{Data: make([]byte, 0, chunk)}
+			if err := h.Read(req.ctx, &fuse.ReadRequest{Header: req.header, Offset: int64(len(data)), Size: chunk}, resp); err != nil {
+				return nil, err
+			}
+			data = append(data, resp.Data...)
+			if len(resp.Data) < chunk {
+				return data, nil
+			}
+		}
+	}
+	return nil, fuse.Errno(syscall.EIO)
+}
+
+// readDir returns the entries of the directory e.
+func (s *Server) readDir(req *request, e *entry) ([]fuse.Dirent, error) {
+	dh, release, err := s.open(req, e, true)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	readDirAller, ok := dh.(fs.HandleReadDirAller)
+	if !ok {
+		return nil, fuse.Errno(syscall.ENOTDIR)
+	}
+	return readDirAller.ReadDirAll(req.ctx)
+}
+
+// expireContents releases the contents read contentTTL ago until stopped.
+func (s *Server) expireContents() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(contentTTL / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+
+		s.mu.Lock()
+		for key, c := range s.contents {
+			if time.Since(c.read) < contentTTL {
+				continue
+			}
+			delete(s.contents, key)
+			if c.release != nil {
+				go c.release()
+			}
+		}
+		s.mu.Unlock()
+	}
+}
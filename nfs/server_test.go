@@ -0,0 +1,170 @@
+package nfs
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"golang.org/x/net/context"
+)
+
+// testDir is a directory whose children are testFiles made on lookup.
+type testDir struct {
+	opens *int32
+}
+
+func (d testDir) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Mode = os.ModeDir | 0555
+	return nil
+}
+
+func (d testDir) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	return testFile{content: []byte("value of " + name), opens: d.opens}, nil
+}
+
+// testFile is a file generating its content, of unknown size, when opened.
+type testFile struct {
+	content []byte
+	opens   *int32
+}
+
+func (f testFile) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Mode = 0444
+	return nil
+}
+
+func (f testFile) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	atomic.AddInt32(f.opens, 1)
+	return f, nil
+}
+
+func (f testFile) ReadAll(ctx context.Context) ([]byte, error) {
+	return f.content, nil
+}
+
+// call makes an NFS call of proc with the arguments written by args,
+// returning the reader of its results.
+func call(t *testing.T, s *Server, proc uint32, args func(w *xdrWriter)) *xdrReader {
+	w := callHeader(1, rpcVersion, nfsProgram, nfsVersion, proc, authSys, authSysCred(1000, 1000))
+	args(w)
+	reply := s.handleMessage(w.Bytes())
+	r := &xdrReader{buf: reply}
+	r.next(6 * 4) // xid, message type, reply stat, verifier, accept stat
+	if r.err != nil {
+		t.Fatalf("expected a reply, got %v", reply)
+	}
+	return r
+}
+
+// lookup looks name up in the root, returning its handle.
+func lookup(t *testing.T, s *Server, name string) []byte {
+	r := call(t, s, 3, func(w *xdrWriter) {
+		w.opaque(s.root[:])
+		w.string(name)
+	})
+	if stat := r.uint32(); stat != nfs3OK {
+		t.Fatalf("expected %s to be found, got %d", name, stat)
+	}
+	return r.opaque(handleSize)
+}
+
+// getattr returns the status and size of the node with handle fh.
+func getattr(t *testing.T, s *Server, fh []byte) (uint32, uint64) {
+	r := call(t, s, 1, func(w *xdrWriter) { w.opaque(fh) })
+	stat := r.uint32()
+	if stat != nfs3OK {
+		return stat, 0
+	}
+	r.next(5 * 4) // type, mode, nlink, uid, gid
+	return stat, r.uint64()
+}
+
+func TestGetattrDoesNotRead(t *testing.T) {
+	var opens int32
+	s := NewServer(testDir{opens: &opens}, nil, nil)
+	defer s.Close()
+
+	fh := lookup(t, s, "app")
+	if stat, size := getattr(t, s, fh); stat != nfs3OK || size != unknownSize {
+		t.Errorf("expected the unknown size to be reported, got %d %d", stat, size)
+	}
+	if opens != 0 {
+		t.Errorf("expected getattr not to open the file, got %d opens", opens)
+	}
+
+	r := call(t, s, 6, func(w *xdrWriter) {
+		w.opaque(fh)
+		w.uint64(0)
+		w.uint32(maxRead)
+	})
+	if stat := r.uint32(); stat != nfs3OK {
+		t.Fatalf("expected the read to succeed, got %d", stat)
+	}
+	if opens != 1 {
+		t.Errorf("expected the read to open the file once, got %d opens", opens)
+	}
+	if stat, size := getattr(t, s, fh); stat != nfs3OK || size != uint64(len("value of app")) {
+		t.Errorf("expected the size of the content read, got %d %d", stat, size)
+	}
+	if opens != 1 {
+		t.Errorf("expected getattr to use the content read, got %d opens", opens)
+	}
+}
+
+func TestHandlesEvicted(t *testing.T) {
+	var opens int32
+	s := NewServer(testDir{opens: &opens}, nil, nil)
+	defer s.Close()
+
+	first := lookup(t, s, "0")
+	for i := 1; i <= maxEntries; i++ {
+		h := handleOf(fmt.Sprintf("/%d", i))
+		s.hold(&entry{handle: h, path: fmt.Sprintf("/%d", i), node: testFile{opens: &opens}})
+	}
+	if len(s.entries) != maxEntries || s.lru.Len() != maxEntries {
+		t.Errorf("expected at most %d handles held, got %d", maxEntries, len(s.entries))
+	}
+	if stat, _ := getattr(t, s, first); stat != nfs3ErrStale {
+		t.Errorf("expected the least recently used handle to be stale, got %d", stat)
+	}
+	if stat, _ := getattr(t, s, s.root[:]); stat != nfs3OK {
+		t.Errorf("expected the root never to be dropped, got %d", stat)
+	}
+	if stat, _ := getattr(t, s, lookup(t, s, "0")); stat != nfs3OK {
+		t.Errorf("expected a dropped handle to be valid once looked up again, got %d", stat)
+	}
+}
+
+func TestHandleMessageMalformed(t *testing.T) {
+	var opens int32
+	s := NewServer(testDir{opens: &opens}, nil, nil)
+	defer s.Close()
+
+	w := callHeader(1, rpcVersion, nfsProgram, nfsVersion, 3, authSys, authSysCred(1000, 1000))
+	w.opaque(s.root[:])
+	w.string("app")
+	message := w.Bytes()
+	for n := 0; n < len(message); n++ {
+		s.handleMessage(message[:n])
+	}
+
+	for _, c := range []struct {
+		name     string
+		message  []byte
+		expected uint32
+	}{
+		{"truncated arguments", message[:len(message)-4], acceptGarbageArgs},
+		{"unknown procedure", callHeader(1, rpcVersion, nfsProgram, nfsVersion, 22, authNone, nil).Bytes(), acceptProcUnavail},
+		{"unknown program", callHeader(1, rpcVersion, 1, 1, 0, authNone, nil).Bytes(), acceptProgUnavail},
+		{"unsupported version", callHeader(1, rpcVersion, nfsProgram, 4, 0, authNone, nil).Bytes(), acceptProgMismatch},
+	} {
+		r := &xdrReader{buf: s.handleMessage(c.message)}
+		r.next(5 * 4)
+		if stat := r.uint32(); stat != c.expected || r.err != nil {
+			t.Errorf("%s: expected accept stat %d, got %d (%v)", c.name, c.expected, stat, r.err)
+		}
+	}
+}
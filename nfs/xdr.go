@@ -0,0 +1,114 @@
+package nfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// errGarbage is the error of decoding malformed arguments.
+var errGarbage = errors.New("malformed XDR")
+
+// xdrReader decodes XDR (RFC 4506) values. The first error is kept and
+// every later read returns zero values, so it only needs checking once the
+// arguments are decoded.
+type xdrReader struct {
+	buf []byte
+	err error
+}
+
+func (r *xdrReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || len(r.buf) < n {
+		r.err = errGarbage
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *xdrReader) uint32() uint32 {
+	b := r.next(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (r *xdrReader) uint64() uint64 {
+	b := r.next(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+// opaque reads variable length opaque data of at most max bytes.
+func (r *xdrReader) opaque(max int) []byte {
+	n := r.uint32()
+	if r.err == nil && n > uint32(max) {
+		r.err = errGarbage
+		return nil
+	}
+	b := r.next(int(n))
+	r.next(pad(int(n)))
+	return b
+}
+
+func (r *xdrReader) string(max int) string {
+	return string(r.opaque(max))
+}
+
+// fixed reads fixed length opaque data of n bytes.
+func (r *xdrReader) fixed(n int) []byte {
+	b := r.next(n)
+	r.next(pad(n))
+	return b
+}
+
+// xdrWriter encodes XDR values.
+type xdrWriter struct {
+	bytes.Buffer
+}
+
+func (w *xdrWriter) uint32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	w.Write(b[:])
+}
+
+func (w *xdrWriter) uint64(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	w.Write(b[:])
+}
+
+func (w *xdrWriter) bool(v bool) {
+	if v {
+		w.uint32(1)
+	} else {
+		w.uint32(0)
+	}
+}
+
+func (w *xdrWriter) opaque(b []byte) {
+	w.uint32(uint32(len(b)))
+	w.fixed(b)
+}
+
+func (w *xdrWriter) string(s string) {
+	w.opaque([]byte(s))
+}
+
+func (w *xdrWriter) fixed(b []byte) {
+	w.Write(b)
+	w.Write(make([]byte, pad(len(b))))
+}
+
+// pad returns the padding following n bytes of opaque data.
+func pad(n int) int {
+	return (4 - n%4) % 4
+}
@@ -0,0 +1,169 @@
+package secretset
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-errors/errors"
+	"github.com/hashicorp/errwrap"
+	"github.com/wrouesnel/vaultfs/vaultapi"
+)
+
+// ExportOptions controls the files written by Export.
+type ExportOptions struct {
+	// Mode is the file mode of the exported files. Directories get the same
+	// mode with search permission added wherever read permission is given.
+	Mode os.FileMode
+	// UID and GID own the exported files and directories. -1 leaves them
+	// owned by the current user or group.
+	UID int
+	GID int
+}
+
+// Export writes every secret beneath the Vault path root into dir, with a
+// directory per secret holding a file per data key. Files whose content is
+// unchanged are left alone, and files of secrets or keys no longer in Vault are
+// removed. It reports whether anything in dir changed.
+func Export(logical vaultapi.Logical, root string, dir string, options ExportOptions) (bool, error) {
+	e := &exporter{
+		logical: logical,
+		options: options,
+		written: make(map[string]bool),
+	}
+	if err := os.MkdirAll(dir, e.dirMode()); err != nil {
+		return false, err
+	}
+	if err := e.export(strings.Trim(root, "/"), dir); err != nil {
+		return false, err
+	}
+	if err := e.prune(dir); err != nil {
+		return e.changed, err
+	}
+	return e.changed, e.chown(dir)
+}
+
+// exporter holds the state of a single Export.
+type exporter struct {
+	logical vaultapi.Logical
+	options ExportOptions
+	written map[string]bool
+	changed bool
+}
+
+// export writes the secret at vaultPath, and those beneath it, into dir.
+func (e *exporter) export(vaultPath string, dir string) error {
+	// Either reading or listing may be denied where the other is allowed.
+	secret, readErr := e.logical.Read(vaultPath)
+	if readErr != nil && !errwrap.ContainsType(readErr, vaultapi.ErrPermissionDenied{}) {
+		return errors.WrapPrefix(readErr, vaultPath, 0)
+	}
+	for key, value := range vaultapi.SecretData(secret) {
+		if !validName(key) {
+			return errors.Errorf("%s: key can't be a filename: %s", vaultPath, key)
+		}
+		if err := e.write(filepath.Join(dir, key), []byte(stringValue(value))); err != nil {
+			return err
+		}
+	}
+
+	// KV v2 secrets are listed through their metadata path.
+	listPath := vaultPath
+	if metadataPath, ok := vaultapi.KVv2MetadataPath(vaultPath); ok {
+		listPath = metadataPath
+	}
+	list, err := e.logical.List(listPath)
+	if err != nil && (readErr != nil || !errwrap.ContainsType(err, vaultapi.ErrPermissionDenied{})) {
+		return errors.WrapPrefix(err, vaultPath, 0)
+	}
+	if list == nil {
+		return nil
+	}
+	keys, _ := list.Data["keys"].([]interface{})
+	for _, key := range keys {
+		name, _ := key.(string)
+		name = strings.TrimSuffix(name, "/")
+		if !validName(name) {
+			return errors.Errorf("%s: secret can't be a filename: %s", vaultPath, name)
+		}
+		if err := e.export(path.Join(vaultPath, name), filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write writes content to filename, unless it already holds it.
+func (e *exporter) write(filename string, content []byte) error {
+	e.written[filename] = true
+
+	if existing, err := ioutil.ReadFile(filename); err == nil && bytes.Equal(existing, content) {
+		if st, err := os.Stat(filename); err == nil && st.Mode().Perm() == e.options.Mode {
+			return nil
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), e.dirMode()); err != nil {
+		return err
+	}
+	e.changed = true
+	return writeFileAtomic(filename, content, e.options.Mode)
+}
+
+// prune removes the files beneath dir which weren't written, and the
+// directories left empty.
+func (e *exporter) prune(dir string) error {
+	var dirs []string
+	err := filepath.Walk(dir, func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if filename != dir {
+				dirs = append(dirs, filename)
+			}
+			return nil
+		}
+		if e.written[filename] {
+			return nil
+		}
+		e.changed = true
+		return os.Remove(filename)
+	})
+	if err != nil {
+		return err
+	}
+	// Remove the deepest directories first, ignoring those not empty.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if os.Remove(dirs[i]) == nil {
+			e.changed = true
+		}
+	}
+	return nil
+}
+
+// chown sets the owner of dir and everything beneath it.
+func (e *exporter) chown(dir string) error {
+	if e.options.UID < 0 && e.options.GID < 0 {
+		return nil
+	}
+	return filepath.Walk(dir, func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(filename, e.options.UID, e.options.GID)
+	})
+}
+
+// dirMode returns the mode of exported directories.
+func (e *exporter) dirMode() os.FileMode {
+	mode := e.options.Mode
+	return mode | (mode&0444)>>2
+}
+
+// validName reports whether name is usable as a single path component.
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.Contains(name, "/")
+}
@@ -0,0 +1,187 @@
+package secretset
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-errors/errors"
+	"github.com/wrouesnel/vaultfs/vaultapi"
+)
+
+// fetch reads the data of a secret from Vault.
+func (s *Secret) fetch(logical vaultapi.Logical) (map[string]interface{}, error) {
+	secret, err := logical.Read(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return nil, errors.Errorf("%s: secret not found", s.Path)
+	}
+	if s.Key != "" {
+		if _, found := secret.Data[s.Key]; !found {
+			return nil, errors.Errorf("%s: key not found: %s", s.Path, s.Key)
+		}
+	}
+	return secret.Data, nil
+}
+
+// Render renders the given secret data with the secret's renderer.
+func (s *Secret) Render(data map[string]interface{}) ([]byte, error) {
+	switch s.Renderer {
+	case RenderValue:
+		return []byte(stringValue(data[s.Key])), nil
+	case RenderJSON:
+		var v interface{} = data
+		if s.Key != "" {
+			v = data[s.Key]
+		}
+		out, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(out, '\n'), nil
+	case RenderEnv:
+		buf := new(bytes.Buffer)
+		for _, pair := range s.envPairs(data) {
+			fmt.Fprintln(buf, pair)
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, errors.Errorf("unknown renderer %q", s.Renderer)
+}
+
+// envPairs renders data as NAME="value" pairs sorted by name, where names
+// are the upper-cased data keys.
+func (s *Secret) envPairs(data map[string]interface{}) []string {
+	if s.Key != "" {
+		data = map[string]interface{}{s.Key: data[s.Key]}
+	}
+
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, envName(k)+"="+strconv.Quote(stringValue(data[k])))
+	}
+	return pairs
+}
+
+// Materialize reads every secret in the spec that has a File and writes it
+// atomically beneath dir.
+func (s *Spec) Materialize(logical vaultapi.Logical, dir string) error {
+	for _, secret := range s.Secrets {
+		if secret.File == "" {
+			continue
+		}
+
+		data, err := secret.fetch(logical)
+		if err != nil {
+			return err
+		}
+		content, err := secret.Render(data)
+		if err != nil {
+			return err
+		}
+		mode, _ := secret.FileMode()
+		if err := writeFileAtomic(filepath.Join(dir, secret.File), content, mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Environ reads every secret in the spec that has an Env and returns the
+// resulting NAME=value environment entries.
+func (s *Spec) Environ(logical vaultapi.Logical) ([]string, error) {
+	env := []string{}
+	for _, secret := range s.Secrets {
+		if secret.Env == "" {
+			continue
+		}
+
+		data, err := secret.fetch(logical)
+		if err != nil {
+			return nil, err
+		}
+
+		if secret.Key != "" {
+			env = append(env, secret.Env+"="+stringValue(data[secret.Key]))
+			continue
+		}
+
+		keys := make([]string, 0, len(data))
+		for k := range data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			env = append(env, envName(secret.Env+k)+"="+stringValue(data[k]))
+		}
+	}
+	return env, nil
+}
+
+// stringValue renders a secret data value as a string. Strings are returned
+// as-is, anything else is JSON encoded.
+func stringValue(v interface{}) string {
+	if str, ok := v.(string); ok {
+		return str
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(out)
+}
+
+// envName converts a data key into a valid environment variable name.
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// writeFileAtomic writes content to a temporary file beside filename and
+// renames it into place, so readers never observe a partial secret.
+func writeFileAtomic(filename string, content []byte, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
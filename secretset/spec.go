@@ -0,0 +1,125 @@
+// Package secretset implements the declarative secret set specification
+// shared by the non-FUSE ways of consuming secrets (sync, export, exec and docker
+// tmpfs volumes). A spec lists which secrets to materialize, under what
+// filenames or environment variables, with which renderer and file mode.
+//
+// In the config file a spec looks like:
+//
+//	secrets:
+//	  - path: secret/app/db
+//	    key: password
+//	    file: db_password
+//	    env: DB_PASSWORD
+//	  - path: secret/app/config
+//	    file: config.json
+//	    render: json
+//	    mode: "0440"
+package secretset
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-errors/errors"
+)
+
+// Renderers understood by a Secret.
+const (
+	// RenderValue renders a single data key as its raw value.
+	RenderValue = "value"
+	// RenderJSON renders the secret data (or a single key) as indented JSON.
+	RenderJSON = "json"
+	// RenderEnv renders the secret data as sorted KEY="value" lines.
+	RenderEnv = "env"
+)
+
+// defaultMode is the file mode used when a Secret does not specify one.
+const defaultMode = os.FileMode(0400)
+
+// Spec is a set of secrets to materialize.
+type Spec struct {
+	Secrets []Secret `mapstructure:"secrets"`
+}
+
+// Secret describes how to materialize a single Vault secret.
+type Secret struct {
+	// Path is the Vault path to read.
+	Path string `mapstructure:"path"`
+	// Key selects a single data key. Empty means the whole secret.
+	Key string `mapstructure:"key"`
+	// File is the filename, relative to the target directory, to write the
+	// rendered secret to. Empty means the secret is not written to a file.
+	File string `mapstructure:"file"`
+	// Env is the environment variable to export the secret as. If Key is
+	// empty it is used as a prefix for one variable per data key.
+	Env string `mapstructure:"env"`
+	// Renderer is the renderer used for File. Defaults to value if Key is set,
+	// and json otherwise.
+	Renderer string `mapstructure:"render"`
+	// Mode is the octal file mode for File. Defaults to 0400.
+	Mode string `mapstructure:"mode"`
+}
+
+// Validate checks the spec and fills in defaults.
+func (s *Spec) Validate() error {
+	for i := range s.Secrets {
+		if err := s.Secrets[i].validate(); err != nil {
+			return errors.WrapPrefix(err, "secret "+strconv.Itoa(i), 0)
+		}
+	}
+	return nil
+}
+
+func (s *Secret) validate() error {
+	if s.Path == "" {
+		return errors.New("path is required")
+	}
+	if s.File == "" && s.Env == "" {
+		return errors.Errorf("%s: one of file or env is required", s.Path)
+	}
+
+	if s.Renderer == "" {
+		if s.Key != "" {
+			s.Renderer = RenderValue
+		} else {
+			s.Renderer = RenderJSON
+		}
+	}
+	switch s.Renderer {
+	case RenderValue:
+		if s.Key == "" {
+			return errors.Errorf("%s: the value renderer requires a key", s.Path)
+		}
+	case RenderJSON, RenderEnv:
+	default:
+		return errors.Errorf("%s: unknown renderer %q", s.Path, s.Renderer)
+	}
+
+	if s.File != "" {
+		clean := filepath.Clean(s.File)
+		if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+			return errors.Errorf("%s: file must be relative to the target directory: %s", s.Path, s.File)
+		}
+		s.File = clean
+	}
+
+	if _, err := s.FileMode(); err != nil {
+		return errors.Errorf("%s: invalid mode %q", s.Path, s.Mode)
+	}
+
+	return nil
+}
+
+// FileMode returns the parsed file mode of the secret.
+func (s *Secret) FileMode() (os.FileMode, error) {
+	if s.Mode == "" {
+		return defaultMode, nil
+	}
+	mode, err := strconv.ParseUint(s.Mode, 8, 32)
+	if err != nil {
+		return 0, err
+	}
+	return os.FileMode(mode) & os.ModePerm, nil
+}
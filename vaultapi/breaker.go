@@ -0,0 +1,166 @@
+package vaultapi
+
+import (
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/api"
+)
+
+// ensure BreakerLogical implements Logical at compile-time.
+var _ = Logical(&BreakerLogical{})
+
+// ErrCircuitOpen is returned without making a request while the circuit
+// breaker is open.
+type ErrCircuitOpen struct{}
+
+// Error implements the error interface
+func (err ErrCircuitOpen) Error() string {
+	return "vault is failing, not sending requests"
+}
+
+// BreakerConfig configures the circuit breaker, which stops requests being
+// made to a failing Vault for a while.
+type BreakerConfig struct {
+	// Threshold is the number of consecutive failed requests which open the
+	// circuit. Zero disables the breaker.
+	Threshold int
+	// Cooldown is how long the circuit stays open before requests are tried
+	// again.
+	Cooldown time.Duration
+}
+
+// Enabled returns true if the circuit breaker is configured.
+func (c BreakerConfig) Enabled() bool {
+	return c.Threshold > 0
+}
+
+// serverErrorCode matches the status line of 5xx errors from the api package.
+var serverErrorCode = regexp.MustCompile(`Code: 5\d\d\.`)
+
+// isBackendFailure returns true if err means Vault is failing, rather than
+// refusing the request: it couldn't be reached or returned a server error.
+func isBackendFailure(err error) bool {
+	if isConnectionError(err) {
+		return true
+	}
+	failure := false
+	errwrap.Walk(err, func(err error) {
+		if serverErrorCode.MatchString(err.Error()) {
+			failure = true
+		}
+	})
+	return failure
+}
+
+// BreakerLogical is a Logical which fails requests immediately, with
+// ErrCircuitOpen, for a cooldown after enough consecutive requests to the
+// underlying backend have failed, rather than leaving every caller waiting for
+// Vault to time out. Once the cooldown passes requests are let through again,
+// and the first to fail opens the circuit again.
+type BreakerLogical struct {
+	backend Logical
+	config  BreakerConfig
+	*circuit
+}
+
+// circuit is the state of a BreakerLogical, and of those it was made from by
+// WithBackend.
+type circuit struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+// NewBreakerLogical wraps backend in a circuit breaker with the given config.
+func NewBreakerLogical(backend Logical, config BreakerConfig) *BreakerLogical {
+	return &BreakerLogical{
+		backend: backend,
+		config:  config,
+		circuit: &circuit{},
+	}
+}
+
+// WithBackend returns a BreakerLogical of backend sharing the circuit of b, so
+// failures of either open it for both.
+func (b *BreakerLogical) WithBackend(backend Logical) *BreakerLogical {
+	return &BreakerLogical{backend: backend, config: b.config, circuit: b.circuit}
+}
+
+// allow returns an error if the circuit is open.
+func (b *BreakerLogical) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if time.Now().Before(b.openUntil) {
+		return ErrVaultInaccessible{ErrCircuitOpen{}}
+	}
+	return nil
+}
+
+// record notes the outcome of a request, opening the circuit once the
+// threshold of consecutive failures is reached.
+func (b *BreakerLogical) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil || !isBackendFailure(err) {
+		if b.failures >= b.config.Threshold {
+			safeLog().Info("vault requests are succeeding again, closing the circuit")
+		}
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.failures >= b.config.Threshold {
+		b.openUntil = time.Now().Add(b.config.Cooldown)
+		safeLog().WithError(err).WithField("cooldown", b.config.Cooldown).Warn("vault requests are failing, opening the circuit")
+	}
+}
+
+// do makes a request through the breaker.
+func (b *BreakerLogical) do(request func() (*api.Secret, error)) (*api.Secret, error) {
+	if err := b.allow(); err != nil {
+		return nil, err
+	}
+	secret, err := request()
+	b.record(err)
+	return secret, err
+}
+
+// Read implements Logical
+func (b *BreakerLogical) Read(path string) (*api.Secret, error) {
+	return b.do(func() (*api.Secret, error) { return b.backend.Read(path) })
+}
+
+// ReadDynamic implements Logical
+func (b *BreakerLogical) ReadDynamic(path string) (*api.Secret, error) {
+	return b.do(func() (*api.Secret, error) { return b.backend.ReadDynamic(path) })
+}
+
+// ReadWrapped implements Logical
+func (b *BreakerLogical) ReadWrapped(path string, wrapTTL time.Duration) (*api.Secret, error) {
+	return b.do(func() (*api.Secret, error) { return b.backend.ReadWrapped(path, wrapTTL) })
+}
+
+// List implements Logical
+func (b *BreakerLogical) List(path string) (*api.Secret, error) {
+	return b.do(func() (*api.Secret, error) { return b.backend.List(path) })
+}
+
+// Write implements Logical
+func (b *BreakerLogical) Write(path string, data map[string]interface{}) (*api.Secret, error) {
+	return b.do(func() (*api.Secret, error) { return b.backend.Write(path, data) })
+}
+
+// Delete implements Logical
+func (b *BreakerLogical) Delete(path string) (*api.Secret, error) {
+	return b.do(func() (*api.Secret, error) { return b.backend.Delete(path) })
+}
+
+// Unwrap implements Logical
+func (b *BreakerLogical) Unwrap(wrappingToken string) (*api.Secret, error) {
+	return b.do(func() (*api.Secret, error) { return b.backend.Unwrap(wrappingToken) })
+}
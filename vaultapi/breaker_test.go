@@ -0,0 +1,102 @@
+package vaultapi
+
+import (
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/hashicorp/errwrap"
+)
+
+func TestIsBackendFailure(t *testing.T) {
+	refused := &url.Error{Op: "Get", URL: "https://vault:8200/v1/secret/app", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connect: connection refused")}}
+	for _, c := range []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"internal error", apiError("500", "internal error"), true},
+		{"sealed", apiError("503", "Vault is sealed"), true},
+		{"wrapped server error", errwrap.Wrapf("reading secret/app: {{err}}", apiError("502", "bad gateway")), true},
+		{"connection refused", refused, true},
+		{"wrapped connection refused", errwrap.Wrapf("reading secret/app: {{err}}", refused), true},
+		{"permission denied", apiError("403", "permission denied"), false},
+		{"bad request", apiError("400", "invalid path"), false},
+		{"not found", apiError("404", "not found"), false},
+		{"short code", apiError("50", "internal error"), false},
+		{"long code", errors.New("Code: 5000 Errors:"), false},
+		{"code in message", apiError("400", "Code: 50"), false},
+		{"plain error", errors.New("something failed"), false},
+	} {
+		if failure := isBackendFailure(c.err); failure != c.expected {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expected, failure)
+		}
+	}
+}
+
+// readThrough reads secret/app through b, returning whether the circuit was
+// open.
+func readThrough(b *BreakerLogical) bool {
+	_, err := b.Read("secret/app")
+	return errwrap.ContainsType(err, ErrCircuitOpen{})
+}
+
+func TestBreakerTransitions(t *testing.T) {
+	backend := &flakyLogical{err: apiError("503", "Vault is sealed")}
+	b := NewBreakerLogical(backend, BreakerConfig{Threshold: 3, Cooldown: 20 * time.Millisecond})
+
+	// Denials don't count towards the threshold, and reset the count.
+	readThrough(b)
+	readThrough(b)
+	backend.err = apiError("403", "permission denied")
+	readThrough(b)
+	backend.err = apiError("503", "Vault is sealed")
+	readThrough(b)
+	if open := readThrough(b); open || backend.requests != 5 {
+		t.Fatalf("expected the circuit to stay closed below the threshold, got open %v after %d requests", open, backend.requests)
+	}
+
+	// The threshold opens the circuit, which fails requests without making
+	// them.
+	readThrough(b)
+	if open := readThrough(b); !open || backend.requests != 6 {
+		t.Fatalf("expected the circuit to open at the threshold, got open %v after %d requests", open, backend.requests)
+	}
+	if _, err := b.Read("secret/app"); !errwrap.ContainsType(err, ErrVaultInaccessible{}) {
+		t.Errorf("expected the open circuit to report Vault as inaccessible, got %v", err)
+	}
+
+	// After the cooldown a request is let through, and its failure opens the
+	// circuit again at once.
+	time.Sleep(30 * time.Millisecond)
+	if open := readThrough(b); open || backend.requests != 7 {
+		t.Fatalf("expected a request after the cooldown, got open %v after %d requests", open, backend.requests)
+	}
+	if open := readThrough(b); !open || backend.requests != 7 {
+		t.Fatalf("expected a failure after the cooldown to reopen the circuit, got open %v after %d requests", open, backend.requests)
+	}
+
+	// A success after the cooldown closes it, and the threshold applies again.
+	time.Sleep(30 * time.Millisecond)
+	backend.err = nil
+	readThrough(b)
+	backend.err = apiError("500", "internal error")
+	readThrough(b)
+	if open := readThrough(b); open || backend.requests != 10 {
+		t.Errorf("expected a success to close the circuit, got open %v after %d requests", open, backend.requests)
+	}
+}
+
+func TestBreakerWithBackend(t *testing.T) {
+	backend := &flakyLogical{err: apiError("503", "Vault is sealed")}
+	b := NewBreakerLogical(backend, BreakerConfig{Threshold: 1, Cooldown: time.Hour})
+	other := &flakyLogical{}
+	shared := b.WithBackend(other)
+
+	readThrough(b)
+	if open := readThrough(shared); !open || other.requests != 0 {
+		t.Errorf("expected the circuit to be shared, got open %v after %d requests", open, other.requests)
+	}
+}
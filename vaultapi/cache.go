@@ -0,0 +1,412 @@
+package vaultapi
+
+import (
+	"container/list"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// ensure CachedLogical implements Logical at compile-time.
+var _ = Logical(&CachedLogical{})
+
+// CacheConfig configures the in-memory response cache.
+type CacheConfig struct {
+	// MaxEntries bounds the number of cached responses. Zero means unbounded.
+	MaxEntries int
+	// TTL is how long a found response is served from the cache. Zero
+	// disables caching of found responses.
+	TTL time.Duration
+	// StaleWhileRevalidate is a window past the TTL in which a stale entry is
+	// still served while it is refreshed in the background.
+	StaleWhileRevalidate time.Duration
+	// NegativeTTL is how long a not-found response is cached. Zero disables
+	// negative caching.
+	NegativeTTL time.Duration
+	// MaxStaleness bounds the age of any response served from the cache,
+	// including stale-while-revalidate. Older entries are revalidated
+	// synchronously before being served. Zero means no bound.
+	MaxStaleness time.Duration
+
+	// DiskDir, if set, is a directory to keep the last responses in, to be
+	// served while Vault is unreachable (see DiskCachedLogical).
+	DiskDir string
+	// DiskKeyFile is read for the key the disk cache is encrypted with. If it
+	// is not set a random key is kept in DiskDir.
+	DiskKeyFile string
+}
+
+// Enabled returns true if the configuration describes an active cache.
+func (c CacheConfig) Enabled() bool {
+	return c.TTL > 0 || c.NegativeTTL > 0
+}
+
+// CacheStats is a snapshot of the cache counters.
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Stale     uint64 // Hits served stale while a refresh was in progress
+	Negative  uint64 // Hits on cached not-found responses
+	Evictions uint64
+	Entries   int
+
+	// Revalidations of entries older than MaxStaleness, and how many of them
+	// were found unchanged by comparing KV v2 versions.
+	Revalidations uint64
+	Unchanged     uint64
+}
+
+// cacheEntry is a cached response. The secret is held encoded in a
+// SecureBuffer, which is zeroed when the entry is dropped, and decoded afresh
+// for each hit. The decoded copies handed out can't be zeroed, but only live
+// as long as the callers keep them.
+type cacheEntry struct {
+	key        string
+	sealed     *SecureBuffer // nil for a cached not-found response
+	fetched    time.Time
+	refreshing bool
+}
+
+// CachedLogical is a Logical which caches successful Read and List responses
+// from an underlying backend. Writes and deletes pass through and invalidate
+// any affected entries.
+type CachedLogical struct {
+	backend Logical
+	config  CacheConfig
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // front is most recently used
+
+	// Fetches only store their result if the key wasn't invalidated (or the
+	// cache flushed) since they began, as of the clock, which ticks on each
+	// invalidation. Invalidations are only recorded while fetches are in
+	// flight.
+	clock       uint64
+	flushed     uint64
+	invalidated map[string]uint64
+	fetching    int
+
+	hits      uint64
+	misses    uint64
+	stale     uint64
+	negative  uint64
+	revalids  uint64
+	unchanged uint64
+	evictions uint64
+}
+
+// NewCachedLogical wraps backend in a response cache with the given config.
+func NewCachedLogical(backend Logical, config CacheConfig) *CachedLogical {
+	return &CachedLogical{
+		backend: backend,
+		config:  config,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+
+		invalidated: make(map[string]uint64),
+	}
+}
+
+// Stats returns a snapshot of the cache counters.
+func (c *CachedLogical) Stats() CacheStats {
+	c.mu.Lock()
+	entries := c.lru.Len()
+	c.mu.Unlock()
+
+	return CacheStats{
+		Hits:     atomic.LoadUint64(&c.hits),
+		Misses:   atomic.LoadUint64(&c.misses),
+		Stale:    atomic.LoadUint64(&c.stale),
+		Negative: atomic.LoadUint64(&c.negative),
+
+		Revalidations: atomic.LoadUint64(&c.revalids),
+		Unchanged:     atomic.LoadUint64(&c.unchanged),
+		Evictions:     atomic.LoadUint64(&c.evictions),
+		Entries:       entries,
+	}
+}
+
+// Flush drops every cached response, zeroing them.
+func (c *CachedLogical) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for elem := c.lru.Front(); elem != nil; elem = elem.Next() {
+		elem.Value.(*cacheEntry).destroy()
+	}
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+	c.clock++
+	c.flushed = c.clock
+}
+
+// Invalidate drops any cached responses for the given path, including the
+// listing of its parent, and for a KV v2 data path those of its metadata path
+// too. Fetches in flight for them don't store their results.
+func (c *CachedLogical) Invalidate(p string) {
+	keys := invalidatedKeys(p)
+	if metadataPath, ok := KVv2MetadataPath(p); ok {
+		keys = append(keys, invalidatedKeys(metadataPath)...)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clock++
+	for _, key := range keys {
+		c.remove(key)
+		if c.fetching > 0 {
+			c.invalidated[key] = c.clock
+		}
+	}
+}
+
+// invalidatedKeys returns the keys of the responses a change to p affects:
+// its own and the listing of its parent, listed with or without a trailing
+// slash.
+func invalidatedKeys(p string) []string {
+	parent := path.Dir(strings.TrimSuffix(p, "/"))
+	return []string{"read:" + p, "list:" + p, "list:" + parent, "list:" + parent + "/"}
+}
+
+// Read implements Logical
+func (c *CachedLogical) Read(path string) (*api.Secret, error) {
+	return c.cached("read:"+path, func() (*api.Secret, error) {
+		return c.backend.Read(path)
+	})
+}
+
+// ReadDynamic implements Logical. Dynamic secrets are never cached.
+func (c *CachedLogical) ReadDynamic(path string) (*api.Secret, error) {
+	return c.backend.ReadDynamic(path)
+}
+
+// ReadWrapped implements Logical. Wrapping tokens are single-use, so are never
+// cached.
+func (c *CachedLogical) ReadWrapped(path string, wrapTTL time.Duration) (*api.Secret, error) {
+	return c.backend.ReadWrapped(path, wrapTTL)
+}
+
+// List implements Logical
+func (c *CachedLogical) List(path string) (*api.Secret, error) {
+	return c.cached("list:"+path, func() (*api.Secret, error) {
+		return c.backend.List(path)
+	})
+}
+
+// Write implements Logical
+func (c *CachedLogical) Write(path string, data map[string]interface{}) (*api.Secret, error) {
+	defer c.Invalidate(path)
+	return c.backend.Write(path, data)
+}
+
+// Delete implements Logical
+func (c *CachedLogical) Delete(path string) (*api.Secret, error) {
+	defer c.Invalidate(path)
+	return c.backend.Delete(path)
+}
+
+// Unwrap implements Logical. Unwrapping is single-use and never cached.
+func (c *CachedLogical) Unwrap(wrappingToken string) (*api.Secret, error) {
+	return c.backend.Unwrap(wrappingToken)
+}
+
+// cached serves key from the cache if it is fresh (or stale but within the
+// revalidation window), and otherwise calls fetch and stores the result.
+func (c *CachedLogical) cached(key string, fetch func() (*api.Secret, error)) (*api.Secret, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	if elem, found := c.entries[key]; found {
+		entry := elem.Value.(*cacheEntry)
+		age := now.Sub(entry.fetched)
+
+		if entry.sealed == nil {
+			if age < c.config.NegativeTTL && (c.config.MaxStaleness <= 0 || age < c.config.MaxStaleness) {
+				c.lru.MoveToFront(elem)
+				c.mu.Unlock()
+				atomic.AddUint64(&c.negative, 1)
+				return nil, nil
+			}
+		} else if c.config.MaxStaleness > 0 && age >= c.config.MaxStaleness &&
+			age < c.config.TTL+c.config.StaleWhileRevalidate {
+			// Would be served, but is older than the staleness bound.
+			if secret, err := openSecret(entry.sealed); err == nil {
+				since := c.startFetch()
+				c.mu.Unlock()
+				return c.revalidate(key, secret, fetch, since)
+			}
+		} else if age < c.config.TTL {
+			if secret, err := openSecret(entry.sealed); err == nil {
+				c.lru.MoveToFront(elem)
+				c.mu.Unlock()
+				atomic.AddUint64(&c.hits, 1)
+				return secret, nil
+			}
+		} else if age < c.config.TTL+c.config.StaleWhileRevalidate {
+			if secret, err := openSecret(entry.sealed); err == nil {
+				c.lru.MoveToFront(elem)
+				if !entry.refreshing {
+					entry.refreshing = true
+					go c.refresh(key, fetch, c.startFetch())
+				}
+				c.mu.Unlock()
+				atomic.AddUint64(&c.stale, 1)
+				return secret, nil
+			}
+		}
+		c.remove(key)
+	}
+	since := c.startFetch()
+	c.mu.Unlock()
+
+	atomic.AddUint64(&c.misses, 1)
+	secret, err := fetch()
+	if err == nil {
+		c.store(key, secret, since)
+	} else {
+		c.mu.Lock()
+		c.endFetch(key, since)
+		c.mu.Unlock()
+	}
+	return secret, err
+}
+
+// revalidate synchronously checks a cached secret which has exceeded the
+// staleness bound, as of since. KV v2 secrets are revalidated by comparing
+// their version with the current version in their metadata, which avoids
+// refetching the secret value if it has not changed.
+func (c *CachedLogical) revalidate(key string, cached *api.Secret, fetch func() (*api.Secret, error), since uint64) (*api.Secret, error) {
+	atomic.AddUint64(&c.revalids, 1)
+
+	if version, ok := KVv2Version(cached); ok && strings.HasPrefix(key, "read:") {
+		if metadataPath, ok := KVv2MetadataPath(strings.TrimPrefix(key, "read:")); ok {
+			metadata, err := c.backend.Read(metadataPath)
+			if err == nil && metadata != nil && fmt.Sprintf("%v", metadata.Data["current_version"]) == version {
+				atomic.AddUint64(&c.unchanged, 1)
+				c.store(key, cached, since)
+				return cached, nil
+			}
+		}
+	}
+
+	atomic.AddUint64(&c.misses, 1)
+	secret, err := fetch()
+	if err == nil {
+		c.store(key, secret, since)
+	} else {
+		c.drop(key, since)
+	}
+	return secret, err
+}
+
+// refresh re-fetches key in the background for stale-while-revalidate, as of
+// since.
+func (c *CachedLogical) refresh(key string, fetch func() (*api.Secret, error), since uint64) {
+	secret, err := fetch()
+	if err == nil && secret != nil && c.config.TTL > 0 {
+		c.store(key, secret, since)
+		return
+	}
+
+	// Refresh failed or the key went away - drop the stale entry so the next
+	// access goes to the backend and sees the real result.
+	c.drop(key, since)
+}
+
+// startFetch records a fetch beginning, returning the clock to store its
+// result as of. Caller must hold c.mu.
+func (c *CachedLogical) startFetch() uint64 {
+	c.fetching++
+	return c.clock
+}
+
+// endFetch records a fetch ending, returning whether key was invalidated
+// since it began. Caller must hold c.mu.
+func (c *CachedLogical) endFetch(key string, since uint64) bool {
+	invalidated := c.flushed > since || c.invalidated[key] > since
+	c.fetching--
+	if c.fetching == 0 && len(c.invalidated) > 0 {
+		c.invalidated = make(map[string]uint64)
+	}
+	return invalidated
+}
+
+// drop ends a fetch of key begun at since which failed, dropping its entry.
+func (c *CachedLogical) drop(key string, since uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.endFetch(key, since)
+	c.remove(key)
+}
+
+// store ends a fetch of key begun at since, caching secret unless key was
+// invalidated in the meantime. A nil secret is a not-found response and is
+// only stored if negative caching is enabled.
+func (c *CachedLogical) store(key string, secret *api.Secret, since uint64) {
+	storable := secret == nil && c.config.NegativeTTL > 0 || secret != nil && c.config.TTL > 0
+	// Stale responses from the disk cache are only served until Vault is
+	// back.
+	if _, stale := StaleSince(secret); stale {
+		storable = false
+	}
+	var sealed *SecureBuffer
+	if storable && secret != nil {
+		var err error
+		if sealed, err = sealSecret(secret); err != nil {
+			storable = false
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if invalidated := c.endFetch(key, since); invalidated || !storable {
+		if sealed != nil {
+			sealed.Destroy()
+		}
+		return
+	}
+
+	if elem, found := c.entries[key]; found {
+		entry := elem.Value.(*cacheEntry)
+		entry.destroy()
+		entry.sealed = sealed
+		entry.fetched = time.Now()
+		entry.refreshing = false
+		c.lru.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(&cacheEntry{
+		key:     key,
+		sealed:  sealed,
+		fetched: time.Now(),
+	})
+
+	for c.config.MaxEntries > 0 && c.lru.Len() > c.config.MaxEntries {
+		oldest := c.lru.Back()
+		c.remove(oldest.Value.(*cacheEntry).key)
+		atomic.AddUint64(&c.evictions, 1)
+	}
+}
+
+// remove drops key from the cache, zeroing it. Caller must hold c.mu.
+func (c *CachedLogical) remove(key string) {
+	if elem, found := c.entries[key]; found {
+		elem.Value.(*cacheEntry).destroy()
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+	}
+}
+
+// destroy zeroes the cached secret, if any.
+func (e *cacheEntry) destroy() {
+	if e.sealed != nil {
+		e.sealed.Destroy()
+	}
+}
@@ -0,0 +1,195 @@
+package vaultapi
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// countingLogical is a Logical serving the values of secrets from a map,
+// counting the requests made. If gate is set, reads wait for it after
+// signalling started.
+type countingLogical struct {
+	Logical
+
+	mu       sync.Mutex
+	values   map[string]string
+	requests int
+	started  chan struct{}
+	gate     chan struct{}
+}
+
+func (l *countingLogical) set(path string, value string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.values[path] = value
+}
+
+func (l *countingLogical) count() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.requests
+}
+
+func (l *countingLogical) Read(path string) (*api.Secret, error) {
+	l.mu.Lock()
+	l.requests++
+	started, gate := l.started, l.gate
+	l.mu.Unlock()
+	if gate != nil {
+		close(started)
+		<-gate
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	value, found := l.values[path]
+	if !found {
+		return nil, nil
+	}
+	return &api.Secret{Data: map[string]interface{}{"value": value}}, nil
+}
+
+func (l *countingLogical) List(path string) (*api.Secret, error) {
+	return l.Read(path)
+}
+
+// value returns the value read from c at path, failing the test on error.
+func value(t *testing.T, c *CachedLogical, path string) interface{} {
+	secret, err := c.Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret == nil {
+		return nil
+	}
+	return secret.Data["value"]
+}
+
+func TestCacheTTL(t *testing.T) {
+	backend := &countingLogical{values: map[string]string{"secret/app": "v1"}}
+	c := NewCachedLogical(backend, CacheConfig{TTL: 50 * time.Millisecond})
+
+	value(t, c, "secret/app")
+	backend.set("secret/app", "v2")
+	if v := value(t, c, "secret/app"); v != "v1" || backend.count() != 1 {
+		t.Errorf("expected the cached value within the TTL, got %v after %d requests", v, backend.count())
+	}
+	time.Sleep(60 * time.Millisecond)
+	if v := value(t, c, "secret/app"); v != "v2" || backend.count() != 2 {
+		t.Errorf("expected the value to be fetched again after the TTL, got %v after %d requests", v, backend.count())
+	}
+}
+
+func TestCacheNegativeTTL(t *testing.T) {
+	backend := &countingLogical{values: map[string]string{}}
+	c := NewCachedLogical(backend, CacheConfig{TTL: time.Hour, NegativeTTL: 50 * time.Millisecond})
+
+	value(t, c, "secret/app")
+	backend.set("secret/app", "v1")
+	if v := value(t, c, "secret/app"); v != nil || backend.count() != 1 {
+		t.Errorf("expected the not-found response to be cached, got %v after %d requests", v, backend.count())
+	}
+	if stats := c.Stats(); stats.Negative != 1 {
+		t.Errorf("expected a negative hit, got %+v", stats)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if v := value(t, c, "secret/app"); v != "v1" {
+		t.Errorf("expected the secret to be found after the negative TTL, got %v", v)
+	}
+}
+
+func TestCacheMaxStaleness(t *testing.T) {
+	backend := &countingLogical{values: map[string]string{"secret/app": "v1"}}
+	c := NewCachedLogical(backend, CacheConfig{TTL: time.Hour, MaxStaleness: 50 * time.Millisecond})
+
+	value(t, c, "secret/app")
+	backend.set("secret/app", "v2")
+	time.Sleep(60 * time.Millisecond)
+	if v := value(t, c, "secret/app"); v != "v2" || backend.count() != 2 {
+		t.Errorf("expected the entry past the staleness bound to be revalidated, got %v after %d requests", v, backend.count())
+	}
+	if stats := c.Stats(); stats.Revalidations != 1 {
+		t.Errorf("expected a revalidation, got %+v", stats)
+	}
+	if v := value(t, c, "secret/app"); v != "v2" || backend.count() != 2 {
+		t.Errorf("expected the revalidated value to be cached, got %v after %d requests", v, backend.count())
+	}
+}
+
+func TestCacheInvalidateDuringRefresh(t *testing.T) {
+	backend := &countingLogical{values: map[string]string{"secret/app": "v1"}}
+	c := NewCachedLogical(backend, CacheConfig{TTL: 20 * time.Millisecond, StaleWhileRevalidate: time.Hour})
+
+	value(t, c, "secret/app")
+	time.Sleep(30 * time.Millisecond)
+
+	// The stale entry is served while it is refreshed in the background.
+	started, gate := make(chan struct{}), make(chan struct{})
+	backend.mu.Lock()
+	backend.started, backend.gate = started, gate
+	backend.mu.Unlock()
+	if v := value(t, c, "secret/app"); v != "v1" {
+		t.Fatalf("expected the stale value, got %v", v)
+	}
+	<-started
+
+	// The secret is invalidated while the refresh is in flight, which then
+	// returns the old value.
+	backend.mu.Lock()
+	backend.started, backend.gate = nil, nil
+	backend.mu.Unlock()
+	c.Invalidate("secret/app")
+	close(gate)
+
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		c.mu.Lock()
+		fetching := c.fetching
+		c.mu.Unlock()
+		if fetching == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the refresh to finish")
+		}
+	}
+	backend.set("secret/app", "v2")
+	if v := value(t, c, "secret/app"); v != "v2" {
+		t.Errorf("expected the refresh not to store its result after the invalidation, got %v", v)
+	}
+	if len(c.invalidated) != 0 {
+		t.Errorf("expected invalidations to be forgotten once no fetch is in flight, got %v", c.invalidated)
+	}
+}
+
+func TestCacheInvalidateKVv2(t *testing.T) {
+	backend := &countingLogical{values: map[string]string{
+		"secret/data/app":     "v1",
+		"secret/metadata/app": "metadata",
+		"secret/metadata":     "listing",
+		"secret/metadata/":    "listing",
+		"secret/data/other":   "v1",
+	}}
+	c := NewCachedLogical(backend, CacheConfig{TTL: time.Hour})
+	value(t, c, "secret/data/app")
+	value(t, c, "secret/metadata/app")
+	value(t, c, "secret/data/other")
+	if _, err := c.List("secret/metadata"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.List("secret/metadata/"); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Invalidate("secret/data/app")
+	for _, key := range []string{"read:secret/data/app", "read:secret/metadata/app", "list:secret/metadata", "list:secret/metadata/"} {
+		if _, found := c.entries[key]; found {
+			t.Errorf("expected %s to be invalidated", key)
+		}
+	}
+	if _, found := c.entries["read:secret/data/other"]; !found {
+		t.Errorf("expected other secrets to stay cached")
+	}
+}
@@ -0,0 +1,71 @@
+package vaultapi
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// ensure deniedLogical implements AuthableLogical at compile-time.
+var _ = AuthableLogical(deniedLogical{})
+
+// deniedLogical is an AuthableLogical which fails every request with
+// permission denied, for callers which have no credentials.
+type deniedLogical struct {
+	reason error
+}
+
+// NewDeniedLogical returns an AuthableLogical which fails every request with
+// an ErrPermissionDenied wrapping reason.
+func NewDeniedLogical(reason error) AuthableLogical {
+	return deniedLogical{reason: reason}
+}
+
+func (d deniedLogical) err() error {
+	return ErrAuth{ErrPermissionDenied{d.reason}}
+}
+
+func (d deniedLogical) Auth() error {
+	return d.err()
+}
+
+func (d deniedLogical) Token() string {
+	return ""
+}
+
+func (d deniedLogical) RenewToken() (time.Duration, error) {
+	return 0, d.err()
+}
+
+func (d deniedLogical) ReadRaw(path string, params url.Values) (map[string]interface{}, error) {
+	return nil, d.err()
+}
+
+func (d deniedLogical) Read(path string) (*api.Secret, error) {
+	return nil, d.err()
+}
+
+func (d deniedLogical) ReadDynamic(path string) (*api.Secret, error) {
+	return nil, d.err()
+}
+
+func (d deniedLogical) ReadWrapped(path string, wrapTTL time.Duration) (*api.Secret, error) {
+	return nil, d.err()
+}
+
+func (d deniedLogical) List(path string) (*api.Secret, error) {
+	return nil, d.err()
+}
+
+func (d deniedLogical) Write(path string, data map[string]interface{}) (*api.Secret, error) {
+	return nil, d.err()
+}
+
+func (d deniedLogical) Delete(path string) (*api.Secret, error) {
+	return nil, d.err()
+}
+
+func (d deniedLogical) Unwrap(wrappingToken string) (*api.Secret, error) {
+	return nil, d.err()
+}
@@ -0,0 +1,185 @@
+package vaultapi
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/hashicorp/vault/api"
+)
+
+// ensure DenyListLogical implements AuthableLogical at compile-time.
+var _ = AuthableLogical(&DenyListLogical{})
+
+// ErrDeniedPath is the reason for the ErrPermissionDenied of requests for a
+// path on the deny-list.
+type ErrDeniedPath struct {
+	Path string
+}
+
+// Error implements the error interface
+func (err ErrDeniedPath) Error() string {
+	return fmt.Sprintf("%s is on the deny-list", err.Path)
+}
+
+// DenyListLogical is an AuthableLogical which refuses requests for the paths
+// on its deny-list with ErrPermissionDenied, without making them, so that
+// those paths can never be reached through it whatever the token may access.
+// Listings have the names of denied paths removed.
+type DenyListLogical struct {
+	backend  AuthableLogical
+	patterns []string
+}
+
+// NewDenyListLogical wraps backend so that the paths matching any of patterns
+// are denied. Patterns are globs of Vault API paths, as in Vault policies (so
+// KV v2 secrets are under data/ and metadata/), and a path matching a pattern
+// denies everything beneath it. A trailing /* denies the directory itself too,
+// so that sys/* also denies listing sys/.
+func NewDenyListLogical(backend AuthableLogical, patterns []string) (*DenyListLogical, error) {
+	d := &DenyListLogical{backend: backend}
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, errors.Errorf("invalid deny pattern: %q", pattern)
+		}
+		pattern = strings.TrimSuffix(cleanPath(pattern), "/*")
+		if pattern == "" || pattern == "*" {
+			return nil, errors.Errorf("deny pattern denies every path: %q", pattern)
+		}
+		d.patterns = append(d.patterns, pattern)
+	}
+	return d, nil
+}
+
+// cleanPath returns p without empty, . or .. components, or leading and
+// trailing slashes, as Vault resolves it.
+func cleanPath(p string) string {
+	return strings.Trim(path.Clean("/"+p), "/")
+}
+
+// Denied returns true if p is on the deny-list. It is cleaned first, so that
+// e.g. secret//admin and secret/./admin are denied with secret/admin.
+func (d *DenyListLogical) Denied(p string) bool {
+	parts := strings.Split(cleanPath(p), "/")
+	for _, pattern := range d.patterns {
+		for i := len(parts); i > 0; i-- {
+			if matched, _ := path.Match(pattern, strings.Join(parts[:i], "/")); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// check returns the error of a request for p if it is denied.
+func (d *DenyListLogical) check(p string) error {
+	if d.Denied(p) {
+		return ErrPermissionDenied{ErrDeniedPath{p}}
+	}
+	return nil
+}
+
+// Auth implements AuthableLogical
+func (d *DenyListLogical) Auth() error {
+	return d.backend.Auth()
+}
+
+// Token implements AuthableLogical
+func (d *DenyListLogical) Token() string {
+	return d.backend.Token()
+}
+
+// RenewToken implements AuthableLogical
+func (d *DenyListLogical) RenewToken() (time.Duration, error) {
+	return d.backend.RenewToken()
+}
+
+// ReadRaw implements AuthableLogical
+func (d *DenyListLogical) ReadRaw(path string, params url.Values) (map[string]interface{}, error) {
+	if err := d.check(path); err != nil {
+		return nil, err
+	}
+	return d.backend.ReadRaw(path, params)
+}
+
+// Read implements Logical
+func (d *DenyListLogical) Read(path string) (*api.Secret, error) {
+	if err := d.check(path); err != nil {
+		return nil, err
+	}
+	return d.backend.Read(path)
+}
+
+// ReadDynamic implements Logical
+func (d *DenyListLogical) ReadDynamic(path string) (*api.Secret, error) {
+	if err := d.check(path); err != nil {
+		return nil, err
+	}
+	return d.backend.ReadDynamic(path)
+}
+
+// ReadWrapped implements Logical
+func (d *DenyListLogical) ReadWrapped(path string, wrapTTL time.Duration) (*api.Secret, error) {
+	if err := d.check(path); err != nil {
+		return nil, err
+	}
+	return d.backend.ReadWrapped(path, wrapTTL)
+}
+
+// List implements Logical
+func (d *DenyListLogical) List(p string) (*api.Secret, error) {
+	if err := d.check(p); err != nil {
+		return nil, err
+	}
+	secret, err := d.backend.List(p)
+	if err != nil || secret == nil {
+		return secret, err
+	}
+	keys, ok := secret.Data["keys"].([]interface{})
+	if !ok {
+		return secret, nil
+	}
+	allowed := []interface{}{}
+	for _, key := range keys {
+		if name, ok := key.(string); ok && d.Denied(path.Join(p, name)) {
+			continue
+		}
+		allowed = append(allowed, key)
+	}
+	// The backend's response may be shared, so is copied rather than changed.
+	filtered := *secret
+	filtered.Data = make(map[string]interface{}, len(secret.Data))
+	for key, value := range secret.Data {
+		filtered.Data[key] = value
+	}
+	filtered.Data["keys"] = allowed
+	return &filtered, nil
+}
+
+// Write implements Logical
+func (d *DenyListLogical) Write(path string, data map[string]interface{}) (*api.Secret, error) {
+	if err := d.check(path); err != nil {
+		return nil, err
+	}
+	return d.backend.Write(path, data)
+}
+
+// Delete implements Logical
+func (d *DenyListLogical) Delete(path string) (*api.Secret, error) {
+	if err := d.check(path); err != nil {
+		return nil, err
+	}
+	return d.backend.Delete(path)
+}
+
+// Unwrap implements Logical. Unwrapping is a request for
+// sys/wrapping/unwrap, so is denied with sys/.
+func (d *DenyListLogical) Unwrap(wrappingToken string) (*api.Secret, error) {
+	if err := d.check("sys/wrapping/unwrap"); err != nil {
+		return nil, err
+	}
+	return d.backend.Unwrap(wrappingToken)
+}
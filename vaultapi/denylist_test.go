@@ -0,0 +1,92 @@
+package vaultapi
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/api"
+)
+
+// listingLogical is an AuthableLogical listing keys at every path, and
+// recording the last path requested.
+type listingLogical struct {
+	AuthableLogical
+	keys      []interface{}
+	requested string
+}
+
+func (l *listingLogical) Read(path string) (*api.Secret, error) {
+	l.requested = path
+	return &api.Secret{Data: map[string]interface{}{"value": "v"}}, nil
+}
+
+func (l *listingLogical) List(path string) (*api.Secret, error) {
+	l.requested = path
+	return &api.Secret{Data: map[string]interface{}{"keys": l.keys}}, nil
+}
+
+func TestDenyListDenied(t *testing.T) {
+	d, err := NewDenyListLogical(nil, []string{"secret/admin", "sys/*", "/auth/token/*/", "secret/data/team-*/private"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for p, expected := range map[string]bool{
+		"secret/admin":                  true,
+		"secret/admin/db":               true,
+		"/secret/admin/":                true,
+		"secret//admin":                 true,
+		"secret/./admin":                true,
+		"secret/app/../admin":           true,
+		"./secret/admin":                true,
+		"secret/administrators":         false,
+		"secret/app":                    false,
+		"secret":                        false,
+		"sys":                           true,
+		"sys/mounts":                    true,
+		"//sys/mounts":                  true,
+		"auth/token/lookup-self":        true,
+		"auth/approle/login":            false,
+		"secret/data/team-a/private":    true,
+		"secret/data/team-a//private/x": true,
+		"secret/data/team-a/public":     false,
+	} {
+		if denied := d.Denied(p); denied != expected {
+			t.Errorf("%s: expected denied to be %v, got %v", p, expected, denied)
+		}
+	}
+}
+
+func TestDenyListInvalidPatterns(t *testing.T) {
+	for _, pattern := range []string{"", "/", "*", "/*", "secret/[", "./"} {
+		if _, err := NewDenyListLogical(nil, []string{pattern}); err == nil {
+			t.Errorf("%q: expected the pattern to be refused", pattern)
+		}
+	}
+}
+
+func TestDenyListRequests(t *testing.T) {
+	backend := &listingLogical{keys: []interface{}{"admin", "admin/", "app", "app/"}}
+	d, err := NewDenyListLogical(backend, []string{"secret/admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.Read("secret//admin/db"); !errwrap.ContainsType(err, ErrDeniedPath{}) || backend.requested != "" {
+		t.Errorf("expected the read to be refused without a request, got %v after requesting %q", err, backend.requested)
+	}
+	if _, err := d.List("secret/./admin"); !errwrap.ContainsType(err, ErrDeniedPath{}) || backend.requested != "" {
+		t.Errorf("expected the listing to be refused without a request, got %v after requesting %q", err, backend.requested)
+	}
+
+	secret, err := d.List("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := secret.Data["keys"]; !reflect.DeepEqual(keys, []interface{}{"app", "app/"}) {
+		t.Errorf("expected the denied names to be removed from the listing, got %v", keys)
+	}
+	if keys := backend.keys; len(keys) != 4 {
+		t.Errorf("expected the backend's response to be left as it was, got %v", keys)
+	}
+}
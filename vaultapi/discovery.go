@@ -0,0 +1,169 @@
+package vaultapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/go-errors/errors"
+)
+
+// Kinds of Vault address discovery, given as the scheme of a discovery
+// address.
+const (
+	// DiscoveryConsul finds the nodes of a Consul service, the active node
+	// first.
+	DiscoveryConsul = "consul"
+	// DiscoverySRV finds the targets of a DNS SRV record, in priority order.
+	DiscoverySRV = "srv"
+)
+
+// Consul agent settings, read from the environment as by the consul CLI.
+const (
+	consulAddrEnv     = "CONSUL_HTTP_ADDR"
+	consulTokenEnv    = "CONSUL_HTTP_TOKEN"
+	defaultConsulAddr = "127.0.0.1:8500"
+)
+
+// DiscoveryAddress returns the address to configure in place of a Vault
+// address to discover the nodes of the cluster from spec (consul:<service> or
+// srv:<name>). The nodes are reached with scheme (http or https).
+func DiscoveryAddress(spec string, scheme string) (string, error) {
+	if !IsDiscoveryAddress(spec) {
+		return "", errors.Errorf("discovery must be given as %s:<service> or %s:<name>: %s", DiscoveryConsul, DiscoverySRV, spec)
+	}
+	if scheme != "http" && scheme != "https" {
+		return "", errors.Errorf("invalid discovery scheme: %s", scheme)
+	}
+	return spec + "?scheme=" + scheme, nil
+}
+
+// IsDiscoveryAddress reports whether address is a discovery address rather
+// than the address of a node.
+func IsDiscoveryAddress(address string) bool {
+	return strings.HasPrefix(address, DiscoveryConsul+":") || strings.HasPrefix(address, DiscoverySRV+":")
+}
+
+// IsClusterAddress reports whether address names several nodes to fail over
+// between: a comma separated list, or a discovery address.
+func IsClusterAddress(address string) bool {
+	return IsDiscoveryAddress(address) || len(SplitAddresses(address)) > 1
+}
+
+// ResolveAddresses returns the addresses of the nodes named by address: a
+// comma separated list of them, or a discovery address which is resolved.
+func ResolveAddresses(address string) ([]string, error) {
+	if !IsDiscoveryAddress(address) {
+		return SplitAddresses(address), nil
+	}
+
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	scheme := u.Query().Get("scheme")
+	if scheme == "" {
+		scheme = "https"
+	}
+
+	var addresses []string
+	switch u.Scheme {
+	case DiscoveryConsul:
+		addresses, err = resolveConsul(u.Opaque, scheme)
+	case DiscoverySRV:
+		addresses, err = resolveSRV(u.Opaque, scheme)
+	}
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "could not discover vault", 0)
+	}
+	if len(addresses) == 0 {
+		return nil, errors.Errorf("no vault nodes found for %s", address)
+	}
+	return addresses, nil
+}
+
+// consulServiceEntry is the part of a Consul health/service entry needed to
+// reach a node.
+type consulServiceEntry struct {
+	Node struct {
+		Address string
+	}
+	Service struct {
+		Address string
+		Port    int
+		Tags    []string
+	}
+}
+
+// resolveConsul returns the addresses of the healthy nodes of a Consul
+// service, with the active Vault node first.
+func resolveConsul(service string, scheme string) ([]string, error) {
+	consulAddr := os.Getenv(consulAddrEnv)
+	if consulAddr == "" {
+		consulAddr = defaultConsulAddr
+	}
+	if !strings.Contains(consulAddr, "://") {
+		consulAddr = "http://" + consulAddr
+	}
+
+	req, err := http.NewRequest("GET", strings.TrimRight(consulAddr, "/")+"/v1/health/service/"+url.PathEscape(service)+"?passing", nil)
+	if err != nil {
+		return nil, err
+	}
+	if token := os.Getenv(consulTokenEnv); token != "" {
+		req.Header.Set("X-Consul-Token", token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("consul returned %s", resp.Status)
+	}
+
+	entries := []consulServiceEntry{}
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, err
+	}
+
+	addresses := []string{}
+	for _, entry := range entries {
+		host := entry.Service.Address
+		if host == "" {
+			host = entry.Node.Address
+		}
+		address := fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, fmt.Sprintf("%d", entry.Service.Port)))
+
+		active := false
+		for _, tag := range entry.Service.Tags {
+			active = active || tag == "active"
+		}
+		if active {
+			addresses = append([]string{address}, addresses...)
+		} else {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses, nil
+}
+
+// resolveSRV returns the addresses of the targets of a DNS SRV record, in
+// priority order (and randomised by weight).
+func resolveSRV(name string, scheme string) ([]string, error) {
+	_, records, err := net.LookupSRV("", "", name)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := []string{}
+	for _, record := range records {
+		host := strings.TrimSuffix(record.Target, ".")
+		addresses = append(addresses, fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, fmt.Sprintf("%d", record.Port))))
+	}
+	return addresses, nil
+}
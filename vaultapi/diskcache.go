@@ -0,0 +1,298 @@
+package vaultapi
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/api"
+)
+
+// ensure DiskCachedLogical implements Logical at compile-time.
+var _ = Logical(&DiskCachedLogical{})
+
+// staleWarningPrefix starts the warning added to responses served from the
+// disk cache, which records when they were fetched.
+const staleWarningPrefix = "vaultfs: vault unreachable, served from the disk cache, fetched at "
+
+// StaleSince returns when secret was fetched from Vault if it was served from
+// the disk cache while Vault was unreachable.
+func StaleSince(secret *api.Secret) (time.Time, bool) {
+	if secret == nil {
+		return time.Time{}, false
+	}
+	for _, warning := range secret.Warnings {
+		if strings.HasPrefix(warning, staleWarningPrefix) {
+			fetched, err := time.Parse(time.RFC3339, strings.TrimPrefix(warning, staleWarningPrefix))
+			return fetched, err == nil
+		}
+	}
+	return time.Time{}, false
+}
+
+// diskCacheEntry is the content of a disk cache file, before encryption.
+type diskCacheEntry struct {
+	Fetched time.Time   `json:"fetched"`
+	Secret  *api.Secret `json:"secret"`
+}
+
+// DiskCachedLogical is a Logical which keeps the last successful Read and List
+// responses from an underlying backend in encrypted files, and serves them
+// (marked stale, see StaleSince) in place of the error while the backend is
+// unreachable or failing. Nothing else is served from it, and writes still
+// fail, so the tree is effectively read-only during an outage.
+//
+// Files are encrypted with AES-GCM, using a key derived from a key file if one
+// is configured, or otherwise a random key kept in the directory (see
+// diskCacheKeyFile), so entries survive logging in again and restarts. Entries
+// which can't be decrypted, e.g. after the key file changed, are removed.
+type DiskCachedLogical struct {
+	backend Logical
+	dir     string
+	aead    cipher.AEAD
+}
+
+// diskCacheKeyFile is the name of the file in the disk cache directory holding
+// its random key when no key file is configured. It is only readable by the
+// owner, like the directory, so it protects entries copied out of the
+// directory but not the directory as a whole.
+const diskCacheKeyFile = "key"
+
+// NewDiskCachedLogical wraps backend in a disk cache in dir, creating it if
+// needed. keyFile, if not empty, is read for the encryption key, otherwise a
+// random key is kept in dir.
+func NewDiskCachedLogical(backend Logical, dir string, keyFile string) (*DiskCachedLogical, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	var material []byte
+	var err error
+	if keyFile != "" {
+		if material, err = ioutil.ReadFile(keyFile); err != nil {
+			return nil, errors.WrapPrefix(err, "could not read disk cache key file", 0)
+		}
+	} else if material, err = randomDiskCacheKey(filepath.Join(dir, diskCacheKeyFile)); err != nil {
+		return nil, errors.WrapPrefix(err, "could not read the disk cache key", 0)
+	}
+
+	block, err := aes.NewCipher(deriveDiskCacheKey(material))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &DiskCachedLogical{
+		backend: backend,
+		dir:     dir,
+		aead:    aead,
+	}, nil
+}
+
+// diskCacheKeySize is the size of the random key kept in the directory.
+const diskCacheKeySize = 32
+
+// randomDiskCacheKey reads the random key at file, generating it if there is
+// none yet.
+func randomDiskCacheKey(file string) ([]byte, error) {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL|syscall.O_NOFOLLOW, 0600)
+	switch {
+	case err == nil:
+		key := make([]byte, diskCacheKeySize)
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			f.Close()
+			os.Remove(file)
+			return nil, err
+		}
+		if _, err := f.Write(key); err != nil {
+			f.Close()
+			os.Remove(file)
+			return nil, err
+		}
+		return key, f.Close()
+	case !os.IsExist(err):
+		return nil, err
+	}
+
+	f, err = os.OpenFile(file, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	key, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != diskCacheKeySize {
+		return nil, errors.Errorf("%s is not a disk cache key", file)
+	}
+	return key, nil
+}
+
+// deriveDiskCacheKey derives a 256-bit key from material.
+func deriveDiskCacheKey(material []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte("vaultfs disk cache\x00"))
+	h.Write(material)
+	return h.Sum(nil)
+}
+
+// file returns the file holding key. Names are hashed so that the paths of
+// secrets aren't exposed.
+func (c *DiskCachedLogical) file(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+// Invalidate drops any cached responses for the given path, including the
+// listing of its parent.
+func (c *DiskCachedLogical) Invalidate(p string) {
+	for _, key := range []string{"read:" + p, "list:" + p, "list:" + path.Dir(p)} {
+		if err := os.Remove(c.file(key)); err != nil && !os.IsNotExist(err) {
+			safeLog().WithError(err).Warn("could not remove disk cache entry")
+		}
+	}
+}
+
+// Read implements Logical
+func (c *DiskCachedLogical) Read(path string) (*api.Secret, error) {
+	return c.cached("read:"+path, func() (*api.Secret, error) {
+		return c.backend.Read(path)
+	})
+}
+
+// ReadDynamic implements Logical. Dynamic secrets are never cached.
+func (c *DiskCachedLogical) ReadDynamic(path string) (*api.Secret, error) {
+	return c.backend.ReadDynamic(path)
+}
+
+// ReadWrapped implements Logical. Wrapping tokens are single-use, so are never
+// cached.
+func (c *DiskCachedLogical) ReadWrapped(path string, wrapTTL time.Duration) (*api.Secret, error) {
+	return c.backend.ReadWrapped(path, wrapTTL)
+}
+
+// List implements Logical
+func (c *DiskCachedLogical) List(path string) (*api.Secret, error) {
+	return c.cached("list:"+path, func() (*api.Secret, error) {
+		return c.backend.List(path)
+	})
+}
+
+// Write implements Logical
+func (c *DiskCachedLogical) Write(path string, data map[string]interface{}) (*api.Secret, error) {
+	defer c.Invalidate(path)
+	return c.backend.Write(path, data)
+}
+
+// Delete implements Logical
+func (c *DiskCachedLogical) Delete(path string) (*api.Secret, error) {
+	defer c.Invalidate(path)
+	return c.backend.Delete(path)
+}
+
+// Unwrap implements Logical. Unwrapping is single-use and never cached.
+func (c *DiskCachedLogical) Unwrap(wrappingToken string) (*api.Secret, error) {
+	return c.backend.Unwrap(wrappingToken)
+}
+
+// cached calls fetch, storing the result under key, or serves the stored
+// result if the backend is failing.
+func (c *DiskCachedLogical) cached(key string, fetch func() (*api.Secret, error)) (*api.Secret, error) {
+	secret, err := fetch()
+	switch {
+	case err == nil && secret == nil:
+		if err := os.Remove(c.file(key)); err != nil && !os.IsNotExist(err) {
+			safeLog().WithError(err).Warn("could not remove disk cache entry")
+		}
+	case err == nil:
+		if err := c.store(key, secret); err != nil {
+			safeLog().WithError(err).Warn("could not write disk cache entry")
+		}
+	case isBackendFailure(err) || errwrap.ContainsType(err, ErrCircuitOpen{}):
+		entry, loadErr := c.load(key)
+		if loadErr != nil {
+			if !os.IsNotExist(loadErr) {
+				safeLog().WithError(loadErr).Warn("could not read disk cache entry, removing it")
+				if err := os.Remove(c.file(key)); err != nil && !os.IsNotExist(err) {
+					safeLog().WithError(err).Warn("could not remove disk cache entry")
+				}
+			}
+			return secret, err
+		}
+		safeLog().WithError(err).WithField("fetched", entry.Fetched).Debug("serving stale response from the disk cache")
+		stale := *entry.Secret
+		stale.Warnings = append(append([]string{}, stale.Warnings...), staleWarningPrefix+entry.Fetched.Format(time.RFC3339))
+		return &stale, nil
+	}
+	return secret, err
+}
+
+// store encrypts secret into the file of key.
+func (c *DiskCachedLogical) store(key string, secret *api.Secret) error {
+	plaintext, err := json.Marshal(diskCacheEntry{Fetched: time.Now().UTC(), Secret: secret})
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	// The key is authenticated too, so a file can't be swapped for another.
+	ciphertext := c.aead.Seal(nonce, nonce, plaintext, []byte(key))
+
+	file := c.file(key)
+	tmp, err := ioutil.TempFile(c.dir, ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(ciphertext); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// load decrypts the file of key.
+func (c *DiskCachedLogical) load(key string) (*diskCacheEntry, error) {
+	ciphertext, err := ioutil.ReadFile(c.file(key))
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < c.aead.NonceSize() {
+		return nil, errors.New("disk cache entry is truncated")
+	}
+	nonce, ciphertext := ciphertext[:c.aead.NonceSize()], ciphertext[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, ciphertext, []byte(key))
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "could not decrypt disk cache entry", 0)
+	}
+
+	entry := &diskCacheEntry{}
+	if err := json.Unmarshal(plaintext, entry); err != nil {
+		return nil, err
+	}
+	if entry.Secret == nil {
+		return nil, errors.New("disk cache entry has no secret")
+	}
+	return entry, nil
+}
@@ -0,0 +1,173 @@
+package vaultapi
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// flakyLogical is a Logical reading secrets from a map, or failing with err
+// if set, counting the reads made.
+type flakyLogical struct {
+	Logical
+	secrets  map[string]*api.Secret
+	err      error
+	requests int
+}
+
+func (l *flakyLogical) Read(path string) (*api.Secret, error) {
+	l.requests++
+	if l.err != nil {
+		return nil, l.err
+	}
+	return l.secrets[path], nil
+}
+
+// newDiskCache returns a disk cache of backend in dir, failing the test if it
+// can't be made.
+func newDiskCache(t *testing.T, backend Logical, dir string, keyFile string) *DiskCachedLogical {
+	c, err := NewDiskCachedLogical(backend, dir, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// entries returns the names of the cache entries in dir.
+func entries(t *testing.T, dir string) []string {
+	names, err := filepath.Glob(filepath.Join(dir, "[0-9a-f]*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestDiskCacheRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	backend := &flakyLogical{secrets: map[string]*api.Secret{"secret/app": {Data: map[string]interface{}{"password": "hunter2"}}}}
+	if _, err := newDiskCache(t, backend, dir, "").Read("secret/app"); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(dir, diskCacheKeyFile))
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("expected a private random key to be kept, got %v (%v)", info, err)
+	}
+
+	// The entry is served by a later cache in the same directory, e.g. after
+	// logging in again or restarting, while the backend is failing.
+	backend.err = apiError("503", "service unavailable")
+	secret, err := newDiskCache(t, backend, dir, "").Read("secret/app")
+	if err != nil || secret.Data["password"] != "hunter2" {
+		t.Fatalf("expected the stored secret, got %v (%v)", secret, err)
+	}
+	if _, stale := StaleSince(secret); !stale {
+		t.Errorf("expected the secret to be marked stale, got %v", secret.Warnings)
+	}
+
+	// Only backend failures are served from the cache.
+	backend.err = apiError("403", "permission denied")
+	if _, err := newDiskCache(t, backend, dir, "").Read("secret/app"); err == nil {
+		t.Errorf("expected denials to be returned")
+	}
+}
+
+func TestDiskCacheStaleEntry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	backend := &flakyLogical{secrets: map[string]*api.Secret{"secret/app": {Data: map[string]interface{}{"password": "hunter2"}}}}
+	c := newDiskCache(t, backend, dir, "")
+	if _, err := c.Read("secret/app"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A secret deleted from Vault is dropped rather than served in an outage.
+	delete(backend.secrets, "secret/app")
+	if secret, err := c.Read("secret/app"); err != nil || secret != nil {
+		t.Fatalf("expected the secret to be gone, got %v (%v)", secret, err)
+	}
+	if names := entries(t, dir); len(names) != 0 {
+		t.Errorf("expected the entry to be removed, got %v", names)
+	}
+	backend.err = apiError("500", "internal error")
+	if secret, err := c.Read("secret/app"); err == nil {
+		t.Errorf("expected the failure, got %v", secret)
+	}
+}
+
+func TestDiskCacheTampered(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	backend := &flakyLogical{secrets: map[string]*api.Secret{"secret/app": {Data: map[string]interface{}{"password": "hunter2"}}}}
+	c := newDiskCache(t, backend, dir, "")
+	if _, err := c.Read("secret/app"); err != nil {
+		t.Fatal(err)
+	}
+	names := entries(t, dir)
+	if len(names) != 1 {
+		t.Fatalf("expected an entry, got %v", names)
+	}
+	ciphertext, err := ioutil.ReadFile(names[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext[len(ciphertext)-1] ^= 1
+	if err := ioutil.WriteFile(names[0], ciphertext, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	backend.err = apiError("500", "internal error")
+	if secret, err := c.Read("secret/app"); err == nil {
+		t.Errorf("expected the tampered entry not to be served, got %v", secret)
+	}
+	if names := entries(t, dir); len(names) != 0 {
+		t.Errorf("expected the tampered entry to be removed, got %v", names)
+	}
+}
+
+func TestDiskCacheWrongKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "cache.key")
+	if err := ioutil.WriteFile(keyFile, []byte("first"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cacheDir := filepath.Join(dir, "cache")
+
+	backend := &flakyLogical{secrets: map[string]*api.Secret{"secret/app": {Data: map[string]interface{}{"password": "hunter2"}}}}
+	if _, err := newDiskCache(t, backend, cacheDir, keyFile).Read("secret/app"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(cacheDir, diskCacheKeyFile)); !os.IsNotExist(err) {
+		t.Errorf("expected no random key with a key file, got %v", err)
+	}
+
+	if err := ioutil.WriteFile(keyFile, []byte("second"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	backend.err = apiError("500", "internal error")
+	if secret, err := newDiskCache(t, backend, cacheDir, keyFile).Read("secret/app"); err == nil {
+		t.Errorf("expected the entry not to decrypt with another key, got %v", secret)
+	}
+	if names := entries(t, cacheDir); len(names) != 0 {
+		t.Errorf("expected the undecryptable entry to be removed, got %v", names)
+	}
+}
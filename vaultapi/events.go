@@ -0,0 +1,342 @@
+package vaultapi
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/hashicorp/vault/api"
+	"golang.org/x/net/context"
+)
+
+// eventRetryInterval is how long the subscriber waits before reconnecting
+// after the event stream fails.
+const eventRetryInterval = 10 * time.Second
+
+// websocket opcodes
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+// vaultEvent is the subset of a Vault event notification we care about.
+type vaultEvent struct {
+	EventType string `json:"event_type"`
+	Data      struct {
+		Event struct {
+			Metadata struct {
+				Path string `json:"path"`
+			} `json:"metadata"`
+		} `json:"event"`
+	} `json:"data"`
+}
+
+// EventSubscriber subscribes to the Vault event notification stream
+// (sys/events/subscribe) and reports the path of every secret an event is
+// received for, so cached copies can be invalidated as soon as it changes.
+type EventSubscriber struct {
+	config    *api.Config
+	token     func() string
+	eventType string
+	onPath    func(path string)
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// NewEventSubscriber creates a subscriber for events matching eventType (which
+// may be a glob such as "kv*"). token is called on every connection attempt to
+// get the current Vault token.
+func NewEventSubscriber(config *api.Config, token func() string, eventType string, onPath func(path string)) *EventSubscriber {
+	return &EventSubscriber{
+		config:    config,
+		token:     token,
+		eventType: eventType,
+		onPath:    onPath,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Start begins receiving events in the background. The stream is reconnected
+// automatically if it fails.
+func (s *EventSubscriber) Start() {
+	go s.run()
+}
+
+// Stop closes the event stream and waits for the background loop to exit.
+func (s *EventSubscriber) Stop() {
+	close(s.stop)
+	<-s.done
+}
+
+func (s *EventSubscriber) run() {
+	defer close(s.done)
+
+	for {
+		conn, err := s.connect()
+		if err != nil {
+			safeLog().WithError(err).Warn("could not subscribe to vault events")
+		} else {
+			safeLog().WithField("event_type", s.eventType).Info("subscribed to vault events")
+
+			// Close the connection to unblock the reader when stopped.
+			closed := make(chan struct{})
+			go func() {
+				select {
+				case <-s.stop:
+					conn.Close()
+				case <-closed:
+				}
+			}()
+
+			err = s.receive(conn)
+			close(closed)
+			conn.Close()
+
+			select {
+			case <-s.stop:
+				return
+			default:
+			}
+			safeLog().WithError(err).Warn("vault event stream closed")
+		}
+
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(eventRetryInterval):
+		}
+	}
+}
+
+// connect dials Vault and performs the websocket handshake for the event
+// stream.
+func (s *EventSubscriber) connect() (net.Conn, error) {
+	addr, err := url.Parse(s.address())
+	if err != nil {
+		return nil, err
+	}
+
+	host := addr.Host
+	var conn net.Conn
+	switch addr.Scheme {
+	case "https":
+		if !strings.Contains(host, ":") {
+			host += ":443"
+		}
+		var tlsConfig *tls.Config
+		if transport, ok := s.config.HttpClient.Transport.(*http.Transport); ok && transport.TLSClientConfig != nil {
+			tlsConfig = transport.TLSClientConfig.Clone()
+		} else {
+			tlsConfig = &tls.Config{}
+		}
+		// The handshake must be HTTP/1.1.
+		tlsConfig.NextProtos = nil
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}, "tcp", host, tlsConfig)
+	case "http":
+		if !strings.Contains(host, ":") {
+			host += ":80"
+		}
+		// The transport may dial a unix socket instead.
+		if transport, ok := s.config.HttpClient.Transport.(*http.Transport); ok && transport.DialContext != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			conn, err = transport.DialContext(ctx, "tcp", host)
+			cancel()
+		} else {
+			conn, err = net.DialTimeout("tcp", host, 10*time.Second)
+		}
+	default:
+		return nil, errors.Errorf("unsupported vault address scheme for events: %s", addr.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	req, err := http.NewRequest("GET", strings.TrimRight(s.address(), "/")+
+		"/v1/sys/events/subscribe/"+url.PathEscape(s.eventType)+"?json=true", nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString(key))
+	req.Header.Set("X-Vault-Token", s.token())
+
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, errors.Errorf("unexpected response to event subscription: %s", resp.Status)
+	}
+
+	return &bufferedConn{Conn: conn, reader: reader}, nil
+}
+
+// receive reads events from conn until it fails or is closed.
+func (s *EventSubscriber) receive(conn net.Conn) error {
+	var message []byte
+	for {
+		fin, opcode, payload, err := readFrame(conn)
+		if err != nil {
+			return err
+		}
+
+		switch opcode {
+		case wsPing:
+			if err := writeFrame(conn, wsPong, payload); err != nil {
+				return err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			return io.EOF
+		case wsText, wsBinary:
+			message = payload
+		case wsContinuation:
+			message = append(message, payload...)
+		}
+
+		if !fin {
+			continue
+		}
+
+		event := vaultEvent{}
+		if err := json.Unmarshal(message, &event); err != nil {
+			safeLog().WithError(err).Warn("could not decode vault event")
+			continue
+		}
+		if path := event.Data.Event.Metadata.Path; path != "" {
+			safeLog().WithField("event_type", event.EventType).WithField("path", path).Debug("received vault event")
+			s.onPath(path)
+		}
+	}
+}
+
+// bufferedConn is a net.Conn which reads through the buffer left over from
+// the handshake.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// readFrame reads a single websocket frame.
+func readFrame(r io.Reader) (fin bool, opcode byte, payload []byte, err error) {
+	header := make([]byte, 2)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return
+	}
+	fin = header[0]&0x80 != 0
+	opcode = header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err = io.ReadFull(r, ext); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err = io.ReadFull(r, ext); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext)
+	}
+	if length > 1<<24 {
+		err = errors.Errorf("websocket frame too large: %d bytes", length)
+		return
+	}
+
+	var mask []byte
+	if masked {
+		mask = make([]byte, 4)
+		if _, err = io.ReadFull(r, mask); err != nil {
+			return
+		}
+	}
+
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return
+}
+
+// writeFrame writes a single, masked (as required of clients) websocket frame.
+func writeFrame(w io.Writer, opcode byte, payload []byte) error {
+	frame := []byte{0x80 | opcode}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, 0x80|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, 0x80|126, byte(len(payload)>>8), byte(len(payload)))
+	default:
+		ext := make([]byte, 8)
+		binary.BigEndian.PutUint64(ext, uint64(len(payload)))
+		frame = append(append(frame, 0x80|127), ext...)
+	}
+
+	mask := make([]byte, 4)
+	if _, err := rand.Read(mask); err != nil {
+		return err
+	}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+
+	_, err := w.Write(frame)
+	return err
+}
+
+// address returns the address to subscribe at: the first, if several nodes of
+// a cluster are configured or discovered.
+func (s *EventSubscriber) address() string {
+	if addresses, err := ResolveAddresses(s.config.Address); err == nil && len(addresses) > 0 {
+		return addresses[0]
+	}
+	return s.config.Address
+}
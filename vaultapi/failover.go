@@ -0,0 +1,211 @@
+package vaultapi
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/api"
+)
+
+// SplitAddresses splits a comma separated list of Vault addresses, as may be
+// given in VAULT_ADDR for an HA cluster.
+func SplitAddresses(address string) []string {
+	addresses := []string{}
+	for _, addr := range strings.Split(address, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addresses = append(addresses, addr)
+		}
+	}
+	return addresses
+}
+
+// endpointSet is the addresses of the nodes of a Vault cluster, one of which
+// is in use at a time. The node in use is kept until it can't be reached.
+type endpointSet struct {
+	httpClient *http.Client
+	source     string // discovery address the nodes are found from (optional)
+
+	mu        sync.Mutex
+	addresses []string
+	active    int
+}
+
+// newEndpointSet returns the endpoints named by address, a comma separated
+// list or a discovery address.
+func newEndpointSet(address string, httpClient *http.Client) (*endpointSet, error) {
+	addresses, err := ResolveAddresses(address)
+	if err != nil {
+		return nil, err
+	}
+	if len(addresses) == 0 {
+		return nil, errors.New("no vault address")
+	}
+
+	e := &endpointSet{
+		httpClient: httpClient,
+		addresses:  addresses,
+	}
+	if IsDiscoveryAddress(address) {
+		e.source = address
+	}
+	return e, nil
+}
+
+// current returns the address of the node in use.
+func (e *endpointSet) current() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.addresses[e.active]
+}
+
+// attempts returns how many times a request may be tried: once at each known
+// node, and once more when the nodes are discovered, as they may have moved.
+func (e *endpointSet) attempts() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.source != "" {
+		return len(e.addresses) + 1
+	}
+	return len(e.addresses)
+}
+
+// Node health, from the status codes of sys/health.
+const (
+	healthActive    = iota // the active node
+	healthStandby          // a reachable standby, which forwards requests
+	healthUnhealthy        // unreachable, sealed or uninitialised
+)
+
+// health checks the health of the node at addr.
+func (e *endpointSet) health(addr string) int {
+	resp, err := e.httpClient.Get(strings.TrimRight(addr, "/") + "/v1/sys/health")
+	if err != nil {
+		return healthUnhealthy
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return healthActive
+	case http.StatusTooManyRequests, 473: // standby, performance standby
+		return healthStandby
+	}
+	return healthUnhealthy
+}
+
+// failover selects another node after the node at failed couldn't be reached,
+// preferring the active node, and returns its address. Discovered nodes are
+// discovered again first. It returns false if no other node is healthy.
+func (e *endpointSet) failover(failed string) (string, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// Another request may have already failed over.
+	if current := e.addresses[e.active]; current != failed {
+		return current, true
+	}
+
+	if e.source != "" {
+		addresses, err := ResolveAddresses(e.source)
+		if err != nil {
+			safeLog().WithError(err).Warn("could not discover vault nodes again")
+		} else {
+			e.addresses = addresses
+			e.active = 0
+		}
+	}
+
+	standby := -1
+	for i, addr := range e.addresses {
+		if addr == failed {
+			continue
+		}
+		switch e.health(addr) {
+		case healthActive:
+			e.active = i
+			return addr, true
+		case healthStandby:
+			if standby < 0 {
+				standby = i
+			}
+		}
+	}
+	if standby < 0 {
+		return "", false
+	}
+	e.active = standby
+	return e.addresses[standby], true
+}
+
+// isConnectionError reports whether err means Vault couldn't be reached at
+// all, rather than that it responded with an error.
+func isConnectionError(err error) bool {
+	if _, ok := err.(*url.Error); ok {
+		return true
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	return errwrap.ContainsType(err, &url.Error{}) || errwrap.ContainsType(err, &net.OpError{})
+}
+
+// NewFailoverVaultLogicalBackend creates a Vault logical backend like
+// NewVaultLogicalBackend for the nodes of a cluster at address (a comma
+// separated list, or a discovery address), failing over between them when the
+// node in use can't be reached. httpClient is used to check the health of the
+// nodes.
+func NewFailoverVaultLogicalBackend(client *api.Client, httpClient *http.Client, address string, token string, authMethod string, authUser string, authRole string, authSecret string) (AuthableLogical, error) {
+	endpoints, err := newEndpointSet(address, httpClient)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.SetAddress(endpoints.current()); err != nil {
+		return nil, err
+	}
+
+	b := NewVaultLogicalBackend(client, token, authMethod, authUser, authRole, authSecret).(*vaultBackend)
+	b.endpoints = endpoints
+	return b, nil
+}
+
+// withFailover calls op, retrying it on other nodes while the node in use
+// can't be reached.
+func (b *vaultBackend) withFailover(op func() error) error {
+	err := op()
+	if b.endpoints == nil {
+		return err
+	}
+
+	for attempt := 1; attempt < b.endpoints.attempts() && err != nil && isConnectionError(err); attempt++ {
+		failed := b.client.Address()
+		addr, ok := b.endpoints.failover(failed)
+		if !ok {
+			break
+		}
+		if addr != failed {
+			safeLog().WithField("from_node", failed).WithField("node", addr).Warn("failing over to another vault node")
+			if err := b.client.SetAddress(addr); err != nil {
+				return err
+			}
+		}
+		err = op()
+	}
+	return err
+}
+
+// secretWithFailover calls op with failover, for operations returning a
+// secret.
+func (b *vaultBackend) secretWithFailover(op func() (*api.Secret, error)) (*api.Secret, error) {
+	var secret *api.Secret
+	err := b.withFailover(func() error {
+		var err error
+		secret, err = op()
+		return err
+	})
+	return secret, err
+}
@@ -0,0 +1,123 @@
+package vaultapi
+
+import (
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"golang.org/x/net/context"
+)
+
+// ensure InterruptibleLogical implements Logical at compile-time.
+var _ = Logical(&InterruptibleLogical{})
+
+// ErrInterrupted is returned when the caller a request was made for gave up
+// waiting for it, e.g. on an interrupt from the kernel.
+type ErrInterrupted struct {
+	innerError error
+}
+
+// Error implements the error interface
+func (err ErrInterrupted) Error() string {
+	return "request interrupted"
+}
+
+// WrappedErrors implmenets the hashicorp/errwrap interface
+func (err ErrInterrupted) WrappedErrors() []error {
+	return []error{err.innerError}
+}
+
+// InterruptibleLogical is a Logical whose reads and lists return
+// ErrInterrupted as soon as its context is done, rather than when the response
+// arrives. No request is made once the context is done.
+//
+// The api package can't cancel a request in flight, and reads may be shared
+// with other callers, so the request itself is abandoned rather than
+// cancelled: it completes (or times out) in the background, and its response
+// is still cached. Other requests change something in Vault even if they are
+// abandoned (dynamic reads create leases, and unwrapping consumes the token),
+// so their callers wait for the outcome rather than losing it.
+type InterruptibleLogical struct {
+	ctx     context.Context
+	backend Logical
+}
+
+// NewInterruptibleLogical returns backend interrupted when ctx is done.
+func NewInterruptibleLogical(ctx context.Context, backend Logical) *InterruptibleLogical {
+	return &InterruptibleLogical{
+		ctx:     ctx,
+		backend: backend,
+	}
+}
+
+// wait returns the result of fn, unless the context is already done.
+func (l *InterruptibleLogical) wait(fn func() (*api.Secret, error)) (*api.Secret, error) {
+	if err := l.ctx.Err(); err != nil {
+		return nil, ErrInterrupted{err}
+	}
+	return fn()
+}
+
+// call returns the result of fn, or ErrInterrupted if the context is done
+// first, abandoning fn. It must only be used for requests which change
+// nothing.
+func (l *InterruptibleLogical) call(fn func() (*api.Secret, error)) (*api.Secret, error) {
+	// Contexts which can't be done are waited on directly.
+	if l.ctx.Done() == nil {
+		return fn()
+	}
+	if err := l.ctx.Err(); err != nil {
+		return nil, ErrInterrupted{err}
+	}
+
+	type result struct {
+		secret *api.Secret
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		secret, err := fn()
+		done <- result{secret, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.secret, r.err
+	case <-l.ctx.Done():
+		return nil, ErrInterrupted{l.ctx.Err()}
+	}
+}
+
+// Read implements Logical
+func (l *InterruptibleLogical) Read(path string) (*api.Secret, error) {
+	return l.call(func() (*api.Secret, error) { return l.backend.Read(path) })
+}
+
+// ReadDynamic implements Logical
+func (l *InterruptibleLogical) ReadDynamic(path string) (*api.Secret, error) {
+	return l.wait(func() (*api.Secret, error) { return l.backend.ReadDynamic(path) })
+}
+
+// ReadWrapped implements Logical
+func (l *InterruptibleLogical) ReadWrapped(path string, wrapTTL time.Duration) (*api.Secret, error) {
+	return l.wait(func() (*api.Secret, error) { return l.backend.ReadWrapped(path, wrapTTL) })
+}
+
+// List implements Logical
+func (l *InterruptibleLogical) List(path string) (*api.Secret, error) {
+	return l.call(func() (*api.Secret, error) { return l.backend.List(path) })
+}
+
+// Write implements Logical
+func (l *InterruptibleLogical) Write(path string, data map[string]interface{}) (*api.Secret, error) {
+	return l.wait(func() (*api.Secret, error) { return l.backend.Write(path, data) })
+}
+
+// Delete implements Logical
+func (l *InterruptibleLogical) Delete(path string) (*api.Secret, error) {
+	return l.wait(func() (*api.Secret, error) { return l.backend.Delete(path) })
+}
+
+// Unwrap implements Logical
+func (l *InterruptibleLogical) Unwrap(wrappingToken string) (*api.Secret, error) {
+	return l.wait(func() (*api.Secret, error) { return l.backend.Unwrap(wrappingToken) })
+}
@@ -0,0 +1,258 @@
+package vaultapi
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/api"
+)
+
+// ensure JournalLogical implements Logical at compile-time.
+var _ = Logical(&JournalLogical{})
+
+// Journal methods, matching the Logical operation that was performed.
+const (
+	JournalRead   = "read"
+	JournalList   = "list"
+	JournalWrite  = "write"
+	JournalDelete = "delete"
+	JournalUnwrap = "unwrap"
+)
+
+// Journal statuses, derived from the response of the backend.
+const (
+	JournalStatusOK               = "ok"
+	JournalStatusNotFound         = "not_found"
+	JournalStatusPermissionDenied = "permission_denied"
+	JournalStatusError            = "error"
+)
+
+// JournalEntry records a single backend request. Request and response bodies
+// are never recorded.
+type JournalEntry struct {
+	Time     time.Time     `json:"time"`
+	Method   string        `json:"method"`
+	Path     string        `json:"path"`
+	Status   string        `json:"status"`
+	Duration time.Duration `json:"duration"`
+}
+
+// JournalRecentErrors is the number of the most recent failures kept in
+// JournalStats, however many entries the journal keeps.
+const JournalRecentErrors = 10
+
+// JournalError records a failed backend request.
+type JournalError struct {
+	Time   time.Time
+	Method string
+	Path   string
+	Error  string
+}
+
+// JournalStats counts the requests made to the underlying backend.
+type JournalStats struct {
+	Requests      map[string]uint64 // Requests by method
+	Errors        uint64            // Requests which failed (other than not found)
+	LastError     string            // The most recent failure, if any
+	LastErrorTime time.Time
+	RecentErrors  []JournalError // The last JournalRecentErrors failures, oldest first
+}
+
+// JournalLogical is a Logical which records the last N requests made to the
+// underlying backend in a ring buffer, for debugging performance problems. It
+// counts every request, even if N is 0.
+type JournalLogical struct {
+	backend Logical
+	*journal
+}
+
+// journal holds the requests recorded by a JournalLogical, and those it was
+// made from by WithBackend.
+type journal struct {
+	mu      sync.Mutex
+	entries []JournalEntry
+	next    int
+	full    bool
+	stats   JournalStats
+}
+
+// NewJournalLogical wraps backend in a journal holding the last size requests.
+func NewJournalLogical(backend Logical, size int) *JournalLogical {
+	return &JournalLogical{
+		backend: backend,
+		journal: &journal{
+			entries: make([]JournalEntry, size),
+			stats:   JournalStats{Requests: make(map[string]uint64)},
+		},
+	}
+}
+
+// WithBackend returns a JournalLogical of backend recording its requests in
+// the same journal as j.
+func (j *JournalLogical) WithBackend(backend Logical) *JournalLogical {
+	return &JournalLogical{backend: backend, journal: j.journal}
+}
+
+// Stats returns the request counters.
+func (j *JournalLogical) Stats() JournalStats {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	stats := j.stats
+	stats.Requests = make(map[string]uint64, len(j.stats.Requests))
+	for method, count := range j.stats.Requests {
+		stats.Requests[method] = count
+	}
+	stats.RecentErrors = append([]JournalError{}, j.stats.RecentErrors...)
+	return stats
+}
+
+// Entries returns the recorded requests, oldest first.
+func (j *JournalLogical) Entries() []JournalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if !j.full {
+		return append([]JournalEntry{}, j.entries[:j.next]...)
+	}
+	return append(append([]JournalEntry{}, j.entries[j.next:]...), j.entries[:j.next]...)
+}
+
+// Read implements Logical
+func (j *JournalLogical) Read(path string) (*api.Secret, error) {
+	start := time.Now()
+	secret, err := j.backend.Read(path)
+	j.record(start, JournalRead, path, secret, err)
+	return secret, err
+}
+
+// ReadDynamic implements Logical
+func (j *JournalLogical) ReadDynamic(path string) (*api.Secret, error) {
+	start := time.Now()
+	secret, err := j.backend.ReadDynamic(path)
+	j.record(start, JournalRead, path, secret, err)
+	return secret, err
+}
+
+// ReadWrapped implements Logical
+func (j *JournalLogical) ReadWrapped(path string, wrapTTL time.Duration) (*api.Secret, error) {
+	start := time.Now()
+	secret, err := j.backend.ReadWrapped(path, wrapTTL)
+	j.record(start, JournalRead, path, secret, err)
+	return secret, err
+}
+
+// List implements Logical
+func (j *JournalLogical) List(path string) (*api.Secret, error) {
+	start := time.Now()
+	secret, err := j.backend.List(path)
+	j.record(start, JournalList, path, secret, err)
+	return secret, err
+}
+
+// Write implements Logical
+func (j *JournalLogical) Write(path string, data map[string]interface{}) (*api.Secret, error) {
+	start := time.Now()
+	secret, err := j.backend.Write(path, data)
+	j.record(start, JournalWrite, path, secret, err)
+	return secret, err
+}
+
+// Delete implements Logical
+func (j *JournalLogical) Delete(path string) (*api.Secret, error) {
+	start := time.Now()
+	secret, err := j.backend.Delete(path)
+	j.record(start, JournalDelete, path, secret, err)
+	return secret, err
+}
+
+// Unwrap implements Logical. The wrapping token is not recorded.
+func (j *JournalLogical) Unwrap(wrappingToken string) (*api.Secret, error) {
+	start := time.Now()
+	secret, err := j.backend.Unwrap(wrappingToken)
+	j.record(start, JournalUnwrap, "", secret, err)
+	return secret, err
+}
+
+func (j *JournalLogical) record(start time.Time, method string, path string, secret *api.Secret, err error) {
+	entry := JournalEntry{
+		Time:     start,
+		Method:   method,
+		Path:     path,
+		Status:   JournalRequestStatus(secret, err),
+		Duration: time.Since(start),
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.stats.Requests[method]++
+	if entry.Status == JournalStatusError || entry.Status == JournalStatusPermissionDenied {
+		j.stats.Errors++
+		j.stats.LastError = err.Error()
+		j.stats.LastErrorTime = start
+		j.stats.RecentErrors = append(j.stats.RecentErrors, JournalError{
+			Time:   start,
+			Method: method,
+			Path:   path,
+			Error:  err.Error(),
+		})
+		if len(j.stats.RecentErrors) > JournalRecentErrors {
+			j.stats.RecentErrors = j.stats.RecentErrors[1:]
+		}
+	}
+
+	if len(j.entries) == 0 {
+		return
+	}
+	j.entries[j.next] = entry
+	j.next = (j.next + 1) % len(j.entries)
+	if j.next == 0 {
+		j.full = true
+	}
+}
+
+// JournalRequestStatus classifies the result of a backend request.
+func JournalRequestStatus(secret *api.Secret, err error) string {
+	switch {
+	case err == nil && secret == nil:
+		return JournalStatusNotFound
+	case err == nil:
+		return JournalStatusOK
+	case errwrap.ContainsType(err, ErrPermissionDenied{}):
+		return JournalStatusPermissionDenied
+	default:
+		return JournalStatusError
+	}
+}
+
+// WriteJournal writes entries to w as JSON lines.
+func WriteJournal(w io.Writer, entries []JournalEntry) error {
+	enc := json.NewEncoder(w)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadJournal reads JSON lines journal entries as written by WriteJournal.
+func ReadJournal(r io.Reader) ([]JournalEntry, error) {
+	entries := []JournalEntry{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
@@ -0,0 +1,84 @@
+package vaultapi
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// KV version 2 secrets are read from <mount>/data/<path> and return their
+// values nested under "data" beside a "metadata" object, while their version
+// history lives at <mount>/metadata/<path>.
+
+// KVv2Metadata returns the metadata object of a secret read from a KV v2 data
+// path, and false if the secret does not look like one.
+func KVv2Metadata(secret *api.Secret) (map[string]interface{}, bool) {
+	if secret == nil || secret.Data == nil {
+		return nil, false
+	}
+	if _, found := secret.Data["data"]; !found {
+		return nil, false
+	}
+	metadata, ok := secret.Data["metadata"].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	if _, found := metadata["version"]; !found {
+		return nil, false
+	}
+	return metadata, true
+}
+
+// KVv2Version returns the version of a secret read from a KV v2 data path.
+func KVv2Version(secret *api.Secret) (string, bool) {
+	metadata, ok := KVv2Metadata(secret)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("%v", metadata["version"]), true
+}
+
+// KVv2MetadataPath converts a KV v2 data path into the matching metadata path.
+// The first "data" path component is assumed to follow the mount.
+func KVv2MetadataPath(dataPath string) (string, bool) {
+	return KVv2Path(dataPath, "metadata")
+}
+
+// KVv2Path converts a KV v2 data path into the path of the same secret under
+// another endpoint of the mount (e.g. "undelete" or "destroy").
+func KVv2Path(dataPath string, endpoint string) (string, bool) {
+	parts := strings.Split(strings.Trim(dataPath, "/"), "/")
+	for i, part := range parts {
+		if part == "data" && i > 0 {
+			parts[i] = endpoint
+			return strings.Join(parts, "/"), true
+		}
+	}
+	return "", false
+}
+
+// SecretData returns the values held in a secret, unwrapping the nested data
+// object of a secret read from a KV v2 data path.
+func SecretData(secret *api.Secret) map[string]interface{} {
+	if secret == nil {
+		return nil
+	}
+	if _, ok := KVv2Metadata(secret); ok {
+		data, _ := secret.Data["data"].(map[string]interface{})
+		return data
+	}
+	return secret.Data
+}
+
+// WriteData returns the request body for writing data to path, nesting it
+// under "data" if path looks like a KV v2 data path.
+func WriteData(path string, data map[string]interface{}) map[string]interface{} {
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+	if _, ok := KVv2MetadataPath(path); ok {
+		return map[string]interface{}{"data": data}
+	}
+	return data
+}
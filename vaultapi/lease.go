@@ -0,0 +1,66 @@
+package vaultapi
+
+import (
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// leaseRetryInterval is how long to wait before retrying a failed lease
+// renewal.
+const leaseRetryInterval = 10 * time.Second
+
+// HoldLease keeps the lease of secret renewed through logical (if it is
+// renewable) until the returned func is called, which revokes it.
+func HoldLease(logical Logical, secret *api.Secret) func() {
+	leaseID := secret.LeaseID
+	log := safeLog().WithField("lease_id", leaseID)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		if !secret.Renewable {
+			return
+		}
+
+		duration := time.Duration(secret.LeaseDuration) * time.Second
+		for {
+			wait := duration / 2
+			if wait < time.Second {
+				wait = time.Second
+			}
+			select {
+			case <-stop:
+				return
+			case <-time.After(wait):
+			}
+
+			renewed, err := logical.Write("sys/leases/renew", map[string]interface{}{
+				"lease_id":  leaseID,
+				"increment": int(duration.Seconds()),
+			})
+			if err != nil {
+				log.WithError(err).Warn("could not renew lease")
+				duration = 2 * leaseRetryInterval
+				continue
+			}
+			if renewed != nil && renewed.LeaseDuration > 0 {
+				duration = time.Duration(renewed.LeaseDuration) * time.Second
+			}
+			log.WithField("lease_duration", duration).Debug("renewed lease")
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+		if _, err := logical.Write("sys/leases/revoke", map[string]interface{}{
+			"lease_id": leaseID,
+		}); err != nil {
+			log.WithError(err).Warn("could not revoke lease")
+			return
+		}
+		log.Info("revoked lease")
+	}
+}
@@ -0,0 +1,219 @@
+package vaultapi
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// ensure LimitedLogical implements Logical at compile-time.
+var _ = Logical(&LimitedLogical{})
+
+// QoSClass is a quality-of-service class, applying concurrency and rate limits
+// to requests for the paths under its prefixes. Each class is limited
+// independently, so requests in one class can never starve another.
+type QoSClass struct {
+	// Name of the class, for logging.
+	Name string `mapstructure:"class"`
+	// Prefixes of the Vault paths belonging to this class. A path belongs to
+	// the class with the longest matching prefix.
+	Prefixes []string `mapstructure:"prefixes"`
+	// MaxConcurrent bounds the number of in-flight requests. Zero means
+	// unlimited.
+	MaxConcurrent int `mapstructure:"max-concurrent"`
+	// Rate bounds requests per second. Zero means unlimited.
+	Rate float64 `mapstructure:"rate"`
+	// Burst is the number of requests which may exceed Rate at once. Defaults
+	// to 1.
+	Burst int `mapstructure:"burst"`
+}
+
+// limited returns true if the class imposes any limit.
+func (c QoSClass) limited() bool {
+	return c.MaxConcurrent > 0 || c.Rate > 0
+}
+
+// LimitConfig configures client-side limiting of Vault requests.
+type LimitConfig struct {
+	// Default applies to every path not matched by one of Classes.
+	Default QoSClass
+	// Classes assigns limits to path prefixes.
+	Classes []QoSClass
+}
+
+// Enabled returns true if any limits are configured.
+func (c LimitConfig) Enabled() bool {
+	if c.Default.limited() {
+		return true
+	}
+	for _, class := range c.Classes {
+		if class.limited() {
+			return true
+		}
+	}
+	return false
+}
+
+// classLimiter enforces the limits of a single QoSClass.
+type classLimiter struct {
+	QoSClass
+	sem    chan struct{} // nil if concurrency is unlimited
+	bucket *tokenBucket  // nil if rate is unlimited
+}
+
+func newClassLimiter(class QoSClass) *classLimiter {
+	l := &classLimiter{QoSClass: class}
+	if class.MaxConcurrent > 0 {
+		l.sem = make(chan struct{}, class.MaxConcurrent)
+	}
+	if class.Rate > 0 {
+		l.bucket = newTokenBucket(class.Rate, class.Burst)
+	}
+	return l
+}
+
+// acquire blocks until the request may proceed, and returns a func to release
+// the concurrency slot once it is complete.
+func (l *classLimiter) acquire() func() {
+	if l.bucket != nil {
+		l.bucket.wait()
+	}
+	if l.sem == nil {
+		return func() {}
+	}
+	l.sem <- struct{}{}
+	return func() { <-l.sem }
+}
+
+// LimitedLogical is a Logical which applies per-class concurrency and rate
+// limits to requests made to an underlying backend.
+type LimitedLogical struct {
+	backend  Logical
+	def      *classLimiter
+	prefixes map[string]*classLimiter
+}
+
+// NewLimitedLogical wraps backend with the limits in config.
+func NewLimitedLogical(backend Logical, config LimitConfig) *LimitedLogical {
+	l := &LimitedLogical{
+		backend:  backend,
+		def:      newClassLimiter(config.Default),
+		prefixes: make(map[string]*classLimiter),
+	}
+	for _, class := range config.Classes {
+		limiter := newClassLimiter(class)
+		for _, prefix := range class.Prefixes {
+			l.prefixes[strings.Trim(prefix, "/")] = limiter
+		}
+	}
+	return l
+}
+
+// WithBackend returns a LimitedLogical of backend sharing the limits of l, so
+// requests through either count against the same limits.
+func (l *LimitedLogical) WithBackend(backend Logical) *LimitedLogical {
+	return &LimitedLogical{backend: backend, def: l.def, prefixes: l.prefixes}
+}
+
+// classFor returns the limiter of the class with the longest prefix matching
+// path, or the default class.
+func (l *LimitedLogical) classFor(path string) *classLimiter {
+	path = strings.Trim(path, "/")
+	for {
+		if limiter, found := l.prefixes[path]; found {
+			return limiter
+		}
+		idx := strings.LastIndex(path, "/")
+		if idx < 0 {
+			return l.def
+		}
+		path = path[:idx]
+	}
+}
+
+// Read implements Logical
+func (l *LimitedLogical) Read(path string) (*api.Secret, error) {
+	defer l.classFor(path).acquire()()
+	return l.backend.Read(path)
+}
+
+// ReadDynamic implements Logical
+func (l *LimitedLogical) ReadDynamic(path string) (*api.Secret, error) {
+	defer l.classFor(path).acquire()()
+	return l.backend.ReadDynamic(path)
+}
+
+// ReadWrapped implements Logical
+func (l *LimitedLogical) ReadWrapped(path string, wrapTTL time.Duration) (*api.Secret, error) {
+	defer l.classFor(path).acquire()()
+	return l.backend.ReadWrapped(path, wrapTTL)
+}
+
+// List implements Logical
+func (l *LimitedLogical) List(path string) (*api.Secret, error) {
+	defer l.classFor(path).acquire()()
+	return l.backend.List(path)
+}
+
+// Write implements Logical
+func (l *LimitedLogical) Write(path string, data map[string]interface{}) (*api.Secret, error) {
+	defer l.classFor(path).acquire()()
+	return l.backend.Write(path, data)
+}
+
+// Delete implements Logical
+func (l *LimitedLogical) Delete(path string) (*api.Secret, error) {
+	defer l.classFor(path).acquire()()
+	return l.backend.Delete(path)
+}
+
+// Unwrap implements Logical. Unwrapping is always in the default class.
+func (l *LimitedLogical) Unwrap(wrappingToken string) (*api.Secret, error) {
+	defer l.def.acquire()()
+	return l.backend.Unwrap(wrappingToken)
+}
+
+// tokenBucket is a minimal token bucket rate limiter.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// wait blocks until a token is available and takes it.
+func (b *tokenBucket) wait() {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	// Take the token now (possibly going into debt) and sleep until it would
+	// have been available, so waiters are served in order.
+	b.tokens--
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+
+	time.Sleep(delay)
+}
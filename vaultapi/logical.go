@@ -2,9 +2,12 @@ package vaultapi
 
 import (
 	"fmt"
+	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/vault/api"
+	"net/url"
 	"strings"
-	"github.com/hashicorp/errwrap"
+	"sync"
+	"time"
 )
 
 // ensure ErrAuth implements Wrapper at compile-time.
@@ -90,9 +93,31 @@ func (err ErrVaultInaccessible) WrappedErrors() []error {
 	return []error{err.innerError}
 }
 
+// ErrSealed is returned when Vault is sealed, which is usually temporary.
+type ErrSealed struct {
+	innerError error
+}
+
+// Error implements the error interface
+func (err ErrSealed) Error() string {
+	return "vault is sealed"
+}
+
+// WrappedErrors implmenets the hashicorp/errwrap interface
+func (err ErrSealed) WrappedErrors() []error {
+	return []error{err.innerError}
+}
+
 // Logical is used to perform logical backend operations on Vault.
 type Logical interface {
 	Read(path string) (*api.Secret, error)
+	// ReadDynamic reads a dynamic secret, which differs on every read (e.g.
+	// generated credentials). The response is never cached or shared with
+	// concurrent reads of the same path.
+	ReadDynamic(path string) (*api.Secret, error)
+	// ReadWrapped reads path with response wrapping, returning the wrapping
+	// token (in WrapInfo) instead of the secret itself.
+	ReadWrapped(path string, wrapTTL time.Duration) (*api.Secret, error)
 	List(path string) (*api.Secret, error)
 	Write(path string, data map[string]interface{}) (*api.Secret, error)
 	Delete(path string) (*api.Secret, error)
@@ -103,18 +128,45 @@ type Logical interface {
 type AuthableLogical interface {
 	Logical
 	Auth() error
+	// Token returns the token currently in use.
+	Token() string
+	// RenewToken renews the current token and returns its new TTL. A zero
+	// TTL means the token does not expire.
+	RenewToken() (time.Duration, error)
+	// ReadRaw reads path and returns the decoded response body, for endpoints
+	// (such as sys/health) whose responses aren't shaped like secrets.
+	ReadRaw(path string, params url.Values) (map[string]interface{}, error)
 }
 
+// AuthMethodAgent is the auth method of requests made through Vault Agent,
+// which adds its auto-auth token to requests made without one.
+const AuthMethodAgent = "agent"
+
 // Logical wrapper for the vault API logical construct so it can be
 // reimplemented with additional handling logic.
+//
+// The token is shared by concurrent requests. It has no generation until the
+// first login, is then current until a request fails with it, and is
+// refreshed by the first of the failed requests to take tokenLock for
+// writing. Requests hold tokenLock for reading while using the client, so
+// they wait out a refresh, and a request which finds the generation of its
+// token has changed since it failed retries with the new token instead of
+// logging in again.
 type vaultBackend struct {
-	client     *api.Client
-	logical    *api.Logical
+	client  *api.Client
+	logical *api.Logical
+
+	tokenLock  sync.RWMutex // held for writing while the token is refreshed
 	token      string
+	generation uint64 // of the token, incremented by every refresh
+
 	authMethod string
 	authUser   string
-    	authRole   string
+	authRole   string
 	authSecret string
+
+	flight    flightGroup  // deduplicates concurrent identical reads and lists
+	endpoints *endpointSet // nodes to fail over between (nil for a single node)
 }
 
 // NewVaultLogicalBackend creates a new Vault logical backend that manages ensuring that
@@ -125,24 +177,129 @@ func NewVaultLogicalBackend(client *api.Client, token string, authMethod string,
 		logical:    client.Logical(),
 		token:      token,
 		authMethod: authMethod,
-		authUser: authUser,
-    		authRole: authRole,
+		authUser:   authUser,
+		authRole:   authRole,
 		authSecret: authSecret,
 	}
 }
 
 // Auth attempts to re-authenticate the backend and get a new token. It fails silently since we
 // always want to retry (i.e. backend down, policies changing out from under us) when we can't.
+// Auth methods which log in (cert, ldap and approle) always log in again, as the current token
+// may have expired, while a token given directly (or Vault Agent's) is kept.
 func (b *vaultBackend) Auth() error {
-	// If no token try and get one with authMethod
-	if b.token == "" || b.authMethod == "approle" {
+	b.tokenLock.RLock()
+	generation := b.generation
+	b.tokenLock.RUnlock()
+
+	_, err := b.refresh(generation)
+	return err
+}
+
+// refresh logs in again, unless the token has been refreshed since the
+// generation given, and returns the generation of the token now in use.
+func (b *vaultBackend) refresh(generation uint64) (uint64, error) {
+	b.tokenLock.Lock()
+	defer b.tokenLock.Unlock()
+
+	if b.generation != generation {
+		// Another request refreshed the token meanwhile.
+		return b.generation, nil
+	}
+	if err := b.withFailover(b.auth); err != nil {
+		return b.generation, err
+	}
+	b.generation++
+	return b.generation, nil
+}
+
+// ensureToken logs in if there is no token yet, and returns the generation of
+// the token in use.
+func (b *vaultBackend) ensureToken() (uint64, error) {
+	b.tokenLock.RLock()
+	token, generation := b.token, b.generation
+	b.tokenLock.RUnlock()
+
+	// Vault Agent adds its own token, so there is none after logging in.
+	if token != "" || (b.authMethod == AuthMethodAgent && generation > 0) {
+		return generation, nil
+	}
+	return b.refresh(generation)
+}
+
+// withToken calls op, which uses the client, without the token changing
+// under it.
+func (b *vaultBackend) withToken(op func() (*api.Secret, error)) (*api.Secret, error) {
+	b.tokenLock.RLock()
+	defer b.tokenLock.RUnlock()
+	return op()
+}
+
+// request makes the request op with the current token, logging in first if
+// there is none. Failed approle requests are retried once with a refreshed
+// token, as the secret id may have expired.
+func (b *vaultBackend) request(op func() (*api.Secret, error)) (*api.Secret, error) {
+	generation, err := b.ensureToken()
+	if err != nil {
+		return nil, err
+	}
+
+	attempt := func() (*api.Secret, error) { return b.secretWithFailover(op) }
+	secret, err := b.withToken(attempt)
+	if err != nil {
+		err = narrowVaultError(err)
+		if b.authMethod == "approle" {
+			if _, err := b.refresh(generation); err != nil {
+				return nil, err
+			}
+			secret, err = b.withToken(attempt)
+			if err != nil {
+				err = narrowVaultError(err)
+			}
+		}
+	}
+	return secret, err
+}
+
+// CanLogin returns true if the auth method logs in for its token, rather than
+// using a token it was given.
+func CanLogin(authMethod string) bool {
+	switch authMethod {
+	case "cert", "ldap", "approle":
+		return true
+	}
+	return false
+}
+
+func (b *vaultBackend) auth() error {
+	// Vault Agent authenticates (and renews its token) itself.
+	if b.authMethod == AuthMethodAgent {
+		b.token = ""
+		b.client.ClearToken()
+		return nil
+	}
+
+	// If no token try and get one with authMethod, and always log in again
+	// with methods which can, as the current token may have expired.
+	if b.token == "" || CanLogin(b.authMethod) {
 		var secret *api.Secret
 		var err error
 
+		// Don't present the current token, which may be invalid, to log in.
+		b.client.ClearToken()
 		switch b.authMethod {
 		case "cert":
 			path := fmt.Sprintf("auth/cert/login")
-			secret, err = b.logical.Write(path, nil)
+
+			// Without a name Vault tries every matching cert role.
+			var certRole map[string]interface{}
+			if b.authRole != "" {
+				certRole = map[string]interface{}{
+					"name": b.authRole,
+				}
+			}
+
+			secret, err = b.logical.Write(path, certRole)
 		case "ldap":
 			path := fmt.Sprintf("auth/ldap/login/%s", b.authUser)
 
@@ -151,33 +308,42 @@ func (b *vaultBackend) Auth() error {
 			}
 
 			secret, err = b.logical.Write(path, ldapPassword)
-    case "approle":
-      b.client.SetToken(b.authSecret)
-      path := fmt.Sprintf("auth/approle/role/%s/role-id", b.authRole)
-      secret, err = b.logical.Read(path)
-      if err != nil {
-        return ErrAuthFailed{err}
-      }
-      roleid := secret.Data["role_id"].(string)
-      empty := map[string]interface{}{
-        "nil": "foo",
-      }
-      path = fmt.Sprintf("auth/approle/role/%s/secret-id", b.authRole)
-      secret, err = b.logical.Write(path, empty)
-      secretid := secret.Data["secret_id"]
-      path = fmt.Sprintf("auth/approle/login")
-      secretAuth := map[string]interface{}{
-        "role_id": roleid,
-        "secret_id": secretid,
-      }
-      secret, err = b.logical.Write(path, secretAuth)
+		case "approle":
+			b.client.SetToken(b.authSecret)
+			path := fmt.Sprintf("auth/approle/role/%s/role-id", b.authRole)
+			secret, err = b.logical.Read(path)
+			if err != nil {
+				return ErrAuthFailed{err}
+			}
+			roleid, ok := secretString(secret, "role_id")
+			if !ok {
+				return ErrAuthFailed{fmt.Errorf("no role_id at %s", path)}
+			}
+			empty := map[string]interface{}{
+				"nil": "foo",
+			}
+			path = fmt.Sprintf("auth/approle/role/%s/secret-id", b.authRole)
+			secret, err = b.logical.Write(path, empty)
+			if err != nil {
+				return ErrAuthFailed{err}
+			}
+			secretid, ok := secretString(secret, "secret_id")
+			if !ok {
+				return ErrAuthFailed{fmt.Errorf("no secret_id from %s", path)}
+			}
+			path = fmt.Sprintf("auth/approle/login")
+			secretAuth := map[string]interface{}{
+				"role_id":   roleid,
+				"secret_id": secretid,
+			}
+			secret, err = b.logical.Write(path, secretAuth)
 		}
 
 		if err != nil {
 			return ErrAuthFailed{err}
 		}
 
-		if secret == nil {
+		if secret == nil || secret.Auth == nil {
 			return ErrAuthFailed{nil}
 		}
 		b.token = secret.Auth.ClientToken
@@ -187,128 +353,139 @@ func (b *vaultBackend) Auth() error {
 	return nil
 }
 
-func (b *vaultBackend) Read(path string) (*api.Secret, error) {
-	if b.token == "" {
-		if err := b.Auth(); err != nil {
-			return nil, err
-		}
+// secretString returns the string value of key in the data of secret.
+func secretString(secret *api.Secret, key string) (string, bool) {
+	if secret == nil {
+		return "", false
 	}
+	value, ok := secret.Data[key].(string)
+	return value, ok
+}
 
-	secret, err := b.logical.Read(path)
-    if err != nil {
-        err = narrowVaultError(err)
-        if b.authMethod == "approle" {
-            if err := b.Auth(); err != nil {
-                return nil, err
-            }
-            secret, err = b.logical.Read(path)
-            if err != nil {
-                err = narrowVaultError(err)
-            }
-        }
-    }
-    return secret, err
+func (b *vaultBackend) Token() string {
+	b.tokenLock.RLock()
+	defer b.tokenLock.RUnlock()
+	return b.token
 }
 
-func (b *vaultBackend) List(path string) (*api.Secret, error) {
-	if b.token == "" {
-		if err := b.Auth(); err != nil {
-			return nil, err
-		}
+func (b *vaultBackend) RenewToken() (time.Duration, error) {
+	if b.authMethod == AuthMethodAgent {
+		return 0, nil
 	}
-
-	secret, err := b.logical.List(path)
+	secret, err := b.withToken(func() (*api.Secret, error) {
+		return b.secretWithFailover(func() (*api.Secret, error) { return b.client.Auth().Token().RenewSelf(0) })
+	})
 	if err != nil {
-		err = narrowVaultError(err)
-        if b.authMethod == "approle" {
-            if err := b.Auth(); err != nil {
-                return nil, err
-            }
-            secret, err = b.logical.List(path)
-            if err != nil {
-                err = narrowVaultError(err)
-            }
-        }
+		return 0, narrowVaultError(err)
 	}
-	return secret, err
+	if secret == nil || secret.Auth == nil {
+		return 0, ErrAuthFailed{nil}
+	}
+	return time.Duration(secret.Auth.LeaseDuration) * time.Second, nil
 }
 
-func (b *vaultBackend) Write(path string, data map[string]interface{}) (*api.Secret, error) {
-	if b.token == "" {
-		if err := b.Auth(); err != nil {
-			return nil, err
+func (b *vaultBackend) ReadRaw(path string, params url.Values) (map[string]interface{}, error) {
+	if _, err := b.ensureToken(); err != nil {
+		return nil, err
+	}
+
+	var resp *api.Response
+	_, err := b.withToken(func() (*api.Secret, error) {
+		r := b.client.NewRequest("GET", "/v1/"+path)
+		for k, values := range params {
+			for _, value := range values {
+				r.Params.Add(k, value)
+			}
 		}
+		return nil, b.withFailover(func() error {
+			var err error
+			resp, err = b.client.RawRequest(r)
+			return err
+		})
+	})
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return nil, narrowVaultError(err)
 	}
 
-	secret, err := b.logical.Write(path, data)
-    if err != nil {
-        err = narrowVaultError(err)
-        if b.authMethod == "approle" {
-            if err := b.Auth(); err != nil {
-                return nil, err
-            }
-            secret, err = b.logical.Write(path, data)
-            if err != nil {
-                err = narrowVaultError(err)
-            }
-        }
-    }
-    return secret, err
+	body := make(map[string]interface{})
+	if err := resp.DecodeJSON(&body); err != nil {
+		return nil, err
+	}
+	return body, nil
 }
 
-func (b *vaultBackend) Delete(path string) (*api.Secret, error) {
-	if b.token == "" {
-		if err := b.Auth(); err != nil {
-			return nil, err
-		}
-	}
+func (b *vaultBackend) Read(path string) (*api.Secret, error) {
+	return b.flight.Do("read:"+path, func() (*api.Secret, error) {
+		return b.read(path)
+	})
+}
 
-	secret, err := b.logical.Delete(path)
-    if err != nil {
-        err = narrowVaultError(err)
-        if b.authMethod == "approle" {
-            if err := b.Auth(); err != nil {
-                return nil, err
-            }
-            secret, err = b.logical.Delete(path)
-            if err != nil {
-                err = narrowVaultError(err)
-            }
-        }
-    }
-	return secret, err
+// ReadDynamic implements Logical
+func (b *vaultBackend) ReadDynamic(path string) (*api.Secret, error) {
+	return b.read(path)
 }
 
-func (b *vaultBackend) Unwrap(wrappingToken string) (*api.Secret, error) {
-	if b.token == "" {
-		if err := b.Auth(); err != nil {
-			return nil, err
-		}
+// ReadWrapped implements Logical
+func (b *vaultBackend) ReadWrapped(path string, wrapTTL time.Duration) (*api.Secret, error) {
+	return b.request(func() (*api.Secret, error) { return b.readWrapped(path, wrapTTL) })
+}
+
+// readWrapped makes a read request with the wrap TTL header set, which the
+// api package only supports client-wide.
+func (b *vaultBackend) readWrapped(path string, wrapTTL time.Duration) (*api.Secret, error) {
+	r := b.client.NewRequest("GET", "/v1/"+path)
+	r.WrapTTL = fmt.Sprintf("%ds", int(wrapTTL.Seconds()))
+
+	resp, err := b.client.RawRequest(r)
+	if resp != nil {
+		defer resp.Body.Close()
 	}
+	if err != nil {
+		return nil, err
+	}
+	return api.ParseSecret(resp.Body)
+}
+
+func (b *vaultBackend) read(path string) (*api.Secret, error) {
+	return b.request(func() (*api.Secret, error) { return b.logical.Read(path) })
+}
 
-	secret, err := b.logical.Unwrap(wrappingToken)
-    if err != nil {
-        err = narrowVaultError(err)
-        if b.authMethod == "approle" {
-            if err := b.Auth(); err != nil {
-                return nil, err
-            }
-            secret, err = b.logical.Unwrap(wrappingToken)
-            if err != nil {
-                err = narrowVaultError(err)
-            }
-        }
-    }
-    return secret, err
+func (b *vaultBackend) List(path string) (*api.Secret, error) {
+	return b.flight.Do("list:"+path, func() (*api.Secret, error) {
+		return b.list(path)
+	})
+}
+
+func (b *vaultBackend) list(path string) (*api.Secret, error) {
+	return b.request(func() (*api.Secret, error) { return b.logical.List(path) })
+}
+
+func (b *vaultBackend) Write(path string, data map[string]interface{}) (*api.Secret, error) {
+	return b.request(func() (*api.Secret, error) { return b.logical.Write(path, data) })
+}
+
+func (b *vaultBackend) Delete(path string) (*api.Secret, error) {
+	return b.request(func() (*api.Secret, error) { return b.logical.Delete(path) })
+}
+
+func (b *vaultBackend) Unwrap(wrappingToken string) (*api.Secret, error) {
+	return b.request(func() (*api.Secret, error) { return b.logical.Unwrap(wrappingToken) })
 }
 
 // narrowVaultError wraps a returned error with a specific error type based on its content
 func narrowVaultError(err error) error {
+	if strings.Contains(err.Error(), "Vault is sealed") {
+		return ErrVaultInaccessible{ErrSealed{err}}
+	}
+
 	if strings.Contains(err.Error(), "* permission denied") {
 		return ErrAuth{ErrPermissionDenied{err}}
 	}
 
-	if !strings.Contains(err.Error(), "* missing client token") {
+	if strings.Contains(err.Error(), "* missing client token") {
 		return ErrAuth{ErrMissingClientToken{err}}
 	}
 
@@ -0,0 +1,44 @@
+package vaultapi
+
+import (
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/go-errors/errors"
+	"github.com/hashicorp/errwrap"
+)
+
+// apiError returns an error as the api package returns for a response with
+// code and errors.
+func apiError(code string, message string) error {
+	return errors.New("Error making API request.\n\nURL: GET https://vault:8200/v1/secret/app\nCode: " + code + ". Errors:\n\n* " + message)
+}
+
+func TestNarrowVaultError(t *testing.T) {
+	refused := &url.Error{Op: "Get", URL: "https://vault:8200/v1/secret/app", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connect: connection refused")}}
+	for _, c := range []struct {
+		name     string
+		err      error
+		expected []interface{}
+		not      []interface{}
+	}{
+		{"permission denied", apiError("403", "permission denied"), []interface{}{ErrAuth{}, ErrPermissionDenied{}}, []interface{}{ErrVaultInaccessible{}}},
+		{"sealed", apiError("503", "Vault is sealed"), []interface{}{ErrVaultInaccessible{}, ErrSealed{}}, []interface{}{ErrAuth{}}},
+		{"missing token", apiError("400", "missing client token"), []interface{}{ErrAuth{}, ErrMissingClientToken{}}, []interface{}{ErrVaultInaccessible{}}},
+		{"connection refused", refused, []interface{}{ErrVaultInaccessible{}}, []interface{}{ErrAuth{}, ErrSealed{}}},
+		{"server error", apiError("500", "internal error"), []interface{}{ErrVaultInaccessible{}}, []interface{}{ErrAuth{}, ErrSealed{}}},
+	} {
+		err := narrowVaultError(c.err)
+		for _, expected := range c.expected {
+			if !errwrap.ContainsType(err, expected) {
+				t.Errorf("%s: expected %T in %v", c.name, expected, err)
+			}
+		}
+		for _, not := range c.not {
+			if errwrap.ContainsType(err, not) {
+				t.Errorf("%s: expected no %T in %v", c.name, not, err)
+			}
+		}
+	}
+}
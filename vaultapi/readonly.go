@@ -0,0 +1,106 @@
+package vaultapi
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// ensure ReadOnlyLogical implements AuthableLogical at compile-time.
+var _ = AuthableLogical(&ReadOnlyLogical{})
+
+// ErrReadOnly is returned without making a request which could modify Vault
+// through a ReadOnlyLogical.
+type ErrReadOnly struct {
+	Path string
+}
+
+// Error implements the error interface
+func (err ErrReadOnly) Error() string {
+	return fmt.Sprintf("read-only: not modifying %s", err.Path)
+}
+
+// readOnlyWrites are the paths written to only to query Vault, or to manage
+// the leases of credentials read through the filesystem (see HoldLease), which
+// are allowed through a ReadOnlyLogical.
+var readOnlyWrites = map[string]bool{
+	"sys/capabilities-self": true,
+	"sys/leases/renew":      true,
+	"sys/leases/revoke":     true,
+}
+
+// ReadOnlyLogical is an AuthableLogical which only makes Vault's read and list
+// requests, refusing writes, deletes and unwrapping (which consumes the
+// wrapping token) with ErrReadOnly, so that nothing layered on it can modify
+// Vault whatever the token may do. Writes which only query Vault, such as
+// sys/capabilities-self, or manage the leases of credentials it has read are
+// let through, as are logging in and renewing the token, which the filesystem
+// needs to keep reading.
+type ReadOnlyLogical struct {
+	backend AuthableLogical
+}
+
+// NewReadOnlyLogical wraps backend so that it can't modify Vault.
+func NewReadOnlyLogical(backend AuthableLogical) *ReadOnlyLogical {
+	return &ReadOnlyLogical{backend: backend}
+}
+
+// Auth implements AuthableLogical
+func (r *ReadOnlyLogical) Auth() error {
+	return r.backend.Auth()
+}
+
+// Token implements AuthableLogical
+func (r *ReadOnlyLogical) Token() string {
+	return r.backend.Token()
+}
+
+// RenewToken implements AuthableLogical
+func (r *ReadOnlyLogical) RenewToken() (time.Duration, error) {
+	return r.backend.RenewToken()
+}
+
+// ReadRaw implements AuthableLogical
+func (r *ReadOnlyLogical) ReadRaw(path string, params url.Values) (map[string]interface{}, error) {
+	return r.backend.ReadRaw(path, params)
+}
+
+// Read implements Logical
+func (r *ReadOnlyLogical) Read(path string) (*api.Secret, error) {
+	return r.backend.Read(path)
+}
+
+// ReadDynamic implements Logical
+func (r *ReadOnlyLogical) ReadDynamic(path string) (*api.Secret, error) {
+	return r.backend.ReadDynamic(path)
+}
+
+// ReadWrapped implements Logical
+func (r *ReadOnlyLogical) ReadWrapped(path string, wrapTTL time.Duration) (*api.Secret, error) {
+	return r.backend.ReadWrapped(path, wrapTTL)
+}
+
+// List implements Logical
+func (r *ReadOnlyLogical) List(path string) (*api.Secret, error) {
+	return r.backend.List(path)
+}
+
+// Write implements Logical
+func (r *ReadOnlyLogical) Write(path string, data map[string]interface{}) (*api.Secret, error) {
+	if !readOnlyWrites[path] {
+		return nil, ErrReadOnly{path}
+	}
+	return r.backend.Write(path, data)
+}
+
+// Delete implements Logical
+func (r *ReadOnlyLogical) Delete(path string) (*api.Secret, error) {
+	return nil, ErrReadOnly{path}
+}
+
+// Unwrap implements Logical
+func (r *ReadOnlyLogical) Unwrap(wrappingToken string) (*api.Secret, error) {
+	return nil, ErrReadOnly{"sys/wrapping/unwrap"}
+}
@@ -0,0 +1,248 @@
+// Redaction of what is logged, so that debug logging can be enabled in
+// production without secret material reaching the log. Loggers from
+// SafeLogger only let through the paths, key names and lengths of secrets, in
+// fields, formatted messages and errors alike, and when sanitising, hash the
+// paths too.
+
+package vaultapi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/api"
+	"github.com/wrouesnel/go.log"
+)
+
+// pathFields are the fields which hold Vault paths, or names within them,
+// which are hashed when sanitising.
+var pathFields = map[string]bool{
+	"path":       true,
+	"root":       true,
+	"vault_root": true,
+	"from":       true,
+	"to":         true,
+	"name":       true,
+	"old_name":   true,
+	"new_name":   true,
+	"key":        true,
+	"lease_id":   true,
+}
+
+// plainFields are the other fields whose strings are logged as they are.
+// Strings of any other field are logged only by their length, so a value
+// can't be logged by mistake.
+var plainFields = map[string]bool{
+	"op":         true,
+	"errno":      true,
+	"address":    true,
+	"mountpoint": true,
+	"sink":       true,
+	"event_type": true,
+	"method":     true,
+	"sealed":     true,
+	"token_ttl":  true,
+	"node":       true,
+	"from_node":  true,
+	"endpoint":   true,
+}
+
+// requestPathPattern matches the paths of Vault API requests in errors.
+var requestPathPattern = regexp.MustCompile(`/v1/[^\s"?]+`)
+
+// sanitize is non-zero if the paths logged are hashed. Accessed atomically.
+var sanitize int32
+
+// SetLogSanitize sets whether the loggers from SafeLogger hash each component
+// of the paths they log, including those in the errors logged.
+func SetLogSanitize(enabled bool) {
+	value := int32(0)
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&sanitize, value)
+}
+
+func sanitizing() bool {
+	return atomic.LoadInt32(&sanitize) != 0
+}
+
+// SanitizePath returns path with each component hashed if sanitising, or as
+// it is.
+func SanitizePath(path string) string {
+	if !sanitizing() || path == "" {
+		return path
+	}
+	components := strings.Split(path, "/")
+	for i, component := range components {
+		if component == "" {
+			continue
+		}
+		sum := sha256.Sum256([]byte(component))
+		components[i] = hex.EncodeToString(sum[:4])
+	}
+	return strings.Join(components, "/")
+}
+
+// safeLog returns the logger everything in this package logs through, so that
+// it is redacted (and sanitised) as the filesystem's own messages are.
+func safeLog() log.Logger {
+	return SafeLogger(log.Base())
+}
+
+// safeLogger is a logger which redacts the fields logged through it.
+type safeLogger struct {
+	log.Logger
+}
+
+// SafeLogger wraps logger so that the fields logged through it can't carry
+// secret values. Strings are logged only if they are paths or known not to be
+// secret, and only by their length otherwise. Byte slices are logged by their
+// length, and maps and secrets by their key names.
+func SafeLogger(logger log.Logger) log.Logger {
+	if _, ok := logger.(safeLogger); ok {
+		return logger
+	}
+	return safeLogger{logger}
+}
+
+func (l safeLogger) With(key string, value interface{}) log.Logger {
+	return safeLogger{l.Logger.With(key, redactField(key, value))}
+}
+
+func (l safeLogger) WithField(key string, value interface{}) log.Logger {
+	return safeLogger{l.Logger.WithField(key, redactField(key, value))}
+}
+
+func (l safeLogger) WithFields(fields log.Fields) log.Logger {
+	redacted := make(log.Fields, len(fields))
+	for key, value := range fields {
+		redacted[key] = redactField(key, value)
+	}
+	return safeLogger{l.Logger.WithFields(redacted)}
+}
+
+func (l safeLogger) WithError(err error) log.Logger {
+	return safeLogger{l.Logger.WithError(sanitizeError(err))}
+}
+
+// The formatting methods redact their arguments as fields are redacted, so
+// values can only be logged by their length; paths belong in fields.
+
+func (l safeLogger) Debugf(format string, args ...interface{}) {
+	l.Logger.Debugf(format, redactArgs(args)...)
+}
+
+func (l safeLogger) Infof(format string, args ...interface{}) {
+	l.Logger.Infof(format, redactArgs(args)...)
+}
+
+func (l safeLogger) Warnf(format string, args ...interface{}) {
+	l.Logger.Warnf(format, redactArgs(args)...)
+}
+
+func (l safeLogger) Errorf(format string, args ...interface{}) {
+	l.Logger.Errorf(format, redactArgs(args)...)
+}
+
+func (l safeLogger) Fatalf(format string, args ...interface{}) {
+	l.Logger.Fatalf(format, redactArgs(args)...)
+}
+
+func (l safeLogger) Panicf(format string, args ...interface{}) {
+	l.Logger.Panicf(format, redactArgs(args)...)
+}
+
+// redactArgs returns what is logged of the arguments of a formatted message:
+// errors as WithError logs them, and anything else as a field of no known
+// name.
+func redactArgs(args []interface{}) []interface{} {
+	redacted := make([]interface{}, len(args))
+	for i, arg := range args {
+		if err, ok := arg.(error); ok {
+			redacted[i] = sanitizeError(err)
+			continue
+		}
+		redacted[i] = redactField("", arg)
+	}
+	return redacted
+}
+
+// sanitizeError returns err with the paths in its message hashed if
+// sanitising: those of Vault API requests, and those named by the errors of
+// this package.
+func sanitizeError(err error) error {
+	if err == nil || !sanitizing() {
+		return err
+	}
+	message := requestPathPattern.ReplaceAllStringFunc(err.Error(), func(path string) string {
+		return "/v1/" + SanitizePath(strings.TrimPrefix(path, "/v1/"))
+	})
+	errwrap.Walk(err, func(err error) {
+		var path string
+		switch e := err.(type) {
+		case ErrReadOnly:
+			path = e.Path
+		case ErrDeniedPath:
+			path = e.Path
+		case ErrTooLarge:
+			path = e.Path
+		}
+		if path != "" {
+			message = strings.Replace(message, path, SanitizePath(path), -1)
+		}
+	})
+	return sanitizedError(message)
+}
+
+// sanitizedError is an error whose message has had its paths hashed.
+type sanitizedError string
+
+func (e sanitizedError) Error() string {
+	return string(e)
+}
+
+// redactField returns what is logged of value as the field key.
+func redactField(key string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		if pathFields[key] {
+			return SanitizePath(v)
+		}
+		if plainFields[key] {
+			return v
+		}
+		return redacted(len(v))
+	case []byte:
+		return redacted(len(v))
+	case map[string]interface{}:
+		return keyNames(v)
+	case *api.Secret:
+		if v == nil {
+			return nil
+		}
+		return keyNames(v.Data)
+	}
+	return value
+}
+
+// redacted describes a value of length bytes which isn't logged.
+func redacted(length int) string {
+	return fmt.Sprintf("<redacted, %d bytes>", length)
+}
+
+// keyNames returns the sorted keys of data.
+func keyNames(data map[string]interface{}) []string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
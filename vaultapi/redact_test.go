@@ -0,0 +1,120 @@
+package vaultapi
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-errors/errors"
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/api"
+	"github.com/wrouesnel/go.log"
+)
+
+func TestRedactField(t *testing.T) {
+	secret := &api.Secret{Data: map[string]interface{}{"password": "hunter2", "username": "app"}}
+	for _, c := range []struct {
+		key      string
+		value    interface{}
+		expected interface{}
+	}{
+		{"path", "secret/app", "secret/app"},
+		{"op", "Lookup", "Lookup"},
+		{"value", "hunter2", "<redacted, 7 bytes>"},
+		{"content", []byte("hunter2"), "<redacted, 7 bytes>"},
+		{"data", secret.Data, []string{"password", "username"}},
+		{"secret", secret, []string{"password", "username"}},
+		{"uid", uint32(1000), uint32(1000)},
+	} {
+		if redacted := redactField(c.key, c.value); !reflect.DeepEqual(redacted, c.expected) {
+			t.Errorf("%s: expected %v, got %v", c.key, c.expected, redacted)
+		}
+	}
+}
+
+func TestSanitizePath(t *testing.T) {
+	if path := SanitizePath("secret/app"); path != "secret/app" {
+		t.Errorf("expected paths to be kept unless sanitising, got %s", path)
+	}
+
+	SetLogSanitize(true)
+	defer SetLogSanitize(false)
+
+	path := SanitizePath("secret/app/")
+	components := strings.Split(path, "/")
+	if len(components) != 3 || components[2] != "" || strings.Contains(path, "secret") || strings.Contains(path, "app") {
+		t.Errorf("expected each component to be hashed, got %s", path)
+	}
+	if again := SanitizePath("secret/app/"); again != path {
+		t.Errorf("expected hashing to be stable, got %s and %s", path, again)
+	}
+	if redacted := redactField("name", "app"); redacted != components[1] {
+		t.Errorf("expected names to be hashed as path components, got %v", redacted)
+	}
+}
+
+// errorLogger records the error logged through it.
+type errorLogger struct {
+	log.Logger
+	err *error
+}
+
+func (l errorLogger) WithError(err error) log.Logger {
+	*l.err = err
+	return l
+}
+
+// formatLogger records the formatted message logged through it.
+type formatLogger struct {
+	log.Logger
+	message *string
+}
+
+func (l formatLogger) Infof(format string, args ...interface{}) {
+	*l.message = fmt.Sprintf(format, args...)
+}
+
+func TestSafeLoggerSanitizesErrors(t *testing.T) {
+	SetLogSanitize(true)
+	defer SetLogSanitize(false)
+
+	var logged error
+	apiErr := errors.New("Error making API request.\n\nURL: GET http://127.0.0.1:8200/v1/secret/app\nCode: 500. Errors:\n\n* internal error")
+	SafeLogger(errorLogger{err: &logged}).WithError(errwrap.Wrapf("request failed: {{err}}", apiErr))
+	if logged == nil || strings.Contains(logged.Error(), "secret/app") || !strings.Contains(logged.Error(), "/v1/"+SanitizePath("secret/app")) {
+		t.Errorf("expected the path in the error to be hashed, got %v", logged)
+	}
+}
+
+func TestSafeLoggerSanitizesPathErrors(t *testing.T) {
+	SetLogSanitize(true)
+	defer SetLogSanitize(false)
+
+	for _, err := range []error{
+		ErrReadOnly{"secret/app"},
+		errwrap.Wrapf("request refused: {{err}}", ErrDeniedPath{"secret/app"}),
+		errwrap.Wrapf("lookup failed: {{err}}", ErrTooLarge{Path: "secret/app", Size: 10, Limit: 1}),
+	} {
+		var logged error
+		SafeLogger(errorLogger{err: &logged}).WithError(err)
+		if logged == nil || strings.Contains(logged.Error(), "secret/app") || !strings.Contains(logged.Error(), SanitizePath("secret/app")) {
+			t.Errorf("expected the path in %v to be hashed, got %v", err, logged)
+		}
+	}
+}
+
+func TestSafeLoggerRedactsFormatArgs(t *testing.T) {
+	var message string
+	SafeLogger(formatLogger{message: &message}).Infof("read %s with %d keys", "hunter2", 2)
+	if message != "read <redacted, 7 bytes> with 2 keys" {
+		t.Errorf("expected the string argument to be redacted, got %q", message)
+	}
+
+	SetLogSanitize(true)
+	defer SetLogSanitize(false)
+	SafeLogger(formatLogger{message: &message}).Infof("failed: %v", ErrReadOnly{"secret/app"})
+	if strings.Contains(message, "secret/app") {
+		t.Errorf("expected the path in the error argument to be hashed, got %q", message)
+	}
+}
@@ -0,0 +1,84 @@
+package vaultapi
+
+import (
+	"time"
+)
+
+// renewRetryInterval is how long the renewer waits after a failed renewal or
+// re-authentication before trying again.
+const renewRetryInterval = 30 * time.Second
+
+// TokenRenewer keeps the token of an AuthableLogical alive for as long as it
+// runs. Tokens are renewed at half their TTL, and the backend is
+// re-authenticated whenever renewal fails (i.e. the token hit its max TTL).
+type TokenRenewer struct {
+	backend AuthableLogical
+	onToken func(token string)
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewTokenRenewer creates a renewer for backend. onToken, if not nil, is
+// called with the current token when the renewer starts and again each time
+// the token changes.
+func NewTokenRenewer(backend AuthableLogical, onToken func(token string)) *TokenRenewer {
+	return &TokenRenewer{
+		backend: backend,
+		onToken: onToken,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Start begins renewing in the background.
+func (r *TokenRenewer) Start() {
+	go r.run()
+}
+
+// Stop halts renewal and waits for the background loop to exit.
+func (r *TokenRenewer) Stop() {
+	close(r.stop)
+	<-r.done
+}
+
+func (r *TokenRenewer) run() {
+	defer close(r.done)
+
+	token := r.backend.Token()
+	r.notify(token)
+
+	for {
+		wait := renewRetryInterval
+
+		ttl, err := r.backend.RenewToken()
+		switch {
+		case err != nil:
+			safeLog().WithError(err).Warn("token renewal failed, re-authenticating")
+			if err := r.backend.Auth(); err != nil {
+				safeLog().WithError(err).Error("re-authentication failed")
+			}
+		case ttl == 0:
+			safeLog().Debug("token does not expire, stopping renewal")
+			return
+		default:
+			wait = ttl / 2
+		}
+
+		if current := r.backend.Token(); current != token {
+			token = current
+			r.notify(token)
+		}
+
+		select {
+		case <-r.stop:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (r *TokenRenewer) notify(token string) {
+	if r.onToken != nil && token != "" {
+		r.onToken(token)
+	}
+}
@@ -0,0 +1,70 @@
+package vaultapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+
+	"github.com/go-errors/errors"
+	"github.com/hashicorp/vault/api"
+)
+
+// ErrDestroyed is returned using a SecureBuffer which has been destroyed.
+var ErrDestroyed = errors.New("secure buffer destroyed")
+
+// SecureBuffer holds secret material which is zeroed when it is destroyed.
+// Secrets held as strings can't be zeroed, and linger in the heap until their
+// memory is reused, so long-lived copies (such as cached responses) are held
+// in SecureBuffers instead. Together with mlockall, which keeps them out of
+// swap, the material only exists in memory for as long as it is needed.
+type SecureBuffer struct {
+	mu   sync.RWMutex
+	data []byte // nil once destroyed
+}
+
+// NewSecureBuffer returns a buffer holding data, which it takes ownership of.
+func NewSecureBuffer(data []byte) *SecureBuffer {
+	return &SecureBuffer{data: data}
+}
+
+// Use calls f with the content of the buffer, which it must not retain. It
+// returns ErrDestroyed if the buffer has been destroyed.
+func (b *SecureBuffer) Use(f func(data []byte) error) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.data == nil {
+		return ErrDestroyed
+	}
+	return f(b.data)
+}
+
+// Destroy zeroes the content of the buffer. It can be called more than once.
+func (b *SecureBuffer) Destroy() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i := range b.data {
+		b.data[i] = 0
+	}
+	b.data = nil
+}
+
+// sealSecret encodes secret into a SecureBuffer.
+func sealSecret(secret *api.Secret) (*SecureBuffer, error) {
+	data, err := json.Marshal(secret)
+	if err != nil {
+		return nil, err
+	}
+	return NewSecureBuffer(data), nil
+}
+
+// openSecret decodes a copy of the secret in b, which is the caller's to
+// discard.
+func openSecret(b *SecureBuffer) (*api.Secret, error) {
+	var secret *api.Secret
+	err := b.Use(func(data []byte) error {
+		var err error
+		secret, err = api.ParseSecret(bytes.NewReader(data))
+		return err
+	})
+	return secret, err
+}
@@ -0,0 +1,59 @@
+package vaultapi
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+func TestSecureBufferDestroy(t *testing.T) {
+	data := []byte("hunter2")
+	b := NewSecureBuffer(data)
+	b.Destroy()
+	for _, c := range data {
+		if c != 0 {
+			t.Fatalf("expected the content to be zeroed, got %q", data)
+		}
+	}
+	if err := b.Use(func([]byte) error { return nil }); err != ErrDestroyed {
+		t.Errorf("expected ErrDestroyed, got %v", err)
+	}
+	b.Destroy()
+}
+
+func TestCacheZeroesSecrets(t *testing.T) {
+	c := NewCachedLogical(nil, CacheConfig{TTL: time.Hour})
+	fetch := func() (*api.Secret, error) {
+		return &api.Secret{Data: map[string]interface{}{"password": "hunter2"}}, nil
+	}
+	if _, err := c.cached("read:secret/app", fetch); err != nil {
+		t.Fatal(err)
+	}
+
+	// Hits are decoded copies, which callers may modify.
+	secret, err := c.cached("read:secret/app", fetch)
+	if err != nil || secret.Data["password"] != "hunter2" {
+		t.Fatalf("expected a cached hunter2, got %v (%v)", secret, err)
+	}
+	secret.Data["password"] = "changed"
+	if secret, _ := c.cached("read:secret/app", fetch); secret.Data["password"] != "hunter2" {
+		t.Errorf("expected the cache to be unaffected by callers, got %v", secret.Data["password"])
+	}
+	if stats := c.Stats(); stats.Hits != 2 || stats.Misses != 1 {
+		t.Errorf("expected 2 hits and 1 miss, got %+v", stats)
+	}
+
+	sealed := c.entries["read:secret/app"].Value.(*cacheEntry).sealed
+	var data []byte
+	sealed.Use(func(d []byte) error {
+		data = d
+		return nil
+	})
+	c.Flush()
+	for _, b := range data {
+		if b != 0 {
+			t.Fatalf("expected flushed secrets to be zeroed, got %q", data)
+		}
+	}
+}
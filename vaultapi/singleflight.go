@@ -0,0 +1,59 @@
+package vaultapi
+
+import (
+	"sync"
+
+	"github.com/go-errors/errors"
+	"github.com/hashicorp/vault/api"
+)
+
+// errFlightPanicked is returned to the callers waiting on a call which
+// panicked.
+var errFlightPanicked = errors.New("request panicked")
+
+// flightCall is an in-progress or completed call made through a flightGroup.
+type flightCall struct {
+	wg     sync.WaitGroup
+	secret *api.Secret
+	err    error
+}
+
+// flightGroup deduplicates concurrent identical backend requests, so that
+// any number of simultaneous lookups of one path result in a single HTTP
+// call to Vault. It is a cut-down golang.org/x/sync/singleflight specialized
+// to *api.Secret results: only Do is needed, and golang.org/x/sync isn't
+// vendored, so this avoids a dependency for the sake of a few lines.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// Do executes fn for the given key unless a call for that key is already in
+// flight, in which case it waits for it and returns its results instead.
+func (g *flightGroup) Do(key string, fn func() (*api.Secret, error)) (*api.Secret, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	if c, found := g.calls[key]; found {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.secret, c.err
+	}
+	c := new(flightCall)
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	// Waiters are released with an error even if fn panics, rather than
+	// blocking on the key forever.
+	c.err = errFlightPanicked
+	defer func() {
+		c.wg.Done()
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+	}()
+	c.secret, c.err = fn()
+	return c.secret, c.err
+}
@@ -0,0 +1,40 @@
+package vaultapi
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+func TestFlightGroupPanic(t *testing.T) {
+	var g flightGroup
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go func() {
+		defer func() { recover() }()
+		g.Do("read:secret/app", func() (*api.Secret, error) {
+			close(started)
+			<-release
+			panic("boom")
+		})
+	}()
+	<-started
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := g.Do("read:secret/app", func() (*api.Secret, error) { return nil, nil })
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond) // for the second call to wait on the first
+	close(release)
+
+	select {
+	case err := <-done:
+		if err != errFlightPanicked {
+			t.Errorf("expected the waiter to fail, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiter blocked after the call panicked")
+	}
+}
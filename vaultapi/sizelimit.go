@@ -0,0 +1,135 @@
+package vaultapi
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// ensure SizeLimitedLogical implements Logical at compile-time.
+var _ = Logical(&SizeLimitedLogical{})
+
+// ErrTooLarge is returned for a response holding a value, or a listing, over
+// the size limits.
+type ErrTooLarge struct {
+	Path  string
+	Size  int // of the value in bytes, or of the listing in entries
+	Limit int
+}
+
+// Error implements the error interface
+func (err ErrTooLarge) Error() string {
+	return fmt.Sprintf("response for %s is too large: %d over the limit of %d", err.Path, err.Size, err.Limit)
+}
+
+// SizeLimits bounds the size of the responses served from Vault.
+type SizeLimits struct {
+	// MaxValueSize bounds the length in bytes of each value in a secret. Zero
+	// means unbounded.
+	MaxValueSize int
+	// MaxEntries bounds the number of keys in a listing. Zero means
+	// unbounded.
+	MaxEntries int
+}
+
+// Enabled returns true if any size limits are configured.
+func (l SizeLimits) Enabled() bool {
+	return l.MaxValueSize > 0 || l.MaxEntries > 0
+}
+
+// SizeLimitedLogical is a Logical which fails responses over its size limits
+// with ErrTooLarge. The response has been read from Vault by then, but is
+// dropped at once rather than being cached and presented, so a single
+// enormous secret can't pin its size in memory many times over.
+type SizeLimitedLogical struct {
+	backend Logical
+	limits  SizeLimits
+}
+
+// NewSizeLimitedLogical wraps backend with limits.
+func NewSizeLimitedLogical(backend Logical, limits SizeLimits) *SizeLimitedLogical {
+	return &SizeLimitedLogical{
+		backend: backend,
+		limits:  limits,
+	}
+}
+
+// checkValues returns the secret if none of its values are over the limit.
+func (l *SizeLimitedLogical) checkValues(path string, secret *api.Secret, err error) (*api.Secret, error) {
+	if err != nil || secret == nil || l.limits.MaxValueSize <= 0 {
+		return secret, err
+	}
+	if size := largestValue(secret.Data); size > l.limits.MaxValueSize {
+		return nil, ErrTooLarge{Path: path, Size: size, Limit: l.limits.MaxValueSize}
+	}
+	return secret, nil
+}
+
+// largestValue returns the length of the longest string in value, including
+// those nested in maps and lists (such as the data of KV v2 secrets).
+func largestValue(value interface{}) int {
+	largest := 0
+	switch v := value.(type) {
+	case string:
+		largest = len(v)
+	case map[string]interface{}:
+		for _, child := range v {
+			if size := largestValue(child); size > largest {
+				largest = size
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			if size := largestValue(child); size > largest {
+				largest = size
+			}
+		}
+	}
+	return largest
+}
+
+// Read implements Logical
+func (l *SizeLimitedLogical) Read(path string) (*api.Secret, error) {
+	secret, err := l.backend.Read(path)
+	return l.checkValues(path, secret, err)
+}
+
+// ReadDynamic implements Logical
+func (l *SizeLimitedLogical) ReadDynamic(path string) (*api.Secret, error) {
+	secret, err := l.backend.ReadDynamic(path)
+	return l.checkValues(path, secret, err)
+}
+
+// ReadWrapped implements Logical. The response only holds a wrapping token.
+func (l *SizeLimitedLogical) ReadWrapped(path string, wrapTTL time.Duration) (*api.Secret, error) {
+	return l.backend.ReadWrapped(path, wrapTTL)
+}
+
+// List implements Logical
+func (l *SizeLimitedLogical) List(path string) (*api.Secret, error) {
+	secret, err := l.backend.List(path)
+	if err != nil || secret == nil || l.limits.MaxEntries <= 0 {
+		return secret, err
+	}
+	if keys, ok := secret.Data["keys"].([]interface{}); ok && len(keys) > l.limits.MaxEntries {
+		return nil, ErrTooLarge{Path: path, Size: len(keys), Limit: l.limits.MaxEntries}
+	}
+	return secret, nil
+}
+
+// Write implements Logical
+func (l *SizeLimitedLogical) Write(path string, data map[string]interface{}) (*api.Secret, error) {
+	return l.backend.Write(path, data)
+}
+
+// Delete implements Logical
+func (l *SizeLimitedLogical) Delete(path string) (*api.Secret, error) {
+	return l.backend.Delete(path)
+}
+
+// Unwrap implements Logical
+func (l *SizeLimitedLogical) Unwrap(wrappingToken string) (*api.Secret, error) {
+	secret, err := l.backend.Unwrap(wrappingToken)
+	return l.checkValues("sys/wrapping/unwrap", secret, err)
+}
@@ -0,0 +1,95 @@
+package vaultapi
+
+import (
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// ensure SwappableLogical implements AuthableLogical at compile-time.
+var _ = AuthableLogical(&SwappableLogical{})
+
+// SwappableLogical is an AuthableLogical passing every request to a backend
+// which can be replaced while in use, e.g. to reconnect to Vault with new
+// settings without rebuilding everything layered on top.
+type SwappableLogical struct {
+	mu      sync.RWMutex
+	backend AuthableLogical
+}
+
+// NewSwappableLogical returns a SwappableLogical initially passing requests to
+// backend.
+func NewSwappableLogical(backend AuthableLogical) *SwappableLogical {
+	return &SwappableLogical{backend: backend}
+}
+
+// Swap replaces the backend. Requests in progress complete with the previous
+// backend.
+func (s *SwappableLogical) Swap(backend AuthableLogical) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.backend = backend
+}
+
+func (s *SwappableLogical) current() AuthableLogical {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.backend
+}
+
+// Auth implements AuthableLogical
+func (s *SwappableLogical) Auth() error {
+	return s.current().Auth()
+}
+
+// Token implements AuthableLogical
+func (s *SwappableLogical) Token() string {
+	return s.current().Token()
+}
+
+// RenewToken implements AuthableLogical
+func (s *SwappableLogical) RenewToken() (time.Duration, error) {
+	return s.current().RenewToken()
+}
+
+// ReadRaw implements AuthableLogical
+func (s *SwappableLogical) ReadRaw(path string, params url.Values) (map[string]interface{}, error) {
+	return s.current().ReadRaw(path, params)
+}
+
+// Read implements Logical
+func (s *SwappableLogical) Read(path string) (*api.Secret, error) {
+	return s.current().Read(path)
+}
+
+// ReadDynamic implements Logical
+func (s *SwappableLogical) ReadDynamic(path string) (*api.Secret, error) {
+	return s.current().ReadDynamic(path)
+}
+
+// ReadWrapped implements Logical
+func (s *SwappableLogical) ReadWrapped(path string, wrapTTL time.Duration) (*api.Secret, error) {
+	return s.current().ReadWrapped(path, wrapTTL)
+}
+
+// List implements Logical
+func (s *SwappableLogical) List(path string) (*api.Secret, error) {
+	return s.current().List(path)
+}
+
+// Write implements Logical
+func (s *SwappableLogical) Write(path string, data map[string]interface{}) (*api.Secret, error) {
+	return s.current().Write(path, data)
+}
+
+// Delete implements Logical
+func (s *SwappableLogical) Delete(path string) (*api.Secret, error) {
+	return s.current().Delete(path)
+}
+
+// Unwrap implements Logical
+func (s *SwappableLogical) Unwrap(wrappingToken string) (*api.Secret, error) {
+	return s.current().Unwrap(wrappingToken)
+}
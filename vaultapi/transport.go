@@ -0,0 +1,62 @@
+package vaultapi
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/hashicorp/vault/api"
+	"golang.org/x/net/context"
+)
+
+// unixAddressPrefix starts the address of a Vault (or Vault Agent) listener on
+// a unix socket.
+const unixAddressPrefix = "unix://"
+
+// unixAddress is the address requests to a unix socket are made to. The host
+// is only a placeholder, as every connection is made to the socket.
+const unixAddress = "http://localhost"
+
+// ConfigureTransport sets up the transport of config for its address and
+// proxy. A unix:///path/to/socket address (e.g. the proxy listener of Vault
+// Agent) is replaced by a plain http address, and connections are made to the
+// socket. proxy is an http://, https:// or socks5:// URL which overrides the
+// proxy of the environment (HTTPS_PROXY, HTTP_PROXY and NO_PROXY).
+func ConfigureTransport(config *api.Config, proxy string) error {
+	transport, ok := config.HttpClient.Transport.(*http.Transport)
+	if !ok {
+		return errors.New("unsupported vault client transport")
+	}
+
+	if strings.HasPrefix(config.Address, unixAddressPrefix) {
+		socket := strings.TrimPrefix(config.Address, unixAddressPrefix)
+		if socket == "" {
+			return errors.Errorf("no socket path in vault address: %s", config.Address)
+		}
+		dialer := &net.Dialer{Timeout: 30 * time.Second}
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+		// The socket is local, so never proxied.
+		transport.Proxy = nil
+		config.Address = unixAddress
+		return nil
+	}
+
+	if proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			return errors.WrapPrefix(err, "invalid proxy", 0)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return errors.Errorf("unsupported proxy scheme: %s", proxyURL.Scheme)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	return nil
+}
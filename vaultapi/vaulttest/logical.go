@@ -0,0 +1,368 @@
+// Package vaulttest provides an in-memory Vault for testing code built on
+// vaultapi, such as the filesystem, without a Vault server.
+package vaulttest
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/hashicorp/vault/api"
+	"github.com/wrouesnel/vaultfs/vaultapi"
+)
+
+// ensure Logical implements AuthableLogical at compile-time.
+var _ = vaultapi.AuthableLogical(&Logical{})
+
+// Operations which can be denied or failed.
+const (
+	OpRead   = "read"
+	OpList   = "list"
+	OpWrite  = "write"
+	OpDelete = "delete"
+)
+
+// Token is the token of every Logical.
+const Token = "vaulttest-token"
+
+// rule fails the operations on the paths matching pattern with err, or with
+// permission denied if err is nil.
+type rule struct {
+	pattern string
+	ops     []string
+	err     error
+}
+
+func (r rule) matches(op string, p string) bool {
+	if matched, _ := path.Match(r.pattern, p); !matched {
+		return false
+	}
+	if len(r.ops) == 0 {
+		return true
+	}
+	for _, o := range r.ops {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// Logical is an in-memory vaultapi.AuthableLogical holding a tree of secrets.
+// Paths are listable if secrets are stored beneath them, as in the KV secrets
+// engine. Operations can be denied or failed by path, and delayed, to exercise
+// the handling of each. The zero value is not usable; create one with
+// NewLogical.
+type Logical struct {
+	mu       sync.Mutex
+	secrets  map[string]map[string]interface{} // data by path
+	versions map[string]int                    // latest version of KV v2 secrets, by metadata path
+	wrapped  map[string]*api.Secret            // by wrapping token
+	rules    []rule
+	latency  time.Duration
+	requests map[string]int // by operation
+}
+
+// NewLogical returns an empty Logical.
+func NewLogical() *Logical {
+	return &Logical{
+		secrets:  make(map[string]map[string]interface{}),
+		versions: make(map[string]int),
+		wrapped:  make(map[string]*api.Secret),
+		requests: make(map[string]int),
+	}
+}
+
+// Put stores a secret holding data at p.
+func (l *Logical) Put(p string, data map[string]interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.put(clean(p), data)
+}
+
+// PutKVv2 stores a new version of a KV version 2 secret holding data at the
+// data path p (e.g. secret/data/app), with its metadata at the matching
+// metadata path.
+func (l *Logical) PutKVv2(p string, data map[string]interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.putKVv2(clean(p), data)
+}
+
+func (l *Logical) put(p string, data map[string]interface{}) {
+	if metadataPath, ok := vaultapi.KVv2MetadataPath(p); ok && l.versions[metadataPath] > 0 {
+		l.putKVv2(p, data)
+		return
+	}
+	l.secrets[p] = copyData(data)
+}
+
+func (l *Logical) putKVv2(p string, data map[string]interface{}) {
+	metadataPath, ok := vaultapi.KVv2MetadataPath(p)
+	if !ok {
+		l.secrets[p] = copyData(data)
+		return
+	}
+	l.versions[metadataPath]++
+	version := l.versions[metadataPath]
+	l.secrets[p] = map[string]interface{}{
+		"data": copyData(data),
+		"metadata": map[string]interface{}{
+			"version":       version,
+			"created_time":  time.Now().UTC().Format(time.RFC3339Nano),
+			"deletion_time": "",
+			"destroyed":     false,
+		},
+	}
+	l.secrets[metadataPath] = map[string]interface{}{
+		"current_version": version,
+		"versions": map[string]interface{}{
+			fmt.Sprintf("%d", version): map[string]interface{}{"destroyed": false},
+		},
+	}
+}
+
+// Deny makes the operations ops (all, if none are given) on the paths
+// matching the path.Match pattern fail with permission denied.
+func (l *Logical) Deny(pattern string, ops ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rules = append(l.rules, rule{pattern: clean(pattern), ops: ops})
+}
+
+// Fail makes the operations ops (all, if none are given) on the paths
+// matching the path.Match pattern fail with err.
+func (l *Logical) Fail(pattern string, err error, ops ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rules = append(l.rules, rule{pattern: clean(pattern), ops: ops, err: err})
+}
+
+// Reset removes the rules made by Deny and Fail.
+func (l *Logical) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rules = nil
+}
+
+// SetLatency delays every request by latency.
+func (l *Logical) SetLatency(latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.latency = latency
+}
+
+// Requests returns the number of requests made for the operation op, or of
+// every request if op is empty.
+func (l *Logical) Requests(op string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if op != "" {
+		return l.requests[op]
+	}
+	total := 0
+	for _, n := range l.requests {
+		total += n
+	}
+	return total
+}
+
+// begin records a request for op on p, waits out the latency and returns the
+// error it fails with, if any. It returns with l.mu held.
+func (l *Logical) begin(op string, p string) error {
+	l.mu.Lock()
+	l.requests[op]++
+	latency := l.latency
+	if latency > 0 {
+		l.mu.Unlock()
+		time.Sleep(latency)
+		l.mu.Lock()
+	}
+
+	for _, r := range l.rules {
+		if r.matches(op, p) {
+			if r.err == nil {
+				_, err := vaultapi.NewDeniedLogical(errors.Errorf("%s on %s denied", op, p)).Read(p)
+				return err
+			}
+			return r.err
+		}
+	}
+	return nil
+}
+
+// Auth implements vaultapi.AuthableLogical.
+func (l *Logical) Auth() error {
+	return nil
+}
+
+// Token implements vaultapi.AuthableLogical.
+func (l *Logical) Token() string {
+	return Token
+}
+
+// RenewToken implements vaultapi.AuthableLogical. The token never expires.
+func (l *Logical) RenewToken() (time.Duration, error) {
+	return 0, nil
+}
+
+// ReadRaw implements vaultapi.AuthableLogical, returning the data stored at
+// p.
+func (l *Logical) ReadRaw(p string, params url.Values) (map[string]interface{}, error) {
+	secret, err := l.Read(p)
+	if err != nil || secret == nil {
+		return nil, err
+	}
+	return secret.Data, nil
+}
+
+// Read implements vaultapi.Logical.
+func (l *Logical) Read(p string) (*api.Secret, error) {
+	p = clean(p)
+	defer l.mu.Unlock()
+	if err := l.begin(OpRead, p); err != nil {
+		return nil, err
+	}
+	return l.read(p), nil
+}
+
+func (l *Logical) read(p string) *api.Secret {
+	data, found := l.secrets[p]
+	if !found {
+		return nil
+	}
+	return &api.Secret{Data: copyData(data)}
+}
+
+// ReadDynamic implements vaultapi.Logical.
+func (l *Logical) ReadDynamic(p string) (*api.Secret, error) {
+	return l.Read(p)
+}
+
+// ReadWrapped implements vaultapi.Logical, wrapping the secret at p until it
+// is unwrapped.
+func (l *Logical) ReadWrapped(p string, wrapTTL time.Duration) (*api.Secret, error) {
+	p = clean(p)
+	defer l.mu.Unlock()
+	if err := l.begin(OpRead, p); err != nil {
+		return nil, err
+	}
+	secret := l.read(p)
+	if secret == nil {
+		return nil, nil
+	}
+	token := fmt.Sprintf("wrapping-token-%d", len(l.wrapped)+1)
+	l.wrapped[token] = secret
+	return &api.Secret{
+		WrapInfo: &api.SecretWrapInfo{
+			Token:        token,
+			TTL:          int(wrapTTL.Seconds()),
+			CreationTime: time.Now(),
+		},
+	}, nil
+}
+
+// List implements vaultapi.Logical, listing the secrets and directories
+// directly beneath p.
+func (l *Logical) List(p string) (*api.Secret, error) {
+	p = clean(p)
+	defer l.mu.Unlock()
+	if err := l.begin(OpList, p); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	for stored := range l.secrets {
+		if !strings.HasPrefix(stored, p+"/") {
+			continue
+		}
+		rest := strings.TrimPrefix(stored, p+"/")
+		if idx := strings.Index(rest, "/"); idx >= 0 {
+			names[rest[:idx+1]] = true
+		} else {
+			names[rest] = true
+		}
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	keys := make([]interface{}, len(sorted))
+	for i, name := range sorted {
+		keys[i] = name
+	}
+	return &api.Secret{Data: map[string]interface{}{"keys": keys}}, nil
+}
+
+// Write implements vaultapi.Logical, storing data at p. Data written to a KV
+// v2 data path is nested under "data", as for Vault.
+func (l *Logical) Write(p string, data map[string]interface{}) (*api.Secret, error) {
+	p = clean(p)
+	defer l.mu.Unlock()
+	if err := l.begin(OpWrite, p); err != nil {
+		return nil, err
+	}
+	if metadataPath, ok := vaultapi.KVv2MetadataPath(p); ok && l.versions[metadataPath] > 0 {
+		nested, _ := data["data"].(map[string]interface{})
+		l.putKVv2(p, nested)
+		return nil, nil
+	}
+	l.put(p, data)
+	return nil, nil
+}
+
+// Delete implements vaultapi.Logical. Deleting a KV v2 secret keeps its
+// metadata, so it can still be found and recovered.
+func (l *Logical) Delete(p string) (*api.Secret, error) {
+	p = clean(p)
+	defer l.mu.Unlock()
+	if err := l.begin(OpDelete, p); err != nil {
+		return nil, err
+	}
+	delete(l.secrets, p)
+	return nil, nil
+}
+
+// Unwrap implements vaultapi.Logical, returning the secret wrapped by
+// ReadWrapped once.
+func (l *Logical) Unwrap(wrappingToken string) (*api.Secret, error) {
+	defer l.mu.Unlock()
+	if err := l.begin(OpWrite, "sys/wrapping/unwrap"); err != nil {
+		return nil, err
+	}
+	secret, found := l.wrapped[wrappingToken]
+	if !found {
+		_, err := vaultapi.NewDeniedLogical(errors.New("wrapping token is not valid or does not exist")).Read("")
+		return nil, err
+	}
+	delete(l.wrapped, wrappingToken)
+	return secret, nil
+}
+
+// clean normalises p as Vault does, without leading or trailing slashes.
+func clean(p string) string {
+	return strings.Trim(path.Clean("/"+p), "/")
+}
+
+// copyData copies data deeply enough that callers can't change the stored
+// secret through it.
+func copyData(data map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		if nested, ok := v.(map[string]interface{}); ok {
+			v = copyData(nested)
+		}
+		copied[k] = v
+	}
+	return copied
+}
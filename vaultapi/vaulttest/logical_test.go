@@ -0,0 +1,95 @@
+package vaulttest
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/hashicorp/errwrap"
+	"github.com/wrouesnel/vaultfs/vaultapi"
+)
+
+func TestLogicalList(t *testing.T) {
+	l := NewLogical()
+	l.Put("secret/a", map[string]interface{}{"k": "v"})
+	l.Put("secret/dir/b", map[string]interface{}{"k": "v"})
+
+	secret, err := l.List("secret/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := secret.Data["keys"]; !reflect.DeepEqual(keys, []interface{}{"a", "dir/"}) {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+	if secret, err := l.List("secret/a"); secret != nil || err != nil {
+		t.Errorf("expected nothing beneath a secret, got %v, %v", secret, err)
+	}
+}
+
+func TestLogicalRules(t *testing.T) {
+	l := NewLogical()
+	l.Put("secret/a", map[string]interface{}{"k": "v"})
+	l.Deny("secret/*", OpRead)
+	l.Fail("other", errors.New("unreachable"))
+
+	if _, err := l.Read("secret/a"); !errwrap.ContainsType(err, vaultapi.ErrPermissionDenied{}) {
+		t.Errorf("expected permission denied, got %v", err)
+	}
+	if _, err := l.List("secret"); err != nil {
+		t.Errorf("expected listing not to be denied, got %v", err)
+	}
+	if _, err := l.Read("other"); err == nil || err.Error() != "unreachable" {
+		t.Errorf("expected the failure given, got %v", err)
+	}
+
+	l.Reset()
+	if secret, err := l.Read("secret/a"); err != nil || secret.Data["k"] != "v" {
+		t.Errorf("expected the secret once reset, got %v, %v", secret, err)
+	}
+	if n := l.Requests(OpRead); n != 3 {
+		t.Errorf("expected 3 reads, got %d", n)
+	}
+}
+
+func TestLogicalKVv2(t *testing.T) {
+	l := NewLogical()
+	l.PutKVv2("kv/data/app", map[string]interface{}{"k": "v1"})
+	if _, err := l.Write("kv/data/app", vaultapi.WriteData("kv/data/app", map[string]interface{}{"k": "v2"})); err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err := l.Read("kv/data/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version, _ := vaultapi.KVv2Version(secret); version != "2" {
+		t.Errorf("expected version 2, got %s", version)
+	}
+	if data := vaultapi.SecretData(secret); data["k"] != "v2" {
+		t.Errorf("expected v2, got %v", data)
+	}
+
+	if _, err := l.Delete("kv/data/app"); err != nil {
+		t.Fatal(err)
+	}
+	if secret, _ := l.Read("kv/data/app"); secret != nil {
+		t.Errorf("expected the deleted secret not to be read, got %v", secret)
+	}
+	if metadata, _ := l.Read("kv/metadata/app"); metadata == nil {
+		t.Error("expected the metadata of a deleted secret to be kept")
+	}
+}
+
+func TestLogicalLatency(t *testing.T) {
+	l := NewLogical()
+	l.SetLatency(20 * time.Millisecond)
+
+	started := time.Now()
+	if _, err := l.Read("secret/a"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(started); elapsed < 20*time.Millisecond {
+		t.Errorf("expected the read to be delayed, took %v", elapsed)
+	}
+}
@@ -0,0 +1,148 @@
+package vaulttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/api"
+	"github.com/wrouesnel/vaultfs/vaultapi"
+)
+
+// TokenTTL is the TTL in seconds of the tokens issued by a Server.
+const TokenTTL = 3600
+
+// Server serves a Logical over Vault's HTTP API, for testing the code which
+// talks to Vault itself, such as logging in and renewing tokens. Logging in
+// with any auth method issues a new token, and requests need a token which
+// hasn't been expired.
+type Server struct {
+	*httptest.Server
+	logical *Logical
+
+	mu     sync.Mutex
+	tokens map[string]bool // valid tokens
+	logins int
+}
+
+// NewServer starts serving l. Token is valid until expired, as a token given
+// to the client may be.
+func NewServer(l *Logical) *Server {
+	s := &Server{
+		logical: l,
+		tokens:  map[string]bool{Token: true},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// VaultClient returns a new Vault client of the server, without a token.
+func (s *Server) VaultClient() (*api.Client, error) {
+	client, err := api.NewClient(&api.Config{Address: s.URL})
+	if err != nil {
+		return nil, err
+	}
+	client.ClearToken()
+	return client, nil
+}
+
+// Logins returns the number of logins made.
+func (s *Server) Logins() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.logins
+}
+
+// ExpireTokens makes every token issued so far invalid, as when they reach
+// their max TTL.
+func (s *Server) ExpireTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = make(map[string]bool)
+}
+
+// valid returns true if token may make requests.
+func (s *Server) valid(token string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tokens[token]
+}
+
+// login issues a new token.
+func (s *Server) login() *api.Secret {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logins++
+	token := fmt.Sprintf("%s-%d", Token, s.logins)
+	s.tokens[token] = true
+	return &api.Secret{Auth: &api.SecretAuth{
+		ClientToken:   token,
+		LeaseDuration: TokenTTL,
+		Renewable:     true,
+	}}
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	p := strings.TrimPrefix(r.URL.Path, "/v1/")
+	if strings.HasPrefix(p, "auth/") && strings.Contains(p, "/login") {
+		respond(w, s.login(), nil)
+		return
+	}
+
+	token := r.Header.Get("X-Vault-Token")
+	if !s.valid(token) {
+		respond(w, nil, vaultapi.ErrPermissionDenied{})
+		return
+	}
+
+	var secret *api.Secret
+	var err error
+	switch {
+	case p == "auth/token/renew-self":
+		secret = &api.Secret{Auth: &api.SecretAuth{
+			ClientToken:   token,
+			LeaseDuration: TokenTTL,
+			Renewable:     true,
+		}}
+	case r.Method == "LIST" || r.URL.Query().Get("list") == "true":
+		secret, err = s.logical.List(p)
+	case r.Method == "GET":
+		secret, err = s.logical.Read(p)
+	case r.Method == "DELETE":
+		secret, err = s.logical.Delete(p)
+	default:
+		data := make(map[string]interface{})
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		secret, err = s.logical.Write(p, data)
+	}
+	if err == nil && secret == nil && r.Method != "DELETE" {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{}})
+		return
+	}
+	respond(w, secret, err)
+}
+
+// respond writes secret, or err as Vault would.
+func respond(w http.ResponseWriter, secret *api.Secret, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case errwrap.ContainsType(err, vaultapi.ErrPermissionDenied{}):
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{"permission denied"}})
+	case err != nil:
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{err.Error()}})
+	case secret == nil:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		json.NewEncoder(w).Encode(secret)
+	}
+}
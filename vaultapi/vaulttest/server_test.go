@@ -0,0 +1,85 @@
+package vaulttest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/wrouesnel/vaultfs/vaultapi"
+)
+
+func TestRenewerLogsInAgain(t *testing.T) {
+	l := NewLogical()
+	l.Put("secret/app", map[string]interface{}{"password": "hunter2"})
+	server := NewServer(l)
+	defer server.Close()
+
+	client, err := server.VaultClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := vaultapi.NewVaultLogicalBackend(client, "", "ldap", "user", "", "password")
+	if err := backend.Auth(); err != nil {
+		t.Fatal(err)
+	}
+	first := backend.Token()
+
+	// The token reaching its max TTL fails renewal, so the renewer must log
+	// in again rather than keep the dead token.
+	server.ExpireTokens()
+	tokens := make(chan string, 2)
+	renewer := vaultapi.NewTokenRenewer(backend, func(token string) { tokens <- token })
+	renewer.Start()
+	defer renewer.Stop()
+
+	<-tokens
+	select {
+	case token := <-tokens:
+		if token == first {
+			t.Errorf("expected a new token, got the expired one")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the renewer to log in again")
+	}
+	if logins := server.Logins(); logins != 2 {
+		t.Errorf("expected 2 logins, got %d", logins)
+	}
+	if secret, err := backend.Read("secret/app"); err != nil || secret.Data["password"] != "hunter2" {
+		t.Errorf("expected to read with the new token, got %v, %v", secret, err)
+	}
+}
+
+func TestApproleLoginErrors(t *testing.T) {
+	l := NewLogical()
+	server := NewServer(l)
+	defer server.Close()
+
+	login := func() error {
+		client, err := server.VaultClient()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return vaultapi.NewVaultLogicalBackend(client, "", "approle", "", "app", Token).Auth()
+	}
+
+	// Without a role id, or a secret id in response to asking for one, the
+	// login fails rather than panicking.
+	if err := login(); err == nil {
+		t.Error("expected logging in without a role id to fail")
+	}
+	l.Put("auth/approle/role/app/role-id", map[string]interface{}{"role_id": 1})
+	if err := login(); err == nil {
+		t.Error("expected logging in with a role id which isn't a string to fail")
+	}
+	l.Put("auth/approle/role/app/role-id", map[string]interface{}{"role_id": "role"})
+	if err := login(); err == nil {
+		t.Error("expected logging in without a secret id to fail")
+	}
+	l.Fail("auth/approle/role/app/secret-id", errors.New("secret id unavailable"))
+	if err := login(); err == nil {
+		t.Error("expected logging in to fail when creating a secret id does")
+	}
+	if logins := server.Logins(); logins != 0 {
+		t.Errorf("expected no logins, got %d", logins)
+	}
+}